@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/alpkeskin/rota/internal/api"
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/alpkeskin/rota/internal/proxy"
+)
+
+const (
+	commandCheck  = "check"
+	commandImport = "import"
+	commandExport = "export"
+
+	msgCommandFailed      = "command failed"
+	msgUnknownCommand     = "unknown command"
+	msgImportFileRequired = "import needs one proxy list file, - reads it from stdin"
+	msgInvalidProxyLines  = "invalid lines in proxy list"
+)
+
+// runCommand runs a one-off command against the config and its proxy files and exits, without starting the proxy or the api.
+// They do what the matching api endpoints do, for cron jobs and CI pipelines
+func runCommand(cfgManager *config.ConfigManager) error {
+	switch cfgManager.Command {
+	case commandCheck:
+		cfgManager.Check = true
+		run(cfgManager, nil)
+		return nil
+	case commandImport:
+		return runImport(cfgManager.Config, cfgManager.Args)
+	case commandExport:
+		return runExport(cfgManager.Config, cfgManager.Args)
+	}
+	return fmt.Errorf("%s: %s (check, import, export)", msgUnknownCommand, cfgManager.Command)
+}
+
+func loadProxies(cfg *config.Config) (*proxy.ProxyServer, *proxy.ProxyLoader, error) {
+	proxyServer := proxy.NewProxyServer(cfg)
+	proxyLoader := proxy.NewProxyLoader(proxyServer)
+	if err := proxyLoader.Load(); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", msgFailedToLoadProxies, err)
+	}
+	return proxyServer, proxyLoader, nil
+}
+
+// runImport appends a proxy list to a pool's proxy file like POST /proxies/bulk and prints the report.
+// It fails when a line is invalid, the valid lines are added anyway
+func runImport(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet(commandImport, flag.ContinueOnError)
+	pool := flags.String("pool", "", "proxy file to add the proxies to (default proxy_file)")
+	dryRun := flags.Bool("dry-run", false, "only report what would be added")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New(msgImportFileRequired)
+	}
+
+	var content []byte
+	var err error
+	if file := flags.Arg(0); file == "-" {
+		content, err = io.ReadAll(os.Stdin)
+	} else {
+		content, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, proxyLoader, err := loadProxies(cfg)
+	if err != nil {
+		return err
+	}
+	entries, err := proxyLoader.ImportProxies(string(content), *pool, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LINE\tSTATUS\tPROXY\tERROR")
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", entry.Line, entry.Status, entry.Proxy, entry.Error)
+	}
+	w.Flush()
+	summary := proxy.SummarizeImport(entries)
+	fmt.Printf("%d added, %d merged, %d skipped, %d invalid\n", summary.Added, summary.Merged, summary.Skipped, summary.Invalid)
+	if summary.Invalid > 0 {
+		return fmt.Errorf("%d %s", summary.Invalid, msgInvalidProxyLines)
+	}
+	return nil
+}
+
+// runExport writes the proxies in one of the /proxies/export formats to stdout or a file
+func runExport(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet(commandExport, flag.ContinueOnError)
+	format := flags.String("format", "txt", "txt, proxychains, clash or yaml")
+	pool := flags.String("pool", "", "only export the proxies of this proxy file")
+	tag := flags.String("tag", "", "only export the proxies with this tag")
+	withCredentials := flags.Bool("credentials", false, "include proxy credentials")
+	output := flags.String("output", "", "file to write, stdout by default")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	proxyServer, _, err := loadProxies(cfg)
+	if err != nil {
+		return err
+	}
+	body, _, err := api.ExportProxies(proxyServer, *format, *pool, *tag, *withCredentials)
+	if err != nil {
+		return err
+	}
+	if *output != "" {
+		// the export may carry proxy credentials
+		return os.WriteFile(*output, body, 0o600)
+	}
+	_, err = os.Stdout.Write(body)
+	return err
+}
@@ -6,12 +6,15 @@ import (
 	"log/slog"
 	"os"
 	"os/signal"
+	"runtime/debug"
 	"syscall"
+	"text/tabwriter"
 
 	"github.com/alpkeskin/rota/internal/api"
 	"github.com/alpkeskin/rota/internal/config"
 	"github.com/alpkeskin/rota/internal/logging"
 	"github.com/alpkeskin/rota/internal/proxy"
+	"github.com/alpkeskin/rota/pkg/systemd"
 	"github.com/alpkeskin/rota/pkg/watcher"
 )
 
@@ -23,12 +26,24 @@ const (
 	msgFailedToCreateWatcher  = "failed to create watcher"
 	msgFailedToWatchProxyFile = "failed to watch proxy file"
 	msgFailedToLoadProxies    = "failed to load proxies"
+	msgFailedToLoadGeoIP      = "failed to load geoip"
+	msgFailedToLoadTLS        = "failed to load tls"
+	msgFailedToLoadMitmCA     = "failed to load mitm ca"
 	msgWatchingProxyFile      = "watching proxy file"
 	msgMissingProxyFile       = "missing proxy file"
 	msgFailedToCheckProxies   = "failed to check proxies"
 	msgFailedToServeApi       = "failed to serve api"
 	msgFailedToListen         = "failed to listen"
 	msgReceivedSignal         = "received signal, shutting down..."
+	msgReloadingConfig        = "received SIGHUP, reloading config"
+	msgFailedToReloadConfig   = "failed to reload config"
+	msgFailedToReloadProxies  = "failed to reload proxies"
+	msgConfigReloaded         = "config reloaded successfully"
+	msgFailedToNotifySystemd  = "failed to notify systemd"
+	msgServiceCommandFailed   = "service command failed"
+	msgLowMemoryMode          = "low memory mode enabled"
+
+	defaultLowMemoryLimit = 64 // MiB
 )
 
 func main() {
@@ -37,24 +52,65 @@ func main() {
 		panic(err)
 	}
 
+	if cfgManager.BenchSelectors {
+		runBenchSelectors()
+		return
+	}
+
+	if cfgManager.Command != "" {
+		if err := runCommand(cfgManager); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", msgCommandFailed, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if cfgManager.Service != "" {
+		if err := runService(cfgManager); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", msgServiceCommandFailed, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	done := make(chan os.Signal, 1)
+	signal.Notify(done, syscall.SIGINT, syscall.SIGTERM)
+	run(cfgManager, done)
+}
+
+func run(cfgManager *config.ConfigManager, done chan os.Signal) {
 	logger, err := logging.NewLogger(cfgManager.Config)
 	if err != nil {
 		panic(err)
 	}
 	logger.Setup()
+	defer logger.Close()
 
 	cfg := cfgManager.Config
+	setupLowMemory(cfg)
 
 	proxyServer := proxy.NewProxyServer(cfg)
-	proxyLoader := proxy.NewProxyLoader(cfg, proxyServer)
-	err = proxyLoader.Load()
+	if err := proxyServer.LoadGeoIP(); err != nil {
+		slog.Error(msgFailedToLoadGeoIP, "error", err)
+		os.Exit(1)
+	}
+	if err := proxyServer.LoadTLS(); err != nil {
+		slog.Error(msgFailedToLoadTLS, "error", err)
+		os.Exit(1)
+	}
+	if err := proxyServer.LoadMitmCA(); err != nil {
+		slog.Error(msgFailedToLoadMitmCA, "error", err)
+		os.Exit(1)
+	}
+	proxyLoader := proxy.NewProxyLoader(proxyServer)
+	err = proxyLoader.LoadWithRetry()
 	if err != nil {
 		slog.Error(msgFailedToLoadProxies, "error", err)
 		os.Exit(1)
 	}
 
 	if cfgManager.Check {
-		proxyChecker := proxy.NewProxyChecker(cfg, proxyServer)
+		proxyChecker := proxy.NewProxyChecker(proxyServer)
 		err = proxyChecker.Check()
 		if err != nil {
 			slog.Error(msgFailedToCheckProxies, "error", err)
@@ -63,20 +119,28 @@ func main() {
 		return
 	}
 
-	done := make(chan os.Signal, 1)
-	signal.Notify(done, syscall.SIGINT, syscall.SIGTERM)
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
 
+	go runReloader(cfgManager, proxyServer, proxyLoader, reload)
 	go runFileWatcher(cfg, proxyLoader, done)
-	go runApi(cfg, proxyServer)
+	go proxyLoader.RunSources()
+	go proxy.NewProxyChecker(proxyServer).RunPeriodic()
+	go runApi(cfg, proxyServer, logger.Stream())
 	go proxyServer.Listen()
+	notify(systemd.Ready)
 
 	<-done
+	notify(systemd.Stopping)
 	slog.Info(msgReceivedSignal)
+	proxyServer.Shutdown()
 }
 
 func setupConfig() (*config.ConfigManager, error) {
 	configPath := flag.String("config", "config.yml", "config file path")
 	check := flag.Bool("check", false, "check proxies")
+	benchSelectors := flag.Bool("bench-selectors", false, "benchmark the rotation methods and exit")
+	service := flag.String("service", "", "windows service command (install, uninstall, run)")
 	flag.Parse()
 
 	configManager, err := config.NewConfigManager(*configPath)
@@ -85,9 +149,75 @@ func setupConfig() (*config.ConfigManager, error) {
 	}
 
 	configManager.Check = *check
+	configManager.BenchSelectors = *benchSelectors
+	configManager.Service = *service
+	if args := flag.Args(); len(args) > 0 {
+		configManager.Command = args[0]
+		configManager.Args = args[1:]
+	}
 	return configManager, nil
 }
 
+func setupLowMemory(cfg *config.Config) {
+	if !cfg.LowMemory.Enabled {
+		return
+	}
+
+	limit := cfg.LowMemory.MemoryLimit
+	if limit <= 0 {
+		limit = defaultLowMemoryLimit
+	}
+	// a soft limit, the GC runs more often as the heap approaches it
+	debug.SetMemoryLimit(int64(limit) << 20)
+	slog.Info(msgLowMemoryMode, "memory_limit", fmt.Sprintf("%dMiB", limit))
+}
+
+func runBenchSelectors() {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "METHOD\tPARALLEL\tSELECTIONS/SEC\tALLOCS/OP\tBYTES/OP\tMUTEX WAIT")
+	for _, result := range proxy.BenchSelectors() {
+		fmt.Fprintf(w, "%s\t%t\t%.0f\t%d\t%d\t%s\n",
+			result.Method,
+			result.Parallel,
+			result.SelectionsPerSec,
+			result.AllocsPerOp,
+			result.BytesPerOp,
+			result.MutexWait,
+		)
+	}
+	w.Flush()
+}
+
+func runReloader(cfgManager *config.ConfigManager, proxyServer *proxy.ProxyServer, proxyLoader *proxy.ProxyLoader, reload chan os.Signal) {
+	for range reload {
+		slog.Info(msgReloadingConfig)
+		notify(systemd.Reloading)
+		reloadConfig(cfgManager, proxyServer, proxyLoader)
+		notify(systemd.Ready)
+	}
+}
+
+func reloadConfig(cfgManager *config.ConfigManager, proxyServer *proxy.ProxyServer, proxyLoader *proxy.ProxyLoader) {
+	if err := cfgManager.Reload(); err != nil {
+		slog.Error(msgFailedToReloadConfig, "error", err)
+		return
+	}
+	proxyServer.SetConfig(cfgManager.Config)
+
+	if err := proxyLoader.Reload(); err != nil {
+		slog.Error(msgFailedToReloadProxies, "error", err)
+		return
+	}
+
+	slog.Info(msgConfigReloaded)
+}
+
+func notify(state string) {
+	if err := systemd.Notify(state); err != nil {
+		slog.Warn(msgFailedToNotifySystemd, "error", err, "state", state)
+	}
+}
+
 func runFileWatcher(cfg *config.Config, proxyLoader *proxy.ProxyLoader, done chan os.Signal) {
 	if !cfg.FileWatch {
 		return
@@ -100,18 +230,19 @@ func runFileWatcher(cfg *config.Config, proxyLoader *proxy.ProxyLoader, done cha
 		return
 	}
 
-	if err := fileWatcher.Watch(cfg.ProxyFile); err != nil {
+	if err := fileWatcher.Watch(proxyLoader.ProxyFiles()...); err != nil {
 		slog.Error(msgFailedToWatchProxyFile, "error", err)
 		done <- syscall.SIGTERM
 	}
 }
 
-func runApi(cfg *config.Config, proxyServer *proxy.ProxyServer) {
+func runApi(cfg *config.Config, proxyServer *proxy.ProxyServer, logs *logging.Stream) {
 	if !cfg.Api.Enabled {
 		return
 	}
 
-	api := api.NewApi(cfg, proxyServer)
+	api := api.NewApi(proxyServer)
+	api.SetLogStream(logs)
 	err := api.Serve()
 	if err != nil {
 		slog.Error(msgFailedToServeApi, "error", err)
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+
+	"github.com/alpkeskin/rota/internal/config"
+)
+
+const msgServiceUnsupported = "windows service commands are only supported on windows"
+
+func runService(cfgManager *config.ConfigManager) error {
+	return errors.New(msgServiceUnsupported)
+}
@@ -0,0 +1,131 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const (
+	serviceName        = "rota"
+	serviceDisplayName = "Rota Proxy"
+	serviceDescription = "Rota proxy rotation server"
+
+	msgServiceExists         = "service already installed"
+	msgUnknownServiceCommand = "unknown service command, use install, uninstall or run"
+)
+
+type service struct {
+	cfgManager *config.ConfigManager
+}
+
+func runService(cfgManager *config.ConfigManager) error {
+	switch cfgManager.Service {
+	case "install":
+		return installService(cfgManager.Path())
+	case "uninstall":
+		return uninstallService()
+	case "run":
+		// services start in the system directory, relative paths in the config are relative to the config file
+		if err := os.Chdir(filepath.Dir(cfgManager.Path())); err != nil {
+			return err
+		}
+		return svc.Run(serviceName, &service{cfgManager: cfgManager})
+	}
+	return errors.New(msgUnknownServiceCommand)
+}
+
+func (s *service) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+
+	done := make(chan os.Signal, 1)
+	stopped := make(chan struct{})
+	go func() {
+		run(s.cfgManager, done)
+		close(stopped)
+	}()
+
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case <-stopped:
+			return false, 0
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				status <- request.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				done <- syscall.SIGTERM
+				<-stopped
+				return false, 0
+			}
+		}
+	}
+}
+
+func installService(configPath string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	configPath, err = filepath.Abs(configPath)
+	if err != nil {
+		return err
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return fmt.Errorf("%s: %s", msgServiceExists, serviceName)
+	}
+
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: serviceDisplayName,
+		Description: serviceDescription,
+		StartType:   mgr.StartAutomatic,
+	}, "--service", "run", "--config", configPath)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	if err := eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		_ = s.Delete()
+		return err
+	}
+	return nil
+}
+
+func uninstallService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	if err := s.Delete(); err != nil {
+		return err
+	}
+	return eventlog.Remove(serviceName)
+}
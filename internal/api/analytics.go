@@ -0,0 +1,260 @@
+package api
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/proxy"
+)
+
+const (
+	msgAnalyticsRequested     = "analytics requested"
+	msgInvalidAnalyticsQuery  = "invalid analytics query"
+	msgFailedToWriteAnalytics = "failed to write analytics"
+
+	defaultAnalyticsWindow = time.Hour
+	defaultAnalyticsLimit  = 10
+	maxAnalyticsLimit      = 100
+	analyticsBreakdown     = 5
+)
+
+// analyticsCount is the number of failed attempts of one proxy, domain or error class
+type analyticsCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type domainStats struct {
+	Domain    string           `json:"domain"`
+	Requests  int              `json:"requests"`
+	Errors    int              `json:"errors"`
+	ErrorRate float64          `json:"error_rate"`
+	Proxies   []analyticsCount `json:"proxies"`
+	Classes   []analyticsCount `json:"classes"`
+}
+
+type errorStats struct {
+	Class   string           `json:"class"`
+	Count   int              `json:"count"`
+	Proxies []analyticsCount `json:"proxies"`
+	Domains []analyticsCount `json:"domains"`
+}
+
+type analyticsResponse struct {
+	Since    time.Time `json:"since"`
+	Until    time.Time `json:"until"`
+	Requests int       `json:"requests"`
+	Items    any       `json:"items"`
+}
+
+// handleTopDomains ranks the requested hosts by attempts in the window, with the proxies that failed them most
+func (a *Api) handleTopDomains(w http.ResponseWriter, r *http.Request) {
+	a.handleAnalytics(w, r, func(records []proxy.RequestRecord, limit int) any {
+		return topDomains(records, limit)
+	})
+}
+
+// handleErrorBreakdown counts the failed attempts in the window by error class, with the proxies and hosts behind them
+func (a *Api) handleErrorBreakdown(w http.ResponseWriter, r *http.Request) {
+	a.handleAnalytics(w, r, func(records []proxy.RequestRecord, limit int) any {
+		return errorBreakdown(records, limit)
+	})
+}
+
+func (a *Api) handleAnalytics(w http.ResponseWriter, r *http.Request, aggregate func([]proxy.RequestRecord, int) any) {
+	rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	w = rw
+
+	defer func() {
+		slog.Info(msgAnalyticsRequested,
+			"status", rw.statusCode,
+			"method", r.Method,
+			"url", r.URL.String(),
+			"ip", r.RemoteAddr,
+		)
+	}()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, limit, err := parseAnalyticsQuery(r.URL.Query(), time.Now())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("%s: %v", msgInvalidAnalyticsQuery, err), http.StatusBadRequest)
+		return
+	}
+	if tenant := tenantOf(r); tenant != "" {
+		filter.Tenant = tenant
+	}
+
+	records, total := a.proxyServer.Requests(filter)
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(analyticsResponse{
+		Since:    filter.Since,
+		Until:    filter.Until,
+		Requests: total,
+		Items:    aggregate(records, limit),
+	})
+	if err != nil {
+		slog.Error(msgFailedToWriteAnalytics, "error", err)
+		http.Error(w, msgFailedToWriteAnalytics, http.StatusInternalServerError)
+		return
+	}
+}
+
+// parseAnalyticsQuery reads the window, since and until bound it exactly and window counts back from until or now
+func parseAnalyticsQuery(query url.Values, now time.Time) (proxy.RequestFilter, int, error) {
+	filter := proxy.RequestFilter{
+		Proxy:      query.Get("proxy"),
+		Credential: query.Get("credential"),
+		Tenant:     query.Get("tenant"),
+		Until:      now,
+	}
+
+	if value := query.Get("until"); value != "" {
+		until, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return filter, 0, fmt.Errorf("until: %w", err)
+		}
+		filter.Until = until
+	}
+	window := defaultAnalyticsWindow
+	if value := query.Get("window"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return filter, 0, errors.New("window: must be a positive duration such as 15m or 24h")
+		}
+		window = d
+	}
+	filter.Since = filter.Until.Add(-window)
+	if value := query.Get("since"); value != "" {
+		since, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return filter, 0, fmt.Errorf("since: %w", err)
+		}
+		filter.Since = since
+	}
+	if filter.Since.After(filter.Until) {
+		return filter, 0, errors.New("since: must not be after until")
+	}
+
+	limit := defaultAnalyticsLimit
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return filter, 0, errors.New("limit: must be a positive integer")
+		}
+		limit = min(n, maxAnalyticsLimit)
+	}
+	return filter, limit, nil
+}
+
+// topDomains groups attempts by requested host, busiest first
+func topDomains(records []proxy.RequestRecord, limit int) []domainStats {
+	type domain struct {
+		requests int
+		errors   int
+		proxies  map[string]int
+		classes  map[string]int
+	}
+	domains := make(map[string]*domain)
+	for _, record := range records {
+		name := recordDomain(record)
+		d, ok := domains[name]
+		if !ok {
+			d = &domain{proxies: make(map[string]int), classes: make(map[string]int)}
+			domains[name] = d
+		}
+		d.requests++
+		if class := record.ErrorClass; class != "" {
+			d.errors++
+			d.proxies[record.Proxy]++
+			d.classes[class]++
+		}
+	}
+
+	stats := make([]domainStats, 0, len(domains))
+	for name, d := range domains {
+		stats = append(stats, domainStats{
+			Domain:    name,
+			Requests:  d.requests,
+			Errors:    d.errors,
+			ErrorRate: float64(d.errors) / float64(d.requests),
+			Proxies:   topCounts(d.proxies, analyticsBreakdown),
+			Classes:   topCounts(d.classes, analyticsBreakdown),
+		})
+	}
+	slices.SortFunc(stats, func(a, b domainStats) int {
+		return cmp.Or(cmp.Compare(b.Requests, a.Requests), cmp.Compare(b.Errors, a.Errors), strings.Compare(a.Domain, b.Domain))
+	})
+	return stats[:min(len(stats), limit)]
+}
+
+// errorBreakdown groups failed attempts by error class, most frequent first
+func errorBreakdown(records []proxy.RequestRecord, limit int) []errorStats {
+	type class struct {
+		count   int
+		proxies map[string]int
+		domains map[string]int
+	}
+	classes := make(map[string]*class)
+	for _, record := range records {
+		name := record.ErrorClass
+		if name == "" {
+			continue
+		}
+		c, ok := classes[name]
+		if !ok {
+			c = &class{proxies: make(map[string]int), domains: make(map[string]int)}
+			classes[name] = c
+		}
+		c.count++
+		c.proxies[record.Proxy]++
+		c.domains[recordDomain(record)]++
+	}
+
+	stats := make([]errorStats, 0, len(classes))
+	for name, c := range classes {
+		stats = append(stats, errorStats{
+			Class:   name,
+			Count:   c.count,
+			Proxies: topCounts(c.proxies, limit),
+			Domains: topCounts(c.domains, limit),
+		})
+	}
+	slices.SortFunc(stats, func(a, b errorStats) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), strings.Compare(a.Class, b.Class))
+	})
+	return stats
+}
+
+// recordDomain is the host of the requested url without its port
+func recordDomain(record proxy.RequestRecord) string {
+	u, err := url.Parse(record.URL)
+	if err != nil || u.Hostname() == "" {
+		return record.URL
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+func topCounts(counts map[string]int, limit int) []analyticsCount {
+	top := make([]analyticsCount, 0, len(counts))
+	for _, name := range slices.Sorted(maps.Keys(counts)) {
+		top = append(top, analyticsCount{Name: name, Count: counts[name]})
+	}
+	slices.SortStableFunc(top, func(a, b analyticsCount) int {
+		return cmp.Compare(b.Count, a.Count)
+	})
+	return top[:min(len(top), limit)]
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/alpkeskin/rota/internal/proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleAnalytics(t *testing.T) {
+	cfg := &config.Config{History: config.HistoryConfig{Enabled: true}}
+	mux := NewApi(proxy.NewProxyServer(cfg)).routes()
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		wantStatus int
+	}{
+		{"top domains", http.MethodGet, "/analytics/top-domains", http.StatusOK},
+		{"errors with window", http.MethodGet, "/analytics/errors?window=24h&proxy=a:1&limit=3", http.StatusOK},
+		{"invalid window", http.MethodGet, "/analytics/errors?window=-1h", http.StatusBadRequest},
+		{"since after until", http.MethodGet, "/analytics/top-domains?since=2024-01-02T00:00:00Z&until=2024-01-01T00:00:00Z", http.StatusBadRequest},
+		{"invalid limit", http.MethodGet, "/analytics/top-domains?limit=0", http.StatusBadRequest},
+		{"method not allowed", http.MethodPost, "/analytics/errors", http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, nil))
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var response struct {
+				Requests int   `json:"requests"`
+				Items    []any `json:"items"`
+			}
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+			assert.Zero(t, response.Requests)
+			assert.NotNil(t, response.Items)
+		})
+	}
+
+	w := httptest.NewRecorder()
+	NewApi(proxy.NewProxyServer(&config.Config{})).routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/analytics/errors", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestParseAnalyticsQuery(t *testing.T) {
+	now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+
+	filter, limit, err := parseAnalyticsQuery(map[string][]string{}, now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(-defaultAnalyticsWindow), filter.Since)
+	assert.Equal(t, now, filter.Until)
+	assert.Equal(t, defaultAnalyticsLimit, limit)
+
+	filter, limit, err = parseAnalyticsQuery(map[string][]string{"until": {"2024-01-01T00:00:00Z"}, "window": {"30m"}, "limit": {"500"}}, now)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2023, 12, 31, 23, 30, 0, 0, time.UTC), filter.Since)
+	assert.Equal(t, maxAnalyticsLimit, limit)
+
+	filter, _, err = parseAnalyticsQuery(map[string][]string{"since": {"2024-01-01T00:00:00Z"}, "window": {"5m"}}, now)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), filter.Since)
+}
+
+func TestAnalyticsAggregation(t *testing.T) {
+	records := []proxy.RequestRecord{
+		{Proxy: "a:1", URL: "https://example.com/", StatusCode: http.StatusOK},
+		{Proxy: "a:1", URL: "https://Example.com:443/login", StatusCode: http.StatusForbidden, ErrorClass: proxy.ErrorClassForbidden},
+		{Proxy: "b:1", URL: "https://example.com/", Error: "i/o timeout", ErrorClass: proxy.ErrorClassTimeout},
+		{Proxy: "b:1", URL: "https://example.com/", Error: "i/o timeout", ErrorClass: proxy.ErrorClassTimeout},
+		{Proxy: "b:1", URL: "http://api.example.org/v1", Error: "i/o timeout", ErrorClass: proxy.ErrorClassTimeout},
+		{Proxy: "a:1", URL: "http://api.example.org/v1", StatusCode: http.StatusOK},
+		{Proxy: "c:1", URL: "http://static.example.net/", StatusCode: http.StatusOK},
+	}
+
+	domains := topDomains(records, 2)
+	require.Len(t, domains, 2)
+	assert.Equal(t, domainStats{
+		Domain:    "example.com",
+		Requests:  4,
+		Errors:    3,
+		ErrorRate: 0.75,
+		Proxies:   []analyticsCount{{"b:1", 2}, {"a:1", 1}},
+		Classes:   []analyticsCount{{proxy.ErrorClassTimeout, 2}, {proxy.ErrorClassForbidden, 1}},
+	}, domains[0])
+	assert.Equal(t, "api.example.org", domains[1].Domain)
+	assert.Equal(t, 0.5, domains[1].ErrorRate)
+
+	breakdown := errorBreakdown(records, 1)
+	require.Len(t, breakdown, 2)
+	assert.Equal(t, errorStats{
+		Class:   proxy.ErrorClassTimeout,
+		Count:   3,
+		Proxies: []analyticsCount{{"b:1", 3}},
+		Domains: []analyticsCount{{"example.com", 2}},
+	}, breakdown[0])
+	assert.Equal(t, proxy.ErrorClassForbidden, breakdown[1].Class)
+	assert.Equal(t, 1, breakdown[1].Count)
+}
@@ -6,35 +6,73 @@ import (
 	"log/slog"
 	"net/http"
 	"runtime"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/alpkeskin/rota/internal/config"
+	"github.com/alpkeskin/rota/internal/logging"
 	"github.com/alpkeskin/rota/internal/proxy"
+	"github.com/alpkeskin/rota/internal/stats"
+	"github.com/alpkeskin/rota/pkg/systemd"
+	"github.com/alpkeskin/rota/pkg/unixsocket"
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/disk"
 	"github.com/shirou/gopsutil/v3/mem"
 )
 
 const (
-	msgApiServerStarted         = "API server started"
-	msgCertRequested            = "cert requested"
-	msgFailedToCreateCert       = "failed to create cert"
-	msgFailedToWriteCert        = "failed to write cert"
-	msgMethodNotAllowed         = "method not allowed"
-	msgFailedToCollectMetrics   = "failed to collect metrics"
-	msgFailedToWriteMetrics     = "failed to write metrics"
-	msgFailedToWriteHealthcheck = "failed to write healthcheck"
-	msgFailedToReadProxies      = "failed to read proxies"
-	msgFailedToWriteProxies     = "failed to write proxies"
-	msgHealthcheckRequested     = "healthcheck requested"
-	msgProxiesRequested         = "proxies requested"
-	msgMetricsRequested         = "metrics requested"
+	msgApiServerStarted          = "API server started"
+	msgCertRequested             = "cert requested"
+	msgFailedToCreateCert        = "failed to create cert"
+	msgFailedToWriteCert         = "failed to write cert"
+	msgMethodNotAllowed          = "method not allowed"
+	msgFailedToCollectMetrics    = "failed to collect metrics"
+	msgFailedToWriteMetrics      = "failed to write metrics"
+	msgFailedToWriteHealthcheck  = "failed to write healthcheck"
+	msgFailedToReadProxies       = "failed to read proxies"
+	msgFailedToWriteProxies      = "failed to write proxies"
+	msgHealthcheckRequested      = "healthcheck requested"
+	msgProxiesRequested          = "proxies requested"
+	msgMetricsRequested          = "metrics requested"
+	msgReadinessRequested        = "readiness requested"
+	msgFailedToWriteReadiness    = "failed to write readiness"
+	msgFeaturesRequested         = "features requested"
+	msgFailedToWriteFeatures     = "failed to write features"
+	msgInvalidFeatureRequest     = "invalid feature request"
+	msgFeatureUpdated            = "feature updated"
+	msgNextProxyRequested        = "next proxy requested"
+	msgFailedToWriteNextProxy    = "failed to write next proxy"
+	msgNoProxyAvailable          = "no proxy available"
+	msgInvalidPreviewRequest     = "invalid preview request"
+	msgRotateRequested           = "rotation requested"
+	msgFailedToWriteRotation     = "failed to write rotation"
+	msgPrometheusRequested       = "prometheus metrics requested"
+	msgProxyTagsRequested        = "proxy tags requested"
+	msgInvalidTagsRequest        = "invalid tags request"
+	msgProxiesTagged             = "proxies tagged"
+	msgFailedToWriteTags         = "failed to write tags"
+	msgSourcesRequested          = "sources requested"
+	msgHealthchecksRequested     = "healthchecks requested"
+	msgFailedToWriteHealthchecks = "failed to write healthchecks"
+	msgFailedToWriteSources      = "failed to write sources"
+
+	prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+	statusHealthy  = "healthy"
+	statusDegraded = "degraded"
+	statusReady    = "ready"
+	statusNotReady = "not ready"
+	statusStopping = "shutting down"
 )
 
 type Api struct {
-	cfg         *config.Config
 	proxyServer *proxy.ProxyServer
 	startTime   time.Time
+	auth        *authenticator
+	audit       *auditLog
+	logs        *logging.Stream
 }
 
 type responseWriter struct {
@@ -47,6 +85,12 @@ type metrics struct {
 	Status    string  `json:"status"`
 	Uptime    float64 `json:"uptime"`
 
+	// Proxy pool metrics
+	Proxies  int   `json:"proxies"`
+	Degraded bool  `json:"degraded"`
+	InFlight int64 `json:"in_flight"`
+	Tunnels  int64 `json:"tunnels"`
+
 	// Memory metrics
 	TotalMemory uint64  `json:"total_memory_mb"`
 	UsedMemory  uint64  `json:"used_memory_mb"`
@@ -66,23 +110,103 @@ type metrics struct {
 	GCPauses    uint32 `json:"gc_pauses"`
 }
 
-func NewApi(cfg *config.Config, proxyServer *proxy.ProxyServer) *Api {
-	return &Api{cfg: cfg, proxyServer: proxyServer, startTime: time.Now()}
+func NewApi(proxyServer *proxy.ProxyServer) *Api {
+	cfg := proxyServer.Config()
+	api := &Api{proxyServer: proxyServer, startTime: time.Now()}
+	if cfg.Api.Authentication.Enabled {
+		api.auth = newAuthenticator(cfg.Api.Authentication)
+	}
+	if cfg.Api.Audit.Enabled {
+		api.audit = newAuditLog(cfg.Api.Audit.Size, cfg.Api.Audit.File)
+	}
+	return api
 }
 
-func (a *Api) Serve() error {
+// config is the config of the proxy server, handlers load it once so a reload does not change it halfway through
+func (a *Api) config() *config.Config {
+	return a.proxyServer.Config()
+}
+
+func (a *Api) routes() *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/metrics", a.handleMetrics)
+	mux.HandleFunc("/metrics/prometheus", a.handlePrometheus)
+	mux.HandleFunc("/sse/dashboard", a.requireRole(roleViewer, roleViewer, a.handleDashboardStream))
+	mux.HandleFunc("/sse/logs", a.requireRole(roleOperator, roleOperator, a.handleLogStream))
+	mux.HandleFunc("/sse/proxies/test", a.requireRole(roleViewer, roleViewer, a.handleProxyTestStream))
 	mux.HandleFunc("/healthz", a.handleHealthcheck)
-	mux.HandleFunc("/proxies", a.handleProxies)
+	mux.HandleFunc("/readyz", a.handleReadiness)
+	mux.HandleFunc("/proxies", a.requireScoped(roleViewer, roleOperator, a.handleProxies))
+	mux.HandleFunc("/proxies/tags", a.requireRole(roleOperator, roleOperator, a.handleProxyTags))
+	mux.HandleFunc("/proxies/export", a.requireRole(roleOperator, roleOperator, a.handleProxyExport))
+	mux.HandleFunc("/proxies/bulk", a.requireRole(roleOperator, roleOperator, a.handleProxyBulk))
+	mux.HandleFunc("/proxies/in-flight", a.requireScoped(roleViewer, roleViewer, a.handleInFlight))
+	mux.HandleFunc("/proxies/latency", a.requireScoped(roleViewer, roleViewer, a.handleProxyLatency))
+	mux.HandleFunc("/proxies/drain", a.requireRole(roleViewer, roleOperator, a.handleProxyDrain))
+	mux.HandleFunc("/proxies/test", a.requireRole(roleViewer, roleOperator, a.handleProxyTest))
+	mux.HandleFunc("/sources", a.requireRole(roleViewer, roleOperator, a.handleSources))
+	mux.HandleFunc("/mirror", a.requireRole(roleViewer, roleViewer, a.handleMirror))
+	mux.HandleFunc("/healthcheck", a.requireRole(roleViewer, roleOperator, a.handleHealthchecks))
+	mux.HandleFunc("/healthcheck/pause", a.requireRole(roleOperator, roleOperator, a.handleHealthchecks))
+	mux.HandleFunc("/healthcheck/resume", a.requireRole(roleOperator, roleOperator, a.handleHealthchecks))
+	mux.HandleFunc("/features", a.requireRole(roleViewer, roleAdmin, a.handleFeatures))
+	mux.HandleFunc("/features/history", a.requireRole(roleViewer, roleAdmin, a.handleFeatureHistory))
+	mux.HandleFunc("/features/rollback", a.requireAdmin(a.handleFeatureRollback))
+	mux.HandleFunc(credentialsPath, a.requireScoped(roleAdmin, roleAdmin, a.handleCredentials))
+	mux.HandleFunc(credentialsPath+"/", a.requireScoped(roleAdmin, roleAdmin, a.handleCredentials))
+	mux.HandleFunc("/rotation/next", a.requireRole(roleOperator, roleOperator, a.handleNextProxy))
+	mux.HandleFunc("/rotation/rotate", a.requireRole(roleOperator, roleOperator, a.handleRotate))
+	mux.HandleFunc("/tenants", a.requireScoped(roleViewer, roleAdmin, a.handleTenants))
+	mux.HandleFunc("/backup", a.requireAdmin(a.handleBackup))
+	mux.HandleFunc("/restore", a.requireAdmin(a.handleRestore))
+	if a.config().History.Enabled {
+		mux.HandleFunc("/requests", a.requireScoped(roleViewer, roleAdmin, a.handleRequests))
+		mux.HandleFunc("/analytics/top-domains", a.requireScoped(roleViewer, roleAdmin, a.handleTopDomains))
+		mux.HandleFunc("/analytics/errors", a.requireScoped(roleViewer, roleAdmin, a.handleErrorBreakdown))
+	}
+	if a.audit != nil {
+		mux.HandleFunc("/audit", a.requireAdmin(a.handleAudit))
+	}
+	if a.auth != nil {
+		mux.HandleFunc("/auth/token", a.handleToken)
+		mux.HandleFunc("/auth/refresh", a.handleRefresh)
+		mux.HandleFunc("/auth/rotate-secret", a.requireAdmin(a.handleRotateSecret))
+	}
+	return mux
+}
+
+func (a *Api) Serve() error {
+	cfg := a.config().Api
 	server := &http.Server{
-		Addr:    fmt.Sprintf(":%d", a.cfg.Api.Port),
-		Handler: mux,
+		Addr:    fmt.Sprintf(":%d", cfg.Port),
+		Handler: a.routes(),
+	}
+
+	errs := make(chan error, 2)
+	if cfg.Socket != "" {
+		listener, err := unixsocket.Listen(cfg.Socket)
+		if err != nil {
+			return err
+		}
+		slog.Info(msgApiServerStarted, "socket", cfg.Socket)
+		go func() { errs <- server.Serve(listener) }()
 	}
 
-	slog.Info(msgApiServerStarted, "port", a.cfg.Api.Port)
+	activated, err := systemd.Listener(systemd.ApiSocket)
+	if err != nil {
+		return err
+	}
+	switch {
+	case activated != nil:
+		// a socket passed by systemd replaces the configured port
+		slog.Info(msgApiServerStarted, "systemd", activated.Addr().String())
+		go func() { errs <- server.Serve(activated) }()
+	case cfg.Port > 0 || cfg.Socket == "":
+		slog.Info(msgApiServerStarted, "port", cfg.Port)
+		go func() { errs <- server.ListenAndServe() }()
+	}
 
-	return server.ListenAndServe()
+	return <-errs
 }
 
 func (rw *responseWriter) WriteHeader(code int) {
@@ -90,6 +214,11 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Unwrap lets http.ResponseController reach the flusher of streaming handlers
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 func (a *Api) handleMetrics(w http.ResponseWriter, r *http.Request) {
 	rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
 	w = rw
@@ -108,7 +237,7 @@ func (a *Api) handleMetrics(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	metrics, err := collectMetrics()
+	metrics, err := a.metrics()
 	if err != nil {
 		slog.Error(msgFailedToCollectMetrics, "error", err)
 		http.Error(w, msgFailedToCollectMetrics, http.StatusInternalServerError)
@@ -124,6 +253,45 @@ func (a *Api) handleMetrics(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (a *Api) handlePrometheus(w http.ResponseWriter, r *http.Request) {
+	rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	w = rw
+
+	defer func() {
+		slog.Debug(msgPrometheusRequested,
+			"status", rw.statusCode,
+			"method", r.Method,
+			"url", r.URL.String(),
+			"ip", r.RemoteAddr,
+		)
+	}()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	degraded := 0.0
+	if a.proxyServer.IsDegraded() {
+		degraded = 1
+	}
+
+	w.Header().Set("Content-Type", prometheusContentType)
+	for _, err := range []error{
+		a.proxyServer.Stats().WritePrometheus(w),
+		stats.WriteGauge(w, "rota_proxies", "Proxies in the pool.", float64(a.proxyServer.ProxyCount())),
+		stats.WriteGauge(w, "rota_sessions", "Sessions pinned to a proxy by username directives.", float64(a.proxyServer.SessionCount())),
+		stats.WriteGauge(w, "rota_degraded", "1 while serving the previous proxy snapshot after a failed reload.", degraded),
+		stats.WriteGauge(w, "rota_uptime_seconds", "Seconds since the API server started.", time.Since(a.startTime).Seconds()),
+		stats.WriteGauge(w, "rota_goroutines", "Running goroutines.", float64(runtime.NumGoroutine())),
+	} {
+		if err != nil {
+			slog.Error(msgFailedToWriteMetrics, "error", err)
+			return
+		}
+	}
+}
+
 func (a *Api) handleHealthcheck(w http.ResponseWriter, r *http.Request) {
 	rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
 	w = rw
@@ -150,7 +318,7 @@ func (a *Api) handleHealthcheck(w http.ResponseWriter, r *http.Request) {
 		int(duration.Seconds())%60,
 	)
 	response := map[string]any{
-		"status":    "healthy",
+		"status":    a.status(),
 		"timestamp": time.Now().Format(time.RFC3339),
 		"uptime":    uptime,
 		"coffee":    "☕",
@@ -184,16 +352,74 @@ func (a *Api) handleProxies(w http.ResponseWriter, r *http.Request) {
 	}
 
 	type proxyResponse struct {
-		Scheme string `json:"scheme"`
-		Host   string `json:"host"`
+		Scheme        string                   `json:"scheme"`
+		Host          string                   `json:"host"`
+		Pool          string                   `json:"pool"`
+		Tags          []string                 `json:"tags"`
+		Circuit       string                   `json:"circuit"`
+		State         string                   `json:"state"`
+		StateChanged  *time.Time               `json:"state_changed_at,omitempty"`
+		LastCheck     *time.Time               `json:"last_check,omitempty"`
+		AvgResponse   int64                    `json:"avg_response_time"`
+		ResponseTime  proxy.LatencyPercentiles `json:"response_time"`
+		BytesSent     uint64                   `json:"bytes_sent"`
+		BytesReceived uint64                   `json:"bytes_received"`
+		Credentials   []proxy.CredentialUsage  `json:"credentials,omitempty"`
+		proxy.GeoLocation
 	}
 
-	responses := make([]proxyResponse, len(a.proxyServer.Proxies))
-	for i, p := range a.proxyServer.Proxies {
-		responses[i] = proxyResponse{
-			Scheme: p.Scheme,
-			Host:   p.Host,
+	query := r.URL.Query()
+	tag := strings.ToLower(query.Get("tag"))
+	country := query.Get("country")
+	asn := query.Get("asn")
+	state := query.Get("state")
+	pool := a.tenantPool(r)
+	proxies := a.proxyServer.GetProxies()
+	responses := make([]proxyResponse, 0, len(proxies))
+	for _, p := range proxies {
+		if pool != "" && p.Pool != pool {
+			continue
+		}
+		tags := a.proxyServer.ProxyTags(p)
+		if tag != "" && !slices.Contains(tags, tag) {
+			continue
+		}
+		location := a.proxyServer.ProxyLocation(p)
+		if country != "" && !strings.EqualFold(country, location.Country) {
+			continue
 		}
+		if asn != "" && asn != strconv.FormatUint(uint64(location.ASN), 10) {
+			continue
+		}
+		proxyState, changed := a.proxyServer.ProxyState(p)
+		if state != "" && state != proxyState {
+			continue
+		}
+		var stateChanged *time.Time
+		if !changed.IsZero() {
+			stateChanged = &changed
+		}
+		var lastCheck *time.Time
+		if checked := a.proxyServer.ProxyLastCheck(p); !checked.IsZero() {
+			lastCheck = &checked
+		}
+		sent, received := a.proxyServer.ProxyBandwidth(p)
+		responses = append(responses, proxyResponse{
+			Scheme:        p.Scheme,
+			Host:          p.Host,
+			Pool:          p.Pool,
+			Tags:          tags,
+			Circuit:       a.proxyServer.CircuitState(p),
+			State:         proxyState,
+			StateChanged:  stateChanged,
+			LastCheck:     lastCheck,
+			AvgResponse:   a.proxyServer.ProxyLatency(p).Milliseconds(),
+			ResponseTime:  a.proxyServer.ProxyLatencyPercentiles(p, proxy.LatencyHours),
+			BytesSent:     sent,
+			BytesReceived: received,
+			Credentials:   a.proxyServer.ProxyCredentials(p),
+			GeoLocation:   location,
+		})
 	}
 
 	jsonProxies, err := json.Marshal(responses)
@@ -212,10 +438,317 @@ func (a *Api) handleProxies(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (a *Api) handleProxyTags(w http.ResponseWriter, r *http.Request) {
+	rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	w = rw
+
+	defer func() {
+		slog.Info(msgProxyTagsRequested,
+			"status", rw.statusCode,
+			"method", r.Method,
+			"url", r.URL.String(),
+			"ip", r.RemoteAddr,
+		)
+	}()
+
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		Proxies []string `json:"proxies"`
+		Pool    string   `json:"pool"`
+		Tags    []string `json:"tags"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.Tags) == 0 || (len(request.Proxies) == 0 && request.Pool == "") {
+		http.Error(w, msgInvalidTagsRequest, http.StatusBadRequest)
+		return
+	}
+
+	remove := r.Method == http.MethodDelete
+	updated := a.proxyServer.TagProxies(request.Proxies, request.Pool, request.Tags, remove)
+	slog.Info(msgProxiesTagged, "tags", request.Tags, "updated", updated, "remove", remove)
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(map[string]int{"updated": updated})
+	if err != nil {
+		slog.Error(msgFailedToWriteTags, "error", err)
+		http.Error(w, msgFailedToWriteTags, http.StatusInternalServerError)
+		return
+	}
+}
+
+func (a *Api) handleSources(w http.ResponseWriter, r *http.Request) {
+	rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	w = rw
+
+	defer func() {
+		slog.Info(msgSourcesRequested,
+			"status", rw.statusCode,
+			"method", r.Method,
+			"url", r.URL.String(),
+			"ip", r.RemoteAddr,
+		)
+	}()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(a.proxyServer.Sources())
+	if err != nil {
+		slog.Error(msgFailedToWriteSources, "error", err)
+		http.Error(w, msgFailedToWriteSources, http.StatusInternalServerError)
+		return
+	}
+}
+
+func (a *Api) handleHealthchecks(w http.ResponseWriter, r *http.Request) {
+	rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	w = rw
+
+	defer func() {
+		slog.Info(msgHealthchecksRequested,
+			"status", rw.statusCode,
+			"method", r.Method,
+			"url", r.URL.String(),
+			"ip", r.RemoteAddr,
+		)
+	}()
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/healthcheck":
+	case r.Method == http.MethodPost && r.URL.Path == "/healthcheck/pause":
+		a.proxyServer.PauseHealthchecks(true)
+	case r.Method == http.MethodPost && r.URL.Path == "/healthcheck/resume":
+		a.proxyServer.PauseHealthchecks(false)
+	default:
+		http.Error(w, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(a.proxyServer.HealthcheckStatus())
+	if err != nil {
+		slog.Error(msgFailedToWriteHealthchecks, "error", err)
+		http.Error(w, msgFailedToWriteHealthchecks, http.StatusInternalServerError)
+		return
+	}
+}
+
+func (a *Api) handleReadiness(w http.ResponseWriter, r *http.Request) {
+	rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	w = rw
+
+	defer func() {
+		slog.Info(msgReadinessRequested,
+			"status", rw.statusCode,
+			"method", r.Method,
+			"url", r.URL.String(),
+			"ip", r.RemoteAddr,
+		)
+	}()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	proxies := a.proxyServer.ProxyCount()
+	degraded := a.proxyServer.IsDegraded()
+
+	status := statusReady
+	statusCode := http.StatusOK
+	switch {
+	// a load balancer stops sending new clients while the open ones finish
+	case a.proxyServer.ShuttingDown():
+		status = statusStopping
+		statusCode = http.StatusServiceUnavailable
+	case proxies == 0:
+		status = statusNotReady
+		statusCode = http.StatusServiceUnavailable
+	case degraded:
+		status = statusDegraded
+	}
+
+	response := map[string]any{
+		"status":    status,
+		"timestamp": time.Now().Format(time.RFC3339),
+		"proxies":   proxies,
+		"degraded":  degraded,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		slog.Error(msgFailedToWriteReadiness, "error", err)
+		return
+	}
+}
+
+func (a *Api) handleFeatures(w http.ResponseWriter, r *http.Request) {
+	rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	w = rw
+
+	defer func() {
+		slog.Info(msgFeaturesRequested,
+			"status", rw.statusCode,
+			"method", r.Method,
+			"url", r.URL.String(),
+			"ip", r.RemoteAddr,
+		)
+	}()
+
+	flags := a.proxyServer.Features()
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var request struct {
+			Name    string `json:"name"`
+			Enabled *bool  `json:"enabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Name == "" || request.Enabled == nil {
+			http.Error(w, msgInvalidFeatureRequest, http.StatusBadRequest)
+			return
+		}
+		flags.SetBy(request.Name, *request.Enabled, actor(r))
+		slog.Info(msgFeatureUpdated, "feature", request.Name, "enabled", *request.Enabled, "actor", actor(r))
+	default:
+		http.Error(w, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(flags.All())
+	if err != nil {
+		slog.Error(msgFailedToWriteFeatures, "error", err)
+		http.Error(w, msgFailedToWriteFeatures, http.StatusInternalServerError)
+		return
+	}
+}
+
+func (a *Api) handleNextProxy(w http.ResponseWriter, r *http.Request) {
+	rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	w = rw
+
+	defer func() {
+		slog.Info(msgNextProxyRequested,
+			"status", rw.statusCode,
+			"method", r.Method,
+			"url", r.URL.String(),
+			"ip", r.RemoteAddr,
+		)
+	}()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	preview := proxy.Preview{
+		Host:     query.Get("host"),
+		Username: query.Get("username"),
+		Session:  query.Get("session"),
+	}
+	if value := query.Get("listener"); value != "" {
+		port, err := strconv.Atoi(value)
+		if err != nil || port <= 0 {
+			http.Error(w, msgInvalidPreviewRequest, http.StatusBadRequest)
+			return
+		}
+		preview.Listener = port
+	}
+
+	next, rotation, err := a.proxyServer.PeekProxy(preview)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if next == nil {
+		http.Error(w, msgNoProxyAvailable, http.StatusServiceUnavailable)
+		return
+	}
+
+	response := map[string]any{
+		"method": rotation.Method,
+		"scheme": next.Scheme,
+		"host":   next.Host,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		slog.Error(msgFailedToWriteNextProxy, "error", err)
+		http.Error(w, msgFailedToWriteNextProxy, http.StatusInternalServerError)
+		return
+	}
+}
+
+func (a *Api) handleRotate(w http.ResponseWriter, r *http.Request) {
+	rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	w = rw
+
+	defer func() {
+		slog.Info(msgRotateRequested,
+			"status", rw.statusCode,
+			"method", r.Method,
+			"url", r.URL.String(),
+			"ip", r.RemoteAddr,
+		)
+	}()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	rotation := a.proxyServer.Rotate(proxy.RotateScope{
+		Username: query.Get("username"),
+		Session:  query.Get("session"),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(rotation)
+	if err != nil {
+		slog.Error(msgFailedToWriteRotation, "error", err)
+		http.Error(w, msgFailedToWriteRotation, http.StatusInternalServerError)
+		return
+	}
+}
+
+func (a *Api) status() string {
+	if a.proxyServer.IsDegraded() {
+		return statusDegraded
+	}
+	return statusHealthy
+}
+
+func (a *Api) metrics() (*metrics, error) {
+	metrics, err := collectMetrics()
+	if err != nil {
+		return nil, err
+	}
+	metrics.Status = a.status()
+	metrics.Degraded = a.proxyServer.IsDegraded()
+	metrics.Proxies = a.proxyServer.ProxyCount()
+	for _, load := range a.proxyServer.InFlight("") {
+		metrics.InFlight += load.InFlight
+	}
+	metrics.Tunnels = a.proxyServer.Stats().Tunnels()
+	return metrics, nil
+}
+
 func collectMetrics() (*metrics, error) {
 	metrics := &metrics{
 		Timestamp: time.Now().Format(time.RFC3339),
-		Status:    "healthy",
+		Status:    statusHealthy,
 	}
 
 	if vmStat, err := mem.VirtualMemory(); err == nil {
@@ -1,14 +1,21 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/alpkeskin/rota/internal/config"
 	"github.com/alpkeskin/rota/internal/proxy"
+	"github.com/alpkeskin/rota/internal/stats"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewApi(t *testing.T) {
@@ -18,9 +25,9 @@ func TestNewApi(t *testing.T) {
 		},
 	}
 	proxyServer := proxy.NewProxyServer(cfg)
-	api := NewApi(cfg, proxyServer)
+	api := NewApi(proxyServer)
 	assert.NotNil(t, api)
-	assert.Equal(t, cfg, api.cfg)
+	assert.Equal(t, cfg, api.config())
 }
 
 func TestHandleMetrics(t *testing.T) {
@@ -50,7 +57,7 @@ func TestHandleMetrics(t *testing.T) {
 		},
 	}
 	proxyServer := proxy.NewProxyServer(cfg)
-	api := NewApi(cfg, proxyServer)
+	api := NewApi(proxyServer)
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -76,6 +83,27 @@ func TestHandleMetrics(t *testing.T) {
 	}
 }
 
+func TestHandlePrometheus(t *testing.T) {
+	cfg := &config.Config{}
+	proxyServer := proxy.NewProxyServer(cfg)
+	proxyServer.AddProxy(&proxy.Proxy{Scheme: "http", Host: "http://127.0.0.1:8080"})
+	proxyServer.Stats().ObserveRequest(stats.ResultSuccess)
+	api := NewApi(proxyServer)
+
+	w := httptest.NewRecorder()
+	api.handlePrometheus(w, httptest.NewRequest(http.MethodGet, "/metrics/prometheus", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, prometheusContentType, w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "rota_requests_total{result=\"success\"} 1\n")
+	assert.Contains(t, w.Body.String(), "rota_proxies 1\n")
+	assert.Contains(t, w.Body.String(), "rota_degraded 0\n")
+	assert.Contains(t, w.Body.String(), "rota_tunnels_in_flight 0\n")
+
+	w = httptest.NewRecorder()
+	api.handlePrometheus(w, httptest.NewRequest(http.MethodPost, "/metrics/prometheus", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
 func TestCollectMetrics(t *testing.T) {
 	metrics, err := collectMetrics()
 
@@ -98,7 +126,7 @@ func TestHandleProxies(t *testing.T) {
 		},
 	}
 	proxyServer := proxy.NewProxyServer(cfg)
-	api := NewApi(cfg, proxyServer)
+	api := NewApi(proxyServer)
 
 	req := httptest.NewRequest(http.MethodGet, "/proxies", nil)
 	w := httptest.NewRecorder()
@@ -108,6 +136,99 @@ func TestHandleProxies(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 }
 
+func TestHandleInFlight(t *testing.T) {
+	cfg := &config.Config{}
+	proxyServer := proxy.NewProxyServer(cfg)
+	proxyServer.AddProxy(&proxy.Proxy{Scheme: "http", Host: "10.0.0.1:8080", Pool: "proxies.txt"})
+	mux := NewApi(proxyServer).routes()
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/proxies/in-flight", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response inFlightResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.Equal(t, []proxy.ProxyLoad{{Proxy: "10.0.0.1:8080", Pool: "proxies.txt"}}, response.Proxies)
+	require.NotNil(t, response.Tunnels)
+	assert.Zero(t, *response.Tunnels)
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/proxies/in-flight", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestHandleMirror(t *testing.T) {
+	cfg := &config.Config{Proxy: config.ProxyConfig{Mirror: config.ProxyMirrorConfig{Enabled: true, Percent: 5, ProxyFile: "candidates.txt"}}}
+	mux := NewApi(proxy.NewProxyServer(cfg)).routes()
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/mirror", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response proxy.MirrorStats
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.Equal(t, proxy.MirrorStats{Enabled: true, Percent: 5, Pool: "candidates.txt", Proxies: []proxy.MirrorProxy{}}, response)
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/mirror", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestHandleProxyTags(t *testing.T) {
+	cfg := &config.Config{}
+	proxyServer := proxy.NewProxyServer(cfg)
+	proxyServer.AddProxy(&proxy.Proxy{Scheme: "http", Host: "http://127.0.0.1:8080", Pool: "proxies.txt"})
+	proxyServer.AddProxy(&proxy.Proxy{Scheme: "socks5", Host: "socks5://127.0.0.1:1080", Pool: "proxies.txt"})
+	api := NewApi(proxyServer)
+
+	tests := []struct {
+		name        string
+		method      string
+		body        string
+		wantStatus  int
+		wantUpdated float64
+	}{
+		{"tag by pool", http.MethodPost, `{"pool": "proxies.txt", "tags": ["residential"]}`, http.StatusOK, 2},
+		{"untag by host", http.MethodDelete, `{"proxies": ["socks5://127.0.0.1:1080"], "tags": ["residential"]}`, http.StatusOK, 1},
+		{"missing tags", http.MethodPost, `{"pool": "proxies.txt"}`, http.StatusBadRequest, 0},
+		{"missing proxies", http.MethodPost, `{"tags": ["residential"]}`, http.StatusBadRequest, 0},
+		{"wrong method", http.MethodGet, ``, http.StatusMethodNotAllowed, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			api.handleProxyTags(w, httptest.NewRequest(tt.method, "/proxies/tags", strings.NewReader(tt.body)))
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantStatus == http.StatusOK {
+				var response map[string]any
+				assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+				assert.Equal(t, tt.wantUpdated, response["updated"])
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	api.handleProxies(w, httptest.NewRequest(http.MethodGet, "/proxies?tag=residential", nil))
+	var proxies []map[string]any
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&proxies))
+	assert.Len(t, proxies, 1)
+	assert.Equal(t, "http://127.0.0.1:8080", proxies[0]["host"])
+	assert.Equal(t, []any{"residential"}, proxies[0]["tags"])
+}
+
+func TestHandleSources(t *testing.T) {
+	cfg := &config.Config{}
+	api := NewApi(proxy.NewProxyServer(cfg))
+
+	w := httptest.NewRecorder()
+	api.handleSources(w, httptest.NewRequest(http.MethodGet, "/sources", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, "[]", w.Body.String())
+
+	w = httptest.NewRecorder()
+	api.handleSources(w, httptest.NewRequest(http.MethodPost, "/sources", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
 func TestHandleHealthcheck(t *testing.T) {
 	cfg := &config.Config{
 		Api: config.ApiConfig{
@@ -115,7 +236,7 @@ func TestHandleHealthcheck(t *testing.T) {
 		},
 	}
 	proxyServer := proxy.NewProxyServer(cfg)
-	api := NewApi(cfg, proxyServer)
+	api := NewApi(proxyServer)
 
 	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
 	w := httptest.NewRecorder()
@@ -124,3 +245,255 @@ func TestHandleHealthcheck(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, w.Code)
 }
+
+func TestHandleReadiness(t *testing.T) {
+	cfg := &config.Config{
+		Api: config.ApiConfig{
+			Port: 8080,
+		},
+	}
+	proxyServer := proxy.NewProxyServer(cfg)
+	api := NewApi(proxyServer)
+
+	req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+	w := httptest.NewRecorder()
+	api.handleReadiness(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	proxyServer.AddProxy(&proxy.Proxy{Scheme: "http", Host: "http://127.0.0.1:8080"})
+	proxyServer.SetDegraded(true)
+
+	w = httptest.NewRecorder()
+	api.handleReadiness(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]any
+	err := json.NewDecoder(w.Body).Decode(&response)
+	assert.NoError(t, err)
+	assert.Equal(t, "degraded", response["status"])
+	assert.Equal(t, true, response["degraded"])
+
+	proxyServer.Shutdown()
+	w = httptest.NewRecorder()
+	api.handleReadiness(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "shutting down")
+}
+
+func TestHandleFeatures(t *testing.T) {
+	cfg := &config.Config{
+		Features: map[string]bool{"cache": true},
+	}
+	proxyServer := proxy.NewProxyServer(cfg)
+	api := NewApi(proxyServer)
+
+	testCases := []struct {
+		name         string
+		method       string
+		body         string
+		expectedCode int
+	}{
+		{
+			name:         "List features",
+			method:       http.MethodGet,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Toggle feature",
+			method:       http.MethodPut,
+			body:         `{"name": "cache", "enabled": false}`,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "Missing enabled",
+			method:       http.MethodPut,
+			body:         `{"name": "cache"}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Invalid HTTP method",
+			method:       http.MethodDelete,
+			expectedCode: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "/features", strings.NewReader(tc.body))
+			w := httptest.NewRecorder()
+
+			api.handleFeatures(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+		})
+	}
+
+	assert.False(t, proxyServer.Features().Enabled("cache"))
+}
+
+func TestHandleFeatureHistory(t *testing.T) {
+	cfg := &config.Config{
+		Features: map[string]bool{"cache": true},
+	}
+	proxyServer := proxy.NewProxyServer(cfg)
+	mux := NewApi(proxyServer).routes()
+	proxyServer.Features().SetBy("cache", false, "admin")
+
+	testCases := []struct {
+		name         string
+		method       string
+		target       string
+		body         string
+		expectedCode int
+	}{
+		{"List history", http.MethodGet, "/features/history", "", http.StatusOK},
+		{"Rollback", http.MethodPost, "/features/rollback", `{"version": 1}`, http.StatusOK},
+		{"Unknown version", http.MethodPost, "/features/rollback", `{"version": 42}`, http.StatusNotFound},
+		{"Missing version", http.MethodPost, "/features/rollback", `{}`, http.StatusBadRequest},
+		{"Invalid HTTP method", http.MethodDelete, "/features/history", "", http.StatusMethodNotAllowed},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+			w := httptest.NewRecorder()
+
+			mux.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+		})
+	}
+
+	assert.True(t, proxyServer.Features().Enabled("cache"))
+	history := proxyServer.Features().History()
+	assert.Len(t, history, 3)
+	assert.Equal(t, "192.0.2.1:1234", history[0].Actor)
+}
+
+func TestHandleNextProxy(t *testing.T) {
+	cfg := &config.Config{
+		Proxy: config.ProxyConfig{
+			Rotation: config.ProxyRotationConfig{Method: "roundrobin"},
+		},
+	}
+	proxyServer := proxy.NewProxyServer(cfg)
+	api := NewApi(proxyServer)
+
+	req := httptest.NewRequest(http.MethodGet, "/rotation/next", nil)
+	w := httptest.NewRecorder()
+	api.handleNextProxy(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	proxyServer.AddProxy(&proxy.Proxy{Scheme: "http", Host: "http://127.0.0.1:8080"})
+	proxyServer.AddProxy(&proxy.Proxy{Scheme: "socks5", Host: "socks5://127.0.0.1:1080"})
+
+	// previewing must not advance the rotation
+	for range 2 {
+		w = httptest.NewRecorder()
+		api.handleNextProxy(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response map[string]any
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.Equal(t, "roundrobin", response["method"])
+		assert.Equal(t, "http://127.0.0.1:8080", response["host"])
+	}
+
+	w = httptest.NewRecorder()
+	api.handleNextProxy(w, httptest.NewRequest(http.MethodGet, "/rotation/next?host=example.com&username=alice&session=abc", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	for _, target := range []string{"/rotation/next?listener=abc", "/rotation/next?listener=9999"} {
+		w = httptest.NewRecorder()
+		api.handleNextProxy(w, httptest.NewRequest(http.MethodGet, target, nil))
+		assert.Equal(t, http.StatusBadRequest, w.Code, target)
+	}
+
+	w = httptest.NewRecorder()
+	api.handleNextProxy(w, httptest.NewRequest(http.MethodPost, "/rotation/next", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestHandleRotate(t *testing.T) {
+	api := NewApi(proxy.NewProxyServer(&config.Config{}))
+
+	w := httptest.NewRecorder()
+	api.handleRotate(w, httptest.NewRequest(http.MethodPost, "/rotation/rotate", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var rotation proxy.Rotation
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&rotation))
+	assert.True(t, rotation.HashRing)
+
+	w = httptest.NewRecorder()
+	api.handleRotate(w, httptest.NewRequest(http.MethodPost, "/rotation/rotate?username=alice", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&rotation))
+	assert.False(t, rotation.HashRing, "a scoped rotation only drops the scope's sessions")
+
+	w = httptest.NewRecorder()
+	api.handleRotate(w, httptest.NewRequest(http.MethodGet, "/rotation/rotate", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestServeUnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "api.sock")
+	cfg := &config.Config{
+		Api: config.ApiConfig{
+			Socket: socket,
+		},
+	}
+	api := NewApi(proxy.NewProxyServer(cfg))
+	go api.Serve()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+
+	assert.Eventually(t, func() bool {
+		resp, err := client.Get("http://rota/healthz")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}, 2*time.Second, 20*time.Millisecond)
+}
+
+func TestHandleHealthchecks(t *testing.T) {
+	cfg := &config.Config{}
+	api := NewApi(proxy.NewProxyServer(cfg))
+
+	tests := []struct {
+		method     string
+		path       string
+		wantStatus int
+		wantPaused bool
+	}{
+		{http.MethodGet, "/healthcheck", http.StatusOK, false},
+		{http.MethodPost, "/healthcheck/pause", http.StatusOK, true},
+		{http.MethodGet, "/healthcheck", http.StatusOK, true},
+		{http.MethodPost, "/healthcheck/resume", http.StatusOK, false},
+		{http.MethodPost, "/healthcheck", http.StatusMethodNotAllowed, false},
+		{http.MethodGet, "/healthcheck/pause", http.StatusMethodNotAllowed, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			api.handleHealthchecks(w, httptest.NewRequest(tt.method, tt.path, nil))
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var status proxy.HealthcheckStatus
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&status))
+			assert.Equal(t, tt.wantPaused, status.Paused)
+			assert.Nil(t, status.LastRun)
+		})
+	}
+}
@@ -0,0 +1,358 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	msgAuditRequested        = "audit log requested"
+	msgInvalidAuditFilter    = "invalid audit filter"
+	msgFailedToWriteAudit    = "failed to write audit log"
+	msgFailedToOpenAuditFile = "failed to open audit file"
+	msgFailedToAppendAudit   = "failed to append audit entry"
+
+	defaultAuditSize  = 1000
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+
+	// bodies are summarized from their first bytes only, larger ones are reported by size
+	maxAuditBody    = 4096
+	maxAuditPayload = 512
+	redacted        = "[redacted]"
+)
+
+type AuditEntry struct {
+	ID         uint64    `json:"id"`
+	Time       time.Time `json:"time"`
+	Actor      string    `json:"actor"`
+	Tenant     string    `json:"tenant,omitempty"`
+	IP         string    `json:"ip"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Query      string    `json:"query,omitempty"`
+	Payload    string    `json:"payload,omitempty"`
+	Status     int       `json:"status"`
+	Success    bool      `json:"success"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+// auditFilter selects audit entries, zero values match everything
+type auditFilter struct {
+	Actor   string
+	Method  string
+	Path    string
+	Success *bool
+	Since   time.Time
+	Until   time.Time
+	Limit   int
+}
+
+type auditResponse struct {
+	Total   int          `json:"total"`
+	Limit   int          `json:"limit"`
+	Entries []AuditEntry `json:"entries"`
+}
+
+// auditLog keeps the last size admin changes in a ring buffer and appends them to the audit file when one is set
+type auditLog struct {
+	mu      sync.RWMutex
+	entries []AuditEntry
+	next    int
+	full    bool
+	added   uint64
+	file    io.Writer
+}
+
+func newAuditLog(size int, path string) *auditLog {
+	if size <= 0 {
+		size = defaultAuditSize
+	}
+	log := &auditLog{entries: make([]AuditEntry, size)}
+	if path != "" {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			// the entries are still kept in memory, refusing to start over the file would hide the api
+			slog.Error(msgFailedToOpenAuditFile, "error", err, "file", path)
+		} else {
+			log.file = file
+		}
+	}
+	return log
+}
+
+func (l *auditLog) add(entry AuditEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.added++
+	entry.ID = l.added
+	l.entries[l.next] = entry
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+
+	if l.file == nil {
+		return
+	}
+	line, err := json.Marshal(entry)
+	if err == nil {
+		_, err = l.file.Write(append(line, '\n'))
+	}
+	if err != nil {
+		slog.Error(msgFailedToAppendAudit, "error", err, "id", entry.ID)
+	}
+}
+
+// find returns the matching entries newest first and the number of matches before the limit
+func (l *auditLog) find(filter auditFilter) ([]AuditEntry, int) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	count := l.next
+	if l.full {
+		count = len(l.entries)
+	}
+	entries := make([]AuditEntry, 0, min(count, filter.Limit))
+	total := 0
+	for i := 1; i <= count; i++ {
+		entry := l.entries[(l.next-i+len(l.entries))%len(l.entries)]
+		if !filter.matches(entry) {
+			continue
+		}
+		total++
+		if len(entries) < filter.Limit {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, total
+}
+
+func (f auditFilter) matches(entry AuditEntry) bool {
+	switch {
+	case f.Actor != "" && entry.Actor != f.Actor:
+		return false
+	case f.Method != "" && !strings.EqualFold(entry.Method, f.Method):
+		return false
+	case f.Path != "" && !strings.HasPrefix(entry.Path, f.Path):
+		return false
+	case f.Success != nil && entry.Success != *f.Success:
+		return false
+	case !f.Since.IsZero() && entry.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && entry.Time.After(f.Until):
+		return false
+	}
+	return true
+}
+
+// auditBody keeps the start of the request body as the handler reads it
+type auditBody struct {
+	io.ReadCloser
+	head  bytes.Buffer
+	size  int
+	lines int
+	last  byte
+}
+
+func (b *auditBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if room := maxAuditBody - b.head.Len(); room > 0 {
+			b.head.Write(p[:min(n, room)])
+		}
+		b.size += n
+		b.lines += bytes.Count(p[:n], []byte{'\n'})
+		b.last = p[n-1]
+	}
+	return n, err
+}
+
+// summary describes the body without secrets: json objects are kept with passwords, secrets and tokens redacted,
+// anything else (e.g. proxy lists with credentials) only by its size
+func (b *auditBody) summary() string {
+	if b.size == 0 {
+		return ""
+	}
+	var payload map[string]any
+	if b.size <= maxAuditBody && json.Unmarshal(b.head.Bytes(), &payload) == nil {
+		summary, err := json.Marshal(redact(payload))
+		if err == nil {
+			if len(summary) > maxAuditPayload {
+				return string(summary[:maxAuditPayload]) + "..."
+			}
+			return string(summary)
+		}
+	}
+	lines := b.lines
+	if b.last != '\n' {
+		lines++
+	}
+	return fmt.Sprintf("%d bytes, %d lines", b.size, lines)
+}
+
+func redact(value any) any {
+	switch value := value.(type) {
+	case map[string]any:
+		for key, v := range value {
+			if secretKey(key) {
+				value[key] = redacted
+				continue
+			}
+			value[key] = redact(v)
+		}
+	case []any:
+		for i, v := range value {
+			value[i] = redact(v)
+		}
+	case string:
+		// proxy urls carry their credentials
+		if u, err := url.Parse(value); err == nil && u.User != nil && u.Host != "" {
+			return u.Redacted()
+		}
+	}
+	return value
+}
+
+func secretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, secret := range []string{"password", "secret", "token"} {
+		if strings.Contains(key, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+func mutating(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// audited records every change made through next in the audit log, reads are not recorded
+func (a *Api) audited(next http.HandlerFunc) http.HandlerFunc {
+	if a.audit == nil {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !mutating(r.Method) {
+			next(w, r)
+			return
+		}
+
+		start := time.Now()
+		rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		body := &auditBody{ReadCloser: r.Body}
+		r.Body = body
+		defer func() {
+			a.audit.add(AuditEntry{
+				Time:       start,
+				Actor:      actor(r),
+				Tenant:     tenantOf(r),
+				IP:         r.RemoteAddr,
+				Method:     r.Method,
+				Path:       r.URL.Path,
+				Query:      r.URL.RawQuery,
+				Payload:    body.summary(),
+				Status:     rw.statusCode,
+				Success:    rw.statusCode < http.StatusBadRequest,
+				DurationMs: time.Since(start).Milliseconds(),
+			})
+		}()
+		next(rw, r)
+	}
+}
+
+// handleAudit lists the recorded api changes, newest first
+func (a *Api) handleAudit(w http.ResponseWriter, r *http.Request) {
+	rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	w = rw
+
+	defer func() {
+		slog.Info(msgAuditRequested,
+			"status", rw.statusCode,
+			"method", r.Method,
+			"url", r.URL.String(),
+			"ip", r.RemoteAddr,
+		)
+	}()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, err := parseAuditFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("%s: %v", msgInvalidAuditFilter, err), http.StatusBadRequest)
+		return
+	}
+
+	entries, total := a.audit.find(filter)
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(auditResponse{Total: total, Limit: filter.Limit, Entries: entries})
+	if err != nil {
+		slog.Error(msgFailedToWriteAudit, "error", err)
+		http.Error(w, msgFailedToWriteAudit, http.StatusInternalServerError)
+		return
+	}
+}
+
+func parseAuditFilter(query url.Values) (auditFilter, error) {
+	filter := auditFilter{
+		Actor:  query.Get("actor"),
+		Method: query.Get("method"),
+		Path:   query.Get("path"),
+		Limit:  defaultAuditLimit,
+	}
+
+	if value := query.Get("success"); value != "" {
+		success, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, fmt.Errorf("success: %w", err)
+		}
+		filter.Success = &success
+	}
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return filter, errors.New("limit: must be a positive integer")
+		}
+		filter.Limit = min(n, maxAuditLimit)
+	}
+
+	times := []struct {
+		name  string
+		value *time.Time
+	}{
+		{"since", &filter.Since},
+		{"until", &filter.Until},
+	}
+	for _, param := range times {
+		value := query.Get(param.name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return filter, fmt.Errorf("%s: %w", param.name, err)
+		}
+		*param.value = t
+	}
+	return filter, nil
+}
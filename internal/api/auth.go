@@ -0,0 +1,443 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/alpkeskin/rota/pkg/jwt"
+	"github.com/google/uuid"
+)
+
+const (
+	tokenTypeAccess  = "access"
+	tokenTypeRefresh = "refresh"
+
+	defaultAccessTTL  = 15 * 60
+	defaultRefreshTTL = 24 * 60 * 60
+
+	msgTokenRequested      = "token requested"
+	msgTokenRefreshed      = "token refreshed"
+	msgInvalidCredentials  = "invalid credentials"
+	msgInvalidTokenRequest = "invalid token request"
+	msgUnauthorized        = "unauthorized"
+	msgFailedToWriteToken  = "failed to write token"
+	msgRefreshTokenReused  = "refresh token already used"
+	msgSecretRotated       = "token secret rotated"
+	msgFailedToLoadSecret  = "failed to load token secret, tokens are invalid after a restart"
+	msgFailedToSaveSecret  = "failed to save token secret"
+
+	msgFailedToLoadRefreshTokens = "failed to load refresh tokens, tokens issued before the restart can not be refreshed"
+	msgFailedToSaveRefreshTokens = "failed to save refresh tokens"
+
+	// SecretEnv overrides api.authentication.secret, so the key does not have to be written to the config file
+	SecretEnv = "ROTA_JWT_SECRET"
+
+	secretSize = 32
+
+	// the unused refresh tokens are kept next to the secret file, e.g. jwt.key.refresh
+	refreshFileSuffix = ".refresh"
+)
+
+type authenticator struct {
+	cfg config.ApiAuthenticationConfig
+	mu  sync.Mutex
+	// secret is replaced on rotation, read it with key
+	secret []byte
+	// refresh tokens are single use, a refreshed token is removed and replaced by the new one. They are saved with
+	// every change when there is a secret file, so tokens issued before a restart can be refreshed after it
+	refreshTokens map[string]time.Time
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	Role         string `json:"role,omitempty"`
+}
+
+func newAuthenticator(cfg config.ApiAuthenticationConfig) *authenticator {
+	if cfg.AccessTTL <= 0 {
+		cfg.AccessTTL = defaultAccessTTL
+	}
+	if cfg.RefreshTTL <= 0 {
+		cfg.RefreshTTL = defaultRefreshTTL
+	}
+
+	secret, err := loadSecret(cfg)
+	if err != nil {
+		slog.Error(msgFailedToLoadSecret, "error", err, "secret_file", cfg.SecretFile)
+		secret = randomSecret()
+	}
+	refreshTokens, err := loadRefreshTokens(refreshFile(cfg), time.Now())
+	if err != nil {
+		slog.Error(msgFailedToLoadRefreshTokens, "error", err, "file", refreshFile(cfg))
+	}
+
+	return &authenticator{
+		cfg:           cfg,
+		secret:        secret,
+		refreshTokens: refreshTokens,
+	}
+}
+
+// loadSecret takes the key from the environment, the config or the secret file in that order. A missing secret
+// file is created with a random key, without any of them tokens are only valid until the next restart
+func loadSecret(cfg config.ApiAuthenticationConfig) ([]byte, error) {
+	if secret := os.Getenv(SecretEnv); secret != "" {
+		return []byte(secret), nil
+	}
+	if cfg.Secret != "" {
+		return []byte(cfg.Secret), nil
+	}
+	if cfg.SecretFile == "" {
+		return randomSecret(), nil
+	}
+
+	secret, err := os.ReadFile(cfg.SecretFile)
+	if err == nil && len(secret) > 0 {
+		return secret, nil
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	secret = randomSecret()
+	return secret, saveSecret(cfg.SecretFile, secret)
+}
+
+func saveSecret(path string, secret []byte) error {
+	return os.WriteFile(path, secret, 0o600)
+}
+
+func refreshFile(cfg config.ApiAuthenticationConfig) string {
+	if cfg.SecretFile == "" {
+		return ""
+	}
+	return cfg.SecretFile + refreshFileSuffix
+}
+
+// loadRefreshTokens reads the ids and expiry times of the refresh tokens not used yet, expired ones are dropped
+func loadRefreshTokens(path string, now time.Time) (map[string]time.Time, error) {
+	tokens := make(map[string]time.Time)
+	if path == "" {
+		return tokens, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return tokens, nil
+	}
+	if err != nil {
+		return tokens, err
+	}
+	var expiries map[string]int64
+	if err := json.Unmarshal(data, &expiries); err != nil {
+		return tokens, err
+	}
+	for id, expiry := range expiries {
+		if expires := time.Unix(expiry, 0); now.Before(expires) {
+			tokens[id] = expires
+		}
+	}
+	return tokens, nil
+}
+
+// saveRefreshTokens replaces the refresh token file, it is called with au.mu held
+func (au *authenticator) saveRefreshTokens() {
+	path := refreshFile(au.cfg)
+	if path == "" {
+		return
+	}
+	expiries := make(map[string]int64, len(au.refreshTokens))
+	for id, expires := range au.refreshTokens {
+		expiries[id] = expires.Unix()
+	}
+	data, err := json.Marshal(expiries)
+	if err == nil {
+		// written aside and renamed, a crash halfway through must not lose every token
+		err = os.WriteFile(path+".tmp", data, 0o600)
+	}
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		slog.Error(msgFailedToSaveRefreshTokens, "error", err, "file", path)
+	}
+}
+
+func randomSecret() []byte {
+	secret := make([]byte, secretSize)
+	rand.Read(secret)
+	return secret
+}
+
+// requireAuth wraps admin handlers, they are served unauthenticated while api.authentication is disabled.
+// Changes that pass authentication are recorded in the audit log
+func (a *Api) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	next = a.audited(next)
+	if a.auth == nil {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			a.unauthorized(w)
+			return
+		}
+		claims, err := jwt.Verify(token, a.auth.key())
+		if err != nil || claims.Type != tokenTypeAccess || !a.validTenant(claims.Tenant) {
+			a.unauthorized(w)
+			return
+		}
+		role := claims.Role
+		// tokens issued before roles existed belong to the main user
+		if role == "" && claims.Tenant == "" {
+			role = roleAdmin
+		}
+		ctx := context.WithValue(r.Context(), subjectKey{}, claims.Subject)
+		ctx = context.WithValue(ctx, roleKey{}, role)
+		next(w, r.WithContext(context.WithValue(ctx, tenantKey{}, claims.Tenant)))
+	}
+}
+
+type subjectKey struct{}
+
+// actor names who made an admin request, the token's username or the client address while authentication is disabled
+func actor(r *http.Request) string {
+	if subject, ok := r.Context().Value(subjectKey{}).(string); ok && subject != "" {
+		return subject
+	}
+	return r.RemoteAddr
+}
+
+func (a *Api) unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="rota"`)
+	http.Error(w, msgUnauthorized, http.StatusUnauthorized)
+}
+
+func (a *Api) handleToken(w http.ResponseWriter, r *http.Request) {
+	rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	w = rw
+
+	defer func() {
+		slog.Info(msgTokenRequested,
+			"status", rw.statusCode,
+			"method", r.Method,
+			"url", r.URL.String(),
+			"ip", r.RemoteAddr,
+		)
+	}()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, msgInvalidTokenRequest, http.StatusBadRequest)
+		return
+	}
+	if role, ok := a.auth.login(request.Username, request.Password); ok {
+		a.writeTokens(w, request.Username, "", role)
+		return
+	}
+	if tenant := a.tenantLogin(request.Username, request.Password); tenant != "" {
+		a.writeTokens(w, request.Username, tenant, "")
+		return
+	}
+	http.Error(w, msgInvalidCredentials, http.StatusUnauthorized)
+}
+
+func (a *Api) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	w = rw
+
+	defer func() {
+		slog.Info(msgTokenRefreshed,
+			"status", rw.statusCode,
+			"method", r.Method,
+			"url", r.URL.String(),
+			"ip", r.RemoteAddr,
+		)
+	}()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.RefreshToken == "" {
+		http.Error(w, msgInvalidTokenRequest, http.StatusBadRequest)
+		return
+	}
+
+	claims, err := a.auth.redeem(request.RefreshToken)
+	if err != nil {
+		slog.Warn(msgUnauthorized, "error", err, "ip", r.RemoteAddr)
+		a.unauthorized(w)
+		return
+	}
+	// a tenant removed from the config can not refresh its tokens
+	if !a.validTenant(claims.Tenant) {
+		a.unauthorized(w)
+		return
+	}
+
+	a.writeTokens(w, claims.Subject, claims.Tenant, claims.Role)
+}
+
+// handleRotateSecret signs out every client by replacing the token secret, the caller gets tokens signed with the new one
+func (a *Api) handleRotateSecret(w http.ResponseWriter, r *http.Request) {
+	rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	w = rw
+
+	defer func() {
+		slog.Info(msgSecretRotated,
+			"status", rw.statusCode,
+			"method", r.Method,
+			"url", r.URL.String(),
+			"ip", r.RemoteAddr,
+			"actor", actor(r),
+		)
+	}()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := a.auth.rotate(); err != nil {
+		slog.Error(msgFailedToSaveSecret, "error", err, "secret_file", a.auth.cfg.SecretFile)
+		http.Error(w, msgFailedToSaveSecret, http.StatusInternalServerError)
+		return
+	}
+
+	a.writeTokens(w, actor(r), "", roleOf(r))
+}
+
+func (a *Api) writeTokens(w http.ResponseWriter, subject, tenant, role string) {
+	tokens, err := a.auth.issue(subject, tenant, role)
+	if err != nil {
+		slog.Error(msgFailedToWriteToken, "error", err)
+		http.Error(w, msgFailedToWriteToken, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(tokens); err != nil {
+		slog.Error(msgFailedToWriteToken, "error", err)
+		http.Error(w, msgFailedToWriteToken, http.StatusInternalServerError)
+		return
+	}
+}
+
+// issue signs tokens for subject, tokens of a tenant only reach that tenant's data and api users get their role
+func (au *authenticator) issue(subject, tenant, role string) (*tokenResponse, error) {
+	now := time.Now()
+	secret := au.key()
+	access, err := jwt.Sign(jwt.Claims{
+		Subject:   subject,
+		Type:      tokenTypeAccess,
+		ID:        uuid.New().String(),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(time.Duration(au.cfg.AccessTTL) * time.Second).Unix(),
+		Tenant:    tenant,
+		Role:      role,
+	}, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshID := uuid.New().String()
+	refreshExpiry := now.Add(time.Duration(au.cfg.RefreshTTL) * time.Second)
+	refresh, err := jwt.Sign(jwt.Claims{
+		Subject:   subject,
+		Type:      tokenTypeRefresh,
+		ID:        refreshID,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: refreshExpiry.Unix(),
+		Tenant:    tenant,
+		Role:      role,
+	}, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	au.mu.Lock()
+	defer au.mu.Unlock()
+	for id, expiry := range au.refreshTokens {
+		if now.After(expiry) {
+			delete(au.refreshTokens, id)
+		}
+	}
+	au.refreshTokens[refreshID] = refreshExpiry
+	au.saveRefreshTokens()
+
+	return &tokenResponse{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    au.cfg.AccessTTL,
+		Role:         role,
+	}, nil
+}
+
+func (au *authenticator) redeem(token string) (*jwt.Claims, error) {
+	claims, err := jwt.Verify(token, au.key())
+	if err != nil {
+		return nil, err
+	}
+	if claims.Type != tokenTypeRefresh {
+		return nil, jwt.ErrInvalidToken
+	}
+
+	au.mu.Lock()
+	defer au.mu.Unlock()
+	if _, ok := au.refreshTokens[claims.ID]; !ok {
+		return nil, errors.New(msgRefreshTokenReused)
+	}
+	delete(au.refreshTokens, claims.ID)
+	au.saveRefreshTokens()
+	return claims, nil
+}
+
+func (au *authenticator) key() []byte {
+	au.mu.Lock()
+	defer au.mu.Unlock()
+	return au.secret
+}
+
+// rotate replaces the secret, which invalidates every issued token. The new key is written to the secret file
+// when the secret came from there, a key from the environment or the config comes back on the next restart
+func (au *authenticator) rotate() error {
+	secret := randomSecret()
+	if os.Getenv(SecretEnv) == "" && au.cfg.Secret == "" && au.cfg.SecretFile != "" {
+		if err := saveSecret(au.cfg.SecretFile, secret); err != nil {
+			return err
+		}
+	}
+
+	au.mu.Lock()
+	defer au.mu.Unlock()
+	au.secret = secret
+	clear(au.refreshTokens)
+	au.saveRefreshTokens()
+	return nil
+}
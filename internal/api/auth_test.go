@@ -0,0 +1,199 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/alpkeskin/rota/internal/proxy"
+	"github.com/alpkeskin/rota/pkg/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApiAuthentication(t *testing.T) {
+	cfg := &config.Config{
+		Api: config.ApiConfig{
+			Authentication: config.ApiAuthenticationConfig{
+				Enabled:  true,
+				Username: "admin",
+				Password: "secret",
+			},
+		},
+	}
+	mux := NewApi(proxy.NewProxyServer(cfg)).routes()
+
+	do := func(method, path, body, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+	decode := func(w *httptest.ResponseRecorder) tokenResponse {
+		var tokens tokenResponse
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&tokens))
+		return tokens
+	}
+
+	// probes stay public
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/healthz", "", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/proxies", "", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/proxies", "", "garbage").Code)
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodPost, "/auth/token", `{"username": "admin", "password": "wrong"}`, "").Code)
+
+	w := do(http.MethodPost, "/auth/token", `{"username": "admin", "password": "secret"}`, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	tokens := decode(w)
+	assert.Equal(t, defaultAccessTTL, tokens.ExpiresIn)
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/proxies", "", tokens.AccessToken).Code)
+	// a refresh token is not an access token
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/proxies", "", tokens.RefreshToken).Code)
+
+	refreshBody := `{"refresh_token": "` + tokens.RefreshToken + `"}`
+	w = do(http.MethodPost, "/auth/refresh", refreshBody, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	refreshed := decode(w)
+	assert.NotEqual(t, tokens.RefreshToken, refreshed.RefreshToken)
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/features", "", refreshed.AccessToken).Code)
+	// changes are recorded under the token's username
+	assert.Equal(t, http.StatusOK, do(http.MethodPut, "/features", `{"name": "cache", "enabled": true}`, refreshed.AccessToken).Code)
+	assert.Contains(t, do(http.MethodGet, "/features/history", "", refreshed.AccessToken).Body.String(), `"actor":"admin"`)
+
+	// refresh tokens are rotated, the old one can not be used again
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodPost, "/auth/refresh", refreshBody, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodPost, "/auth/refresh", `{"refresh_token": "`+refreshed.AccessToken+`"}`, "").Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "/auth/refresh", `{"refresh_token": "`+refreshed.RefreshToken+`"}`, "").Code)
+}
+
+func TestApiAuthenticationDisabled(t *testing.T) {
+	cfg := &config.Config{}
+	mux := NewApi(proxy.NewProxyServer(cfg)).routes()
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/proxies", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/token", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestExpiredAccessToken(t *testing.T) {
+	au := newAuthenticator(config.ApiAuthenticationConfig{Username: "admin", Password: "secret", Secret: "key"})
+	token, err := jwt.Sign(jwt.Claims{Subject: "admin", Type: tokenTypeAccess, ExpiresAt: 1}, []byte("key"))
+	assert.NoError(t, err)
+
+	api := &Api{auth: au}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/proxies", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	api.requireAuth(func(w http.ResponseWriter, r *http.Request) {})(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Bearer realm="rota"`, w.Header().Get("WWW-Authenticate"))
+}
+
+func TestLoadSecret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwt.key")
+
+	secret, err := loadSecret(config.ApiAuthenticationConfig{Secret: "configured", SecretFile: path})
+	require.NoError(t, err)
+	assert.Equal(t, "configured", string(secret))
+	assert.NoFileExists(t, path)
+
+	// a missing secret file is created and read back on the next start
+	created, err := loadSecret(config.ApiAuthenticationConfig{SecretFile: path})
+	require.NoError(t, err)
+	assert.Len(t, created, secretSize)
+	loaded, err := loadSecret(config.ApiAuthenticationConfig{SecretFile: path})
+	require.NoError(t, err)
+	assert.Equal(t, created, loaded)
+
+	t.Setenv(SecretEnv, "from-env")
+	secret, err = loadSecret(config.ApiAuthenticationConfig{Secret: "configured", SecretFile: path})
+	require.NoError(t, err)
+	assert.Equal(t, "from-env", string(secret))
+}
+
+func TestRotateSecret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwt.key")
+	cfg := &config.Config{
+		Api: config.ApiConfig{
+			Authentication: config.ApiAuthenticationConfig{
+				Enabled:    true,
+				Username:   "admin",
+				Password:   "secret",
+				SecretFile: path,
+			},
+		},
+	}
+	api := NewApi(proxy.NewProxyServer(cfg))
+	mux := api.routes()
+	before, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	do := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	tokens, err := api.auth.issue("admin", "", roleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodPost, "/auth/rotate-secret", "").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, do(http.MethodGet, "/auth/rotate-secret", tokens.AccessToken).Code)
+
+	w := do(http.MethodPost, "/auth/rotate-secret", tokens.AccessToken)
+	require.Equal(t, http.StatusOK, w.Code)
+	var rotated tokenResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&rotated))
+
+	// tokens signed with the old secret are rejected, the caller keeps access with the new ones
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/proxies", tokens.AccessToken).Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/proxies", rotated.AccessToken).Code)
+	_, err = api.auth.redeem(tokens.RefreshToken)
+	assert.Error(t, err)
+
+	after, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotEqual(t, before, after)
+	assert.Equal(t, after, api.auth.key())
+}
+
+func TestRefreshAfterRestart(t *testing.T) {
+	cfg := config.ApiAuthenticationConfig{
+		Enabled:    true,
+		Username:   "admin",
+		Password:   "secret",
+		SecretFile: filepath.Join(t.TempDir(), "jwt.key"),
+	}
+	tokens, err := newAuthenticator(cfg).issue("admin", "", roleAdmin)
+	require.NoError(t, err)
+
+	// a new authenticator is what a restart builds, it reads the secret and the unused refresh tokens
+	claims, err := newAuthenticator(cfg).redeem(tokens.RefreshToken)
+	require.NoError(t, err)
+	assert.Equal(t, "admin", claims.Subject)
+
+	_, err = newAuthenticator(cfg).redeem(tokens.RefreshToken)
+	assert.Error(t, err, "a refresh token stays single use across restarts")
+
+	// without a secret file the tokens are only kept in memory
+	cfg.SecretFile = ""
+	cfg.Secret = "configured"
+	tokens, err = newAuthenticator(cfg).issue("admin", "", roleAdmin)
+	require.NoError(t, err)
+	_, err = newAuthenticator(cfg).redeem(tokens.RefreshToken)
+	assert.Error(t, err)
+}
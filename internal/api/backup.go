@@ -0,0 +1,186 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/alpkeskin/rota/internal/middleware"
+	"github.com/alpkeskin/rota/internal/proxy"
+)
+
+const (
+	msgBackupRequested      = "backup requested"
+	msgRestoreRequested     = "restore requested"
+	msgInvalidBackup        = "invalid backup"
+	msgFailedToCreateBackup = "failed to create backup"
+	msgFailedToRestore      = "failed to restore backup"
+	msgFailedToWriteBackup  = "failed to write backup"
+	msgBackupRestored       = "backup restored"
+
+	backupVersion = 1
+	maxBackupSize = 50 << 20
+)
+
+// backup holds the state an instance can not rebuild from its config file alone
+type backup struct {
+	Version     int                     `json:"version"`
+	CreatedAt   time.Time               `json:"created_at"`
+	ProxyFiles  map[string]string       `json:"proxy_files"`
+	Credentials []middleware.Credential `json:"credentials"`
+	Features    map[string]bool         `json:"features"`
+	Routing     []routingRule           `json:"routing"`
+}
+
+// routingRule is config.RoutingRuleConfig with json names
+type routingRule struct {
+	Hosts  []string `json:"hosts"`
+	Direct bool     `json:"direct,omitempty"`
+	Pool   string   `json:"pool,omitempty"`
+	Scheme string   `json:"scheme,omitempty"`
+	Tag    string   `json:"tag,omitempty"`
+}
+
+type restoreResponse struct {
+	ProxyFiles  int `json:"proxy_files"`
+	Proxies     int `json:"proxies"`
+	Credentials int `json:"credentials"`
+	Features    int `json:"features"`
+	Routing     int `json:"routing"`
+}
+
+// handleBackup downloads the proxy files, accounts with their passwords, feature flags and routing rules as one document
+func (a *Api) handleBackup(w http.ResponseWriter, r *http.Request) {
+	rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	w = rw
+
+	defer func() {
+		slog.Info(msgBackupRequested,
+			"status", rw.statusCode,
+			"method", r.Method,
+			"url", r.URL.String(),
+			"ip", r.RemoteAddr,
+		)
+	}()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	files, err := proxy.NewProxyLoader(a.proxyServer).ReadProxyFiles()
+	if err != nil {
+		slog.Error(msgFailedToCreateBackup, "error", err)
+		http.Error(w, msgFailedToCreateBackup, http.StatusInternalServerError)
+		return
+	}
+	now := time.Now().UTC()
+	routing := a.config().Routing
+	b := backup{
+		Version:     backupVersion,
+		CreatedAt:   now,
+		ProxyFiles:  files,
+		Credentials: a.proxyServer.Credentials().Snapshot(),
+		Features:    a.proxyServer.Features().All(),
+		Routing:     make([]routingRule, 0, len(routing)),
+	}
+	for _, rule := range routing {
+		b.Routing = append(b.Routing, routingRule(rule))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="rota-backup-%s.json"`, now.Format("20060102-150405")))
+	if err := json.NewEncoder(w).Encode(b); err != nil {
+		slog.Error(msgFailedToWriteBackup, "error", err)
+	}
+}
+
+// handleRestore validates a backup as a whole before applying any of it, a backup with one bad part changes nothing
+func (a *Api) handleRestore(w http.ResponseWriter, r *http.Request) {
+	rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	w = rw
+
+	defer func() {
+		slog.Info(msgRestoreRequested,
+			"status", rw.statusCode,
+			"method", r.Method,
+			"url", r.URL.String(),
+			"ip", r.RemoteAddr,
+		)
+	}()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var b backup
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBackupSize)).Decode(&b); err != nil {
+		http.Error(w, fmt.Sprintf("%s: %v", msgInvalidBackup, err), http.StatusBadRequest)
+		return
+	}
+	if err := a.validateBackup(b); err != nil {
+		http.Error(w, fmt.Sprintf("%s: %v", msgInvalidBackup, err), http.StatusBadRequest)
+		return
+	}
+
+	// proxy files are the only part that can fail to apply, they are rolled back on failure and go first
+	err := proxy.NewProxyLoader(a.proxyServer).RestoreProxyFiles(b.ProxyFiles)
+	if errors.Is(err, proxy.ErrInvalidProxyList) || errors.Is(err, proxy.ErrUnknownPool) {
+		http.Error(w, fmt.Sprintf("%s: %v", msgInvalidBackup, err), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		slog.Error(msgFailedToRestore, "error", err)
+		http.Error(w, msgFailedToRestore, http.StatusInternalServerError)
+		return
+	}
+	a.proxyServer.Credentials().Replace(b.Credentials)
+	a.proxyServer.Features().RestoreBy(b.Features, actor(r))
+	routing := make([]config.RoutingRuleConfig, 0, len(b.Routing))
+	for _, rule := range b.Routing {
+		routing = append(routing, config.RoutingRuleConfig(rule))
+	}
+	a.proxyServer.UpdateConfig(func(cfg *config.Config) { cfg.Routing = routing })
+	slog.Info(msgBackupRestored, "created_at", b.CreatedAt, "actor", actor(r))
+
+	response := restoreResponse{
+		ProxyFiles:  len(b.ProxyFiles),
+		Proxies:     a.proxyServer.ProxyCount(),
+		Credentials: len(b.Credentials),
+		Features:    len(b.Features),
+		Routing:     len(routing),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error(msgFailedToWriteBackup, "error", err)
+	}
+}
+
+func (a *Api) validateBackup(b backup) error {
+	if b.Version != backupVersion {
+		return fmt.Errorf("unsupported version %d", b.Version)
+	}
+	seen := make(map[string]bool, len(b.Credentials))
+	for _, credential := range b.Credentials {
+		switch {
+		case credential.Username == "" || credential.Password == "":
+			return errors.New("credentials: username and password are required")
+		case seen[credential.Username]:
+			return fmt.Errorf("credentials: %s is listed twice", credential.Username)
+		case !a.validTenant(credential.Tenant):
+			return fmt.Errorf("credentials: %s: %s %s", credential.Username, msgUnknownTenant, credential.Tenant)
+		}
+		seen[credential.Username] = true
+	}
+	for i, rule := range b.Routing {
+		if len(rule.Hosts) == 0 {
+			return fmt.Errorf("routing: rule %d has no hosts", i+1)
+		}
+	}
+	return nil
+}
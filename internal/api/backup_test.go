@@ -0,0 +1,92 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/alpkeskin/rota/internal/features"
+	"github.com/alpkeskin/rota/internal/proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupRestore(t *testing.T) {
+	newInstance := func(t *testing.T, proxies string, cfg *config.Config) (*http.ServeMux, *proxy.ProxyServer, string) {
+		file := filepath.Join(t.TempDir(), "proxies.txt")
+		require.NoError(t, os.WriteFile(file, []byte(proxies), 0o644))
+		cfg.ProxyFile = file
+		ps := proxy.NewProxyServer(cfg)
+		require.NoError(t, proxy.NewProxyLoader(ps).Load())
+		return NewApi(ps).routes(), ps, file
+	}
+	source, _, _ := newInstance(t, "http://10.0.0.1:8080 residential\nsocks5://10.0.0.2:1080\n", &config.Config{
+		Features: map[string]bool{"sticky": true},
+		Routing:  []config.RoutingRuleConfig{{Hosts: []string{"*.internal"}, Direct: true}},
+		Proxy: config.ProxyConfig{
+			Credentials: []config.CredentialConfig{{Username: "alice", Password: "secret", Description: "ci"}},
+		},
+	})
+	targetCfg := &config.Config{}
+	target, ps, file := newInstance(t, "http://10.0.0.9:8080\n", targetCfg)
+
+	w := httptest.NewRecorder()
+	source.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/backup", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "rota-backup-")
+	var b backup
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &b))
+	require.Len(t, b.Credentials, 1)
+	assert.Equal(t, "secret", b.Credentials[0].Password)
+
+	// the backup names the source's proxy file, the target restores it into its own
+	var content string
+	for _, c := range b.ProxyFiles {
+		content = c
+	}
+	b.ProxyFiles = map[string]string{file: content}
+	restore := func(b backup) *httptest.ResponseRecorder {
+		body, err := json.Marshal(b)
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		target.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/restore", strings.NewReader(string(body))))
+		return w
+	}
+
+	invalid := b
+	invalid.ProxyFiles = map[string]string{file: content + "ftp://10.0.0.3:21\n"}
+	assert.Equal(t, http.StatusBadRequest, restore(invalid).Code)
+	invalid = b
+	invalid.Version = 2
+	assert.Equal(t, http.StatusBadRequest, restore(invalid).Code)
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "http://10.0.0.9:8080\n", string(data), "a rejected backup changes nothing")
+	assert.Empty(t, ps.Credentials().All())
+
+	w = restore(b)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response restoreResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	// sticky and the flags the proxy defines
+	assert.Equal(t, restoreResponse{ProxyFiles: 1, Proxies: 2, Credentials: 1, Features: 4, Routing: 1}, response)
+
+	data, err = os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, content, string(data))
+	assert.Equal(t, 2, ps.ProxyCount())
+	assert.True(t, ps.Features().Enabled("sticky"))
+	assert.Equal(t, features.ActionRestore, ps.Features().History()[0].Action)
+	require.Len(t, ps.Credentials().Snapshot(), 1)
+	assert.Equal(t, "secret", ps.Credentials().Snapshot()[0].Password)
+	assert.Equal(t, []config.RoutingRuleConfig{{Hosts: []string{"*.internal"}, Direct: true}}, ps.Config().Routing)
+
+	w = httptest.NewRecorder()
+	target.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/restore", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
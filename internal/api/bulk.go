@@ -0,0 +1,83 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/alpkeskin/rota/internal/proxy"
+)
+
+const (
+	msgBulkImportRequested   = "bulk import requested"
+	msgInvalidBulkRequest    = "invalid bulk request"
+	msgFailedToImportProxies = "failed to import proxies"
+	msgFailedToWriteImport   = "failed to write import report"
+
+	maxBulkSize = 10 << 20
+)
+
+type bulkResponse struct {
+	DryRun bool   `json:"dry_run"`
+	Pool   string `json:"pool"`
+	proxy.BulkSummary
+	Entries []proxy.BulkEntry `json:"entries"`
+}
+
+// handleProxyBulk adds a list in the proxy file format to a pool, dry_run=true only reports what would be added
+func (a *Api) handleProxyBulk(w http.ResponseWriter, r *http.Request) {
+	rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	w = rw
+
+	defer func() {
+		slog.Info(msgBulkImportRequested,
+			"status", rw.statusCode,
+			"method", r.Method,
+			"url", r.URL.String(),
+			"ip", r.RemoteAddr,
+		)
+	}()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	dryRun, _ := strconv.ParseBool(query.Get("dry_run"))
+	pool := query.Get("pool")
+	if pool == "" {
+		pool = a.config().ProxyFile
+	}
+	content, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBulkSize))
+	if err != nil {
+		http.Error(w, msgInvalidBulkRequest, http.StatusBadRequest)
+		return
+	}
+
+	entries, err := proxy.NewProxyLoader(a.proxyServer).ImportProxies(string(content), pool, dryRun)
+	if errors.Is(err, proxy.ErrUnknownPool) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		slog.Error(msgFailedToImportProxies, "error", err)
+		http.Error(w, msgFailedToImportProxies, http.StatusInternalServerError)
+		return
+	}
+
+	response := bulkResponse{DryRun: dryRun, Pool: pool, BulkSummary: proxy.SummarizeImport(entries), Entries: entries}
+	status := http.StatusOK
+	if response.Added > 0 {
+		status = http.StatusCreated
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error(msgFailedToWriteImport, "error", err)
+	}
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/alpkeskin/rota/internal/proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleProxyBulk(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "proxies.txt")
+	require.NoError(t, os.WriteFile(file, []byte("http://10.0.0.1:8080"), 0o644))
+
+	cfg := &config.Config{ProxyFile: file}
+	ps := proxy.NewProxyServer(cfg)
+	require.NoError(t, proxy.NewProxyLoader(ps).Load())
+	mux := NewApi(ps).routes()
+
+	list := "http://10.0.0.1:8080\nsocks5://10.0.0.2:1080 residential\nftp://10.0.0.3:21\n10.0.0.4:3128\nsocks5://10.0.0.2:1080\n"
+	post := func(target string) (int, bulkResponse) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, target, strings.NewReader(list)))
+		var response bulkResponse
+		if w.Code < http.StatusBadRequest {
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		}
+		return w.Code, response
+	}
+
+	code, response := post("/proxies/bulk?dry_run=true")
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, response.DryRun)
+	assert.Zero(t, response.Added)
+	statuses := make([]string, 0, len(response.Entries))
+	for _, entry := range response.Entries {
+		statuses = append(statuses, entry.Status)
+	}
+	assert.Equal(t, []string{proxy.BulkDuplicate, proxy.BulkValid, proxy.BulkInvalid, proxy.BulkInvalid, proxy.BulkDuplicate}, statuses)
+	assert.Equal(t, []string{"residential"}, response.Entries[1].Tags)
+	assert.Equal(t, 1, ps.ProxyCount())
+
+	code, response = post("/proxies/bulk")
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, 1, response.Added)
+	assert.Equal(t, proxy.BulkAdded, response.Entries[1].Status)
+	assert.Equal(t, 2, ps.ProxyCount())
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "http://10.0.0.1:8080\nsocks5://10.0.0.2:1080 residential\n", string(data))
+
+	code, response = post("/proxies/bulk")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Zero(t, response.Added)
+
+	code, _ = post("/proxies/bulk?pool=other.txt")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/proxies/bulk", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestHandleProxyBulkMerge(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "proxies.txt")
+	require.NoError(t, os.WriteFile(file, []byte("http://10.0.0.1:8080 fast\n"), 0o644))
+
+	cfg := &config.Config{ProxyFile: file}
+	ps := proxy.NewProxyServer(cfg)
+	require.NoError(t, proxy.NewProxyLoader(ps).Load())
+	mux := NewApi(ps).routes()
+
+	list := "http://10.0.0.1:8080 fast residential\nhttp://10.0.0.1:8080 fast\nsocks5://10.0.0.2:1080 us\nsocks5://10.0.0.2:1080 us mobile\nsocks5://10.0.0.2:1080\n"
+	post := func(target string) bulkResponse {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, target, strings.NewReader(list)))
+		var response bulkResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		return response
+	}
+	statuses := func(response bulkResponse) []string {
+		statuses := make([]string, 0, len(response.Entries))
+		for _, entry := range response.Entries {
+			statuses = append(statuses, entry.Status)
+		}
+		return statuses
+	}
+
+	response := post("/proxies/bulk?dry_run=true")
+	assert.Equal(t, []string{proxy.BulkMergeable, proxy.BulkDuplicate, proxy.BulkValid, proxy.BulkMergeable, proxy.BulkDuplicate}, statuses(response))
+	assert.Equal(t, proxy.BulkSummary{Skipped: 2}, response.BulkSummary)
+
+	response = post("/proxies/bulk")
+	assert.Equal(t, []string{proxy.BulkMerged, proxy.BulkDuplicate, proxy.BulkAdded, proxy.BulkMerged, proxy.BulkDuplicate}, statuses(response))
+	assert.Equal(t, proxy.BulkSummary{Added: 1, Merged: 2, Skipped: 2}, response.BulkSummary)
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "http://10.0.0.1:8080 fast residential\nsocks5://10.0.0.2:1080 us mobile\n", string(data))
+	require.Equal(t, 2, ps.ProxyCount())
+	assert.Equal(t, []string{"fast", "residential"}, ps.ProxyTags(ps.GetProxies()[0]))
+
+	// a second import of the same list has nothing left to do
+	response = post("/proxies/bulk")
+	assert.Equal(t, proxy.BulkSummary{Skipped: 5}, response.BulkSummary)
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/alpkeskin/rota/internal/middleware"
+)
+
+const (
+	msgCredentialsRequested     = "credentials requested"
+	msgInvalidCredentialRequest = "invalid credential request"
+	msgCredentialCreated        = "credential created"
+	msgCredentialUpdated        = "credential updated"
+	msgCredentialRemoved        = "credential removed"
+	msgFailedToWriteCredentials = "failed to write credentials"
+
+	credentialsPath = "/credentials"
+)
+
+// handleCredentials lists and creates accounts on /credentials, and updates or removes one on /credentials/<username>
+func (a *Api) handleCredentials(w http.ResponseWriter, r *http.Request) {
+	rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	w = rw
+
+	defer func() {
+		slog.Info(msgCredentialsRequested,
+			"status", rw.statusCode,
+			"method", r.Method,
+			"url", r.URL.String(),
+			"ip", r.RemoteAddr,
+		)
+	}()
+
+	credentials := a.proxyServer.Credentials()
+	username := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, credentialsPath), "/")
+	scope := tenantOf(r)
+	// accounts of other tenants look missing to a tenant token
+	if username != "" && !ownsCredential(credentials, scope, username) {
+		writeCredentialError(w, middleware.ErrCredentialNotFound)
+		return
+	}
+
+	var response any
+	status := http.StatusOK
+	switch {
+	case username == "" && r.Method == http.MethodGet:
+		response = scopedCredentials(credentials.All(), scope)
+	case username == "" && r.Method == http.MethodPost:
+		var credential middleware.Credential
+		if err := json.NewDecoder(r.Body).Decode(&credential); err != nil || credential.Username == "" || credential.Password == "" {
+			http.Error(w, msgInvalidCredentialRequest, http.StatusBadRequest)
+			return
+		}
+		if !a.scopeCredential(w, &credential, scope) {
+			return
+		}
+		if err := credentials.Add(credential); err != nil {
+			writeCredentialError(w, err)
+			return
+		}
+		slog.Info(msgCredentialCreated, "username", credential.Username)
+		credential.Password = ""
+		response = credential
+		status = http.StatusCreated
+	case username != "" && r.Method == http.MethodPut:
+		var credential middleware.Credential
+		if err := json.NewDecoder(r.Body).Decode(&credential); err != nil {
+			http.Error(w, msgInvalidCredentialRequest, http.StatusBadRequest)
+			return
+		}
+		credential.Username = username
+		if !a.scopeCredential(w, &credential, scope) {
+			return
+		}
+		if err := credentials.Update(credential); err != nil {
+			writeCredentialError(w, err)
+			return
+		}
+		slog.Info(msgCredentialUpdated, "username", username)
+		credential.Password = ""
+		response = credential
+	case username != "" && r.Method == http.MethodDelete:
+		if err := credentials.Remove(username); err != nil {
+			writeCredentialError(w, err)
+			return
+		}
+		slog.Info(msgCredentialRemoved, "username", username)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		http.Error(w, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		slog.Error(msgFailedToWriteCredentials, "error", err)
+		http.Error(w, msgFailedToWriteCredentials, http.StatusInternalServerError)
+		return
+	}
+}
+
+func writeCredentialError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, middleware.ErrCredentialExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, middleware.ErrCredentialNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// scopeCredential puts accounts created by a tenant token into its tenant, admins may only name configured tenants
+func (a *Api) scopeCredential(w http.ResponseWriter, credential *middleware.Credential, scope string) bool {
+	if scope != "" {
+		credential.Tenant = scope
+		return true
+	}
+	if !a.validTenant(credential.Tenant) {
+		http.Error(w, msgUnknownTenant, http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func ownsCredential(credentials *middleware.Credentials, scope, username string) bool {
+	if scope == "" {
+		return true
+	}
+	tenant, ok := credentials.Tenant(username)
+	return ok && tenant == scope
+}
+
+func scopedCredentials(all []middleware.Credential, scope string) []middleware.Credential {
+	if scope == "" {
+		return all
+	}
+	scoped := make([]middleware.Credential, 0, len(all))
+	for _, credential := range all {
+		if credential.Tenant == scope {
+			scoped = append(scoped, credential)
+		}
+	}
+	return scoped
+}
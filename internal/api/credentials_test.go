@@ -0,0 +1,59 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/alpkeskin/rota/internal/middleware"
+	"github.com/alpkeskin/rota/internal/proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleCredentials(t *testing.T) {
+	cfg := &config.Config{Proxy: config.ProxyConfig{Credentials: []config.CredentialConfig{
+		{Username: "alice", Password: "secret"},
+	}}}
+	mux := NewApi(proxy.NewProxyServer(cfg)).routes()
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		wantStatus int
+	}{
+		{"list", http.MethodGet, "/credentials", "", http.StatusOK},
+		{"create", http.MethodPost, "/credentials", `{"username":"bob","password":"pass","expires_at":"2030-01-01T00:00:00Z"}`, http.StatusCreated},
+		{"create existing", http.MethodPost, "/credentials", `{"username":"bob","password":"pass"}`, http.StatusConflict},
+		{"create without password", http.MethodPost, "/credentials", `{"username":"carol"}`, http.StatusBadRequest},
+		{"create invalid json", http.MethodPost, "/credentials", `{`, http.StatusBadRequest},
+		{"update", http.MethodPut, "/credentials/bob", `{"disabled":true}`, http.StatusOK},
+		{"update missing", http.MethodPut, "/credentials/carol", `{}`, http.StatusNotFound},
+		{"remove", http.MethodDelete, "/credentials/alice", "", http.StatusNoContent},
+		{"remove missing", http.MethodDelete, "/credentials/alice", "", http.StatusNotFound},
+		{"method not allowed", http.MethodDelete, "/credentials", "", http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)))
+			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.NotContains(t, w.Body.String(), `"password"`)
+		})
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/credentials", nil))
+	var credentials []middleware.Credential
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&credentials))
+	require.Len(t, credentials, 1)
+	assert.Equal(t, "bob", credentials[0].Username)
+	assert.True(t, credentials[0].Disabled)
+	assert.Nil(t, credentials[0].ExpiresAt, "an update replaces the whole account")
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/proxy"
+)
+
+const (
+	msgDrainRequested      = "drain requested"
+	msgInvalidDrainRequest = "invalid drain request"
+	msgFailedToDrainProxy  = "failed to drain proxy"
+	msgFailedToWriteDrains = "failed to write drains"
+)
+
+// handleProxyDrain lists the drains, and POST starts draining a proxy before it is removed
+func (a *Api) handleProxyDrain(w http.ResponseWriter, r *http.Request) {
+	rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	w = rw
+
+	defer func() {
+		slog.Info(msgDrainRequested,
+			"status", rw.statusCode,
+			"method", r.Method,
+			"url", r.URL.String(),
+			"ip", r.RemoteAddr,
+		)
+	}()
+
+	var response any
+	status := http.StatusOK
+	switch r.Method {
+	case http.MethodGet:
+		response = a.proxyServer.Drains()
+	case http.MethodPost:
+		var request struct {
+			Proxy   string `json:"proxy"`
+			Timeout int    `json:"timeout"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Proxy == "" || request.Timeout < 0 {
+			http.Error(w, msgInvalidDrainRequest, http.StatusBadRequest)
+			return
+		}
+		drain, err := proxy.NewProxyLoader(a.proxyServer).Drain(request.Proxy, time.Duration(request.Timeout)*time.Second)
+		switch {
+		case errors.Is(err, proxy.ErrProxyNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, proxy.ErrProxyDraining), errors.Is(err, proxy.ErrProxyDrained):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			slog.Error(msgFailedToDrainProxy, "error", err)
+			http.Error(w, msgFailedToDrainProxy, http.StatusInternalServerError)
+			return
+		}
+		response = drain
+		status = http.StatusAccepted
+	default:
+		http.Error(w, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		slog.Error(msgFailedToWriteDrains, "error", err)
+		return
+	}
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/alpkeskin/rota/internal/proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleProxyDrain(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "proxies.txt")
+	require.NoError(t, os.WriteFile(file, []byte("http://10.0.0.1:8080\nhttp://10.0.0.2:8080\n"), 0o644))
+
+	cfg := &config.Config{ProxyFile: file}
+	ps := proxy.NewProxyServer(cfg)
+	require.NoError(t, proxy.NewProxyLoader(ps).Load())
+	mux := NewApi(ps).routes()
+
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		wantStatus int
+	}{
+		{"drain", http.MethodPost, `{"proxy": "http://10.0.0.1:8080", "timeout": 5}`, http.StatusAccepted},
+		{"already draining", http.MethodPost, `{"proxy": "http://10.0.0.1:8080"}`, http.StatusConflict},
+		{"unknown proxy", http.MethodPost, `{"proxy": "http://10.0.0.9:8080"}`, http.StatusNotFound},
+		{"missing proxy", http.MethodPost, `{}`, http.StatusBadRequest},
+		{"negative timeout", http.MethodPost, `{"proxy": "http://10.0.0.2:8080", "timeout": -1}`, http.StatusBadRequest},
+		{"list", http.MethodGet, "", http.StatusOK},
+		{"method not allowed", http.MethodDelete, "", http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(tt.method, "/proxies/drain", strings.NewReader(tt.body)))
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.method == http.MethodGet {
+				var drains []proxy.DrainStatus
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&drains))
+				require.Len(t, drains, 1)
+				assert.Equal(t, "http://10.0.0.1:8080", drains[0].Proxy)
+			}
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/alpkeskin/rota/internal/proxy"
+	"github.com/goccy/go-yaml"
+)
+
+const (
+	msgProxiesExported       = "proxies exported"
+	msgUnsupportedFormat     = "unsupported export format"
+	msgFailedToExportProxies = "failed to export proxies"
+
+	formatText        = "txt"
+	formatProxychains = "proxychains"
+	formatClash       = "clash"
+	formatYAML        = "yaml"
+
+	yamlContentType = "application/yaml"
+	textContentType = "text/plain; charset=utf-8"
+)
+
+var ErrUnsupportedFormat = errors.New(msgUnsupportedFormat)
+
+type exportedProxy struct {
+	URL      string   `yaml:"url"`
+	Scheme   string   `yaml:"scheme"`
+	Host     string   `yaml:"host"`
+	Port     int      `yaml:"port"`
+	Username string   `yaml:"username,omitempty"`
+	Password string   `yaml:"password,omitempty"`
+	Pool     string   `yaml:"pool"`
+	Tags     []string `yaml:"tags"`
+}
+
+type clashProxy struct {
+	Name     string `yaml:"name"`
+	Type     string `yaml:"type"`
+	Server   string `yaml:"server"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	TLS      bool   `yaml:"tls,omitempty"`
+}
+
+// handleProxyExport writes the proxies in a format other tools read, credentials are left out unless credentials=true
+func (a *Api) handleProxyExport(w http.ResponseWriter, r *http.Request) {
+	rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	w = rw
+
+	defer func() {
+		slog.Info(msgProxiesExported,
+			"status", rw.statusCode,
+			"method", r.Method,
+			"url", r.URL.String(),
+			"ip", r.RemoteAddr,
+		)
+	}()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	withCredentials, _ := strconv.ParseBool(query.Get("credentials"))
+	body, contentType, err := ExportProxies(a.proxyServer, query.Get("format"), query.Get("pool"), query.Get("tag"), withCredentials)
+	if errors.Is(err, ErrUnsupportedFormat) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		slog.Error(msgFailedToExportProxies, "error", err)
+		http.Error(w, msgFailedToExportProxies, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	if _, err := w.Write(body); err != nil {
+		slog.Error(msgFailedToExportProxies, "error", err)
+	}
+}
+
+// ExportProxies encodes the proxies of the server in one of the export formats and returns the body with its content type.
+// It backs both the /proxies/export endpoint and the export command
+func ExportProxies(proxyServer *proxy.ProxyServer, format, pool, tag string, withCredentials bool) ([]byte, string, error) {
+	format = strings.ToLower(format)
+	if format == "" {
+		format = formatText
+	}
+	proxies := exportedProxies(proxyServer, pool, strings.ToLower(tag), withCredentials)
+
+	switch format {
+	case formatText:
+		return []byte(exportText(proxies)), textContentType, nil
+	case formatProxychains:
+		return []byte(exportProxychains(proxies)), textContentType, nil
+	case formatClash:
+		body, err := yaml.Marshal(map[string][]clashProxy{"proxies": exportClash(proxies)})
+		return body, yamlContentType, err
+	case formatYAML:
+		body, err := yaml.Marshal(map[string][]exportedProxy{"proxies": proxies})
+		return body, yamlContentType, err
+	}
+	return nil, "", fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+}
+
+// exportedProxies lists every proxy once, chains have no single address and are left out
+func exportedProxies(proxyServer *proxy.ProxyServer, pool, tag string, withCredentials bool) []exportedProxy {
+	exported := make([]exportedProxy, 0)
+	seen := make(map[string]bool)
+	for _, p := range proxyServer.GetProxies() {
+		if p.Url == nil || p.Scheme == "chain" || seen[p.Host] {
+			continue
+		}
+		tags := proxyServer.ProxyTags(p)
+		if (pool != "" && p.Pool != pool) || (tag != "" && !slices.Contains(tags, tag)) {
+			continue
+		}
+		seen[p.Host] = true
+		exported = append(exported, exportProxy(p, tags, withCredentials))
+	}
+	return exported
+}
+
+func exportProxy(p *proxy.Proxy, tags []string, withCredentials bool) exportedProxy {
+	u := *p.Url
+	port, _ := strconv.Atoi(u.Port())
+	if port == 0 {
+		port = defaultPort(u.Scheme)
+	}
+	e := exportedProxy{
+		Scheme: u.Scheme,
+		Host:   u.Hostname(),
+		Port:   port,
+		Pool:   p.Pool,
+		Tags:   tags,
+	}
+	if tags == nil {
+		e.Tags = []string{}
+	}
+	if withCredentials && u.User != nil {
+		e.Username = u.User.Username()
+		e.Password, _ = u.User.Password()
+	} else {
+		u.User = nil
+	}
+	e.URL = u.String()
+	return e
+}
+
+func exportText(proxies []exportedProxy) string {
+	var b strings.Builder
+	for _, p := range proxies {
+		b.WriteString(p.URL + "\n")
+	}
+	return b.String()
+}
+
+// exportProxychains writes a ProxyList section, proxychains has no https or socks4a type
+func exportProxychains(proxies []exportedProxy) string {
+	var b strings.Builder
+	b.WriteString("[ProxyList]\n")
+	for _, p := range proxies {
+		kind := p.Scheme
+		switch kind {
+		case "https":
+			kind = "http"
+		case "socks4a":
+			kind = "socks4"
+		}
+		fmt.Fprintf(&b, "%s %s %d", kind, p.Host, p.Port)
+		if p.Username != "" {
+			fmt.Fprintf(&b, " %s %s", p.Username, p.Password)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// exportClash writes http and socks5 proxies, clash has no socks4 support
+func exportClash(proxies []exportedProxy) []clashProxy {
+	exported := make([]clashProxy, 0, len(proxies))
+	names := make(map[string]int)
+	for _, p := range proxies {
+		c := clashProxy{
+			Name:     net.JoinHostPort(p.Host, strconv.Itoa(p.Port)),
+			Server:   p.Host,
+			Port:     p.Port,
+			Username: p.Username,
+			Password: p.Password,
+		}
+		switch p.Scheme {
+		case "http":
+			c.Type = "http"
+		case "https":
+			c.Type = "http"
+			c.TLS = true
+		case "socks5":
+			c.Type = "socks5"
+		default:
+			continue
+		}
+		if p.Username != "" {
+			c.Name = p.Username + "@" + c.Name
+		}
+		// clash needs unique names, accounts on one address look alike without credentials
+		names[c.Name]++
+		if n := names[c.Name]; n > 1 {
+			c.Name = fmt.Sprintf("%s #%d", c.Name, n)
+		}
+		exported = append(exported, c)
+	}
+	return exported
+}
+
+func defaultPort(scheme string) int {
+	switch scheme {
+	case "http":
+		return 80
+	case "https":
+		return 443
+	}
+	return 1080
+}
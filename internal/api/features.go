@@ -0,0 +1,87 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/alpkeskin/rota/internal/features"
+)
+
+const (
+	msgFeatureHistoryRequested     = "feature history requested"
+	msgFeaturesRolledBack          = "features rolled back"
+	msgInvalidRollbackRequest      = "invalid rollback request"
+	msgFailedToWriteFeatureHistory = "failed to write feature history"
+)
+
+// handleFeatureHistory lists the recorded feature flag changes, newest first
+func (a *Api) handleFeatureHistory(w http.ResponseWriter, r *http.Request) {
+	rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	w = rw
+
+	defer func() {
+		slog.Info(msgFeatureHistoryRequested,
+			"status", rw.statusCode,
+			"method", r.Method,
+			"url", r.URL.String(),
+			"ip", r.RemoteAddr,
+		)
+	}()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(a.proxyServer.Features().History())
+	if err != nil {
+		slog.Error(msgFailedToWriteFeatureHistory, "error", err)
+		http.Error(w, msgFailedToWriteFeatureHistory, http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleFeatureRollback restores the flags of a recorded version, the rollback is recorded as a change of its own
+func (a *Api) handleFeatureRollback(w http.ResponseWriter, r *http.Request) {
+	rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	w = rw
+
+	defer func() {
+		slog.Info(msgFeatureHistoryRequested,
+			"status", rw.statusCode,
+			"method", r.Method,
+			"url", r.URL.String(),
+			"ip", r.RemoteAddr,
+		)
+	}()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		Version int `json:"version"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Version <= 0 {
+		http.Error(w, msgInvalidRollbackRequest, http.StatusBadRequest)
+		return
+	}
+	change, err := a.proxyServer.Features().Rollback(request.Version, actor(r))
+	if errors.Is(err, features.ErrVersionNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	slog.Info(msgFeaturesRolledBack, "version", request.Version, "new_version", change.Version, "actor", change.Actor)
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(change)
+	if err != nil {
+		slog.Error(msgFailedToWriteFeatureHistory, "error", err)
+		http.Error(w, msgFailedToWriteFeatureHistory, http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/alpkeskin/rota/internal/proxy"
+)
+
+const (
+	msgInFlightRequested     = "in flight requests requested"
+	msgFailedToWriteInFlight = "failed to write in flight requests"
+)
+
+type inFlightResponse struct {
+	InFlight int64             `json:"in_flight"`
+	Tunnels  *int64            `json:"tunnels,omitempty"`
+	Proxies  []proxy.ProxyLoad `json:"proxies"`
+}
+
+// handleInFlight lists how many requests every proxy serves right now, a tenant token only sees its pool and no
+// instance wide tunnel count
+func (a *Api) handleInFlight(w http.ResponseWriter, r *http.Request) {
+	rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	w = rw
+
+	defer func() {
+		slog.Info(msgInFlightRequested,
+			"status", rw.statusCode,
+			"method", r.Method,
+			"url", r.URL.String(),
+			"ip", r.RemoteAddr,
+		)
+	}()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	pool := a.tenantPool(r)
+	response := inFlightResponse{Proxies: a.proxyServer.InFlight(pool)}
+	for _, load := range response.Proxies {
+		response.InFlight += load.InFlight
+	}
+	if pool == "" {
+		tunnels := a.proxyServer.Stats().Tunnels()
+		response.Tunnels = &tunnels
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error(msgFailedToWriteInFlight, "error", err)
+	}
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/alpkeskin/rota/internal/proxy"
+)
+
+const (
+	msgProxyLatencyRequested = "proxy latency requested"
+	msgMissingLatencyProxy   = "missing proxy"
+	msgLatencyProxyNotFound  = "proxy not found"
+	msgFailedToWriteLatency  = "failed to write proxy latency"
+)
+
+type proxyLatencyResponse struct {
+	Proxy        string                   `json:"proxy"`
+	Pool         string                   `json:"pool"`
+	AvgResponse  int64                    `json:"avg_response_time"`
+	ResponseTime proxy.LatencyPercentiles `json:"response_time"`
+	Hours        []proxy.LatencyHour      `json:"hours"`
+}
+
+// handleProxyLatency returns the response time percentiles of one proxy for each of the last hours, a tenant token
+// only finds the proxies of its pool
+func (a *Api) handleProxyLatency(w http.ResponseWriter, r *http.Request) {
+	rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	w = rw
+
+	defer func() {
+		slog.Info(msgProxyLatencyRequested,
+			"status", rw.statusCode,
+			"method", r.Method,
+			"url", r.URL.String(),
+			"ip", r.RemoteAddr,
+		)
+	}()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+	host := r.URL.Query().Get("proxy")
+	if host == "" {
+		http.Error(w, msgMissingLatencyProxy, http.StatusBadRequest)
+		return
+	}
+	pool := a.tenantPool(r)
+	proxies := a.proxyServer.GetProxies()
+	i := slices.IndexFunc(proxies, func(p *proxy.Proxy) bool {
+		return p.Host == host && (pool == "" || p.Pool == pool)
+	})
+	if i < 0 {
+		http.Error(w, msgLatencyProxyNotFound, http.StatusNotFound)
+		return
+	}
+	p := proxies[i]
+
+	response := proxyLatencyResponse{
+		Proxy:        p.Host,
+		Pool:         p.Pool,
+		AvgResponse:  a.proxyServer.ProxyLatency(p).Milliseconds(),
+		ResponseTime: a.proxyServer.ProxyLatencyPercentiles(p, proxy.LatencyHours),
+		Hours:        a.proxyServer.ProxyLatencyHours(p),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.Error(msgFailedToWriteLatency, "error", err)
+	}
+}
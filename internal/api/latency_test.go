@@ -0,0 +1,60 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/alpkeskin/rota/internal/proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleProxyLatency(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+
+	cfg := &config.Config{Healthcheck: config.HealthcheckConfig{URL: target.URL, Status: http.StatusOK, Timeout: 5}}
+	ps := proxy.NewProxyServer(cfg)
+	measured := &proxy.Proxy{Host: "http://10.0.0.1:8080", Pool: "proxies.txt", Transport: &http.Transport{}}
+	ps.AddProxy(measured)
+	ps.AddProxy(&proxy.Proxy{Host: "http://10.0.0.2:8080", Pool: "proxies.txt", Transport: &http.Transport{}})
+	// a healthcheck answered straight by the target is a response time sample
+	require.NoError(t, proxy.NewProxyChecker(ps).CheckProxy(measured))
+	api := NewApi(ps)
+	mux := api.routes()
+
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+		wantHours  int
+	}{
+		{"measured", "/proxies/latency?proxy=http://10.0.0.1:8080", http.StatusOK, 1},
+		{"unmeasured", "/proxies/latency?proxy=http://10.0.0.2:8080", http.StatusOK, 0},
+		{"unknown proxy", "/proxies/latency?proxy=http://10.0.0.9:8080", http.StatusNotFound, 0},
+		{"missing proxy", "/proxies/latency", http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			require.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var response proxyLatencyResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+			assert.NotNil(t, response.Hours)
+			assert.Len(t, response.Hours, tt.wantHours)
+			assert.Equal(t, uint64(tt.wantHours), response.ResponseTime.Samples)
+		})
+	}
+
+	// only measured proxies make the dashboard's slowest list
+	slowest := api.slowest()
+	require.Len(t, slowest, 1)
+	assert.Equal(t, measured.Host, slowest[0].Proxy)
+}
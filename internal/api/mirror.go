@@ -0,0 +1,37 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+const (
+	msgMirrorRequested     = "mirror stats requested"
+	msgFailedToWriteMirror = "failed to write mirror stats"
+)
+
+// handleMirror compares the main pool with the mirror pool on the mirrored requests since startup
+func (a *Api) handleMirror(w http.ResponseWriter, r *http.Request) {
+	rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	w = rw
+
+	defer func() {
+		slog.Info(msgMirrorRequested,
+			"status", rw.statusCode,
+			"method", r.Method,
+			"url", r.URL.String(),
+			"ip", r.RemoteAddr,
+		)
+	}()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(a.proxyServer.MirrorStats()); err != nil {
+		slog.Error(msgFailedToWriteMirror, "error", err)
+	}
+}
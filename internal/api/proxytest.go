@@ -0,0 +1,141 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/proxy"
+)
+
+const (
+	msgProxyTestRequested       = "proxy test requested"
+	msgProxyTestStreamRequested = "proxy test stream requested"
+	msgInvalidProxyTestRequest  = "invalid proxy test request"
+	msgMissingTestID            = "missing test id"
+	msgFailedToWriteProxyTest   = "failed to write proxy test"
+
+	testProgressInterval = 500 * time.Millisecond
+)
+
+// handleProxyTest starts a healthcheck of the listed proxies with POST, GET returns a test with its results
+func (a *Api) handleProxyTest(w http.ResponseWriter, r *http.Request) {
+	rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	w = rw
+
+	defer func() {
+		slog.Info(msgProxyTestRequested,
+			"status", rw.statusCode,
+			"method", r.Method,
+			"url", r.URL.String(),
+			"ip", r.RemoteAddr,
+		)
+	}()
+
+	var job proxy.TestJob
+	var err error
+	status := http.StatusOK
+	switch r.Method {
+	case http.MethodGet:
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			http.Error(w, msgMissingTestID, http.StatusBadRequest)
+			return
+		}
+		job, err = a.proxyServer.TestJob(id, 0)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	case http.MethodPost:
+		var request struct {
+			Proxies []string `json:"proxies"`
+			Pool    string   `json:"pool"`
+		}
+		// an empty body tests the whole pool
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, fmt.Sprintf("%s: %v", msgInvalidProxyTestRequest, err), http.StatusBadRequest)
+			return
+		}
+		job, err = proxy.NewProxyChecker(a.proxyServer).StartTest(request.Proxies, request.Pool)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		status = http.StatusAccepted
+	default:
+		http.Error(w, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(job); err != nil {
+		slog.Error(msgFailedToWriteProxyTest, "error", err)
+	}
+}
+
+// handleProxyTestStream sends the progress of a test as progress events, each with the results since the last one,
+// and a done event once every proxy is checked
+func (a *Api) handleProxyTestStream(w http.ResponseWriter, r *http.Request) {
+	rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	w = rw
+
+	defer func() {
+		slog.Info(msgProxyTestStreamRequested,
+			"status", rw.statusCode,
+			"method", r.Method,
+			"url", r.URL.String(),
+			"ip", r.RemoteAddr,
+		)
+	}()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, msgMissingTestID, http.StatusBadRequest)
+		return
+	}
+	job, err := a.proxyServer.TestJob(id, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	stream, err := startEventStream(w)
+	if err != nil {
+		return
+	}
+	ticker := time.NewTicker(testProgressInterval)
+	defer ticker.Stop()
+	sent := 0
+	for {
+		if len(job.Results) > 0 {
+			if err := stream.send("progress", job); err != nil {
+				return
+			}
+			sent += len(job.Results)
+		}
+		if job.FinishedAt != nil {
+			job.Results = []proxy.TestResult{}
+			_ = stream.send("done", job)
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+		// a test dropped from the table while streaming is long finished, nothing more will come
+		if job, err = a.proxyServer.TestJob(id, sent); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/proxy"
+)
+
+const (
+	msgRequestsRequested     = "requests requested"
+	msgInvalidRequestsFilter = "invalid requests filter"
+	msgFailedToWriteRequests = "failed to write requests"
+	msgRequestsDropped       = "recorded requests dropped"
+
+	defaultRequestsLimit = 100
+	maxRequestsLimit     = 1000
+)
+
+type dropRequestsResponse struct {
+	Removed int `json:"removed"`
+}
+
+type requestsResponse struct {
+	Total      int                   `json:"total"`
+	Offset     int                   `json:"offset"`
+	Limit      int                   `json:"limit"`
+	NextCursor string                `json:"next_cursor,omitempty"`
+	Requests   []proxy.RequestRecord `json:"requests"`
+}
+
+func (a *Api) handleRequests(w http.ResponseWriter, r *http.Request) {
+	rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	w = rw
+
+	defer func() {
+		slog.Info(msgRequestsRequested,
+			"status", rw.statusCode,
+			"method", r.Method,
+			"url", r.URL.String(),
+			"ip", r.RemoteAddr,
+		)
+	}()
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodDelete:
+		a.dropRequests(w, r)
+		return
+	default:
+		http.Error(w, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, err := parseRequestFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("%s: %v", msgInvalidRequestsFilter, err), http.StatusBadRequest)
+		return
+	}
+	// tenant tokens only see their own requests whatever the query asks for
+	if tenant := tenantOf(r); tenant != "" {
+		filter.Tenant = tenant
+	}
+
+	requests, total := a.proxyServer.Requests(filter)
+	response := requestsResponse{
+		Total:    total,
+		Offset:   filter.Offset,
+		Limit:    filter.Limit,
+		Requests: requests,
+	}
+	if len(requests) > 0 && filter.Offset+len(requests) < total {
+		response.NextCursor = encodeCursor(requests[len(requests)-1].ID)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		slog.Error(msgFailedToWriteRequests, "error", err)
+		http.Error(w, msgFailedToWriteRequests, http.StatusInternalServerError)
+		return
+	}
+}
+
+// dropRequests frees the history of the attempts that started before the before query, of all of them without it.
+// The history is shared by every tenant, so tenant tokens may not drop it
+func (a *Api) dropRequests(w http.ResponseWriter, r *http.Request) {
+	if tenantOf(r) != "" {
+		http.Error(w, msgAdminOnly, http.StatusForbidden)
+		return
+	}
+
+	var before time.Time
+	if value := r.URL.Query().Get("before"); value != "" {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%s: before: %v", msgInvalidRequestsFilter, err), http.StatusBadRequest)
+			return
+		}
+		before = t
+	}
+
+	removed := a.proxyServer.DropRequests(before)
+	slog.Info(msgRequestsDropped, "removed", removed)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(dropRequestsResponse{Removed: removed}); err != nil {
+		slog.Error(msgFailedToWriteRequests, "error", err)
+	}
+}
+
+func parseRequestFilter(query url.Values) (proxy.RequestFilter, error) {
+	filter := proxy.RequestFilter{
+		Proxy:      query.Get("proxy"),
+		Credential: query.Get("credential"),
+		Tenant:     query.Get("tenant"),
+		ErrorClass: query.Get("error_class"),
+		URL:        query.Get("url"),
+		Limit:      defaultRequestsLimit,
+	}
+
+	if value := query.Get("success"); value != "" {
+		success, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, fmt.Errorf("success: %w", err)
+		}
+		filter.Success = &success
+	}
+
+	ints := []struct {
+		name  string
+		value *int
+	}{
+		{"status_min", &filter.StatusMin},
+		{"status_max", &filter.StatusMax},
+		{"offset", &filter.Offset},
+		{"limit", &filter.Limit},
+	}
+	for _, param := range ints {
+		value := query.Get(param.name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return filter, fmt.Errorf("%s: must be a non negative integer", param.name)
+		}
+		*param.value = n
+	}
+	if filter.Limit == 0 {
+		filter.Limit = defaultRequestsLimit
+	}
+	if cursor := query.Get("cursor"); cursor != "" {
+		if filter.Offset > 0 {
+			return filter, errors.New("cursor: can not be combined with offset")
+		}
+		before, err := decodeCursor(cursor)
+		if err != nil {
+			return filter, fmt.Errorf("cursor: %w", err)
+		}
+		filter.Before = before
+	}
+	filter.Limit = min(filter.Limit, maxRequestsLimit)
+
+	times := []struct {
+		name  string
+		value *time.Time
+	}{
+		{"since", &filter.Since},
+		{"until", &filter.Until},
+	}
+	for _, param := range times {
+		value := query.Get(param.name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return filter, fmt.Errorf("%s: %w", param.name, err)
+		}
+		*param.value = t
+	}
+	return filter, nil
+}
+
+// cursors are opaque to clients, they carry the id of the last record of a page
+func encodeCursor(id uint64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(id, 10)))
+}
+
+func decodeCursor(cursor string) (uint64, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(data), 10, 64)
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/alpkeskin/rota/internal/proxy"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleRequests(t *testing.T) {
+	cfg := &config.Config{History: config.HistoryConfig{Enabled: true}}
+	mux := NewApi(proxy.NewProxyServer(cfg)).routes()
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		wantStatus int
+		wantLimit  int
+	}{
+		{"default limit", http.MethodGet, "/requests", http.StatusOK, defaultRequestsLimit},
+		{"filters", http.MethodGet, "/requests?proxy=a:1&success=false&status_min=500&status_max=599&url=example&since=2024-01-01T00:00:00Z&offset=10&limit=5", http.StatusOK, 5},
+		{"limit is capped", http.MethodGet, "/requests?limit=5000", http.StatusOK, maxRequestsLimit},
+		{"invalid success", http.MethodGet, "/requests?success=maybe", http.StatusBadRequest, 0},
+		{"negative offset", http.MethodGet, "/requests?offset=-1", http.StatusBadRequest, 0},
+		{"invalid time", http.MethodGet, "/requests?until=yesterday", http.StatusBadRequest, 0},
+		{"cursor", http.MethodGet, "/requests?limit=10&cursor=" + encodeCursor(42), http.StatusOK, 10},
+		{"invalid cursor", http.MethodGet, "/requests?cursor=bm9wZQ", http.StatusBadRequest, 0},
+		{"cursor with offset", http.MethodGet, "/requests?offset=10&cursor=" + encodeCursor(42), http.StatusBadRequest, 0},
+		{"method not allowed", http.MethodPost, "/requests", http.StatusMethodNotAllowed, 0},
+		{"invalid drop time", http.MethodDelete, "/requests?before=yesterday", http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, nil))
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var response requestsResponse
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+			assert.Equal(t, tt.wantLimit, response.Limit)
+			assert.Zero(t, response.Total)
+			assert.NotNil(t, response.Requests)
+		})
+	}
+}
+
+func TestHandleDropRequests(t *testing.T) {
+	cfg := &config.Config{History: config.HistoryConfig{Enabled: true}}
+	mux := NewApi(proxy.NewProxyServer(cfg)).routes()
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/requests?before=2024-01-01T00:00:00Z", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response dropRequestsResponse
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.Zero(t, response.Removed)
+}
+
+func TestHandleRequestsDisabled(t *testing.T) {
+	cfg := &config.Config{}
+	mux := NewApi(proxy.NewProxyServer(cfg)).routes()
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/requests", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
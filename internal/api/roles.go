@@ -0,0 +1,100 @@
+package api
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const (
+	// roleViewer reads proxies, requests and status, roleOperator also manages the proxies and roleAdmin changes everything
+	roleViewer   = "viewer"
+	roleOperator = "operator"
+	roleAdmin    = "admin"
+
+	msgInsufficientRole = "not available to this role"
+	msgUnknownRole      = "api user has an unknown role, skipping it"
+)
+
+// roles in order of what they may do, every role may do what the ones before it may
+var roles = []string{roleViewer, roleOperator, roleAdmin}
+
+type roleKey struct{}
+
+// roleOf returns the role of the token, tenant tokens have none
+func roleOf(r *http.Request) string {
+	role, _ := r.Context().Value(roleKey{}).(string)
+	return role
+}
+
+func hasRole(role, required string) bool {
+	return slices.Index(roles, role) >= slices.Index(roles, required)
+}
+
+// login returns the role of the configured api user with this username and password, the main user is an admin.
+// Users with an unknown role can not log in
+func (au *authenticator) login(username, password string) (string, bool) {
+	if au.cfg.Username != "" && validLogin(username, password, au.cfg.Username, au.cfg.Password) {
+		return roleAdmin, true
+	}
+	for _, user := range au.cfg.Users {
+		if user.Username == "" || !validLogin(username, password, user.Username, user.Password) {
+			continue
+		}
+		role := strings.ToLower(user.Role)
+		if !slices.Contains(roles, role) {
+			slog.Error(msgUnknownRole, "username", user.Username, "role", user.Role)
+			return "", false
+		}
+		return role, true
+	}
+	return "", false
+}
+
+func validLogin(username, password, wantUsername, wantPassword string) bool {
+	validUser := subtle.ConstantTimeCompare([]byte(username), []byte(wantUsername)) == 1
+	validPassword := subtle.ConstantTimeCompare([]byte(password), []byte(wantPassword)) == 1
+	return validUser && validPassword
+}
+
+// requireRole wraps instance wide handlers: GET and HEAD requests need the read role, the other methods the write
+// role. Tenant tokens are refused
+func (a *Api) requireRole(read, write string, next http.HandlerFunc) http.HandlerFunc {
+	return a.authorize(read, write, false, next)
+}
+
+// requireScoped wraps handlers that limit tenant tokens to the tenant's data, other tokens need the roles of requireRole
+func (a *Api) requireScoped(read, write string, next http.HandlerFunc) http.HandlerFunc {
+	return a.authorize(read, write, true, next)
+}
+
+// requireAdmin wraps handlers that change or show the whole instance, only admins reach them
+func (a *Api) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return a.requireRole(roleAdmin, roleAdmin, next)
+}
+
+func (a *Api) authorize(read, write string, tenants bool, next http.HandlerFunc) http.HandlerFunc {
+	return a.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		if tenantOf(r) != "" {
+			if !tenants {
+				http.Error(w, msgAdminOnly, http.StatusForbidden)
+				return
+			}
+			next(w, r)
+			return
+		}
+
+		required := write
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			required = read
+		}
+		// without authentication there are no roles, every handler is open
+		if a.auth != nil && !hasRole(roleOf(r), required) {
+			http.Error(w, msgInsufficientRole, http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
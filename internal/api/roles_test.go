@@ -0,0 +1,94 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/alpkeskin/rota/internal/proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoles(t *testing.T) {
+	cfg := &config.Config{
+		Api: config.ApiConfig{
+			Authentication: config.ApiAuthenticationConfig{
+				Enabled:  true,
+				Username: "admin",
+				Password: "secret",
+				Users: []config.ApiUserConfig{
+					{Username: "olivia", Password: "o", Role: "operator"},
+					{Username: "victor", Password: "v", Role: "viewer"},
+					{Username: "mallory", Password: "m", Role: "root"},
+				},
+			},
+		},
+	}
+	mux := NewApi(proxy.NewProxyServer(cfg)).routes()
+
+	do := func(method, path, body, token string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w.Code
+	}
+	login := func(username, password string) tokenResponse {
+		req := httptest.NewRequest(http.MethodPost, "/auth/token", strings.NewReader(`{"username": "`+username+`", "password": "`+password+`"}`))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, username)
+		var tokens tokenResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&tokens))
+		return tokens
+	}
+	admin, operator, viewer := login("admin", "secret"), login("olivia", "o"), login("victor", "v")
+	assert.Equal(t, roleAdmin, admin.Role)
+	assert.Equal(t, roleOperator, operator.Role)
+	assert.Equal(t, roleViewer, viewer.Role)
+
+	// a user with an unknown role can not log in
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/token", strings.NewReader(`{"username": "mallory", "password": "m"}`)))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	tests := []struct {
+		method, path, body      string
+		admin, operator, viewer bool
+	}{
+		{http.MethodGet, "/proxies", "", true, true, true},
+		{http.MethodGet, "/healthcheck", "", true, true, true},
+		{http.MethodGet, "/features", "", true, true, true},
+		{http.MethodPost, "/healthcheck/pause", "", true, true, false},
+		{http.MethodPost, "/proxies/tags", `{"proxies": ["http://10.0.0.1:8080"], "tags": ["x"]}`, true, true, false},
+		{http.MethodGet, "/proxies/export", "", true, true, false},
+		{http.MethodPut, "/features", `{"name": "sticky", "enabled": true}`, true, false, false},
+		{http.MethodGet, "/credentials", "", true, false, false},
+	}
+	for _, tt := range tests {
+		for _, role := range []struct {
+			token   string
+			allowed bool
+		}{{admin.AccessToken, tt.admin}, {operator.AccessToken, tt.operator}, {viewer.AccessToken, tt.viewer}} {
+			code := do(tt.method, tt.path, tt.body, role.token)
+			if role.allowed {
+				assert.NotEqual(t, http.StatusForbidden, code, tt.method+" "+tt.path)
+			} else {
+				assert.Equal(t, http.StatusForbidden, code, tt.method+" "+tt.path)
+			}
+		}
+	}
+
+	// refreshed tokens keep the role
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/refresh", strings.NewReader(`{"refresh_token": "`+viewer.RefreshToken+`"}`)))
+	require.Equal(t, http.StatusOK, w.Code)
+	var refreshed tokenResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&refreshed))
+	assert.Equal(t, roleViewer, refreshed.Role)
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPost, "/healthcheck/resume", "", refreshed.AccessToken))
+}
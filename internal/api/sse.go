@@ -0,0 +1,251 @@
+package api
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/logging"
+	"github.com/alpkeskin/rota/internal/proxy"
+)
+
+const (
+	msgLogStreamRequested       = "log stream requested"
+	msgDashboardStreamRequested = "dashboard stream requested"
+	msgLogStreamUnavailable     = "log stream unavailable"
+	msgInvalidStreamQuery       = "invalid stream query"
+
+	// a comment line every keepAlive keeps idle streams open through proxies that close silent connections
+	sseKeepAlive             = 15 * time.Second
+	sseLogBuffer             = 256
+	defaultDashboardInterval = 5
+	dashboardBusiest         = 10
+	dashboardSlowest         = 10
+	// the slowest list covers the current and the previous hour, so it does not empty at the top of every hour
+	dashboardLatencyHours = 2
+)
+
+// dashboardEvent is the /metrics document with the proxies serving the most requests right now and the slowest ones
+type dashboardEvent struct {
+	*metrics
+	Busiest []proxy.ProxyLoad `json:"busiest"`
+	Slowest []slowProxy       `json:"slowest"`
+}
+
+// slowProxy is a proxy of the dashboard's slowest list with its recent response time percentiles
+type slowProxy struct {
+	Proxy string `json:"proxy"`
+	Pool  string `json:"pool"`
+	proxy.LatencyPercentiles
+}
+
+// eventStream writes server-sent events, every event is flushed right away
+type eventStream struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+// logFilter selects streamed log entries, zero values match everything
+type logFilter struct {
+	level     slog.Level
+	query     string
+	proxy     string
+	requestID string
+}
+
+// SetLogStream makes the records of the stream available at /sse/logs
+func (a *Api) SetLogStream(stream *logging.Stream) {
+	a.logs = stream
+}
+
+func startEventStream(w http.ResponseWriter) (*eventStream, error) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// nginx buffers responses unless told otherwise, which holds events back until the buffer fills
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	s := &eventStream{w: w, rc: http.NewResponseController(w)}
+	return s, s.rc.Flush()
+}
+
+func (s *eventStream) send(event string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+func (s *eventStream) keepAlive() error {
+	if _, err := fmt.Fprint(s.w, ": keep-alive\n\n"); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// handleLogStream sends log records as they are written, as log events
+func (a *Api) handleLogStream(w http.ResponseWriter, r *http.Request) {
+	rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	w = rw
+
+	defer func() {
+		slog.Info(msgLogStreamRequested,
+			"status", rw.statusCode,
+			"method", r.Method,
+			"url", r.URL.String(),
+			"ip", r.RemoteAddr,
+		)
+	}()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+	if a.logs == nil {
+		http.Error(w, msgLogStreamUnavailable, http.StatusServiceUnavailable)
+		return
+	}
+	filter, err := parseLogFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("%s: %v", msgInvalidStreamQuery, err), http.StatusBadRequest)
+		return
+	}
+
+	entries, unsubscribe := a.logs.Subscribe(sseLogBuffer)
+	defer unsubscribe()
+	stream, err := startEventStream(w)
+	if err != nil {
+		return
+	}
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			err = stream.keepAlive()
+		case entry := <-entries:
+			if filter.matches(entry) {
+				err = stream.send("log", entry)
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// handleDashboardStream sends the /metrics document and the busiest proxies every interval seconds, as metrics events
+func (a *Api) handleDashboardStream(w http.ResponseWriter, r *http.Request) {
+	rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	w = rw
+
+	defer func() {
+		slog.Info(msgDashboardStreamRequested,
+			"status", rw.statusCode,
+			"method", r.Method,
+			"url", r.URL.String(),
+			"ip", r.RemoteAddr,
+		)
+	}()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+	interval := defaultDashboardInterval
+	if value := r.URL.Query().Get("interval"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			http.Error(w, fmt.Sprintf("%s: interval: must be a positive number of seconds", msgInvalidStreamQuery), http.StatusBadRequest)
+			return
+		}
+		interval = n
+	}
+
+	stream, err := startEventStream(w)
+	if err != nil {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		metrics, err := a.metrics()
+		if err != nil {
+			slog.Error(msgFailedToCollectMetrics, "error", err)
+		} else if err := stream.send("metrics", dashboardEvent{metrics: metrics, Busiest: a.busiest(), Slowest: a.slowest()}); err != nil {
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// busiest returns the proxies with requests in flight, at most dashboardBusiest of them
+func (a *Api) busiest() []proxy.ProxyLoad {
+	loads := a.proxyServer.InFlight("")
+	n := 0
+	for n < len(loads) && n < dashboardBusiest && loads[n].InFlight > 0 {
+		n++
+	}
+	return loads[:n]
+}
+
+// slowest returns the proxies with the highest p99 response time since the start of the previous hour, at most
+// dashboardSlowest of them
+func (a *Api) slowest() []slowProxy {
+	slow := make([]slowProxy, 0)
+	for _, p := range a.proxyServer.GetProxies() {
+		percentiles := a.proxyServer.ProxyLatencyPercentiles(p, dashboardLatencyHours)
+		if percentiles.Samples > 0 {
+			slow = append(slow, slowProxy{Proxy: p.Host, Pool: p.Pool, LatencyPercentiles: percentiles})
+		}
+	}
+	slices.SortFunc(slow, func(a, b slowProxy) int {
+		return cmp.Or(cmp.Compare(b.P99, a.P99), cmp.Compare(a.Proxy, b.Proxy))
+	})
+	return slow[:min(len(slow), dashboardSlowest)]
+}
+
+func parseLogFilter(query url.Values) (logFilter, error) {
+	filter := logFilter{
+		level:     slog.LevelInfo,
+		query:     strings.ToLower(query.Get("q")),
+		proxy:     query.Get("proxy"),
+		requestID: query.Get("request_id"),
+	}
+	if value := query.Get("level"); value != "" {
+		if err := filter.level.UnmarshalText([]byte(value)); err != nil {
+			return filter, fmt.Errorf("level: %w", err)
+		}
+	}
+	return filter, nil
+}
+
+func (f logFilter) matches(entry logging.Entry) bool {
+	switch {
+	case entry.Level < f.level:
+		return false
+	case f.query != "" && !strings.Contains(strings.ToLower(entry.Message), f.query):
+		return false
+	case f.proxy != "" && entry.Attrs["proxy"] != f.proxy:
+		return false
+	case f.requestID != "" && entry.Attrs["request_id"] != f.requestID:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/alpkeskin/rota/internal/logging"
+	"github.com/alpkeskin/rota/internal/proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogStream(t *testing.T) {
+	logger, err := logging.NewLogger(&config.Config{})
+	require.NoError(t, err)
+	previous := slog.Default()
+	logger.Setup()
+	defer slog.SetDefault(previous)
+	cfg := &config.Config{}
+	api := NewApi(proxy.NewProxyServer(cfg))
+	api.SetLogStream(logger.Stream())
+	server := httptest.NewServer(api.routes())
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/sse/logs?level=warn&proxy=10.0.0.1:8080")
+	require.NoError(t, err)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "text/event-stream", response.Header.Get("Content-Type"))
+
+	// the subscription starts with the response, keep logging until the filtered record comes through
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			slog.Info("below the level", "proxy", "10.0.0.1:8080")
+			slog.Warn("other proxy", "proxy", "10.0.0.2:8080")
+			slog.Warn("dead proxy", "proxy", "10.0.0.1:8080")
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}()
+
+	reader := bufio.NewReader(response.Body)
+	event, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "event: log\n", event)
+	data, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	var entry logging.Entry
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(data, "data: ")), &entry))
+	assert.Equal(t, "dead proxy", entry.Message)
+	assert.Equal(t, slog.LevelWarn, entry.Level)
+}
+
+func TestStreamQueries(t *testing.T) {
+	cfg := &config.Config{}
+	mux := NewApi(proxy.NewProxyServer(cfg)).routes()
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/sse/logs", http.StatusServiceUnavailable},
+		{"/sse/dashboard?interval=0", http.StatusBadRequest},
+		{"/sse/dashboard?interval=soon", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		assert.Equal(t, tt.want, w.Code, tt.path)
+	}
+
+	filter, err := parseLogFilter(map[string][]string{"level": {"debug"}, "q": {"Dead"}})
+	require.NoError(t, err)
+	assert.True(t, filter.matches(logging.Entry{Level: slog.LevelDebug, Message: "dead proxy"}))
+	assert.False(t, filter.matches(logging.Entry{Level: slog.LevelError, Message: "alive proxy"}))
+	_, err = parseLogFilter(map[string][]string{"level": {"loud"}})
+	assert.Error(t, err)
+}
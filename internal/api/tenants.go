@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/alpkeskin/rota/internal/proxy"
+)
+
+const (
+	msgTenantsRequested     = "tenants requested"
+	msgFailedToWriteTenants = "failed to write tenants"
+	msgAdminOnly            = "not available to tenants"
+	msgUnknownTenant        = "unknown tenant"
+)
+
+type tenantResponse struct {
+	Name      string            `json:"name"`
+	ProxyFile string            `json:"proxy_file"`
+	Tag       string            `json:"tag,omitempty"`
+	Proxies   int               `json:"proxies"`
+	Usage     proxy.TenantUsage `json:"usage"`
+}
+
+type tenantKey struct{}
+
+// tenantOf names the tenant of the token, admin tokens and disabled authentication have none
+func tenantOf(r *http.Request) string {
+	tenant, _ := r.Context().Value(tenantKey{}).(string)
+	return tenant
+}
+
+func (a *Api) validTenant(name string) bool {
+	if name == "" {
+		return true
+	}
+	_, ok := a.proxyServer.Tenant(name)
+	return ok
+}
+
+// tenantLogin returns the tenant whose api username and password match
+func (a *Api) tenantLogin(username, password string) string {
+	for _, tenant := range a.config().Tenants {
+		if tenant.Api.Username == "" || !a.validTenant(tenant.Name) {
+			continue
+		}
+		if validLogin(username, password, tenant.Api.Username, tenant.Api.Password) {
+			return tenant.Name
+		}
+	}
+	return ""
+}
+
+// tenantPool is the pool a tenant token may see, empty for admins
+func (a *Api) tenantPool(r *http.Request) string {
+	tenant, _ := a.proxyServer.Tenant(tenantOf(r))
+	return tenant.ProxyFile
+}
+
+// handleTenants lists the tenants with their usage, a tenant token only sees its own tenant
+func (a *Api) handleTenants(w http.ResponseWriter, r *http.Request) {
+	rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	w = rw
+
+	defer func() {
+		slog.Info(msgTenantsRequested,
+			"status", rw.statusCode,
+			"method", r.Method,
+			"url", r.URL.String(),
+			"ip", r.RemoteAddr,
+		)
+	}()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, msgMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	counts := make(map[string]int)
+	for _, p := range a.proxyServer.GetProxies() {
+		counts[p.Pool]++
+	}
+	scope := tenantOf(r)
+	seen := make(map[string]bool)
+	configured := a.config().Tenants
+	tenants := make([]tenantResponse, 0, len(configured))
+	for _, cfg := range configured {
+		tenant, ok := a.proxyServer.Tenant(cfg.Name)
+		if !ok || seen[tenant.Name] || (scope != "" && tenant.Name != scope) {
+			continue
+		}
+		seen[tenant.Name] = true
+		tenants = append(tenants, tenantResponse{
+			Name:      tenant.Name,
+			ProxyFile: tenant.ProxyFile,
+			Tag:       tenant.Tag,
+			Proxies:   counts[tenant.ProxyFile],
+			Usage:     a.proxyServer.TenantUsage(tenant.Name),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(tenants); err != nil {
+		slog.Error(msgFailedToWriteTenants, "error", err)
+		http.Error(w, msgFailedToWriteTenants, http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/alpkeskin/rota/internal/middleware"
+	"github.com/alpkeskin/rota/internal/proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenantScopedApi(t *testing.T) {
+	cfg := &config.Config{
+		ProxyFile: "proxies.txt",
+		Api: config.ApiConfig{
+			Authentication: config.ApiAuthenticationConfig{Enabled: true, Username: "admin", Password: "secret"},
+		},
+		Proxy: config.ProxyConfig{
+			Credentials: []config.CredentialConfig{
+				{Username: "alice", Password: "a", Tenant: "team-a"},
+				{Username: "bob", Password: "b"},
+			},
+		},
+		Tenants: []config.TenantConfig{
+			{Name: "team-a", ProxyFile: "team-a.txt", Api: config.TenantApiConfig{Username: "team-a-admin", Password: "pass"}},
+			{Name: "team-b", ProxyFile: "team-b.txt"},
+		},
+	}
+	ps := proxy.NewProxyServer(cfg)
+	pl := proxy.NewProxyLoader(ps)
+	var proxies []*proxy.Proxy
+	for _, pool := range []string{"proxies.txt", "team-a.txt"} {
+		p, err := pl.CreateProxy("http://127.0.0.1:8080")
+		require.NoError(t, err)
+		p.Pool = pool
+		proxies = append(proxies, p)
+	}
+	ps.SetProxies(proxies)
+	mux := NewApi(ps).routes()
+
+	do := func(method, path, body, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+	login := func(username, password string) string {
+		w := do(http.MethodPost, "/auth/token", `{"username": "`+username+`", "password": "`+password+`"}`, "")
+		require.Equal(t, http.StatusOK, w.Code)
+		var tokens tokenResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&tokens))
+		return tokens.AccessToken
+	}
+	admin := login("admin", "secret")
+	tenant := login("team-a-admin", "pass")
+
+	// tenants can not reach instance wide endpoints
+	for _, path := range []string{"/features", "/sources", "/healthcheck", "/proxies/export", "/rotation/next", "/rotation/rotate"} {
+		assert.Equal(t, http.StatusForbidden, do(http.MethodGet, path, "", tenant).Code, path)
+		assert.NotEqual(t, http.StatusForbidden, do(http.MethodGet, path, "", admin).Code, path)
+	}
+
+	var listed []map[string]any
+	require.NoError(t, json.NewDecoder(do(http.MethodGet, "/proxies", "", tenant).Body).Decode(&listed))
+	require.Len(t, listed, 1)
+	assert.Equal(t, "team-a.txt", listed[0]["pool"])
+	require.NoError(t, json.NewDecoder(do(http.MethodGet, "/proxies", "", admin).Body).Decode(&listed))
+	assert.Len(t, listed, 2)
+
+	var credentials []middleware.Credential
+	require.NoError(t, json.NewDecoder(do(http.MethodGet, "/credentials", "", tenant).Body).Decode(&credentials))
+	require.Len(t, credentials, 1)
+	assert.Equal(t, "alice", credentials[0].Username)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/credentials/bob", "", tenant).Code)
+	// accounts created by a tenant belong to it whatever the body says
+	assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/credentials", `{"username": "carol", "password": "c", "tenant": "team-b"}`, tenant).Code)
+	owner, _ := ps.Credentials().Tenant("carol")
+	assert.Equal(t, "team-a", owner)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/credentials", `{"username": "dave", "password": "d", "tenant": "nope"}`, admin).Code)
+	assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/credentials/carol", "", tenant).Code)
+
+	var tenants []tenantResponse
+	require.NoError(t, json.NewDecoder(do(http.MethodGet, "/tenants", "", tenant).Body).Decode(&tenants))
+	require.Len(t, tenants, 1)
+	assert.Equal(t, "team-a", tenants[0].Name)
+	assert.Equal(t, 1, tenants[0].Proxies)
+	require.NoError(t, json.NewDecoder(do(http.MethodGet, "/tenants", "", admin).Body).Decode(&tenants))
+	assert.Len(t, tenants, 2)
+
+	// removing the tenant from the config invalidates its tokens
+	cfg.Tenants = cfg.Tenants[1:]
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/proxies", "", tenant).Code)
+}
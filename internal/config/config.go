@@ -7,12 +7,43 @@ import (
 )
 
 type ConfigManager struct {
-	Config *Config
-	Check  bool
-	path   string
+	Config         *Config
+	Check          bool
+	BenchSelectors bool
+	Service        string
+	Command        string
+	Args           []string
+	path           string
 }
 
 func NewConfigManager(path string) (*ConfigManager, error) {
+	cfg, err := readConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConfigManager{
+		Config: cfg,
+		path:   path,
+	}, nil
+}
+
+func (cm *ConfigManager) Path() string {
+	return cm.path
+}
+
+// Reload reads the config file again into a new Config, the old one is left as it is for whoever still reads it
+func (cm *ConfigManager) Reload() error {
+	cfg, err := readConfig(cm.path)
+	if err != nil {
+		return err
+	}
+
+	cm.Config = cfg
+	return nil
+}
+
+func readConfig(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
@@ -23,8 +54,5 @@ func NewConfigManager(path string) (*ConfigManager, error) {
 		return nil, err
 	}
 
-	return &ConfigManager{
-		Config: cfg,
-		path:   path,
-	}, nil
+	return cfg, nil
 }
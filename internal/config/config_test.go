@@ -97,3 +97,45 @@ logging:
 		})
 	}
 }
+
+func TestConfigManager_Reload(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "config-*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if err := os.WriteFile(tmpfile.Name(), []byte("proxy:\n  rotation:\n    method: random\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cm, err := NewConfigManager(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := cm.Config
+
+	if err := os.WriteFile(tmpfile.Name(), []byte("proxy:\n  rotation:\n    method: roundrobin\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if cm.Config.Proxy.Rotation.Method != "roundrobin" {
+		t.Errorf("Proxy.Rotation.Method = %v, expected = %v", cm.Config.Proxy.Rotation.Method, "roundrobin")
+	}
+	// whoever still reads the old config keeps seeing it unchanged
+	if cfg.Proxy.Rotation.Method != "random" {
+		t.Errorf("old Proxy.Rotation.Method = %v, expected = %v", cfg.Proxy.Rotation.Method, "random")
+	}
+
+	if err := os.WriteFile(tmpfile.Name(), []byte("proxy: ["), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.Reload(); err == nil {
+		t.Errorf("Reload() expected error for invalid config")
+	}
+	if cm.Config.Proxy.Rotation.Method != "roundrobin" {
+		t.Errorf("Proxy.Rotation.Method = %v, expected = %v", cm.Config.Proxy.Rotation.Method, "roundrobin")
+	}
+}
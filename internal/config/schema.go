@@ -1,47 +1,324 @@
 package config
 
 type Config struct {
-	ProxyFile   string            `yaml:"proxy_file"`
-	FileWatch   bool              `yaml:"file_watch"`
-	Proxy       ProxyConfig       `yaml:"proxy"`
-	Api         ApiConfig         `yaml:"api"`
-	Healthcheck HealthcheckConfig `yaml:"healthcheck"`
-	Logging     LoggingConfig     `yaml:"logging"`
+	ProxyFile      string                    `yaml:"proxy_file"`
+	FileWatch      bool                      `yaml:"file_watch"`
+	DetectProtocol bool                      `yaml:"detect_protocol"`
+	Proxy          ProxyConfig               `yaml:"proxy"`
+	Api            ApiConfig                 `yaml:"api"`
+	Healthcheck    HealthcheckConfig         `yaml:"healthcheck"`
+	Logging        LoggingConfig             `yaml:"logging"`
+	Startup        StartupConfig             `yaml:"startup"`
+	Features       map[string]bool           `yaml:"features"`
+	Upstreams      map[string]UpstreamConfig `yaml:"upstreams"`
+	LowMemory      LowMemoryConfig           `yaml:"low_memory"`
+	Routing        []RoutingRuleConfig       `yaml:"routing"`
+	Sources        []SourceConfig            `yaml:"sources"`
+	History        HistoryConfig             `yaml:"history"`
+	Notifications  NotificationsConfig       `yaml:"notifications"`
+	GeoIP          GeoIPConfig               `yaml:"geoip"`
+	Chains         []ChainConfig             `yaml:"chains"`
+	Targets        TargetsConfig             `yaml:"targets"`
+	Redis          RedisConfig               `yaml:"redis"`
+	HeaderRules    []HeaderRuleConfig        `yaml:"header_rules"`
+	Cache          CacheConfig               `yaml:"cache"`
+	DNS            DNSConfig                 `yaml:"dns"`
+	Tenants        []TenantConfig            `yaml:"tenants"`
 }
 
 type ProxyConfig struct {
 	Port           int                       `yaml:"port"`
+	Socket         string                    `yaml:"socket"`
 	Authentication ProxyAuthenticationConfig `yaml:"authentication"`
 	Rotation       ProxyRotationConfig       `yaml:"rotation"`
+	Listeners      []ProxyListenerConfig     `yaml:"listeners"`
+	Timeouts       ProxyTimeoutsConfig       `yaml:"timeouts"`
+	CircuitBreaker CircuitBreakerConfig      `yaml:"circuit_breaker"`
+	Quarantine     QuarantineConfig          `yaml:"quarantine"`
+	SessionTTL     int                       `yaml:"session_ttl"`
+	Credentials    []CredentialConfig        `yaml:"credentials"`
+	TLS            ProxyTLSConfig            `yaml:"tls"`
+	Mitm           ProxyMitmConfig           `yaml:"mitm"`
+	ConnectionPool ConnectionPoolConfig      `yaml:"connection_pool"`
+	HTTP2          bool                      `yaml:"http2"`
+	RateLimit      ProxyRateLimitConfig      `yaml:"rate_limit"`
+	UserAgent      UserAgentConfig           `yaml:"user_agent"`
+	DryRun         bool                      `yaml:"dry_run"`
+	DebugHeaders   bool                      `yaml:"debug_headers"`
+	ErrorDetail    string                    `yaml:"error_detail"`
+	TLSFingerprint string                    `yaml:"tls_fingerprint"`
+	Mirror         ProxyMirrorConfig         `yaml:"mirror"`
+}
+
+type ProxyMirrorConfig struct {
+	Enabled   bool    `yaml:"enabled"`
+	Percent   float64 `yaml:"percent"`
+	ProxyFile string  `yaml:"proxy_file"`
+}
+
+type UserAgentConfig struct {
+	Enabled bool     `yaml:"enabled"`
+	Per     string   `yaml:"per"`
+	List    []string `yaml:"list"`
+}
+
+type ProxyRateLimitConfig struct {
+	Enabled           bool    `yaml:"enabled"`
+	By                string  `yaml:"by"`
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	Burst             int     `yaml:"burst"`
+}
+
+type RateLimitConfig struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	Burst             int     `yaml:"burst"`
+}
+
+type ConnectionPoolConfig struct {
+	Enabled        bool `yaml:"enabled"`
+	MaxIdlePerHost int  `yaml:"max_idle_per_host"`
+	IdleTimeout    int  `yaml:"idle_timeout"`
+	Spare          int  `yaml:"spare"`
+}
+
+type ProxyTLSConfig struct {
+	Enabled  bool     `yaml:"enabled"`
+	CertFile string   `yaml:"cert_file"`
+	KeyFile  string   `yaml:"key_file"`
+	Hosts    []string `yaml:"hosts"`
+}
+
+type ProxyMitmConfig struct {
+	CaCertFile string `yaml:"ca_cert_file"`
+	CaKeyFile  string `yaml:"ca_key_file"`
+}
+
+type CredentialConfig struct {
+	Username    string           `yaml:"username"`
+	Password    string           `yaml:"password"`
+	Description string           `yaml:"description"`
+	Disabled    bool             `yaml:"disabled"`
+	ExpiresAt   string           `yaml:"expires_at"`
+	RateLimit   *RateLimitConfig `yaml:"rate_limit"`
+	Tenant      string           `yaml:"tenant"`
+}
+
+type CircuitBreakerConfig struct {
+	Failures int `yaml:"failures"`
+	Cooldown int `yaml:"cooldown"`
+}
+
+type QuarantineConfig struct {
+	Enabled             bool    `yaml:"enabled"`
+	Window              int     `yaml:"window"`
+	MinRequests         int     `yaml:"min_requests"`
+	DegradedErrorRate   float64 `yaml:"degraded_error_rate"`
+	QuarantineErrorRate float64 `yaml:"quarantine_error_rate"`
+	Cooldown            int     `yaml:"cooldown"`
+}
+
+type ProxyTimeoutsConfig struct {
+	Read     int `yaml:"read"`
+	Write    int `yaml:"write"`
+	Idle     int `yaml:"idle"`
+	Tunnel   int `yaml:"tunnel"`
+	Shutdown int `yaml:"shutdown"`
+}
+
+type ProxyListenerConfig struct {
+	Port           int                        `yaml:"port"`
+	ProxyFile      string                     `yaml:"proxy_file"`
+	Tag            string                     `yaml:"tag"`
+	Authentication *ProxyAuthenticationConfig `yaml:"authentication"`
+	Rotation       *ProxyRotationConfig       `yaml:"rotation"`
 }
 
 type ProxyAuthenticationConfig struct {
-	Enabled  bool   `yaml:"enabled"`
+	Enabled      bool     `yaml:"enabled"`
+	Scheme       string   `yaml:"scheme"`
+	Username     string   `yaml:"username"`
+	Password     string   `yaml:"password"`
+	Token        string   `yaml:"token"`
+	TrustedCIDRs []string `yaml:"trusted_cidrs"`
+	Directives   bool     `yaml:"directives"`
+}
+
+type ProxyRotationConfig struct {
+	Method             string        `yaml:"method"`
+	RotateAfter        int           `yaml:"rotate_after"`
+	LatencyPercentile  int           `yaml:"latency_percentile"`
+	RemoveUnhealthy    bool          `yaml:"remove_unhealthy"`
+	Fallback           bool          `yaml:"fallback"`
+	FallbackMaxRetries int           `yaml:"fallback_max_retries"`
+	Timeout            int           `yaml:"timeout"`
+	Retries            int           `yaml:"retries"`
+	AllowedCountries   []string      `yaml:"allowed_countries"`
+	MaxRequestBody     int64         `yaml:"max_request_body"`
+	MaxResponseBody    int64         `yaml:"max_response_body"`
+	Backoff            BackoffConfig `yaml:"backoff"`
+}
+
+type BackoffConfig struct {
+	Strategy string `yaml:"strategy"`
+	Delay    int    `yaml:"delay"`
+	MaxDelay int    `yaml:"max_delay"`
+	Jitter   bool   `yaml:"jitter"`
+}
+
+type SourceConfig struct {
+	URL      string `yaml:"url"`
+	Interval int    `yaml:"interval"`
+	Pool     string `yaml:"pool"`
+	Provider string `yaml:"provider"`
+	APIKey   string `yaml:"api_key"`
+	Scheme   string `yaml:"scheme"`
+}
+
+type ChainConfig struct {
+	Hops []string `yaml:"hops"`
+	Pool string   `yaml:"pool"`
+	Tags []string `yaml:"tags"`
+}
+
+type GeoIPConfig struct {
+	Database    string `yaml:"database"`
+	ASNDatabase string `yaml:"asn_database"`
+}
+
+type NotificationsConfig struct {
+	PoolThreshold int             `yaml:"pool_threshold"`
+	Retries       int             `yaml:"retries"`
+	Timeout       int             `yaml:"timeout"`
+	Webhooks      []WebhookConfig `yaml:"webhooks"`
+}
+
+type WebhookConfig struct {
+	URL    string   `yaml:"url"`
+	Secret string   `yaml:"secret"`
+	Events []string `yaml:"events"`
+}
+
+type HistoryConfig struct {
+	Enabled bool `yaml:"enabled"`
+	Size    int  `yaml:"size"`
+}
+
+type TargetsConfig struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+type HeaderRuleConfig struct {
+	Hosts    []string            `yaml:"hosts"`
+	Request  HeaderActionsConfig `yaml:"request"`
+	Response HeaderActionsConfig `yaml:"response"`
+}
+
+type HeaderActionsConfig struct {
+	Add     map[string]string `yaml:"add"`
+	Replace map[string]string `yaml:"replace"`
+	Remove  []string          `yaml:"remove"`
+}
+
+type CacheConfig struct {
+	Enabled    bool              `yaml:"enabled"`
+	Redis      bool              `yaml:"redis"`
+	MaxEntries int               `yaml:"max_entries"`
+	MaxSize    int               `yaml:"max_size"`
+	KeyHeaders []string          `yaml:"key_headers"`
+	Rules      []CacheRuleConfig `yaml:"rules"`
+}
+
+type CacheRuleConfig struct {
+	Hosts []string `yaml:"hosts"`
+	TTL   int      `yaml:"ttl"`
+}
+
+type RedisConfig struct {
+	Enabled      bool   `yaml:"enabled"`
+	Address      string `yaml:"address"`
+	Password     string `yaml:"password"`
+	DB           int    `yaml:"db"`
+	Prefix       string `yaml:"prefix"`
+	Timeout      int    `yaml:"timeout"`
+	DialTimeout  int    `yaml:"dial_timeout"`
+	MaxIdleConns int    `yaml:"max_idle_conns"`
+	RetryAfter   int    `yaml:"retry_after"`
+}
+
+type TenantConfig struct {
+	Name      string               `yaml:"name"`
+	ProxyFile string               `yaml:"proxy_file"`
+	Tag       string               `yaml:"tag"`
+	Rotation  *ProxyRotationConfig `yaml:"rotation"`
+	Api       TenantApiConfig      `yaml:"api"`
+}
+
+type TenantApiConfig struct {
 	Username string `yaml:"username"`
 	Password string `yaml:"password"`
 }
 
-type ProxyRotationConfig struct {
-	Method             string `yaml:"method"`
-	RemoveUnhealthy    bool   `yaml:"remove_unhealthy"`
-	Fallback           bool   `yaml:"fallback"`
-	FallbackMaxRetries int    `yaml:"fallback_max_retries"`
-	Timeout            int    `yaml:"timeout"`
-	Retries            int    `yaml:"retries"`
+type DNSConfig struct {
+	Servers []string `yaml:"servers"`
+	Timeout int      `yaml:"timeout"`
+	Resolve string   `yaml:"resolve"`
+}
+
+type RoutingRuleConfig struct {
+	Hosts  []string `yaml:"hosts"`
+	Direct bool     `yaml:"direct"`
+	Pool   string   `yaml:"pool"`
+	Scheme string   `yaml:"scheme"`
+	Tag    string   `yaml:"tag"`
 }
 
 type ApiConfig struct {
-	Enabled bool `yaml:"enabled"`
-	Port    int  `yaml:"port"`
+	Enabled        bool                    `yaml:"enabled"`
+	Port           int                     `yaml:"port"`
+	Socket         string                  `yaml:"socket"`
+	Authentication ApiAuthenticationConfig `yaml:"authentication"`
+	Audit          ApiAuditConfig          `yaml:"audit"`
+}
+
+type ApiAuditConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Size    int    `yaml:"size"`
+	File    string `yaml:"file"`
+}
+
+type ApiAuthenticationConfig struct {
+	Enabled    bool            `yaml:"enabled"`
+	Username   string          `yaml:"username"`
+	Password   string          `yaml:"password"`
+	Secret     string          `yaml:"secret"`
+	SecretFile string          `yaml:"secret_file"`
+	AccessTTL  int             `yaml:"access_ttl"`
+	RefreshTTL int             `yaml:"refresh_ttl"`
+	Users      []ApiUserConfig `yaml:"users"`
+}
+
+type ApiUserConfig struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	Role     string `yaml:"role"`
 }
 
 type HealthcheckConfig struct {
-	Output  HealthcheckOutputConfig `yaml:"output"`
-	Timeout int                     `yaml:"timeout"`
-	Workers int                     `yaml:"workers"`
-	URL     string                  `yaml:"url"`
-	Status  int                     `yaml:"status"`
-	Headers []string                `yaml:"headers"`
+	Output         HealthcheckOutputConfig      `yaml:"output"`
+	Timeout        int                          `yaml:"timeout"`
+	Workers        int                          `yaml:"workers"`
+	URL            string                       `yaml:"url"`
+	Status         int                          `yaml:"status"`
+	Headers        []string                     `yaml:"headers"`
+	ValidateOnLoad bool                         `yaml:"validate_on_load"`
+	Interval       int                          `yaml:"interval"`
+	Incremental    HealthcheckIncrementalConfig `yaml:"incremental"`
+}
+
+type HealthcheckIncrementalConfig struct {
+	Enabled bool `yaml:"enabled"`
+	Rate    int  `yaml:"rate"`
 }
 
 type HealthcheckOutputConfig struct {
@@ -50,7 +327,60 @@ type HealthcheckOutputConfig struct {
 }
 
 type LoggingConfig struct {
-	Stdout bool   `yaml:"stdout"`
-	File   string `yaml:"file"`
-	Level  string `yaml:"level"`
+	Stdout   bool           `yaml:"stdout"`
+	File     string         `yaml:"file"`
+	Level    string         `yaml:"level"`
+	Format   string         `yaml:"format"`
+	Syslog   SyslogConfig   `yaml:"syslog"`
+	EventLog EventLogConfig `yaml:"eventlog"`
+	Loki     LokiConfig     `yaml:"loki"`
+}
+
+type EventLogConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Source  string `yaml:"source"`
+}
+
+type LokiConfig struct {
+	Enabled   bool              `yaml:"enabled"`
+	URL       string            `yaml:"url"`
+	TenantID  string            `yaml:"tenant_id"`
+	Labels    map[string]string `yaml:"labels"`
+	BatchSize int               `yaml:"batch_size"`
+	BatchWait int               `yaml:"batch_wait"`
+}
+
+type SyslogConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Network string `yaml:"network"`
+	Address string `yaml:"address"`
+	Tag     string `yaml:"tag"`
+}
+
+type StartupConfig struct {
+	Retries    int `yaml:"retries"`
+	Backoff    int `yaml:"backoff"`
+	MaxBackoff int `yaml:"max_backoff"`
+}
+
+type UpstreamConfig struct {
+	Headers       []string                  `yaml:"headers"`
+	Auth          string                    `yaml:"auth"`
+	MaxConcurrent int                       `yaml:"max_concurrent"`
+	MaxRPM        int                       `yaml:"max_rpm"`
+	Credentials   []string                  `yaml:"credentials"`
+	Healthcheck   UpstreamHealthcheckConfig `yaml:"healthcheck"`
+}
+
+type UpstreamHealthcheckConfig struct {
+	URL     string `yaml:"url"`
+	Status  int    `yaml:"status"`
+	Timeout int    `yaml:"timeout"`
+}
+
+type LowMemoryConfig struct {
+	Enabled       bool `yaml:"enabled"`
+	MemoryLimit   int  `yaml:"memory_limit"`
+	MaxCerts      int  `yaml:"max_certs"`
+	LogSampleRate int  `yaml:"log_sample_rate"`
 }
@@ -0,0 +1,189 @@
+package features
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// ActorConfig is recorded for flags taken from the config file, on start and on every reload
+	ActorConfig = "config"
+
+	ActionSet      = "set"
+	ActionReset    = "reset"
+	ActionRollback = "rollback"
+	ActionRestore  = "restore"
+
+	maxChanges = 100
+
+	msgVersionNotFound = "feature flag version not found"
+)
+
+var ErrVersionNotFound = errors.New(msgVersionNotFound)
+
+type Flags struct {
+	mu      sync.RWMutex
+	flags   map[string]bool
+	builtin map[string]bool
+	changes []Change
+	version int
+}
+
+// Change is one update of the flags, Version counts up from 1 on every start
+type Change struct {
+	Version int       `json:"version"`
+	Time    time.Time `json:"time"`
+	Actor   string    `json:"actor"`
+	Action  string    `json:"action"`
+	Diff    []Diff    `json:"diff"`
+	// flags is the state after the change, rolling back to a version restores it
+	flags map[string]bool
+}
+
+// Diff is the value of one flag before and after a change, nil when it was not set
+type Diff struct {
+	Name string `json:"name"`
+	Old  *bool  `json:"old"`
+	New  *bool  `json:"new"`
+}
+
+func NewFlags(defaults map[string]bool) *Flags {
+	f := &Flags{}
+	f.Reset(defaults)
+	return f
+}
+
+// Define declares a flag the code checks and its value while no config, update or backup set it, so a flag can
+// switch off something that runs by default
+func (f *Flags) Define(name string, enabled bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.builtin == nil {
+		f.builtin = make(map[string]bool)
+	}
+	f.builtin[name] = enabled
+}
+
+func (f *Flags) Enabled(name string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if enabled, ok := f.flags[name]; ok {
+		return enabled
+	}
+	return f.builtin[name]
+}
+
+func (f *Flags) Set(name string, enabled bool) {
+	f.SetBy(name, enabled, "")
+}
+
+// SetBy sets a flag and records who did, setting a flag to its current value records nothing
+func (f *Flags) SetBy(name string, enabled bool, actor string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	flags := maps.Clone(f.flags)
+	flags[name] = enabled
+	f.apply(flags, actor, ActionSet)
+}
+
+// All returns every flag that is set or defined
+func (f *Flags) All() map[string]bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	all := maps.Clone(f.builtin)
+	if all == nil {
+		all = make(map[string]bool, len(f.flags))
+	}
+	maps.Copy(all, f.flags)
+	return all
+}
+
+func (f *Flags) Reset(defaults map[string]bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	flags := make(map[string]bool, len(defaults))
+	maps.Copy(flags, defaults)
+	f.apply(flags, ActorConfig, ActionReset)
+}
+
+// RestoreBy replaces every flag, e.g. with the flags of a backup, and records who did
+func (f *Flags) RestoreBy(flags map[string]bool, actor string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.apply(maps.Clone(flags), actor, ActionRestore)
+}
+
+// History returns the recorded changes newest first, only the last 100 are kept
+func (f *Flags) History() []Change {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	history := slices.Clone(f.changes)
+	slices.Reverse(history)
+	return history
+}
+
+// Rollback restores the flags as they were right after version and records it as a new change
+func (f *Flags) Rollback(version int, actor string) (Change, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	i := slices.IndexFunc(f.changes, func(c Change) bool { return c.Version == version })
+	if i < 0 {
+		return Change{}, ErrVersionNotFound
+	}
+	f.apply(maps.Clone(f.changes[i].flags), actor, ActionRollback)
+	return f.changes[len(f.changes)-1], nil
+}
+
+// apply replaces the flags and records the difference, it is called with the lock held
+func (f *Flags) apply(flags map[string]bool, actor, action string) {
+	diff := difference(f.flags, flags)
+	f.flags = flags
+	// the first reset is always recorded so the configured flags are a version to roll back to, and rollbacks are
+	// recorded even when nothing changed so they can be traced
+	if len(diff) == 0 && f.version > 0 && action != ActionRollback {
+		return
+	}
+	f.version++
+	f.changes = append(f.changes, Change{
+		Version: f.version,
+		Time:    time.Now(),
+		Actor:   actor,
+		Action:  action,
+		Diff:    diff,
+		flags:   maps.Clone(flags),
+	})
+	if len(f.changes) > maxChanges {
+		f.changes = slices.Delete(f.changes, 0, len(f.changes)-maxChanges)
+	}
+}
+
+func difference(old, new map[string]bool) []Diff {
+	names := slices.Sorted(maps.Keys(old))
+	for name := range new {
+		if _, ok := old[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	diff := make([]Diff, 0)
+	for _, name := range names {
+		before, hadBefore := old[name]
+		after, hasAfter := new[name]
+		if hadBefore == hasAfter && before == after {
+			continue
+		}
+		d := Diff{Name: name}
+		if hadBefore {
+			d.Old = &before
+		}
+		if hasAfter {
+			d.New = &after
+		}
+		diff = append(diff, d)
+	}
+	return diff
+}
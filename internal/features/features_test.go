@@ -0,0 +1,89 @@
+package features
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlags(t *testing.T) {
+	flags := NewFlags(map[string]bool{"cache": true})
+
+	assert.True(t, flags.Enabled("cache"))
+	assert.False(t, flags.Enabled("unknown"))
+
+	flags.Set("cache", false)
+	flags.Set("mirror", true)
+	assert.False(t, flags.Enabled("cache"))
+	assert.True(t, flags.Enabled("mirror"))
+	assert.Equal(t, map[string]bool{"cache": false, "mirror": true}, flags.All())
+
+	flags.Reset(map[string]bool{"cache": true})
+	assert.True(t, flags.Enabled("cache"))
+	assert.False(t, flags.Enabled("mirror"))
+}
+
+func TestFlagsDefine(t *testing.T) {
+	flags := NewFlags(map[string]bool{"sticky": true})
+	flags.Define("cache", true)
+
+	assert.True(t, flags.Enabled("cache"))
+	assert.Equal(t, map[string]bool{"cache": true, "sticky": true}, flags.All())
+
+	flags.Set("cache", false)
+	assert.False(t, flags.Enabled("cache"))
+
+	// a reset to a config that does not set it goes back to the defined value
+	flags.Reset(nil)
+	assert.True(t, flags.Enabled("cache"))
+	flags.RestoreBy(map[string]bool{"cache": false}, "admin")
+	assert.False(t, flags.Enabled("cache"))
+}
+
+func TestFlagsAllReturnsCopy(t *testing.T) {
+	flags := NewFlags(nil)
+	all := flags.All()
+	all["cache"] = true
+	assert.False(t, flags.Enabled("cache"))
+}
+
+func TestFlagsHistory(t *testing.T) {
+	flags := NewFlags(map[string]bool{"cache": true})
+	flags.SetBy("cache", false, "admin")
+	flags.SetBy("cache", false, "admin")
+	flags.SetBy("mirror", true, "10.0.0.1:5000")
+	flags.Reset(map[string]bool{"cache": false, "mirror": true})
+
+	enabled, disabled := true, false
+	history := flags.History()
+	assert.Len(t, history, 3)
+	assert.Equal(t, 3, history[0].Version)
+	assert.Equal(t, "10.0.0.1:5000", history[0].Actor)
+	assert.Equal(t, []Diff{{Name: "mirror", New: &enabled}}, history[0].Diff)
+	assert.Equal(t, ActionSet, history[1].Action)
+	assert.Equal(t, []Diff{{Name: "cache", Old: &enabled, New: &disabled}}, history[1].Diff)
+	assert.Equal(t, ActorConfig, history[2].Actor)
+	assert.Equal(t, ActionReset, history[2].Action)
+
+	change, err := flags.Rollback(1, "admin")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, change.Version)
+	assert.Equal(t, ActionRollback, change.Action)
+	assert.Equal(t, []Diff{{Name: "cache", Old: &disabled, New: &enabled}, {Name: "mirror", Old: &enabled}}, change.Diff)
+	assert.Equal(t, map[string]bool{"cache": true}, flags.All())
+
+	_, err = flags.Rollback(42, "admin")
+	assert.ErrorIs(t, err, ErrVersionNotFound)
+}
+
+func TestFlagsHistoryIsBounded(t *testing.T) {
+	flags := NewFlags(nil)
+	for i := range maxChanges + 10 {
+		flags.Set("cache", i%2 == 0)
+	}
+	history := flags.History()
+	assert.Len(t, history, maxChanges)
+	assert.Equal(t, maxChanges+11, history[0].Version)
+	_, err := flags.Rollback(1, "")
+	assert.ErrorIs(t, err, ErrVersionNotFound)
+}
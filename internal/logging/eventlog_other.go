@@ -0,0 +1,14 @@
+//go:build !windows
+
+package logging
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/alpkeskin/rota/internal/config"
+)
+
+func newEventLogHandler(cfg config.EventLogConfig, options *slog.HandlerOptions) (slog.Handler, error) {
+	return nil, errors.New(msgEventLogUnsupported)
+}
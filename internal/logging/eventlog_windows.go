@@ -0,0 +1,88 @@
+//go:build windows
+
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// event ids are not used by rota, the event message carries everything
+const eventID = 1
+
+type eventLogWriter struct {
+	mu    sync.Mutex
+	log   *eventlog.Log
+	level slog.Level
+}
+
+type eventLogHandler struct {
+	slog.Handler
+	w *eventLogWriter
+}
+
+func newEventLogHandler(cfg config.EventLogConfig, options *slog.HandlerOptions) (slog.Handler, error) {
+	source := cfg.Source
+	if source == "" {
+		source = "rota"
+	}
+
+	log, err := eventlog.Open(source)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", msgFailedToOpenEventLog, err)
+	}
+
+	w := &eventLogWriter{log: log}
+	return &eventLogHandler{
+		Handler: slog.NewTextHandler(w, &slog.HandlerOptions{
+			Level: options.Level,
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				// the event log stamps its own time
+				if len(groups) == 0 && a.Key == slog.TimeKey {
+					return slog.Attr{}
+				}
+				return a
+			},
+		}),
+		w: w,
+	}, nil
+}
+
+func (h *eventLogHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.w.mu.Lock()
+	defer h.w.mu.Unlock()
+	h.w.level = r.Level
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *eventLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &eventLogHandler{Handler: h.Handler.WithAttrs(attrs), w: h.w}
+}
+
+func (h *eventLogHandler) WithGroup(name string) slog.Handler {
+	return &eventLogHandler{Handler: h.Handler.WithGroup(name), w: h.w}
+}
+
+func (w *eventLogWriter) Write(p []byte) (int, error) {
+	message := string(p)
+
+	var err error
+	switch {
+	case w.level >= slog.LevelError:
+		err = w.log.Error(eventID, message)
+	case w.level >= slog.LevelWarn:
+		err = w.log.Warning(eventID, message)
+	default:
+		err = w.log.Info(eventID, message)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -10,11 +10,20 @@ import (
 )
 
 const (
-	msgFailedCreateLogFile = "failed to create log file"
+	msgFailedCreateLogFile   = "failed to create log file"
+	msgUnsupportedLogFormat  = "unsupported log format"
+	msgFailedToConnectSyslog = "failed to connect to syslog"
+	msgSyslogUnsupported     = "syslog is not supported on this platform"
+	msgFailedToOpenEventLog  = "failed to open event log"
+	msgEventLogUnsupported   = "event log is only supported on windows"
+
+	lowMemoryLokiBatchSize = 20
 )
 
 type Logger struct {
 	handler slog.Handler
+	loki    *lokiClient
+	stream  *Stream
 }
 
 func NewLogger(cfg *config.Config) (*Logger, error) {
@@ -37,12 +46,58 @@ func NewLogger(cfg *config.Config) (*Logger, error) {
 		}
 	}
 
-	handler := slog.NewJSONHandler(multiWriter, &slog.HandlerOptions{
+	options := &slog.HandlerOptions{
 		Level: getLogLevel(cfg.Logging.Level),
-	})
+	}
+	// debug logs are too chatty for small devices
+	if cfg.LowMemory.Enabled && options.Level == slog.LevelDebug {
+		options.Level = slog.LevelInfo
+	}
+
+	var handler slog.Handler
+	switch cfg.Logging.Format {
+	case "", "json":
+		handler = slog.NewJSONHandler(multiWriter, options)
+	case "text":
+		handler = slog.NewTextHandler(multiWriter, options)
+	default:
+		return nil, fmt.Errorf("%s: %s", msgUnsupportedLogFormat, cfg.Logging.Format)
+	}
+
+	if cfg.Logging.Syslog.Enabled {
+		syslogHandler, err := newSyslogHandler(cfg.Logging.Syslog, options)
+		if err != nil {
+			return nil, err
+		}
+		handler = multiHandler{handler, syslogHandler}
+	}
+
+	if cfg.Logging.EventLog.Enabled {
+		eventLogHandler, err := newEventLogHandler(cfg.Logging.EventLog, options)
+		if err != nil {
+			return nil, err
+		}
+		handler = multiHandler{handler, eventLogHandler}
+	}
+
+	var loki *lokiClient
+	if cfg.Logging.Loki.Enabled {
+		lokiCfg := cfg.Logging.Loki
+		if cfg.LowMemory.Enabled && (lokiCfg.BatchSize <= 0 || lokiCfg.BatchSize > lowMemoryLokiBatchSize) {
+			lokiCfg.BatchSize = lowMemoryLokiBatchSize
+		}
+		loki = newLokiClient(lokiCfg)
+		handler = multiHandler{handler, newLokiHandler(loki, options)}
+	}
+
+	// the stream sees every level, /sse/logs subscribers may ask for debug records the other outputs leave out
+	stream := NewStream()
+	handler = multiHandler{handler, &streamHandler{stream: stream}}
 
 	return &Logger{
 		handler: handler,
+		loki:    loki,
+		stream:  stream,
 	}, nil
 }
 
@@ -51,6 +106,18 @@ func (l *Logger) Setup() {
 	slog.SetDefault(logger)
 }
 
+// Stream returns the stream of every record logged after Setup
+func (l *Logger) Stream() *Stream {
+	return l.stream
+}
+
+func (l *Logger) Close() error {
+	if l.loki != nil {
+		return l.loki.Close()
+	}
+	return nil
+}
+
 func getLogLevel(level string) slog.Level {
 	switch level {
 	case "debug":
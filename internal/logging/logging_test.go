@@ -51,6 +51,28 @@ func TestNewLogger(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "Text format",
+			cfg: &config.Config{
+				Logging: config.LoggingConfig{
+					Stdout: true,
+					Level:  "info",
+					Format: "text",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Unsupported format",
+			cfg: &config.Config{
+				Logging: config.LoggingConfig{
+					Stdout: true,
+					Level:  "info",
+					Format: "xml",
+				},
+			},
+			wantErr: true,
+		},
 		{
 			name: "Disable all outputs",
 			cfg: &config.Config{
@@ -0,0 +1,264 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+)
+
+const (
+	lokiPushPath         = "/loki/api/v1/push"
+	lokiProxyLabel       = "proxy"
+	defaultLokiBatchSize = 100
+	defaultLokiBatchWait = 5
+
+	// printed to stderr, the logger can not log its own failures
+	msgFailedToPushLoki = "rota: failed to push logs to loki"
+)
+
+type lokiEntry struct {
+	labels    map[string]string
+	timestamp time.Time
+	line      string
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+type lokiClient struct {
+	url       string
+	tenantID  string
+	labels    map[string]string
+	batchSize int
+	batchWait time.Duration
+	client    *http.Client
+	entries   chan lokiEntry
+	done      chan struct{}
+	mu        sync.RWMutex
+	closed    bool
+}
+
+type lokiWriter struct {
+	mu     sync.Mutex
+	client *lokiClient
+	labels map[string]string
+	time   time.Time
+}
+
+type lokiHandler struct {
+	slog.Handler
+	w     *lokiWriter
+	proxy string
+}
+
+func newLokiClient(cfg config.LokiConfig) *lokiClient {
+	batchSize := cfg.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultLokiBatchSize
+	}
+	batchWait := cfg.BatchWait
+	if batchWait <= 0 {
+		batchWait = defaultLokiBatchWait
+	}
+
+	labels := map[string]string{"source": "rota"}
+	maps.Copy(labels, cfg.Labels)
+
+	c := &lokiClient{
+		url:       strings.TrimSuffix(cfg.URL, "/") + lokiPushPath,
+		tenantID:  cfg.TenantID,
+		labels:    labels,
+		batchSize: batchSize,
+		batchWait: time.Duration(batchWait) * time.Second,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		entries:   make(chan lokiEntry, batchSize*4),
+		done:      make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+func newLokiHandler(client *lokiClient, options *slog.HandlerOptions) slog.Handler {
+	w := &lokiWriter{client: client}
+	return &lokiHandler{
+		Handler: slog.NewJSONHandler(w, options),
+		w:       w,
+	}
+}
+
+func (h *lokiHandler) Handle(ctx context.Context, r slog.Record) error {
+	labels := maps.Clone(h.w.client.labels)
+	labels["level"] = strings.ToLower(r.Level.String())
+	if h.proxy != "" {
+		labels[lokiProxyLabel] = h.proxy
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key == lokiProxyLabel {
+			labels[lokiProxyLabel] = a.Value.String()
+			return false
+		}
+		return true
+	})
+
+	h.w.mu.Lock()
+	defer h.w.mu.Unlock()
+	h.w.labels = labels
+	h.w.time = r.Time
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *lokiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	proxy := h.proxy
+	for _, a := range attrs {
+		if a.Key == lokiProxyLabel {
+			proxy = a.Value.String()
+		}
+	}
+	return &lokiHandler{Handler: h.Handler.WithAttrs(attrs), w: h.w, proxy: proxy}
+}
+
+func (h *lokiHandler) WithGroup(name string) slog.Handler {
+	return &lokiHandler{Handler: h.Handler.WithGroup(name), w: h.w, proxy: h.proxy}
+}
+
+func (w *lokiWriter) Write(p []byte) (int, error) {
+	timestamp := w.time
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	entry := lokiEntry{
+		labels:    w.labels,
+		timestamp: timestamp,
+		line:      strings.TrimSuffix(string(p), "\n"),
+	}
+
+	w.client.send(entry)
+	return len(p), nil
+}
+
+func (c *lokiClient) run() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(c.batchWait)
+	defer ticker.Stop()
+
+	batch := make([]lokiEntry, 0, c.batchSize)
+	for {
+		select {
+		case entry, ok := <-c.entries:
+			if !ok {
+				c.push(batch)
+				return
+			}
+			batch = append(batch, entry)
+			if len(batch) >= c.batchSize {
+				c.push(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			c.push(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+func (c *lokiClient) push(batch []lokiEntry) {
+	if len(batch) == 0 {
+		return
+	}
+
+	streams := make(map[string]*lokiStream)
+	for _, entry := range batch {
+		key := labelsKey(entry.labels)
+		stream, ok := streams[key]
+		if !ok {
+			stream = &lokiStream{Stream: entry.labels}
+			streams[key] = stream
+		}
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(entry.timestamp.UnixNano(), 10), entry.line})
+	}
+
+	payload := struct {
+		Streams []*lokiStream `json:"streams"`
+	}{Streams: slices.Collect(maps.Values(streams))}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, msgFailedToPushLoki, err)
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, msgFailedToPushLoki, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.tenantID != "" {
+		req.Header.Set("X-Scope-OrgID", c.tenantID)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, msgFailedToPushLoki, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		fmt.Fprintln(os.Stderr, msgFailedToPushLoki, resp.Status)
+	}
+}
+
+func (c *lokiClient) send(entry lokiEntry) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.closed {
+		return
+	}
+
+	// never block the request path on log shipping
+	select {
+	case c.entries <- entry:
+	default:
+	}
+}
+
+func (c *lokiClient) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.entries)
+	c.mu.Unlock()
+
+	<-c.done
+	return nil
+}
+
+func labelsKey(labels map[string]string) string {
+	keys := slices.Sorted(maps.Keys(labels))
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(labels[k])
+		b.WriteByte(',')
+	}
+	return b.String()
+}
@@ -0,0 +1,73 @@
+package logging
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLokiHandler(t *testing.T) {
+	var mu sync.Mutex
+	var streams []lokiStream
+	var tenant string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, lokiPushPath, r.URL.Path)
+
+		var payload struct {
+			Streams []lokiStream `json:"streams"`
+		}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+
+		mu.Lock()
+		streams = append(streams, payload.Streams...)
+		tenant = r.Header.Get("X-Scope-OrgID")
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client := newLokiClient(config.LokiConfig{
+		URL:      server.URL,
+		TenantID: "team-a",
+		Labels:   map[string]string{"env": "test"},
+	})
+	logger := slog.New(newLokiHandler(client, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+	logger.Info("request rotation success", "proxy", "http://127.0.0.1:8080")
+	logger.Info("request rotation success", "proxy", "http://127.0.0.1:8080")
+	logger.Error("request rotation error")
+	logger.Debug("filtered out")
+	assert.NoError(t, client.Close())
+
+	// logging after close must not panic
+	logger.Info("after close")
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	assert.Equal(t, "team-a", tenant)
+	assert.Len(t, streams, 2)
+
+	values := make(map[string]int)
+	for _, stream := range streams {
+		assert.Equal(t, "rota", stream.Stream["source"])
+		assert.Equal(t, "test", stream.Stream["env"])
+		values[stream.Stream["level"]+"|"+stream.Stream["proxy"]] += len(stream.Values)
+	}
+	assert.Equal(t, map[string]int{
+		"info|http://127.0.0.1:8080": 2,
+		"error|":                     1,
+	}, values)
+}
+
+func TestLabelsKey(t *testing.T) {
+	assert.Equal(t, labelsKey(map[string]string{"a": "1", "b": "2"}), labelsKey(map[string]string{"b": "2", "a": "1"}))
+	assert.NotEqual(t, labelsKey(map[string]string{"a": "1"}), labelsKey(map[string]string{"a": "2"}))
+}
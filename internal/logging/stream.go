@@ -0,0 +1,119 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Entry is one log record as sent to stream subscribers
+type Entry struct {
+	Time    time.Time      `json:"time"`
+	Level   slog.Level     `json:"level"`
+	Message string         `json:"msg"`
+	Attrs   map[string]any `json:"attrs,omitempty"`
+}
+
+// Stream hands every log record to its subscribers. Records a subscriber has no room for are dropped, a slow reader
+// never holds up logging
+type Stream struct {
+	mu          sync.RWMutex
+	subscribers map[chan Entry]struct{}
+}
+
+type streamHandler struct {
+	stream *Stream
+	attrs  []slog.Attr
+	group  string
+}
+
+func NewStream() *Stream {
+	return &Stream{subscribers: make(map[chan Entry]struct{})}
+}
+
+// Subscribe returns a channel with room for buffer entries and the func that ends the subscription
+func (s *Stream) Subscribe(buffer int) (<-chan Entry, func()) {
+	entries := make(chan Entry, buffer)
+	s.mu.Lock()
+	s.subscribers[entries] = struct{}{}
+	s.mu.Unlock()
+	return entries, func() {
+		s.mu.Lock()
+		delete(s.subscribers, entries)
+		s.mu.Unlock()
+	}
+}
+
+func (s *Stream) publish(entry Entry) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for entries := range s.subscribers {
+		select {
+		case entries <- entry:
+		default:
+		}
+	}
+}
+
+func (s *Stream) active() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.subscribers) > 0
+}
+
+// Enabled lets every level through, subscribers filter for themselves
+func (h *streamHandler) Enabled(context.Context, slog.Level) bool {
+	return h.stream.active()
+}
+
+func (h *streamHandler) Handle(_ context.Context, r slog.Record) error {
+	entry := Entry{Time: r.Time, Level: r.Level, Message: r.Message}
+	if len(h.attrs) > 0 || r.NumAttrs() > 0 {
+		entry.Attrs = make(map[string]any, len(h.attrs)+r.NumAttrs())
+	}
+	for _, attr := range h.attrs {
+		entry.Attrs[attr.Key] = attrValue(attr.Value)
+	}
+	r.Attrs(func(attr slog.Attr) bool {
+		entry.Attrs[h.group+attr.Key] = attrValue(attr.Value)
+		return true
+	})
+	h.stream.publish(entry)
+	return nil
+}
+
+// attrValue returns a value that encodes to readable json, errors as their message and durations as text
+func attrValue(v slog.Value) any {
+	v = v.Resolve()
+	switch v.Kind() {
+	case slog.KindGroup:
+		group := make(map[string]any, len(v.Group()))
+		for _, attr := range v.Group() {
+			group[attr.Key] = attrValue(attr.Value)
+		}
+		return group
+	case slog.KindDuration:
+		return v.Duration().String()
+	case slog.KindAny:
+		if err, ok := v.Any().(error); ok {
+			return err.Error()
+		}
+	}
+	return v.Any()
+}
+
+func (h *streamHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handler := &streamHandler{stream: h.stream, group: h.group, attrs: append([]slog.Attr{}, h.attrs...)}
+	for _, attr := range attrs {
+		handler.attrs = append(handler.attrs, slog.Attr{Key: h.group + attr.Key, Value: attr.Value})
+	}
+	return handler
+}
+
+func (h *streamHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &streamHandler{stream: h.stream, attrs: h.attrs, group: h.group + name + "."}
+}
@@ -0,0 +1,45 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStream(t *testing.T) {
+	stream := NewStream()
+	logger := slog.New(&streamHandler{stream: stream})
+
+	// without subscribers the handler is disabled and records are not built at all
+	assert.False(t, logger.Enabled(context.Background(), slog.LevelError))
+
+	entries, unsubscribe := stream.Subscribe(1)
+	logger.With("proxy", "10.0.0.1:8080").WithGroup("check").Debug("dead proxy", "error", errors.New("refused"))
+	logger.Info("dropped, the subscriber has no room")
+
+	entry := <-entries
+	assert.Equal(t, slog.LevelDebug, entry.Level)
+	assert.Equal(t, "dead proxy", entry.Message)
+	assert.Equal(t, map[string]any{"proxy": "10.0.0.1:8080", "check.error": "refused"}, entry.Attrs)
+	assert.Empty(t, entries)
+
+	unsubscribe()
+	logger.Info("after unsubscribe")
+	assert.Empty(t, entries)
+}
+
+func TestLoggerStream(t *testing.T) {
+	logger, err := NewLogger(&config.Config{Logging: config.LoggingConfig{Level: "error"}})
+	require.NoError(t, err)
+	entries, unsubscribe := logger.Stream().Subscribe(1)
+	defer unsubscribe()
+
+	// the stream gets records below the configured level, subscribers pick their own
+	slog.New(logger.handler).Info("info record")
+	assert.Equal(t, "info record", (<-entries).Message)
+}
@@ -0,0 +1,87 @@
+//go:build !windows && !plan9
+
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"log/syslog"
+	"sync"
+
+	"github.com/alpkeskin/rota/internal/config"
+)
+
+type syslogWriter struct {
+	mu     sync.Mutex
+	writer *syslog.Writer
+	level  slog.Level
+}
+
+type syslogHandler struct {
+	slog.Handler
+	w *syslogWriter
+}
+
+func newSyslogHandler(cfg config.SyslogConfig, options *slog.HandlerOptions) (slog.Handler, error) {
+	tag := cfg.Tag
+	if tag == "" {
+		tag = "rota"
+	}
+
+	writer, err := syslog.Dial(cfg.Network, cfg.Address, syslog.LOG_INFO|syslog.LOG_USER, tag)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", msgFailedToConnectSyslog, err)
+	}
+
+	w := &syslogWriter{writer: writer}
+	return &syslogHandler{
+		Handler: slog.NewTextHandler(w, &slog.HandlerOptions{
+			Level: options.Level,
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				// syslog stamps its own time
+				if len(groups) == 0 && a.Key == slog.TimeKey {
+					return slog.Attr{}
+				}
+				return a
+			},
+		}),
+		w: w,
+	}, nil
+}
+
+func (h *syslogHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.w.mu.Lock()
+	defer h.w.mu.Unlock()
+	h.w.level = r.Level
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *syslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &syslogHandler{Handler: h.Handler.WithAttrs(attrs), w: h.w}
+}
+
+func (h *syslogHandler) WithGroup(name string) slog.Handler {
+	return &syslogHandler{Handler: h.Handler.WithGroup(name), w: h.w}
+}
+
+func (w *syslogWriter) Write(p []byte) (int, error) {
+	message := string(p)
+
+	var err error
+	switch {
+	case w.level >= slog.LevelError:
+		err = w.writer.Err(message)
+	case w.level >= slog.LevelWarn:
+		err = w.writer.Warning(message)
+	case w.level >= slog.LevelInfo:
+		err = w.writer.Info(message)
+	default:
+		err = w.writer.Debug(message)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
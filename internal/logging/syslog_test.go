@@ -0,0 +1,70 @@
+//go:build !windows && !plan9
+
+package logging
+
+import (
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyslogHandler(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	handler, err := newSyslogHandler(config.SyslogConfig{
+		Network: "udp",
+		Address: conn.LocalAddr().String(),
+	}, &slog.HandlerOptions{Level: slog.LevelInfo})
+	assert.NoError(t, err)
+
+	logger := slog.New(handler).With("proxy", "http://127.0.0.1:8080")
+
+	testCases := []struct {
+		name     string
+		log      func(msg string, args ...any)
+		priority string
+	}{
+		{"error", logger.Error, "<11>"},
+		{"warn", logger.Warn, "<12>"},
+		{"info", logger.Info, "<14>"},
+	}
+
+	buf := make([]byte, 1024)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.log("test message")
+
+			_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			n, _, err := conn.ReadFrom(buf)
+			assert.NoError(t, err)
+
+			message := string(buf[:n])
+			assert.True(t, strings.HasPrefix(message, tc.priority), message)
+			assert.Contains(t, message, "rota")
+			assert.Contains(t, message, `msg="test message"`)
+			assert.Contains(t, message, "proxy=http://127.0.0.1:8080")
+		})
+	}
+}
+
+func TestNewLoggerSyslogError(t *testing.T) {
+	_, err := NewLogger(&config.Config{
+		Logging: config.LoggingConfig{
+			Syslog: config.SyslogConfig{
+				Enabled: true,
+				Network: "invalid",
+				Address: "127.0.0.1:514",
+			},
+		},
+	})
+	assert.Error(t, err)
+}
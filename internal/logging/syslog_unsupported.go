@@ -0,0 +1,14 @@
+//go:build windows || plan9
+
+package logging
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/alpkeskin/rota/internal/config"
+)
+
+func newSyslogHandler(cfg config.SyslogConfig, options *slog.HandlerOptions) (slog.Handler, error) {
+	return nil, errors.New(msgSyslogUnsupported)
+}
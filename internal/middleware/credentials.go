@@ -0,0 +1,197 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+)
+
+const (
+	msgInvalidCredential      = "invalid credential, skipping it"
+	msgCredentialUsernameless = "credential username is required"
+	msgCredentialExists       = "credential already exists"
+	msgCredentialNotFound     = "credential not found"
+)
+
+var (
+	ErrCredentialExists   = errors.New(msgCredentialExists)
+	ErrCredentialNotFound = errors.New(msgCredentialNotFound)
+)
+
+// Credential is a client account accepted next to the listener's username and password
+type Credential struct {
+	Username    string     `json:"username"`
+	Password    string     `json:"password,omitempty"`
+	Description string     `json:"description"`
+	Disabled    bool       `json:"disabled"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	RateLimit   *RateLimit `json:"rate_limit,omitempty"`
+	Tenant      string     `json:"tenant,omitempty"`
+}
+
+type Credentials struct {
+	mu       sync.RWMutex
+	accounts map[string]Credential
+}
+
+func NewCredentials() *Credentials {
+	return &Credentials{accounts: make(map[string]Credential)}
+}
+
+// Reset replaces every account with the configured ones, accounts that can not be parsed are logged and skipped
+func (c *Credentials) Reset(defaults []config.CredentialConfig) {
+	accounts := make(map[string]Credential, len(defaults))
+	for _, cfg := range defaults {
+		credential, err := parseCredential(cfg)
+		if err != nil {
+			slog.Error(msgInvalidCredential, "error", err, "username", cfg.Username)
+			continue
+		}
+		accounts[credential.Username] = credential
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.accounts = accounts
+}
+
+// All returns every account without its password, sorted by username
+func (c *Credentials) All() []Credential {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	credentials := make([]Credential, 0, len(c.accounts))
+	for _, username := range slices.Sorted(maps.Keys(c.accounts)) {
+		credential := c.accounts[username]
+		credential.Password = ""
+		credentials = append(credentials, credential)
+	}
+	return credentials
+}
+
+// Snapshot returns every account with its password, sorted by username, for backups
+func (c *Credentials) Snapshot() []Credential {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	credentials := make([]Credential, 0, len(c.accounts))
+	for _, username := range slices.Sorted(maps.Keys(c.accounts)) {
+		credentials = append(credentials, c.accounts[username])
+	}
+	return credentials
+}
+
+// Replace swaps every account for the given ones at once, they are expected to be validated
+func (c *Credentials) Replace(credentials []Credential) {
+	accounts := make(map[string]Credential, len(credentials))
+	for _, credential := range credentials {
+		accounts[credential.Username] = credential
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.accounts = accounts
+}
+
+func (c *Credentials) Add(credential Credential) error {
+	if credential.Username == "" {
+		return errors.New(msgCredentialUsernameless)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.accounts[credential.Username]; ok {
+		return ErrCredentialExists
+	}
+	c.accounts[credential.Username] = credential
+	return nil
+}
+
+// Update replaces the account, an empty password keeps the current one
+func (c *Credentials) Update(credential Credential) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	current, ok := c.accounts[credential.Username]
+	if !ok {
+		return ErrCredentialNotFound
+	}
+	if credential.Password == "" {
+		credential.Password = current.Password
+	}
+	c.accounts[credential.Username] = credential
+	return nil
+}
+
+func (c *Credentials) Remove(username string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.accounts[username]; !ok {
+		return ErrCredentialNotFound
+	}
+	delete(c.accounts, username)
+	return nil
+}
+
+// password returns the password of an enabled, unexpired account
+func (c *Credentials) password(username string, now time.Time) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	credential, ok := c.accounts[username]
+	if !ok || credential.Disabled || (credential.ExpiresAt != nil && !now.Before(*credential.ExpiresAt)) {
+		return "", false
+	}
+	return credential.Password, true
+}
+
+// Tenant returns the tenant the account belongs to, accounts without one are served by the listener's settings
+func (c *Credentials) Tenant(username string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	credential, ok := c.accounts[username]
+	return credential.Tenant, ok
+}
+
+// rateLimit returns the account's own rate limit
+func (c *Credentials) rateLimit(username string) (RateLimit, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	credential, ok := c.accounts[username]
+	if !ok || credential.RateLimit == nil {
+		return RateLimit{}, false
+	}
+	return *credential.RateLimit, true
+}
+
+func (c *Credentials) valid(username, password string, now time.Time) bool {
+	expected, ok := c.password(username, now)
+	return ok && subtle.ConstantTimeCompare([]byte(password), []byte(expected)) == 1
+}
+
+func parseCredential(cfg config.CredentialConfig) (Credential, error) {
+	credential := Credential{
+		Username:    cfg.Username,
+		Password:    cfg.Password,
+		Description: cfg.Description,
+		Disabled:    cfg.Disabled,
+		Tenant:      cfg.Tenant,
+	}
+	if cfg.RateLimit != nil {
+		credential.RateLimit = &RateLimit{RequestsPerSecond: cfg.RateLimit.RequestsPerSecond, Burst: cfg.RateLimit.Burst}
+	}
+	if credential.Username == "" {
+		return credential, errors.New(msgCredentialUsernameless)
+	}
+	if cfg.ExpiresAt != "" {
+		expiresAt, err := time.Parse(time.RFC3339, cfg.ExpiresAt)
+		if err != nil {
+			return credential, fmt.Errorf("expires_at: %w", err)
+		}
+		credential.ExpiresAt = &expiresAt
+	}
+	return credential, nil
+}
@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/elazarl/goproxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCredentials(t *testing.T) {
+	credentials := NewCredentials()
+	credentials.Reset([]config.CredentialConfig{
+		{Username: "alice", Password: "secret", Description: "team a"},
+		{Username: "bob", Password: "secret", ExpiresAt: "2024-01-01T00:00:00Z"},
+		{Username: "carol", Password: "secret", ExpiresAt: "tomorrow"},
+		{Password: "nameless"},
+	})
+
+	all := credentials.All()
+	require.Len(t, all, 2)
+	assert.Equal(t, "alice", all[0].Username)
+	assert.Empty(t, all[0].Password)
+	assert.Equal(t, "bob", all[1].Username)
+
+	assert.ErrorIs(t, credentials.Add(Credential{Username: "alice", Password: "other"}), ErrCredentialExists)
+	assert.Error(t, credentials.Add(Credential{Password: "other"}))
+	assert.NoError(t, credentials.Add(Credential{Username: "dave", Password: "pass"}))
+
+	assert.NoError(t, credentials.Update(Credential{Username: "dave", Disabled: true}))
+	password, ok := credentials.password("dave", time.Now())
+	assert.False(t, ok)
+	assert.Empty(t, password)
+	assert.NoError(t, credentials.Update(Credential{Username: "dave"}))
+	password, ok = credentials.password("dave", time.Now())
+	assert.True(t, ok)
+	assert.Equal(t, "pass", password, "an empty password keeps the current one")
+	assert.ErrorIs(t, credentials.Update(Credential{Username: "erin"}), ErrCredentialNotFound)
+
+	assert.NoError(t, credentials.Remove("dave"))
+	assert.ErrorIs(t, credentials.Remove("dave"), ErrCredentialNotFound)
+
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	assert.True(t, credentials.valid("bob", "secret", now))
+	assert.False(t, credentials.valid("bob", "secret", now.AddDate(1, 0, 0)), "expired")
+	assert.False(t, credentials.valid("alice", "wrong", now))
+}
+
+func TestProxyAuthCredentials(t *testing.T) {
+	middleware := NewMiddleware()
+	middleware.Credentials().Reset([]config.CredentialConfig{
+		{Username: "alice", Password: "alicepass"},
+		{Username: "bob", Password: "bobpass", Disabled: true},
+		{Username: "carol", Password: "carolpass", ExpiresAt: "2000-01-01T00:00:00Z"},
+	})
+
+	basic := func(credentials string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+	}
+	auth := config.ProxyAuthenticationConfig{Enabled: true, Username: "admin", Password: "adminpass", Directives: true}
+
+	tests := []struct {
+		name       string
+		authHeader string
+		wantErr    bool
+	}{
+		{"listener account", basic("admin:adminpass"), false},
+		{"client account", basic("alice:alicepass"), false},
+		{"client account with directives", basic("alice-session-abc:alicepass"), false},
+		{"wrong password", basic("alice:adminpass"), true},
+		{"disabled account", basic("bob:bobpass"), true},
+		{"expired account", basic("carol:carolpass"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+			req.Header.Set(ProxyAuthHeader, tt.authHeader)
+			err := middleware.ProxyAuth(&goproxy.ProxyCtx{Req: req}, auth)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	auth.Scheme = SchemeDigest
+	nonce := parseDigestParams(strings.TrimPrefix(middleware.Challenge(auth), "Digest "))["nonce"]
+	for _, tt := range []struct {
+		username, password string
+		wantErr            bool
+	}{
+		{"alice", "alicepass", false},
+		{"alice-country-de", "alicepass", false},
+		{"bob", "bobpass", true},
+	} {
+		req, _ := http.NewRequest("CONNECT", "https://example.com:443", nil)
+		req.RequestURI = "example.com:443"
+		req.Header.Set(ProxyAuthHeader, digestResponse(tt.username, tt.password, "CONNECT", "example.com:443", nonce))
+		err := middleware.ProxyAuth(&goproxy.ProxyCtx{Req: req}, auth)
+		assert.Equal(t, tt.wantErr, err != nil, tt.username)
+	}
+}
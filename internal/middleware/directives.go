@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+const (
+	directiveSession = "session"
+	directiveCountry = "country"
+)
+
+// Directives are rotation options a client appends to its username, e.g. user-country-de-session-abc123
+type Directives struct {
+	Username string
+	Session  string
+	Country  string
+}
+
+// ParseUsername splits the directives off username. The dash separated words before the first directive
+// are the username, so usernames may contain dashes but directive values may not
+func ParseUsername(username string) Directives {
+	parts := strings.Split(username, "-")
+	directives := Directives{Username: username}
+	for i := 1; i+1 < len(parts); i++ {
+		if !isDirective(parts[i]) {
+			continue
+		}
+
+		directives.Username = strings.Join(parts[:i], "-")
+		for ; i+1 < len(parts); i += 2 {
+			switch strings.ToLower(parts[i]) {
+			case directiveSession:
+				directives.Session = parts[i+1]
+			case directiveCountry:
+				directives.Country = strings.ToUpper(parts[i+1])
+			default:
+				// an unknown word after the directives makes the whole name a plain username
+				return Directives{Username: username}
+			}
+		}
+		if i < len(parts) {
+			return Directives{Username: username}
+		}
+		break
+	}
+	return directives
+}
+
+// RequestDirectives reads the directives from the username of a Basic or Digest Proxy-Authorization header
+func RequestDirectives(r *http.Request) Directives {
+	username := RequestUsername(r)
+	if username == "" {
+		return Directives{}
+	}
+	return ParseUsername(username)
+}
+
+// RequestUsername returns the username of a Basic or Digest Proxy-Authorization header as sent
+func RequestUsername(r *http.Request) string {
+	scheme, credentials, _ := strings.Cut(r.Header.Get(ProxyAuthHeader), " ")
+	switch {
+	case strings.EqualFold(scheme, "Basic"):
+		decoded, err := base64.StdEncoding.DecodeString(credentials)
+		if err != nil {
+			return ""
+		}
+		username, _, _ := strings.Cut(string(decoded), ":")
+		return username
+	case strings.EqualFold(scheme, "Digest"):
+		return parseDigestParams(credentials)["username"]
+	}
+	return ""
+}
+
+func isDirective(word string) bool {
+	return strings.EqualFold(word, directiveSession) || strings.EqualFold(word, directiveCountry)
+}
+
+// authUsername is the username credentials are checked against
+func authUsername(username string, directives bool) string {
+	if directives {
+		return ParseUsername(username).Username
+	}
+	return username
+}
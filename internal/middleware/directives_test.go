@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/elazarl/goproxy"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseUsername(t *testing.T) {
+	tests := []struct {
+		username string
+		want     Directives
+	}{
+		{"user", Directives{Username: "user"}},
+		{"user-session-abc123", Directives{Username: "user", Session: "abc123"}},
+		{"user-country-de", Directives{Username: "user", Country: "DE"}},
+		{"my-user-Country-us-session-42", Directives{Username: "my-user", Session: "42", Country: "US"}},
+		{"user-session", Directives{Username: "user-session"}},
+		{"user-session-abc-extra", Directives{Username: "user-session-abc-extra"}},
+		{"user-session-abc-city-berlin", Directives{Username: "user-session-abc-city-berlin"}},
+		{"session-abc", Directives{Username: "session-abc"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.username, func(t *testing.T) {
+			assert.Equal(t, tt.want, ParseUsername(tt.username))
+		})
+	}
+}
+
+func TestRequestDirectives(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   Directives
+	}{
+		{"basic", "Basic " + base64.StdEncoding.EncodeToString([]byte("user-session-abc:pass")), Directives{Username: "user", Session: "abc"}},
+		{"digest", `Digest username="user-country-nl", realm="rota"`, Directives{Username: "user", Country: "NL"}},
+		{"bearer", "Bearer token", Directives{}},
+		{"invalid basic", "Basic !!!", Directives{}},
+		{"missing", "", Directives{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+			if tt.header != "" {
+				req.Header.Set(ProxyAuthHeader, tt.header)
+			}
+			assert.Equal(t, tt.want, RequestDirectives(req))
+		})
+	}
+}
+
+func TestProxyAuthDirectives(t *testing.T) {
+	auth := config.ProxyAuthenticationConfig{Username: "testuser", Password: "testpass"}
+	middleware := NewMiddleware()
+	basic := func(credentials string) *goproxy.ProxyCtx {
+		req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+		req.Header.Set(ProxyAuthHeader, "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+		return &goproxy.ProxyCtx{Req: req}
+	}
+
+	// directives are part of the username until they are enabled
+	assert.Error(t, middleware.ProxyAuth(basic("testuser-session-abc:testpass"), auth))
+
+	auth.Directives = true
+	assert.NoError(t, middleware.ProxyAuth(basic("testuser-session-abc:testpass"), auth))
+	assert.NoError(t, middleware.ProxyAuth(basic("testuser:testpass"), auth))
+	assert.Error(t, middleware.ProxyAuth(basic("other-session-abc:testpass"), auth))
+
+	auth.Scheme = SchemeDigest
+	nonce := middleware.newNonce(time.Now())
+	req, _ := http.NewRequest(http.MethodConnect, "https://example.com:443", nil)
+	req.RequestURI = "example.com:443"
+	req.Header.Set(ProxyAuthHeader, digestResponse("testuser-country-de", "testpass", "CONNECT", "example.com:443", nonce))
+	assert.NoError(t, middleware.ProxyAuth(&goproxy.ProxyCtx{Req: req}, auth))
+}
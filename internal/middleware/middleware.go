@@ -1,36 +1,124 @@
 package middleware
 
 import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/alpkeskin/rota/internal/config"
 	"github.com/elazarl/goproxy"
 )
 
 const (
-	ProxyAuthHeader = "Proxy-Authorization"
-	msgNoAuthHeader = "no auth header"
-	msgInvalidAuth  = "invalid auth credentials"
+	ProxyAuthHeader         = "Proxy-Authorization"
+	ProxyAuthenticateHeader = "Proxy-Authenticate"
+	msgNoAuthHeader         = "no auth header"
+	msgInvalidAuth          = "invalid auth credentials"
+
+	SchemeBasic  = "basic"
+	SchemeDigest = "digest"
+
+	authRealm      = "rota"
+	digestNonceTTL = 5 * time.Minute
 )
 
 type Middleware struct {
-	cfg *config.Config
+	secret      []byte
+	credentials *Credentials
+	rateLimiter *RateLimiter
 }
 
-func NewMiddleware(cfg *config.Config) *Middleware {
+func NewMiddleware() *Middleware {
+	secret := make([]byte, 32)
+	_, _ = rand.Read(secret)
+
 	return &Middleware{
-		cfg: cfg,
+		secret:      secret,
+		credentials: NewCredentials(),
+		rateLimiter: NewRateLimiter(),
 	}
 }
 
-func (m *Middleware) ProxyAuth(ctx *goproxy.ProxyCtx) error {
+// Credentials returns the client accounts accepted by every listener with basic or digest authentication
+func (m *Middleware) Credentials() *Credentials {
+	return m.credentials
+}
+
+func (m *Middleware) RateLimiter() *RateLimiter {
+	return m.rateLimiter
+}
+
+func (m *Middleware) ProxyAuth(ctx *goproxy.ProxyCtx, auth config.ProxyAuthenticationConfig) error {
+	if Trusted(auth.TrustedCIDRs, ctx.Req.RemoteAddr) {
+		return nil
+	}
+
 	authHeader := ctx.Req.Header.Get(ProxyAuthHeader)
 	if authHeader == "" {
 		return errors.New(msgNoAuthHeader)
 	}
 
+	scheme, credentials, _ := strings.Cut(authHeader, " ")
+
+	switch {
+	case auth.Token != "" && strings.EqualFold(scheme, "Bearer"):
+		return tokenAuth(auth, credentials)
+	case strings.EqualFold(auth.Scheme, SchemeDigest):
+		if !strings.EqualFold(scheme, "Digest") {
+			return errors.New(msgInvalidAuth)
+		}
+		return m.digestAuth(ctx.Req, auth, credentials)
+	}
+
+	return m.basicAuth(auth, authHeader)
+}
+
+func Trusted(trusted []string, remoteAddr string) bool {
+	if len(trusted) == 0 {
+		return false
+	}
+
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	for _, cidr := range trusted {
+		if prefix, err := netip.ParsePrefix(cidr); err == nil && prefix.Contains(addr) {
+			return true
+		}
+		if ip, err := netip.ParseAddr(cidr); err == nil && ip.Unmap() == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Middleware) Challenge(auth config.ProxyAuthenticationConfig) string {
+	if strings.EqualFold(auth.Scheme, SchemeDigest) {
+		return fmt.Sprintf(`Digest realm="%s", qop="auth", algorithm=MD5, nonce="%s"`, authRealm, m.newNonce(time.Now()))
+	}
+	return fmt.Sprintf(`Basic realm="%s"`, authRealm)
+}
+
+func (m *Middleware) basicAuth(auth config.ProxyAuthenticationConfig, authHeader string) error {
 	authHeader = strings.TrimPrefix(authHeader, "Basic ")
 	authBytes, err := base64.StdEncoding.DecodeString(authHeader)
 	if err != nil {
@@ -43,12 +131,124 @@ func (m *Middleware) ProxyAuth(ctx *goproxy.ProxyCtx) error {
 		return errors.New(msgInvalidAuth)
 	}
 
-	username := parts[0]
+	username := authUsername(parts[0], auth.Directives)
 	password := parts[1]
 
-	if username != m.cfg.Proxy.Authentication.Username || password != m.cfg.Proxy.Authentication.Password {
+	if username == auth.Username && password == auth.Password {
+		return nil
+	}
+	if m.credentials.valid(username, password, time.Now()) {
+		return nil
+	}
+	return errors.New(msgInvalidAuth)
+}
+
+func tokenAuth(auth config.ProxyAuthenticationConfig, token string) error {
+	if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(auth.Token)) != 1 {
 		return errors.New(msgInvalidAuth)
 	}
+	return nil
+}
 
+func (m *Middleware) digestAuth(r *http.Request, auth config.ProxyAuthenticationConfig, credentials string) error {
+	params := parseDigestParams(credentials)
+
+	password, ok := m.digestPassword(auth, authUsername(params["username"], auth.Directives))
+	if !ok || params["realm"] != authRealm || !m.validNonce(params["nonce"]) {
+		return errors.New(msgInvalidAuth)
+	}
+	// clients send either the absolute request target or only its path for proxied requests
+	if r.RequestURI != "" && params["uri"] != r.RequestURI && params["uri"] != r.URL.RequestURI() {
+		return errors.New(msgInvalidAuth)
+	}
+
+	// the client hashes the username it sent, directives included
+	ha1 := md5Hex(params["username"] + ":" + authRealm + ":" + password)
+	ha2 := md5Hex(r.Method + ":" + params["uri"])
+
+	var expected string
+	switch params["qop"] {
+	case "auth":
+		expected = md5Hex(strings.Join([]string{ha1, params["nonce"], params["nc"], params["cnonce"], "auth", ha2}, ":"))
+	case "":
+		expected = md5Hex(ha1 + ":" + params["nonce"] + ":" + ha2)
+	default:
+		return errors.New(msgInvalidAuth)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(params["response"])) != 1 {
+		return errors.New(msgInvalidAuth)
+	}
 	return nil
 }
+
+func (m *Middleware) digestPassword(auth config.ProxyAuthenticationConfig, username string) (string, bool) {
+	if username == auth.Username {
+		return auth.Password, true
+	}
+	return m.credentials.password(username, time.Now())
+}
+
+func (m *Middleware) newNonce(now time.Time) string {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(timestamp + ":" + m.sign(timestamp)))
+}
+
+func (m *Middleware) validNonce(nonce string) bool {
+	decoded, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil {
+		return false
+	}
+
+	timestamp, signature, ok := strings.Cut(string(decoded), ":")
+	if !ok || !hmac.Equal([]byte(signature), []byte(m.sign(timestamp))) {
+		return false
+	}
+
+	issued, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	return time.Since(time.Unix(issued, 0)) <= digestNonceTTL
+}
+
+func (m *Middleware) sign(value string) string {
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func parseDigestParams(credentials string) map[string]string {
+	params := make(map[string]string)
+	for _, part := range splitDigestParams(credentials) {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		params[strings.ToLower(key)] = strings.Trim(value, `"`)
+	}
+	return params
+}
+
+func splitDigestParams(credentials string) []string {
+	var parts []string
+	quoted := false
+	start := 0
+	for i, c := range credentials {
+		switch c {
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				parts = append(parts, credentials[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, credentials[start:])
+}
+
+func md5Hex(value string) string {
+	sum := md5.Sum([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
@@ -1,10 +1,14 @@
 package middleware
 
 import (
+	"crypto/md5"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"net/http"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/alpkeskin/rota/internal/config"
 	"github.com/elazarl/goproxy"
@@ -20,7 +24,8 @@ func TestProxyAuth(t *testing.T) {
 			},
 		},
 	}
-	middleware := NewMiddleware(cfg)
+	auth := cfg.Proxy.Authentication
+	middleware := NewMiddleware()
 
 	tests := []struct {
 		name          string
@@ -65,7 +70,7 @@ func TestProxyAuth(t *testing.T) {
 				Req: req,
 			}
 
-			err := middleware.ProxyAuth(ctx)
+			err := middleware.ProxyAuth(ctx, auth)
 			if tt.expectedError == nil {
 				assert.Nil(t, err)
 			} else {
@@ -74,3 +79,142 @@ func TestProxyAuth(t *testing.T) {
 		})
 	}
 }
+
+func digestResponse(username, password, method, uri, nonce string) string {
+	hash := func(value string) string {
+		sum := md5.Sum([]byte(value))
+		return hex.EncodeToString(sum[:])
+	}
+	ha1 := hash(username + ":rota:" + password)
+	ha2 := hash(method + ":" + uri)
+	response := hash(ha1 + ":" + nonce + ":00000001:abcdef:auth:" + ha2)
+	return fmt.Sprintf(`Digest username="%s", realm="rota", nonce="%s", uri="%s", qop=auth, nc=00000001, cnonce="abcdef", response="%s"`,
+		username, nonce, uri, response)
+}
+
+func TestProxyAuthDigest(t *testing.T) {
+	cfg := &config.Config{
+		Proxy: config.ProxyConfig{
+			Authentication: config.ProxyAuthenticationConfig{
+				Scheme:   SchemeDigest,
+				Username: "testuser",
+				Password: "testpass",
+			},
+		},
+	}
+	auth := cfg.Proxy.Authentication
+	middleware := NewMiddleware()
+
+	challenge := middleware.Challenge(auth)
+	assert.True(t, strings.HasPrefix(challenge, "Digest "))
+	nonce := parseDigestParams(strings.TrimPrefix(challenge, "Digest "))["nonce"]
+	assert.NotEmpty(t, nonce)
+
+	tests := []struct {
+		name       string
+		authHeader string
+		wantErr    bool
+	}{
+		{
+			name:       "valid digest",
+			authHeader: digestResponse("testuser", "testpass", "CONNECT", "example.com:443", nonce),
+			wantErr:    false,
+		},
+		{
+			name:       "wrong password",
+			authHeader: digestResponse("testuser", "wrong", "CONNECT", "example.com:443", nonce),
+			wantErr:    true,
+		},
+		{
+			name:       "forged nonce",
+			authHeader: digestResponse("testuser", "testpass", "CONNECT", "example.com:443", "forged"),
+			wantErr:    true,
+		},
+		{
+			name:       "expired nonce",
+			authHeader: digestResponse("testuser", "testpass", "CONNECT", "example.com:443", middleware.newNonce(time.Now().Add(-time.Hour))),
+			wantErr:    true,
+		},
+		{
+			name:       "basic refused",
+			authHeader: "Basic " + base64.StdEncoding.EncodeToString([]byte("testuser:testpass")),
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("CONNECT", "https://example.com:443", nil)
+			req.RequestURI = "example.com:443"
+			req.Header.Set("Proxy-Authorization", tt.authHeader)
+
+			err := middleware.ProxyAuth(&goproxy.ProxyCtx{Req: req}, auth)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestProxyAuthToken(t *testing.T) {
+	cfg := &config.Config{
+		Proxy: config.ProxyConfig{
+			Authentication: config.ProxyAuthenticationConfig{
+				Username: "testuser",
+				Password: "testpass",
+				Token:    "secret-token",
+			},
+		},
+	}
+	auth := cfg.Proxy.Authentication
+	middleware := NewMiddleware()
+
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.Header.Set("Proxy-Authorization", "Bearer secret-token")
+	assert.NoError(t, middleware.ProxyAuth(&goproxy.ProxyCtx{Req: req}, auth))
+
+	req.Header.Set("Proxy-Authorization", "Bearer wrong-token")
+	assert.Error(t, middleware.ProxyAuth(&goproxy.ProxyCtx{Req: req}, auth))
+}
+
+func TestTrusted(t *testing.T) {
+	cfg := &config.Config{
+		Proxy: config.ProxyConfig{
+			Authentication: config.ProxyAuthenticationConfig{
+				Username:     "testuser",
+				Password:     "testpass",
+				TrustedCIDRs: []string{"10.0.0.0/8", "192.168.1.10", "fd00::/8"},
+			},
+		},
+	}
+	auth := cfg.Proxy.Authentication
+	middleware := NewMiddleware()
+
+	tests := []struct {
+		remoteAddr string
+		expected   bool
+	}{
+		{"10.1.2.3:51234", true},
+		{"192.168.1.10:8080", true},
+		{"192.168.1.11:8080", false},
+		{"[fd00::1]:8080", true},
+		{"[::ffff:10.0.0.1]:8080", true},
+		{"203.0.113.5:443", false},
+		{"invalid", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.remoteAddr, func(t *testing.T) {
+			assert.Equal(t, tt.expected, Trusted(auth.TrustedCIDRs, tt.remoteAddr))
+		})
+	}
+
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	req.RemoteAddr = "10.1.2.3:51234"
+	assert.NoError(t, middleware.ProxyAuth(&goproxy.ProxyCtx{Req: req}, auth))
+
+	req.RemoteAddr = "203.0.113.5:443"
+	assert.Error(t, middleware.ProxyAuth(&goproxy.ProxyCtx{Req: req}, auth))
+}
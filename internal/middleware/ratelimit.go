@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/alpkeskin/rota/pkg/redis"
+)
+
+const (
+	RateLimitByIP         = "ip"
+	RateLimitByCredential = "credential"
+
+	rateLimitSweepInterval = time.Minute
+
+	msgSharedRateLimitFailed = "shared rate limit failed, limiting locally"
+)
+
+// RateLimit allows RequestsPerSecond on average and bursts of Burst requests, a rate of 0 does not limit
+type RateLimit struct {
+	RequestsPerSecond float64 `json:"requests_per_second"`
+	Burst             int     `json:"burst"`
+}
+
+// RateLimiter keeps a token bucket per client
+type RateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	shared    *redis.Client
+	prefix    string
+}
+
+type bucket struct {
+	tokens float64
+	at     time.Time
+	limit  RateLimit
+}
+
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{buckets: make(map[string]*bucket)}
+}
+
+// Allow takes a token from the bucket of key, a changed limit applies from the next request on
+func (l *RateLimiter) Allow(key string, limit RateLimit, now time.Time) bool {
+	if limit.RequestsPerSecond <= 0 {
+		return true
+	}
+	burst := limit.burst()
+	if l.shared != nil {
+		allowed, err := l.allowShared(key, limit, now)
+		if err == nil {
+			return allowed
+		}
+		if !errors.Is(err, redis.ErrUnavailable) {
+			slog.Warn(msgSharedRateLimitFailed, "error", err)
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, at: now}
+		l.buckets[key] = b
+	}
+	b.limit = limit
+	b.tokens = min(burst, b.tokens+now.Sub(b.at).Seconds()*limit.RequestsPerSecond)
+	b.at = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Share counts requests in redis so every instance using it enforces one limit, it must be called before the first request
+func (l *RateLimiter) Share(client *redis.Client, prefix string) {
+	l.shared = client
+	l.prefix = prefix
+}
+
+// allowShared admits burst requests per window of burst/rate seconds, which averages to the rate like the local buckets
+// without needing a script on the server
+func (l *RateLimiter) allowShared(key string, limit RateLimit, now time.Time) (bool, error) {
+	burst := limit.burst()
+	window := time.Duration(burst / limit.RequestsPerSecond * float64(time.Second))
+	windowKey := l.prefix + ":ratelimit:" + key + ":" + strconv.FormatInt(now.UnixNano()/int64(window), 10)
+
+	count, err := l.shared.Int("INCR", windowKey)
+	if err != nil {
+		return false, err
+	}
+	if count == 1 {
+		if _, err := l.shared.Do("PEXPIRE", windowKey, strconv.FormatInt(window.Milliseconds()+1, 10)); err != nil {
+			return false, err
+		}
+	}
+	return float64(count) <= burst, nil
+}
+
+// sweep drops the buckets that refilled, a new bucket starts full so forgetting them changes nothing
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.at).Seconds()*b.limit.RequestsPerSecond >= b.limit.burst() {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// burst defaults to one second of requests
+func (r RateLimit) burst() float64 {
+	if r.Burst > 0 {
+		return float64(r.Burst)
+	}
+	return max(1, math.Ceil(r.RequestsPerSecond))
+}
+
+// AllowRequest applies the rate limit to a client, keyed by its username when limits are per credential and the
+// client sent one, otherwise by its IP. Accounts with their own rate_limit use it instead of the default
+func (m *Middleware) AllowRequest(cfg config.ProxyRateLimitConfig, r *http.Request, username string) bool {
+	if !cfg.Enabled {
+		return true
+	}
+
+	limit := RateLimit{RequestsPerSecond: cfg.RequestsPerSecond, Burst: cfg.Burst}
+	key := RateLimitByIP + ":" + clientIP(r.RemoteAddr)
+	if strings.EqualFold(cfg.By, RateLimitByCredential) && username != "" {
+		key = RateLimitByCredential + ":" + username
+		if override, ok := m.credentials.rateLimit(username); ok {
+			limit = override
+		}
+	}
+	return m.rateLimiter.Allow(key, limit, time.Now())
+}
+
+func clientIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
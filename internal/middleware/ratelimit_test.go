@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter()
+	limit := RateLimit{RequestsPerSecond: 2, Burst: 3}
+	now := time.Now()
+
+	for range 3 {
+		assert.True(t, limiter.Allow("a", limit, now))
+	}
+	assert.False(t, limiter.Allow("a", limit, now), "burst used up")
+	assert.True(t, limiter.Allow("b", limit, now), "keys have their own bucket")
+
+	now = now.Add(500 * time.Millisecond)
+	assert.True(t, limiter.Allow("a", limit, now), "one token refilled")
+	assert.False(t, limiter.Allow("a", limit, now))
+
+	assert.True(t, limiter.Allow("a", RateLimit{}, now), "a zero rate does not limit")
+
+	// refilled buckets are forgotten
+	limiter.Allow("c", limit, now.Add(2*rateLimitSweepInterval))
+	assert.Len(t, limiter.buckets, 1)
+}
+
+func TestAllowRequest(t *testing.T) {
+	m := NewMiddleware()
+	m.Credentials().Reset([]config.CredentialConfig{
+		{Username: "premium", Password: "pass", RateLimit: &config.RateLimitConfig{RequestsPerSecond: 100, Burst: 5}},
+		{Username: "basic", Password: "pass"},
+	})
+	cfg := config.ProxyRateLimitConfig{Enabled: true, By: RateLimitByCredential, RequestsPerSecond: 0.001, Burst: 2}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+
+	allowed := func(username string, n int) int {
+		count := 0
+		for range n {
+			if m.AllowRequest(cfg, req, username) {
+				count++
+			}
+		}
+		return count
+	}
+	assert.Equal(t, 2, allowed("basic", 10), "default limit")
+	assert.Equal(t, 5, allowed("premium", 10), "the account's own limit")
+	assert.Equal(t, 2, allowed("", 10), "clients without a username are limited by ip")
+
+	// keyed by ip every client behind 10.0.0.1 shares one bucket, which the anonymous requests used up
+	cfg.By = RateLimitByIP
+	assert.Equal(t, 0, allowed("premium", 1))
+
+	cfg.Enabled = false
+	assert.Equal(t, 3, allowed("basic", 3))
+}
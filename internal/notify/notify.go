@@ -0,0 +1,184 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+)
+
+const (
+	EventProxyFailed        = "proxy_failed"
+	EventProxyRecovered     = "proxy_recovered"
+	EventPoolBelowThreshold = "pool_below_threshold"
+
+	SignatureHeader = "X-Rota-Signature"
+	EventHeader     = "X-Rota-Event"
+
+	msgFailedToSendWebhook = "failed to send webhook"
+	msgWebhookQueueFull    = "webhook queue full, dropping event"
+
+	defaultRetries = 3
+	defaultTimeout = 10
+	queueSize      = 256
+)
+
+type Event struct {
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	Proxy     string    `json:"proxy,omitempty"`
+	Pool      string    `json:"pool,omitempty"`
+	Healthy   int       `json:"healthy"`
+	Total     int       `json:"total"`
+	Threshold int       `json:"threshold,omitempty"`
+}
+
+// Notifier posts events to the configured webhooks in the background, a nil Notifier drops every event
+type Notifier struct {
+	webhooks []config.WebhookConfig
+	retries  int
+	backoff  time.Duration
+	client   *http.Client
+	events   chan Event
+	done     chan struct{}
+	mu       sync.RWMutex
+	closed   bool
+}
+
+func New(cfg config.NotificationsConfig) *Notifier {
+	if len(cfg.Webhooks) == 0 {
+		return nil
+	}
+
+	retries := cfg.Retries
+	if retries <= 0 {
+		retries = defaultRetries
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	n := &Notifier{
+		webhooks: cfg.Webhooks,
+		retries:  retries,
+		backoff:  time.Second,
+		client:   &http.Client{Timeout: time.Duration(timeout) * time.Second},
+		events:   make(chan Event, queueSize),
+		done:     make(chan struct{}),
+	}
+	go n.run()
+	return n
+}
+
+// Notify queues the event, it never blocks the request path
+func (n *Notifier) Notify(event Event) {
+	if n == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.closed {
+		return
+	}
+	select {
+	case n.events <- event:
+	default:
+		slog.Warn(msgWebhookQueueFull, "event", event.Type)
+	}
+}
+
+// Close sends the queued events and stops the notifier
+func (n *Notifier) Close() {
+	if n == nil {
+		return
+	}
+
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return
+	}
+	n.closed = true
+	close(n.events)
+	n.mu.Unlock()
+
+	<-n.done
+}
+
+func (n *Notifier) run() {
+	defer close(n.done)
+	for event := range n.events {
+		body, err := json.Marshal(event)
+		if err != nil {
+			slog.Error(msgFailedToSendWebhook, "error", err, "event", event.Type)
+			continue
+		}
+		for _, webhook := range n.webhooks {
+			if len(webhook.Events) > 0 && !slices.Contains(webhook.Events, event.Type) {
+				continue
+			}
+			if err := n.send(webhook, event.Type, body); err != nil {
+				slog.Error(msgFailedToSendWebhook, "error", err, "event", event.Type, "url", webhook.URL)
+			}
+		}
+	}
+}
+
+// send posts the event, retrying failed deliveries with a doubling backoff
+func (n *Notifier) send(webhook config.WebhookConfig, eventType string, body []byte) error {
+	var err error
+	backoff := n.backoff
+	for attempt := 0; attempt < n.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if err = n.post(webhook, eventType, body); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (n *Notifier) post(webhook config.WebhookConfig, eventType string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, eventType)
+	if webhook.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(webhook.Secret, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+// Sign returns the signature header value of body, "sha256=" and the hex HMAC-SHA256 of body keyed with secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,105 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+type webhookRecorder struct {
+	mu       sync.Mutex
+	events   []Event
+	failures int
+}
+
+func (rec *webhookRecorder) handler(t *testing.T, secret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		if rec.failures > 0 {
+			rec.failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		if secret != "" {
+			assert.Equal(t, Sign(secret, body), r.Header.Get(SignatureHeader))
+		}
+
+		var event Event
+		assert.NoError(t, json.Unmarshal(body, &event))
+		assert.Equal(t, event.Type, r.Header.Get(EventHeader))
+		rec.events = append(rec.events, event)
+	}
+}
+
+func (rec *webhookRecorder) types() []string {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	types := make([]string, 0, len(rec.events))
+	for _, e := range rec.events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func TestNotifier(t *testing.T) {
+	all := &webhookRecorder{}
+	allServer := httptest.NewServer(all.handler(t, "secret"))
+	defer allServer.Close()
+
+	pool := &webhookRecorder{failures: 2}
+	poolServer := httptest.NewServer(pool.handler(t, ""))
+	defer poolServer.Close()
+
+	n := New(config.NotificationsConfig{
+		Webhooks: []config.WebhookConfig{
+			{URL: allServer.URL, Secret: "secret"},
+			{URL: poolServer.URL, Events: []string{EventPoolBelowThreshold}},
+		},
+	})
+	n.backoff = time.Millisecond
+
+	n.Notify(Event{Type: EventProxyFailed, Proxy: "127.0.0.1:8080"})
+	n.Notify(Event{Type: EventPoolBelowThreshold, Healthy: 1, Threshold: 2})
+	n.Close()
+
+	assert.Equal(t, []string{EventProxyFailed, EventPoolBelowThreshold}, all.types())
+	// delivered on the third attempt
+	assert.Equal(t, []string{EventPoolBelowThreshold}, pool.types())
+	assert.False(t, all.events[0].Time.IsZero())
+}
+
+func TestNotifierGivesUp(t *testing.T) {
+	rec := &webhookRecorder{failures: 10}
+	server := httptest.NewServer(rec.handler(t, ""))
+	defer server.Close()
+
+	n := New(config.NotificationsConfig{Retries: 2, Webhooks: []config.WebhookConfig{{URL: server.URL}}})
+	n.backoff = time.Millisecond
+	n.Notify(Event{Type: EventProxyRecovered})
+	n.Close()
+
+	assert.Empty(t, rec.types())
+	assert.Equal(t, 8, rec.failures)
+}
+
+func TestNilNotifier(t *testing.T) {
+	n := New(config.NotificationsConfig{})
+	assert.Nil(t, n)
+	n.Notify(Event{Type: EventProxyFailed})
+	n.Close()
+}
+
+func TestSign(t *testing.T) {
+	assert.Equal(t, "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8", Sign("key", []byte("The quick brown fox jumps over the lazy dog")))
+}
@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"net"
+	"strings"
+
+	"github.com/alpkeskin/rota/internal/config"
+)
+
+// normalizeAddress writes IPv6 hosts of a proxy address the one way net/url reads them, in brackets and
+// in their shortest form, so "2001:0db8::1" and "[2001:db8::1]" are the same proxy. Other addresses are returned as they are
+func normalizeAddress(address string) string {
+	prefix, rest := "", address
+	if scheme, after, ok := strings.Cut(address, "://"); ok {
+		prefix, rest = scheme+"://", after
+	}
+	suffix := ""
+	if i := strings.IndexAny(rest, "/?#"); i >= 0 {
+		rest, suffix = rest[:i], rest[i:]
+	}
+	if i := strings.LastIndex(rest, "@"); i >= 0 {
+		prefix, rest = prefix+rest[:i+1], rest[i+1:]
+	}
+
+	// a bare address can not carry a port, 2001:db8::1:8080 is read as an address
+	if ip := net.ParseIP(strings.Trim(rest, "[]")); ip != nil && ip.To4() == nil && strings.Contains(rest, ":") {
+		return prefix + "[" + ip.String() + "]" + suffix
+	}
+	if host, port, err := net.SplitHostPort(rest); err == nil {
+		if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+			return prefix + net.JoinHostPort(ip.String(), port) + suffix
+		}
+	}
+	return address
+}
+
+// upstream returns the upstreams settings of a proxy, keys are matched after normalizing them like proxy addresses
+func (pl *ProxyLoader) upstream(address string) config.UpstreamConfig {
+	if upstream, ok := pl.config().Upstreams[address]; ok {
+		return upstream
+	}
+	for key, upstream := range pl.config().Upstreams {
+		if normalizeAddress(key) == address {
+			return upstream
+		}
+	}
+	return config.UpstreamConfig{}
+}
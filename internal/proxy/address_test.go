@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{"http://1.2.3.4:8080", "http://1.2.3.4:8080"},
+		{"proxy.example.com:3128", "proxy.example.com:3128"},
+		{"socks5://[2001:0db8:0000::1]:1080", "socks5://[2001:db8::1]:1080"},
+		{"http://user:p@ss@[2001:DB8::1]:8080/", "http://user:p@ss@[2001:db8::1]:8080/"},
+		{"[2001:db8::1]:8080", "[2001:db8::1]:8080"},
+		{"2001:db8::1", "[2001:db8::1]"},
+		{"https://2001:db8::1", "https://[2001:db8::1]"},
+		{"socks5://[::ffff:1.2.3.4]:1080", "socks5://[::ffff:1.2.3.4]:1080"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, normalizeAddress(tt.address), tt.address)
+	}
+}
+
+func TestIPv6Proxies(t *testing.T) {
+	listen := func() net.Listener {
+		listener, err := net.Listen("tcp", "[::1]:0")
+		if err != nil {
+			t.Skip("no ipv6 loopback")
+		}
+		return listener
+	}
+	target := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	}))
+	target.Listener = listen()
+	target.Start()
+	defer target.Close()
+
+	// plain http requests reach an http proxy with the absolute url, answering them stands in for forwarding
+	httpProxy := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Host != target.Listener.Addr().String() || r.Header.Get("X-Api-Key") != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte("hello"))
+	}))
+	httpProxy.Listener = listen()
+	httpProxy.Start()
+	defer httpProxy.Close()
+	_, httpPort, _ := net.SplitHostPort(httpProxy.Listener.Addr().String())
+
+	var tunnels atomic.Int64
+	socks := newSocksServer(t, "", "", &tunnels)
+
+	cfg := &config.Config{
+		Proxy: config.ProxyConfig{Rotation: config.ProxyRotationConfig{Retries: 1, Timeout: 5}},
+		Upstreams: map[string]config.UpstreamConfig{
+			"http://[0:0::1]:" + httpPort: {Headers: []string{"X-Api-Key: secret"}},
+		},
+	}
+	ps := NewProxyServer(cfg)
+	pl := NewProxyLoader(ps)
+
+	proxies := pl.parseProxies("socks5://" + socks + "\nhttp://[0:0:0::1]:" + httpPort + " v6\n")
+	require.Len(t, proxies, 2)
+	assert.Equal(t, "http://[::1]:"+httpPort, proxies[1].Host)
+	assert.Equal(t, []string{"v6"}, proxies[1].Tags)
+
+	for _, p := range proxies {
+		req, _ := http.NewRequest("GET", target.URL, nil)
+		resp, err := ps.tryProxy(p, requestInfo{id: "test-id", request: req})
+		require.NoError(t, err, p.Host)
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode, p.Host)
+		assert.Equal(t, "hello", string(body))
+	}
+	assert.Equal(t, int64(1), tunnels.Load(), "the socks proxy tunneled to the ipv6 target")
+}
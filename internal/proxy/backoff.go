@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"golang.org/x/exp/rand"
+)
+
+const (
+	BackoffNone        = "none"
+	BackoffFixed       = "fixed"
+	BackoffExponential = "exponential"
+
+	defaultMaxBackoff = 30 * time.Second
+
+	msgRetryBackoff = "waiting before retry"
+)
+
+// backoffDelay is the wait before the retry-th retry, 1 being the first. Exponential delays double per retry up to
+// max_delay (default 30s), jitter picks a random delay between half and all of it so clients retrying together spread out
+func backoffDelay(cfg config.BackoffConfig, retry int) time.Duration {
+	if retry < 1 || cfg.Delay <= 0 {
+		return 0
+	}
+
+	delay := time.Duration(cfg.Delay) * time.Millisecond
+	maxDelay := time.Duration(cfg.MaxDelay) * time.Millisecond
+	if maxDelay <= 0 {
+		maxDelay = defaultMaxBackoff
+	}
+	switch strings.ToLower(cfg.Strategy) {
+	case BackoffFixed:
+	case BackoffExponential:
+		for i := 1; i < retry && delay < maxDelay; i++ {
+			delay *= 2
+		}
+	default:
+		return 0
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	if cfg.Jitter {
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	}
+	return delay
+}
+
+// waitBackoff sleeps before a retry, it returns early with the context's error when the client went away
+func waitBackoff(ctx context.Context, cfg config.BackoffConfig, retry int, requestID string) error {
+	delay := backoffDelay(cfg, retry)
+	if delay <= 0 {
+		return nil
+	}
+	slog.Debug(msgRetryBackoff, "request_id", requestID, "retry", retry, "delay", delay.String())
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
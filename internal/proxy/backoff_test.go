@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   config.BackoffConfig
+		retry int
+		want  time.Duration
+	}{
+		{"disabled", config.BackoffConfig{}, 1, 0},
+		{"none", config.BackoffConfig{Strategy: BackoffNone, Delay: 100}, 1, 0},
+		{"first attempt", config.BackoffConfig{Strategy: BackoffFixed, Delay: 100}, 0, 0},
+		{"fixed", config.BackoffConfig{Strategy: BackoffFixed, Delay: 100}, 3, 100 * time.Millisecond},
+		{"exponential first retry", config.BackoffConfig{Strategy: BackoffExponential, Delay: 100}, 1, 100 * time.Millisecond},
+		{"exponential third retry", config.BackoffConfig{Strategy: "Exponential", Delay: 100}, 3, 400 * time.Millisecond},
+		{"exponential capped", config.BackoffConfig{Strategy: BackoffExponential, Delay: 100, MaxDelay: 250}, 3, 250 * time.Millisecond},
+		{"exponential default cap", config.BackoffConfig{Strategy: BackoffExponential, Delay: 1000}, 100, defaultMaxBackoff},
+		{"fixed capped", config.BackoffConfig{Strategy: BackoffFixed, Delay: 500, MaxDelay: 200}, 1, 200 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, backoffDelay(tt.cfg, tt.retry))
+		})
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	cfg := config.BackoffConfig{Strategy: BackoffExponential, Delay: 100, Jitter: true}
+	for range 100 {
+		delay := backoffDelay(cfg, 2)
+		assert.GreaterOrEqual(t, delay, 100*time.Millisecond)
+		assert.LessOrEqual(t, delay, 200*time.Millisecond)
+	}
+}
+
+func TestWaitBackoff(t *testing.T) {
+	cfg := config.BackoffConfig{Strategy: BackoffFixed, Delay: 20}
+	start := time.Now()
+	assert.NoError(t, waitBackoff(context.Background(), cfg, 1, "id"))
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cfg.Delay = 60000
+	start = time.Now()
+	assert.ErrorIs(t, waitBackoff(ctx, cfg, 1, "id"), context.Canceled)
+	assert.Less(t, time.Since(start), time.Second)
+}
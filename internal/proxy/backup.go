@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+const (
+	msgInvalidProxyList    = "invalid proxy list"
+	msgFailedToRestore     = "failed to restore proxy files"
+	msgFailedToRollBack    = "failed to roll back proxy file"
+	msgMissingProxyFileKey = "proxy file missing from the backup"
+)
+
+var ErrInvalidProxyList = errors.New(msgInvalidProxyList)
+
+// ReadProxyFiles returns the content of every loaded proxy file by path
+func (pl *ProxyLoader) ReadProxyFiles() (map[string]string, error) {
+	files := make(map[string]string)
+	for _, file := range pl.ProxyFiles() {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		files[file] = string(data)
+	}
+	return files, nil
+}
+
+// RestoreProxyFiles replaces every loaded proxy file with the given content and reloads the proxies. All lines are
+// validated before anything is written, and the previous files are put back when a write or the reload fails
+func (pl *ProxyLoader) RestoreProxyFiles(files map[string]string) error {
+	if err := pl.validateProxyFiles(files); err != nil {
+		return err
+	}
+
+	pl.proxyServer.imports.Lock()
+	defer pl.proxyServer.imports.Unlock()
+
+	previous, err := pl.ReadProxyFiles()
+	if err != nil {
+		return fmt.Errorf("%s: %w", msgFailedToRestore, err)
+	}
+	written := make([]string, 0, len(files))
+	rollBack := func() {
+		for _, file := range written {
+			if err := os.WriteFile(file, []byte(previous[file]), 0o644); err != nil {
+				// the file is left as restored, the next reload picks up whatever it holds
+				slog.Error(msgFailedToRollBack, "error", err, "file", file)
+			}
+		}
+	}
+
+	for _, file := range slices.Sorted(maps.Keys(files)) {
+		if err := os.WriteFile(file, []byte(files[file]), 0o644); err != nil {
+			rollBack()
+			return fmt.Errorf("%s: %w", msgFailedToRestore, err)
+		}
+		written = append(written, file)
+	}
+	if err := pl.Reload(); err != nil {
+		rollBack()
+		_ = pl.Reload()
+		return fmt.Errorf("%s: %w", msgFailedToRestore, err)
+	}
+	return nil
+}
+
+// validateProxyFiles requires the content of every loaded proxy file and nothing else, with every line valid
+func (pl *ProxyLoader) validateProxyFiles(files map[string]string) error {
+	known := pl.ProxyFiles()
+	for _, file := range known {
+		if _, ok := files[file]; !ok {
+			return fmt.Errorf("%w: %s: %s", ErrInvalidProxyList, msgMissingProxyFileKey, file)
+		}
+	}
+	for file, content := range files {
+		if !slices.Contains(known, file) {
+			return fmt.Errorf("%w: %s", ErrUnknownPool, file)
+		}
+		for i, line := range strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n") {
+			address, _ := parseProxyLine(line)
+			if address == "" {
+				continue
+			}
+			if err := pl.validateProxy(address); err != nil {
+				return fmt.Errorf("%w: %s line %d: %v", ErrInvalidProxyList, file, i+1, err)
+			}
+		}
+	}
+	return nil
+}
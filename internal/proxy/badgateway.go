@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/elazarl/goproxy"
+)
+
+const (
+	// errorDetailFull adds every failed attempt to 502 answers, the default only says why the last one failed
+	errorDetailFull = "full"
+)
+
+// badGatewayBody tells clients why a request failed, so they can tell an exhausted pool from a refusing target
+type badGatewayBody struct {
+	RequestID  string              `json:"request_id"`
+	Error      string              `json:"error"`
+	ErrorClass string              `json:"error_class"`
+	Attempts   int                 `json:"attempts"`
+	History    []badGatewayAttempt `json:"history,omitempty"`
+}
+
+type badGatewayAttempt struct {
+	Proxy      string `json:"proxy"`
+	StatusCode int    `json:"status_code,omitempty"`
+	ErrorClass string `json:"error_class"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// attemptLog collects the failed attempts of one request, only the request's own goroutine adds to it
+type attemptLog struct {
+	records []RequestRecord
+}
+
+func (l *attemptLog) add(record RequestRecord) {
+	if l == nil {
+		return
+	}
+	l.records = append(l.records, record)
+}
+
+func (ps *ProxyServer) badGatewayResponse(reqInfo requestInfo, err error) (*http.Request, *http.Response) {
+	slog.Error(msgReqRotationError, "error", err, "request_id", reqInfo.id, "url", reqInfo.url)
+
+	body := badGatewayBody{RequestID: reqInfo.id, Error: err.Error()}
+	var attempts []RequestRecord
+	if reqInfo.attempts != nil {
+		attempts = reqInfo.attempts.records
+	}
+	body.Attempts = len(attempts)
+	switch {
+	case errors.Is(err, errResponseTooLarge):
+		body.ErrorClass = ErrorClassTooLarge
+	case len(attempts) > 0:
+		body.ErrorClass = attempts[len(attempts)-1].ErrorClass
+	default:
+		// no proxy matched or every matching one was full, no upstream saw the request
+		body.ErrorClass = ErrorClassNoProxy
+	}
+	if ps.Config().Proxy.ErrorDetail == errorDetailFull {
+		body.History = make([]badGatewayAttempt, 0, len(attempts))
+		for _, record := range attempts {
+			body.History = append(body.History, badGatewayAttempt{
+				Proxy:      redactedProxy(record.Proxy),
+				StatusCode: record.StatusCode,
+				ErrorClass: record.ErrorClass,
+				Error:      record.Error,
+				DurationMs: record.DurationMs,
+			})
+		}
+	}
+
+	// the body only holds strings, numbers and slices of them, it always encodes
+	data, _ := json.Marshal(body)
+	return nil, goproxy.NewResponse(reqInfo.request, "application/json", StatusBadGateway, string(data))
+}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/alpkeskin/rota/internal/stats"
+)
+
+// countingConn adds every byte on a connection to an upstream proxy to its bandwidth, headers and tls included
+type countingConn struct {
+	net.Conn
+	bandwidth *stats.Bandwidth
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.bandwidth.AddReceived(n)
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.bandwidth.AddSent(n)
+	return n, err
+}
+
+// countingBody counts the body bytes of one request or response, onClose gets the total once
+type countingBody struct {
+	io.ReadCloser
+	n       atomic.Uint64
+	once    sync.Once
+	onClose func(uint64)
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n.Add(uint64(n))
+	return n, err
+}
+
+func (b *countingBody) Close() error {
+	err := b.ReadCloser.Close()
+	if b.onClose != nil {
+		b.once.Do(func() { b.onClose(b.n.Load()) })
+	}
+	return err
+}
+
+// countBandwidth wraps the dialer of tr so the connections to proxy are counted
+func (ps *ProxyServer) countBandwidth(tr *http.Transport, proxy string) {
+	bandwidth := ps.stats.Bandwidth(proxy)
+	dial := tr.DialContext
+	if dial == nil && tr.Dial != nil {
+		legacy := tr.Dial
+		dial = func(_ context.Context, network, addr string) (net.Conn, error) {
+			return legacy(network, addr)
+		}
+		tr.Dial = nil
+	}
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &countingConn{Conn: conn, bandwidth: bandwidth}, nil
+	}
+}
+
+// ProxyBandwidth returns the bytes sent to and received from the proxy since startup
+func (ps *ProxyServer) ProxyBandwidth(proxy *Proxy) (uint64, uint64) {
+	return ps.stats.ProxyBandwidth(proxy.Host)
+}
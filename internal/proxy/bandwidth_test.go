@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBandwidth(t *testing.T) {
+	content := strings.Repeat("x", 4096)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		_, _ = w.Write([]byte(content))
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{
+		History: config.HistoryConfig{Enabled: true},
+		Proxy:   config.ProxyConfig{Rotation: config.ProxyRotationConfig{Retries: 1, Timeout: 5}},
+	}
+	ps := NewProxyServer(cfg)
+	proxy, err := NewProxyLoader(ps).CreateProxy(upstream.URL)
+	require.NoError(t, err)
+
+	req, _ := http.NewRequest(http.MethodPost, "http://example.com/upload", strings.NewReader("hello world"))
+	response, err := ps.tryProxy(proxy, requestInfo{id: "test-id", request: req})
+	require.NoError(t, err)
+
+	records, _ := ps.Requests(RequestFilter{})
+	assert.Empty(t, records, "the record waits for the body")
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	require.NoError(t, response.Body.Close())
+	assert.Equal(t, content, string(body))
+
+	records, _ = ps.Requests(RequestFilter{})
+	require.Len(t, records, 1)
+	assert.Equal(t, uint64(len("hello world")), records[0].BytesSent)
+	assert.Equal(t, uint64(len(content)), records[0].BytesReceived)
+
+	// the proxy totals count the whole connection, headers included
+	sent, received := ps.ProxyBandwidth(proxy)
+	assert.Greater(t, sent, uint64(len("hello world")))
+	assert.Greater(t, received, uint64(len(content)))
+}
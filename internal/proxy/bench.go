@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"fmt"
+	"runtime/metrics"
+	"testing"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+)
+
+const (
+	benchProxyCount = 1000
+	mutexWaitMetric = "/sync/mutex/wait/total:seconds"
+)
+
+var benchMethods = []string{"random", "roundrobin", "sequential", "least_latency", "consistent_hash"}
+
+type SelectorBenchmark struct {
+	Method           string
+	Parallel         bool
+	Selections       int
+	SelectionsPerSec float64
+	AllocsPerOp      int64
+	BytesPerOp       int64
+	MutexWait        time.Duration
+}
+
+// BenchSelectors runs every rotation method against a synthetic pool, sequentially and from all CPUs at once
+func BenchSelectors() []SelectorBenchmark {
+	results := make([]SelectorBenchmark, 0, len(benchMethods)*2)
+	for _, method := range benchMethods {
+		for _, parallel := range []bool{false, true} {
+			results = append(results, benchSelector(method, parallel))
+		}
+	}
+	return results
+}
+
+func benchSelector(method string, parallel bool) SelectorBenchmark {
+	ps := NewProxyServer(&config.Config{})
+	proxies := make([]*Proxy, benchProxyCount)
+	for i := range proxies {
+		proxies[i] = &Proxy{Host: fmt.Sprintf("http://10.0.%d.%d:8080", i/256, i%256)}
+	}
+	ps.SetProxies(proxies)
+
+	waitBefore := mutexWait()
+	result := testing.Benchmark(func(b *testing.B) {
+		b.ReportAllocs()
+		if !parallel {
+			for i := 0; i < b.N; i++ {
+				ps.getProxy(method, proxyFilter{})
+			}
+			return
+		}
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				ps.getProxy(method, proxyFilter{})
+			}
+		})
+	})
+
+	benchmark := SelectorBenchmark{
+		Method:      method,
+		Parallel:    parallel,
+		Selections:  result.N,
+		AllocsPerOp: result.AllocsPerOp(),
+		BytesPerOp:  result.AllocedBytesPerOp(),
+		MutexWait:   mutexWait() - waitBefore,
+	}
+	if result.T > 0 {
+		benchmark.SelectionsPerSec = float64(result.N) / result.T.Seconds()
+	}
+	return benchmark
+}
+
+// mutexWait is the total time goroutines spent blocked on mutexes since the process started
+func mutexWait() time.Duration {
+	sample := []metrics.Sample{{Name: mutexWaitMetric}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindFloat64 {
+		return 0
+	}
+	return time.Duration(sample[0].Value.Float64() * float64(time.Second))
+}
@@ -0,0 +1,26 @@
+package proxy
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBenchSelectors(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs a benchmark per rotation method")
+	}
+
+	benchtime := flag.Lookup("test.benchtime").Value.String()
+	assert.NoError(t, flag.Set("test.benchtime", "10ms"))
+	defer flag.Set("test.benchtime", benchtime)
+
+	results := BenchSelectors()
+	assert.Len(t, results, len(benchMethods)*2)
+	for _, result := range results {
+		assert.Contains(t, benchMethods, result.Method)
+		assert.Positive(t, result.Selections)
+		assert.Positive(t, result.SelectionsPerSec)
+	}
+}
@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/notify"
+)
+
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half_open"
+
+	msgCircuitOpened = "circuit opened, skipping proxy"
+	msgCircuitClosed = "circuit closed, proxy recovered"
+)
+
+type circuitTransition int
+
+const (
+	circuitUnchanged circuitTransition = iota
+	circuitOpened
+	circuitReopened
+	circuitRecovered
+)
+
+// circuitBreaker skips a proxy after consecutive failures, once the cooldown is over a single request probes it again
+type circuitBreaker struct {
+	failures  atomic.Int64
+	openUntil atomic.Int64
+	probeAt   atomic.Int64
+}
+
+// record returns how the result changed the circuit, a failed probe reopens it
+func (cb *circuitBreaker) record(ok bool, threshold int, cooldown time.Duration) circuitTransition {
+	if ok {
+		cb.failures.Store(0)
+		cb.probeAt.Store(0)
+		if cb.openUntil.Swap(0) != 0 {
+			return circuitRecovered
+		}
+		return circuitUnchanged
+	}
+
+	failures := cb.failures.Add(1)
+	probing := cb.probeAt.Swap(0) != 0
+	if threshold <= 0 || (!probing && failures < int64(threshold)) {
+		return circuitUnchanged
+	}
+	if cb.openUntil.Swap(time.Now().Add(cooldown).UnixNano()) != 0 {
+		return circuitReopened
+	}
+	return circuitOpened
+}
+
+func (cb *circuitBreaker) state(now time.Time, cooldown time.Duration) string {
+	openUntil := cb.openUntil.Load()
+	switch {
+	case openUntil == 0:
+		return CircuitClosed
+	case now.UnixNano() < openUntil:
+		return CircuitOpen
+	}
+
+	// an unanswered probe is given up after another cooldown so the proxy is not skipped forever
+	probeAt := cb.probeAt.Load()
+	if probeAt != 0 && now.UnixNano()-probeAt < int64(cooldown) {
+		return CircuitOpen
+	}
+	return CircuitHalfOpen
+}
+
+func (cb *circuitBreaker) allows(now time.Time, cooldown time.Duration) bool {
+	return cb.state(now, cooldown) != CircuitOpen
+}
+
+// claimProbe marks the request that was just given a half-open proxy as its probe
+func (cb *circuitBreaker) claimProbe(now time.Time, cooldown time.Duration) {
+	if cb.state(now, cooldown) == CircuitHalfOpen {
+		cb.probeAt.Store(now.UnixNano())
+	}
+}
+
+func (ps *ProxyServer) breakerCooldown() time.Duration {
+	return time.Duration(ps.Config().Proxy.CircuitBreaker.Cooldown) * time.Second
+}
+
+func (ps *ProxyServer) recordResult(proxy *Proxy, ok bool) {
+	ps.recordQuarantine(proxy, ok)
+	switch proxy.breaker.record(ok, ps.Config().Proxy.CircuitBreaker.Failures, ps.breakerCooldown()) {
+	case circuitOpened:
+		slog.Warn(msgCircuitOpened, "proxy", proxy.Host, "cooldown", ps.breakerCooldown().String())
+		ps.notifyProxy(notify.EventProxyFailed, proxy)
+	case circuitReopened:
+		slog.Warn(msgCircuitOpened, "proxy", proxy.Host, "cooldown", ps.breakerCooldown().String())
+	case circuitRecovered:
+		slog.Info(msgCircuitClosed, "proxy", proxy.Host)
+		ps.notifyProxy(notify.EventProxyRecovered, proxy)
+	}
+}
+
+// CircuitState reports whether rotation currently skips the proxy
+func (ps *ProxyServer) CircuitState(proxy *Proxy) string {
+	return proxy.breaker.state(time.Now(), ps.breakerCooldown())
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	cooldown := time.Minute
+	cb := &circuitBreaker{}
+	now := time.Now()
+
+	assert.Equal(t, circuitUnchanged, cb.record(false, 3, cooldown))
+	assert.Equal(t, circuitUnchanged, cb.record(false, 3, cooldown))
+	assert.Equal(t, CircuitClosed, cb.state(now, cooldown))
+	assert.Equal(t, circuitOpened, cb.record(false, 3, cooldown))
+	assert.Equal(t, CircuitOpen, cb.state(now, cooldown))
+
+	// after the cooldown a single request may probe the proxy
+	later := now.Add(2 * cooldown)
+	assert.Equal(t, CircuitHalfOpen, cb.state(later, cooldown))
+	cb.claimProbe(later, cooldown)
+	assert.Equal(t, CircuitOpen, cb.state(later, cooldown))
+
+	// a probe that never reports back is given up after another cooldown
+	assert.Equal(t, CircuitHalfOpen, cb.state(later.Add(2*cooldown), cooldown))
+
+	// a failed probe opens the circuit again right away
+	assert.Equal(t, circuitReopened, cb.record(false, 3, cooldown))
+	assert.Equal(t, CircuitOpen, cb.state(time.Now(), cooldown))
+
+	assert.Equal(t, circuitRecovered, cb.record(true, 3, cooldown))
+	assert.Equal(t, CircuitClosed, cb.state(time.Now(), cooldown))
+	assert.Equal(t, circuitUnchanged, cb.record(true, 3, cooldown))
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	cb := &circuitBreaker{}
+	for range 10 {
+		assert.Equal(t, circuitUnchanged, cb.record(false, 0, time.Minute))
+	}
+	assert.Equal(t, CircuitClosed, cb.state(time.Now(), time.Minute))
+}
+
+func TestPickProxySkipsOpenCircuits(t *testing.T) {
+	cfg := &config.Config{
+		Proxy: config.ProxyConfig{
+			CircuitBreaker: config.CircuitBreakerConfig{Failures: 2, Cooldown: 60},
+		},
+	}
+	ps := NewProxyServer(cfg)
+	bad := &Proxy{Host: "bad"}
+	good := &Proxy{Host: "good"}
+	ps.SetProxies([]*Proxy{bad, good})
+
+	ps.recordResult(bad, false)
+	ps.recordResult(bad, false)
+	assert.Equal(t, CircuitOpen, ps.CircuitState(bad))
+
+	for range 4 {
+		assert.Same(t, good, ps.getProxy("roundrobin", proxyFilter{}))
+		assert.Same(t, good, ps.getProxy("random", proxyFilter{}))
+	}
+
+	ps.recordResult(good, false)
+	ps.recordResult(good, false)
+	assert.Nil(t, ps.getProxy("roundrobin", proxyFilter{}))
+}
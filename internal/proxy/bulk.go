@@ -0,0 +1,269 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+const (
+	BulkValid     = "valid"
+	BulkInvalid   = "invalid"
+	BulkDuplicate = "duplicate"
+	BulkAdded     = "added"
+	BulkMergeable = "mergeable"
+	BulkMerged    = "merged"
+
+	msgUnknownPool           = "pool is not a loaded proxy file"
+	msgMissingProxyHost      = "missing proxy host"
+	msgMissingProxyScheme    = "missing proxy scheme, enable detect_protocol to add addresses without one"
+	msgDuplicateProxy        = "proxy already exists"
+	msgDuplicateBulkProxy    = "proxy is listed twice"
+	msgFailedToImportProxies = "failed to import proxies"
+)
+
+var ErrUnknownPool = errors.New(msgUnknownPool)
+
+// BulkEntry reports what an import did with one line of the list
+type BulkEntry struct {
+	Line   int      `json:"line"`
+	Proxy  string   `json:"proxy"`
+	Tags   []string `json:"tags,omitempty"`
+	Status string   `json:"status"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// BulkSummary counts what an import did, dry runs add and merge nothing
+type BulkSummary struct {
+	Added   int `json:"added"`
+	Merged  int `json:"merged"`
+	Skipped int `json:"skipped"`
+	Invalid int `json:"invalid"`
+}
+
+// ImportProxies validates a list in the proxy file format and appends the new proxies to the pool's proxy file.
+// A proxy already in the pool's file or listed earlier that brings new tags gets them merged into its line,
+// other repeats are skipped as duplicates. Nothing is written on a dry run
+func (pl *ProxyLoader) ImportProxies(content, pool string, dryRun bool) ([]BulkEntry, error) {
+	if pool == "" {
+		pool = pl.config().ProxyFile
+	}
+	if !slices.Contains(pl.ProxyFiles(), pool) {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownPool, pool)
+	}
+
+	pl.proxyServer.imports.Lock()
+	defer pl.proxyServer.imports.Unlock()
+
+	fileLines, err := readLineTags(pool)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", msgFailedToImportProxies, err)
+	}
+	existing := make(map[string]*Proxy)
+	for _, p := range pl.proxyServer.GetProxies() {
+		existing[p.Host] = p
+	}
+	// tags every address of the list ends up with, and the tags merged into lines already in the file
+	listed := make(map[string][]string)
+	merges := make(map[string][]string)
+	added := make([]string, 0)
+
+	entries := make([]BulkEntry, 0)
+	for i, line := range strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n") {
+		address, tags := parseProxyLine(line)
+		if address == "" {
+			continue
+		}
+		entry := BulkEntry{Line: i + 1, Proxy: address, Tags: tags, Status: BulkValid}
+		current, isListed := listed[address]
+		if p := existing[address]; !isListed && p != nil && p.Pool == pool {
+			// later listings of a proxy in the file see the tags merged by the earlier ones
+			if current, isListed = fileTags(fileLines, address); isListed {
+				listed[address] = current
+				merges[address] = nil
+			}
+		}
+		_, inFile := merges[address]
+		switch err := pl.validateProxy(address); {
+		case err != nil:
+			entry.Status = BulkInvalid
+			entry.Error = err.Error()
+		case isListed && len(newTags(current, tags)) > 0:
+			entry.Status = BulkMergeable
+			listed[address] = append(slices.Clone(current), newTags(current, tags)...)
+			if inFile {
+				merges[address] = append(merges[address], newTags(current, tags)...)
+			}
+		case isListed && inFile:
+			entry.Status = BulkDuplicate
+			entry.Error = msgDuplicateProxy
+		case isListed:
+			entry.Status = BulkDuplicate
+			entry.Error = msgDuplicateBulkProxy
+		case existing[address] != nil:
+			// proxies of other pools, sources and chains are left alone
+			entry.Status = BulkDuplicate
+			entry.Error = msgDuplicateProxy
+		default:
+			listed[address] = tags
+			added = append(added, address)
+		}
+		entries = append(entries, entry)
+	}
+	for address, tags := range merges {
+		if len(tags) == 0 {
+			delete(merges, address)
+		}
+	}
+
+	if dryRun || (len(added) == 0 && len(merges) == 0) {
+		return entries, nil
+	}
+	if len(added) > 0 {
+		lines := make([]string, 0, len(added))
+		for _, address := range added {
+			lines = append(lines, strings.Join(append([]string{address}, listed[address]...), " "))
+		}
+		if err := appendLines(pool, lines); err != nil {
+			return nil, fmt.Errorf("%s: %w", msgFailedToImportProxies, err)
+		}
+	}
+	if err := addLineTags(pool, merges); err != nil {
+		return nil, fmt.Errorf("%s: %w", msgFailedToImportProxies, err)
+	}
+	for i := range entries {
+		switch entries[i].Status {
+		case BulkValid:
+			entries[i].Status = BulkAdded
+		case BulkMergeable:
+			entries[i].Status = BulkMerged
+		}
+	}
+	return entries, pl.Reload()
+}
+
+// SummarizeImport counts the entries of an import by outcome
+func SummarizeImport(entries []BulkEntry) BulkSummary {
+	var summary BulkSummary
+	for _, entry := range entries {
+		switch entry.Status {
+		case BulkAdded:
+			summary.Added++
+		case BulkMerged:
+			summary.Merged++
+		case BulkDuplicate:
+			summary.Skipped++
+		case BulkInvalid:
+			summary.Invalid++
+		}
+	}
+	return summary
+}
+
+// validateProxy checks an address the way CreateProxy reads it, without building a transport
+func (pl *ProxyLoader) validateProxy(address string) error {
+	if needsDetection(address) {
+		if !pl.config().DetectProtocol {
+			return errors.New(msgMissingProxyScheme)
+		}
+		address = "http://" + address
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks4", "socks4a", "socks5":
+	default:
+		return fmt.Errorf("%s: %s", msgUnsupportedProxyScheme, u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return errors.New(msgMissingProxyHost)
+	}
+	return nil
+}
+
+// appendLines adds lines to the end of a proxy file, starting a new line when the file does not end with one
+func appendLines(file string, lines []string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	content := strings.Join(lines, "\n") + "\n"
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		content = "\n" + content
+	}
+
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readLineTags returns the tags of every address listed in a proxy file, a missing file lists none
+func readLineTags(file string) (map[string][]string, error) {
+	tags := make(map[string][]string)
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return tags, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if address, lineTags := parseProxyLine(line); address != "" {
+			tags[address] = append(tags[address], lineTags...)
+		}
+	}
+	return tags, nil
+}
+
+// fileTags looks host up in the lines of a proxy file, a line without a scheme matches any scheme since detection
+// picked it
+func fileTags(lines map[string][]string, host string) ([]string, bool) {
+	if tags, ok := lines[host]; ok {
+		return tags, true
+	}
+	_, bare, _ := strings.Cut(host, "://")
+	tags, ok := lines[bare]
+	return tags, ok && needsDetection(bare)
+}
+
+func newTags(current, tags []string) []string {
+	return slices.DeleteFunc(slices.Clone(tags), func(tag string) bool { return slices.Contains(current, tag) })
+}
+
+// addLineTags appends tags to the lines of a proxy file that list their host, matched like removeLines
+func addLineTags(file string, tags map[string][]string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	for i, line := range lines {
+		address, _ := parseProxyLine(line)
+		if address == "" {
+			continue
+		}
+		for host, added := range tags {
+			_, bare, _ := strings.Cut(host, "://")
+			if address == host || (needsDetection(address) && address == bare) {
+				text := strings.TrimRight(line, "\r\n")
+				lines[i] = text + " " + strings.Join(added, " ") + line[len(text):]
+			}
+		}
+	}
+	return os.WriteFile(file, []byte(strings.Join(lines, "")), 0o644)
+}
@@ -0,0 +1,314 @@
+package proxy
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alpkeskin/rota/pkg/redis"
+)
+
+const (
+	CacheHeader = "X-Rota-Cache"
+	cacheHit    = "HIT"
+	cacheMiss   = "MISS"
+
+	defaultCacheEntries = 1000
+	defaultCacheMaxSize = 1 << 20
+	defaultCacheTTL     = 300
+)
+
+var defaultCacheKeyHeaders = []string{"Accept", "Accept-Encoding", "Accept-Language"}
+
+// responseCache keeps GET responses in memory, least recently used first out, and in redis when it is shared
+type responseCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+	max     int
+	shared  *sharedState
+}
+
+type cachedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+	// a response that varies on request headers is stored under a key with their values, the entry under the
+	// plain key only names them
+	Vary    []string `json:"vary,omitempty"`
+	expires time.Time
+	key     string
+}
+
+func newResponseCache(maxEntries int, shared *sharedState) *responseCache {
+	if maxEntries <= 0 {
+		maxEntries = defaultCacheEntries
+	}
+	return &responseCache{
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+		max:     maxEntries,
+		shared:  shared,
+	}
+}
+
+func (c *responseCache) get(key string, now time.Time) (*cachedResponse, bool) {
+	c.mu.Lock()
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*cachedResponse)
+		if now.Before(entry.expires) {
+			c.order.MoveToFront(element)
+			c.mu.Unlock()
+			return entry, true
+		}
+		c.order.Remove(element)
+		delete(c.entries, key)
+	}
+	c.mu.Unlock()
+	return c.shared.cached(key)
+}
+
+func (c *responseCache) put(entry *cachedResponse, ttl time.Duration, now time.Time) {
+	entry.expires = now.Add(ttl)
+	c.shared.cache(entry, ttl)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[entry.key]; ok {
+		c.order.Remove(element)
+	}
+	c.entries[entry.key] = c.order.PushFront(entry)
+	for c.order.Len() > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedResponse).key)
+	}
+}
+
+// cached reads a response another instance stored, entries are found in redis until it expires them
+func (s *sharedState) cached(key string) (*cachedResponse, bool) {
+	if s == nil {
+		return nil, false
+	}
+	data, err := s.client.String("GET", s.prefix+":cache:"+key)
+	if err != nil {
+		if !errors.Is(err, redis.ErrNil) {
+			s.failed(err)
+		}
+		return nil, false
+	}
+	var entry cachedResponse
+	if err := json.Unmarshal([]byte(data), &entry); err != nil {
+		return nil, false
+	}
+	return &entry, true
+}
+
+func (s *sharedState) cache(entry *cachedResponse, ttl time.Duration) {
+	if s == nil {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	if _, err := s.client.Do("SET", s.prefix+":cache:"+entry.key, string(data), "PX", strconv.FormatInt(ttl.Milliseconds(), 10)); err != nil {
+		s.failed(err)
+	}
+}
+
+// cacheTTL returns how long responses of the request may be cached, only GET requests for a host matching a cache
+// rule are cached while the cache feature flag is on, and ranged requests never are. Requests with Authorization or
+// Cookie are only stored when the response is marked public, response is nil on the lookup so they are never answered
+// from the cache
+func (ps *ProxyServer) cacheTTL(r *http.Request, response *http.Response) (time.Duration, bool) {
+	if ps.cache == nil || !ps.features.Enabled(FeatureCache) || r.Method != http.MethodGet || isRangeRequest(r) {
+		return 0, false
+	}
+	if hasCredentials(r.Header) && (response == nil || !isPublic(response.Header)) {
+		return 0, false
+	}
+	host := strings.ToLower(hostname(r.URL.Host))
+	for _, rule := range ps.Config().Cache.Rules {
+		if !matchesHost(rule.Hosts, host) {
+			continue
+		}
+		ttl := rule.TTL
+		if ttl <= 0 {
+			ttl = defaultCacheTTL
+		}
+		return time.Duration(ttl) * time.Second, true
+	}
+	return 0, false
+}
+
+// cacheKey is the url, the listener the request came in on and the headers that select a representation, hashed so
+// it fits any redis key. Listeners with another pool or tenant get their own entries, vary adds the headers a stored
+// response varies on
+func (ps *ProxyServer) cacheKey(r *http.Request, listener *listenerConfig, vary []string) string {
+	names := ps.Config().Cache.KeyHeaders
+	if len(names) == 0 {
+		names = defaultCacheKeyHeaders
+	}
+	h := sha256.New()
+	h.Write([]byte(r.URL.String()))
+	h.Write([]byte("\x00" + listener.pool + "\x00" + listener.tag + "\x00" + listener.tenant))
+	h.Write([]byte("\x00" + strings.Join(listener.rotation.AllowedCountries, ",")))
+	for _, name := range names {
+		h.Write([]byte("\x00" + strings.ToLower(name) + ":" + strings.Join(r.Header.Values(name), ",")))
+	}
+	for _, name := range vary {
+		h.Write([]byte("\x01" + strings.ToLower(name) + ":" + strings.Join(r.Header.Values(name), ",")))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cachedResponse answers the request from the cache, a client asking for a fresh copy skips the lookup
+func (ps *ProxyServer) cachedResponse(r *http.Request, listener *listenerConfig) *http.Response {
+	if _, ok := ps.cacheTTL(r, nil); !ok || bypassesCache(r.Header) {
+		return nil
+	}
+	now := time.Now()
+	entry, ok := ps.cache.get(ps.cacheKey(r, listener, nil), now)
+	if ok && len(entry.Vary) > 0 {
+		entry, ok = ps.cache.get(ps.cacheKey(r, listener, entry.Vary), now)
+	}
+	if !ok {
+		return nil
+	}
+
+	header := entry.Header.Clone()
+	header.Set(CacheHeader, cacheHit)
+	return &http.Response{
+		Status:        strconv.Itoa(entry.StatusCode) + " " + http.StatusText(entry.StatusCode),
+		StatusCode:    entry.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(entry.Body)),
+		ContentLength: int64(len(entry.Body)),
+		Request:       r,
+	}
+}
+
+// cacheResponse stores the response once its body was read to the end, bodies larger than cache.max_size pass
+// through without being kept
+func (ps *ProxyServer) cacheResponse(r *http.Request, listener *listenerConfig, response *http.Response) {
+	ttl, ok := ps.cacheTTL(r, response)
+	if !ok {
+		return
+	}
+	response.Header.Set(CacheHeader, cacheMiss)
+	if !storable(response) {
+		return
+	}
+
+	maxSize := ps.Config().Cache.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultCacheMaxSize
+	}
+	vary := varyHeaders(response.Header)
+	entry := &cachedResponse{
+		StatusCode: response.StatusCode,
+		Header:     response.Header.Clone(),
+		key:        ps.cacheKey(r, listener, vary),
+	}
+	entry.Header.Del(CacheHeader)
+	// a hit is served by no proxy, it must not name the request and proxy of the response it was copied from
+	entry.Header.Del(RequestIDHeader)
+	entry.Header.Del(ProxyHeader)
+	response.Body = &cachingBody{
+		ReadCloser: response.Body,
+		limit:      maxSize,
+		onDone: func(body []byte) {
+			entry.Body = body
+			now := time.Now()
+			if len(vary) > 0 {
+				ps.cache.put(&cachedResponse{Vary: vary, key: ps.cacheKey(r, listener, nil)}, ttl, now)
+			}
+			ps.cache.put(entry, ttl, now)
+		},
+	}
+}
+
+// storable leaves out errors, partial content and responses meant for one client
+func storable(response *http.Response) bool {
+	if response.StatusCode != http.StatusOK || response.Header.Get("Set-Cookie") != "" || response.Header.Get("Vary") == "*" {
+		return false
+	}
+	cacheControl := strings.ToLower(response.Header.Get("Cache-Control"))
+	return !strings.Contains(cacheControl, "no-store") && !strings.Contains(cacheControl, "private")
+}
+
+// varyHeaders returns the request headers named by the Vary header of the response, sorted so the key does not
+// depend on their order
+func varyHeaders(header http.Header) []string {
+	var names []string
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+func hasCredentials(header http.Header) bool {
+	return header.Get("Authorization") != "" || header.Get("Cookie") != ""
+}
+
+func isPublic(header http.Header) bool {
+	return strings.Contains(strings.ToLower(header.Get("Cache-Control")), "public")
+}
+
+func bypassesCache(header http.Header) bool {
+	cacheControl := strings.ToLower(header.Get("Cache-Control"))
+	return strings.Contains(cacheControl, "no-cache") || strings.Contains(cacheControl, "no-store")
+}
+
+// cachingBody keeps a copy of the body while the client reads it
+type cachingBody struct {
+	io.ReadCloser
+	buf      bytes.Buffer
+	limit    int
+	overflow bool
+	complete bool
+	onDone   func([]byte)
+}
+
+func (b *cachingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if !b.overflow {
+		if b.buf.Len()+n > b.limit {
+			b.overflow = true
+			b.buf = bytes.Buffer{}
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	if errors.Is(err, io.EOF) {
+		b.complete = true
+	}
+	return n, err
+}
+
+func (b *cachingBody) Close() error {
+	if b.complete && !b.overflow && b.onDone != nil {
+		b.onDone(bytes.Clone(b.buf.Bytes()))
+		b.onDone = nil
+	}
+	return b.ReadCloser.Close()
+}
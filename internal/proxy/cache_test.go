@@ -0,0 +1,227 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseCacheEviction(t *testing.T) {
+	cache := newResponseCache(2, nil)
+	now := time.Now()
+	cache.put(&cachedResponse{key: "a"}, time.Minute, now)
+	cache.put(&cachedResponse{key: "b"}, time.Minute, now)
+	_, ok := cache.get("a", now)
+	assert.True(t, ok)
+
+	// b was used least recently
+	cache.put(&cachedResponse{key: "c"}, time.Minute, now)
+	_, ok = cache.get("b", now)
+	assert.False(t, ok)
+	_, ok = cache.get("a", now)
+	assert.True(t, ok)
+
+	_, ok = cache.get("c", now.Add(time.Minute))
+	assert.False(t, ok)
+	assert.Equal(t, 1, cache.order.Len())
+}
+
+func TestResponseCaching(t *testing.T) {
+	var served atomic.Int64
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served.Add(1)
+		switch r.URL.Path {
+		case "/private":
+			w.Header().Set("Cache-Control", "private")
+		case "/large":
+			_, _ = w.Write([]byte(strings.Repeat("x", 64)))
+			return
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		}
+		_, _ = w.Write([]byte("body of " + r.URL.Path + " in " + r.Header.Get("Accept-Language")))
+	}))
+	defer target.Close()
+
+	cfg := &config.Config{
+		Proxy: config.ProxyConfig{
+			Rotation: config.ProxyRotationConfig{Method: "roundrobin", Retries: 1, Timeout: 5, FallbackMaxRetries: 1},
+		},
+		Routing: []config.RoutingRuleConfig{
+			{Hosts: []string{"127.0.0.1"}, Direct: true},
+		},
+		Cache: config.CacheConfig{
+			Enabled: true,
+			MaxSize: 32,
+			Rules:   []config.CacheRuleConfig{{Hosts: []string{"127.0.0.1"}, TTL: 60}},
+		},
+	}
+	ps := NewProxyServer(cfg)
+	goProxy := newGoProxy()
+	ps.setUpListenerHandlers(goProxy, 0)
+	server := httptest.NewServer(goProxy)
+	defer server.Close()
+
+	proxyURL, _ := url.Parse(server.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	get := func(path string, header http.Header) (string, string) {
+		req, err := http.NewRequest(http.MethodGet, target.URL+path, nil)
+		require.NoError(t, err)
+		req.Header = header
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(body), resp.Header.Get(CacheHeader)
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		header     http.Header
+		wantCache  string
+		wantServed int64
+	}{
+		{"first fetch", "/page", http.Header{"Accept-Language": {"en"}}, cacheMiss, 1},
+		{"cached", "/page", http.Header{"Accept-Language": {"en"}}, cacheHit, 1},
+		{"other representation", "/page", http.Header{"Accept-Language": {"de"}}, cacheMiss, 2},
+		{"client asks for a fresh copy", "/page", http.Header{"Accept-Language": {"en"}, "Cache-Control": {"no-cache"}}, cacheMiss, 3},
+		{"private", "/private", nil, cacheMiss, 4},
+		{"private again", "/private", nil, cacheMiss, 5},
+		{"larger than max_size", "/large", nil, cacheMiss, 6},
+		{"larger than max_size again", "/large", nil, cacheMiss, 7},
+		{"errors are not cached", "/missing", nil, cacheMiss, 8},
+		{"errors again", "/missing", nil, cacheMiss, 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, cache := get(tt.path, tt.header)
+			assert.Equal(t, tt.wantCache, cache)
+			assert.Equal(t, tt.wantServed, served.Load())
+			if tt.path == "/page" {
+				assert.Equal(t, "body of /page in "+tt.header.Get("Accept-Language"), body)
+			}
+		})
+	}
+
+	ps.Features().Set(FeatureCache, false)
+	_, cache := get("/page", http.Header{"Accept-Language": {"en"}})
+	assert.Empty(t, cache)
+	ps.Features().Set(FeatureCache, true)
+
+	cfg.Cache.Rules = nil
+	_, cache = get("/page", http.Header{"Accept-Language": {"en"}})
+	assert.Empty(t, cache)
+}
+
+func TestSharedResponseCache(t *testing.T) {
+	address := newFakeRedis(t)
+	cfg := &config.Config{
+		Redis: config.RedisConfig{Enabled: true, Address: address},
+		Cache: config.CacheConfig{Enabled: true, Redis: true},
+	}
+	first, second := NewProxyServer(cfg), NewProxyServer(cfg)
+
+	entry := &cachedResponse{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": {"text/plain"}}, Body: []byte("hello"), key: "k"}
+	first.cache.put(entry, time.Minute, time.Now())
+
+	cached, ok := second.cache.get("k", time.Now())
+	require.True(t, ok)
+	assert.Equal(t, entry.Body, cached.Body)
+	assert.Equal(t, entry.Header, cached.Header)
+}
+
+func TestResponseCachingCredentials(t *testing.T) {
+	var served atomic.Int64
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served.Add(1)
+		switch r.URL.Path {
+		case "/public":
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		case "/vary":
+			w.Header().Set("Vary", "X-Client")
+		}
+		_, _ = w.Write([]byte(r.URL.Path + " for " + r.Header.Get("Cookie") + r.Header.Get("X-Client")))
+	}))
+	defer target.Close()
+
+	cfg := &config.Config{
+		Proxy: config.ProxyConfig{
+			Rotation: config.ProxyRotationConfig{Method: "roundrobin", Retries: 1, Timeout: 5, FallbackMaxRetries: 1},
+		},
+		Routing: []config.RoutingRuleConfig{
+			{Hosts: []string{"127.0.0.1"}, Direct: true},
+		},
+		Cache: config.CacheConfig{
+			Enabled: true,
+			Rules:   []config.CacheRuleConfig{{Hosts: []string{"127.0.0.1"}, TTL: 60}},
+		},
+	}
+	ps := NewProxyServer(cfg)
+	goProxy := newGoProxy()
+	ps.setUpListenerHandlers(goProxy, 0)
+	server := httptest.NewServer(goProxy)
+	defer server.Close()
+
+	proxyURL, _ := url.Parse(server.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	get := func(path string, header http.Header) (string, string) {
+		req, err := http.NewRequest(http.MethodGet, target.URL+path, nil)
+		require.NoError(t, err)
+		req.Header = header
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(body), resp.Header.Get(CacheHeader)
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		header     http.Header
+		wantBody   string
+		wantCache  string
+		wantServed int64
+	}{
+		{"first client", "/page", http.Header{"Cookie": {"session=a"}}, "/page for session=a", "", 1},
+		{"second client gets its own response", "/page", http.Header{"Cookie": {"session=b"}}, "/page for session=b", "", 2},
+		{"authorization is not cached", "/page", http.Header{"Authorization": {"Bearer a"}}, "/page for ", "", 3},
+		{"public response of a client with a cookie", "/public", http.Header{"Cookie": {"session=a"}}, "/public for session=a", cacheMiss, 4},
+		{"public response is shared", "/public", nil, "/public for session=a", cacheHit, 4},
+		{"varies on a header", "/vary", http.Header{"X-Client": {"a"}}, "/vary for a", cacheMiss, 5},
+		{"same header value", "/vary", http.Header{"X-Client": {"a"}}, "/vary for a", cacheHit, 5},
+		{"other header value", "/vary", http.Header{"X-Client": {"b"}}, "/vary for b", cacheMiss, 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, cache := get(tt.path, tt.header)
+			assert.Equal(t, tt.wantBody, body)
+			assert.Equal(t, tt.wantCache, cache)
+			assert.Equal(t, tt.wantServed, served.Load())
+		})
+	}
+}
+
+func TestCacheKeyListener(t *testing.T) {
+	ps := NewProxyServer(&config.Config{})
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+
+	key := ps.cacheKey(r, &listenerConfig{pool: "proxies.txt"}, nil)
+	assert.Equal(t, key, ps.cacheKey(r, &listenerConfig{pool: "proxies.txt"}, nil))
+	assert.NotEqual(t, key, ps.cacheKey(r, &listenerConfig{pool: "residential.txt"}, nil))
+	assert.NotEqual(t, key, ps.cacheKey(r, &listenerConfig{pool: "proxies.txt", tenant: "acme"}, nil))
+	assert.NotEqual(t, key, ps.cacheKey(r, &listenerConfig{pool: "proxies.txt", tag: "de"}, nil))
+	assert.NotEqual(t, key, ps.cacheKey(r, &listenerConfig{pool: "proxies.txt"}, []string{"X-Client"}))
+}
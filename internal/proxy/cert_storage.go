@@ -7,6 +7,8 @@ import (
 
 type CertStorage struct {
 	certs map[string]*tls.Certificate
+	order []string
+	max   int
 	mtx   sync.RWMutex
 }
 
@@ -24,14 +26,24 @@ func (cs *CertStorage) Fetch(hostname string, gen func() (*tls.Certificate, erro
 	}
 
 	cs.mtx.Lock()
+	if _, ok := cs.certs[hostname]; !ok {
+		cs.order = append(cs.order, hostname)
+	}
 	cs.certs[hostname] = cert
+	// the oldest certificates are dropped first, they are regenerated on the next visit
+	for cs.max > 0 && len(cs.order) > cs.max {
+		delete(cs.certs, cs.order[0])
+		cs.order = cs.order[1:]
+	}
 	cs.mtx.Unlock()
 
 	return cert, nil
 }
 
-func NewCertStorage() *CertStorage {
+// NewCertStorage creates a certificate cache holding at most max certificates, 0 means unbounded
+func NewCertStorage(max int) *CertStorage {
 	return &CertStorage{
 		certs: make(map[string]*tls.Certificate),
+		max:   max,
 	}
 }
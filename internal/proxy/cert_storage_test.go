@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCertStorageEviction(t *testing.T) {
+	cs := NewCertStorage(2)
+	generated := 0
+	gen := func() (*tls.Certificate, error) {
+		generated++
+		return &tls.Certificate{}, nil
+	}
+
+	for _, host := range []string{"a", "b", "a", "c"} {
+		_, err := cs.Fetch(host, gen)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 3, generated)
+	assert.Len(t, cs.certs, 2)
+	assert.NotContains(t, cs.certs, "a")
+
+	// evicted certificates are generated again
+	_, err := cs.Fetch("a", gen)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, generated)
+	assert.Len(t, cs.certs, 2)
+}
+
+func TestCertStorageUnbounded(t *testing.T) {
+	cs := NewCertStorage(0)
+	for _, host := range []string{"a", "b", "c"} {
+		_, err := cs.Fetch(host, func() (*tls.Certificate, error) { return &tls.Certificate{}, nil })
+		assert.NoError(t, err)
+	}
+	assert.Len(t, cs.certs, 3)
+}
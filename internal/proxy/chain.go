@@ -0,0 +1,360 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+)
+
+const (
+	chainScheme    = "chain"
+	chainSeparator = ">"
+
+	msgChainTooShort        = "a chain needs at least two hops"
+	msgUnsupportedChainHop  = "unsupported chain hop scheme"
+	msgSocksHandshakeFailed = "socks handshake failed"
+)
+
+// chainDialer tunnels through every hop in order, each hop is asked to connect to the next one and the last hop to the target
+type chainDialer struct {
+	hops           []*url.URL
+	headers        []http.Header
+	dialer         *net.Dialer
+	resolveTargets bool
+	// auth is the ntlm or negotiate scheme of http hops that authenticate the connection, empty for basic
+	auth []string
+}
+
+// CreateChain creates a proxy that tunnels through hops in order, the last hop is the exit
+func (pl *ProxyLoader) CreateChain(chain config.ChainConfig) (*Proxy, error) {
+	if len(chain.Hops) < 2 {
+		return nil, errors.New(msgChainTooShort)
+	}
+
+	timeout := time.Duration(pl.config().Proxy.Rotation.Timeout) * time.Second
+	dialer := &chainDialer{dialer: pl.proxyServer.netDialer(timeout), resolveTargets: pl.proxyServer.resolveTargets}
+	hops := make([]string, 0, len(chain.Hops))
+	for _, hop := range chain.Hops {
+		hops = append(hops, normalizeAddress(hop))
+	}
+	for _, hop := range hops {
+		hopURL, err := url.Parse(hop)
+		if err != nil {
+			return nil, err
+		}
+		switch hopURL.Scheme {
+		case "http", "https", "socks4", "socks4a", "socks5":
+		default:
+			return nil, fmt.Errorf("%s. URL: %s", msgUnsupportedChainHop, hop)
+		}
+		dialer.hops = append(dialer.hops, hopURL)
+		dialer.headers = append(dialer.headers, parseHeaders(pl.upstream(hop).Headers))
+		auth, _ := connectionAuthScheme(pl.upstream(hop).Auth)
+		dialer.auth = append(dialer.auth, auth)
+	}
+
+	pool := chain.Pool
+	if pool == "" {
+		pool = pl.config().ProxyFile
+	}
+	p := &Proxy{
+		Scheme: chainScheme,
+		Host:   strings.Join(hops, chainSeparator),
+		Url:    dialer.hops[len(dialer.hops)-1],
+		Pool:   pool,
+		Tags:   normalizeTags(chain.Tags),
+	}
+	headers := make([][]string, 0, len(hops))
+	for _, hop := range hops {
+		headers = append(headers, pl.upstream(hop).Headers)
+	}
+	p.Transport = pl.proxyServer.transports.get(pl.transportKey(p.Host, headers, dialer.auth), func() *http.Transport {
+		return pl.configureTransport(&http.Transport{DialContext: dialer.DialContext}, p.Host)
+	})
+	pl.proxyServer.locate(p)
+	return p, nil
+}
+
+func (d *chainDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	first := d.hops[0]
+	conn, err := d.dialer.DialContext(ctx, network, hopAddr(first))
+	if err != nil {
+		return nil, err
+	}
+	if first.Scheme == "https" {
+		conn = tls.Client(conn, &tls.Config{ServerName: first.Hostname(), InsecureSkipVerify: true})
+	}
+
+	deadline, ok := ctx.Deadline()
+	if d.dialer.Timeout > 0 && (!ok || time.Until(deadline) > d.dialer.Timeout) {
+		deadline, ok = time.Now().Add(d.dialer.Timeout), true
+	}
+	if ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	for i, hop := range d.hops {
+		// only single socks proxies have credential sets, chains keep the credentials of their hops
+		if i == 0 {
+			hop = credentialURL(ctx, hop)
+		}
+		var tunnel net.Conn
+		next, err := d.nextAddr(ctx, i, addr)
+		if err == nil {
+			tunnel, err = connectThrough(conn, hop, d.headers[i], d.auth[i], next)
+		}
+		if err != nil {
+			conn.Close()
+			if len(d.hops) == 1 {
+				return nil, err
+			}
+			return nil, fmt.Errorf("hop %d (%s): %w", i+1, hop.Redacted(), err)
+		}
+		conn = tunnel
+		if i+1 < len(d.hops) && d.hops[i+1].Scheme == "https" {
+			conn = tls.Client(conn, &tls.Config{ServerName: d.hops[i+1].Hostname(), InsecureSkipVerify: true})
+		}
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// nextAddr is the address hop i connects to. socks4 hops only take ipv4 addresses, and with dns.resolve local the last
+// hop gets the target's address instead of its name
+func (d *chainDialer) nextAddr(ctx context.Context, i int, addr string) (string, error) {
+	hop := d.hops[i]
+	last := i+1 == len(d.hops)
+	if !last {
+		addr = hopAddr(d.hops[i+1])
+	}
+	if hop.Scheme == "socks4" || (last && d.resolveTargets) {
+		return resolveAddr(ctx, d.dialer.Resolver, addr, hop.Scheme == "socks4")
+	}
+	return addr, nil
+}
+
+// connectThrough asks hop, already connected on conn, to open a tunnel to addr
+func connectThrough(conn net.Conn, hop *url.URL, headers http.Header, auth, addr string) (net.Conn, error) {
+	switch hop.Scheme {
+	case "http", "https":
+		if auth != "" {
+			return ntlmConnect(conn, hop, headers, auth, addr)
+		}
+		return httpConnect(conn, hop, headers, addr)
+	case "socks5":
+		return conn, socks5Connect(conn, hop, addr)
+	default:
+		return conn, socks4Connect(conn, hop, addr)
+	}
+}
+
+func httpConnect(conn net.Conn, hop *url.URL, headers http.Header, addr string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	for name, values := range headers {
+		req.Header[name] = values
+	}
+	if hop.User != nil {
+		password, _ := hop.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(hop.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", msgUpstreamConnectFailed, resp.Status)
+	}
+
+	// bytes the hop sent right after its response belong to the tunnel
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// socks5Connect runs the RFC 1928 handshake with username/password authentication (RFC 1929) when the hop has credentials
+func socks5Connect(conn net.Conn, hop *url.URL, addr string) error {
+	method := byte(0x00)
+	if hop.User != nil {
+		method = 0x02
+	}
+	if _, err := conn.Write([]byte{0x05, 0x01, method}); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 0x05 || reply[1] != method {
+		return fmt.Errorf("%s: authentication method rejected", msgSocksHandshakeFailed)
+	}
+
+	if method == 0x02 {
+		username := hop.User.Username()
+		password, _ := hop.User.Password()
+		if len(username) > 255 || len(password) > 255 {
+			return fmt.Errorf("%s: credentials too long", msgSocksHandshakeFailed)
+		}
+		auth := append([]byte{0x01, byte(len(username))}, username...)
+		auth = append(append(auth, byte(len(password))), password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0x00 {
+			return fmt.Errorf("%s: authentication failed", msgSocksHandshakeFailed)
+		}
+	}
+
+	host, port, err := splitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	req := []byte{0x05, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+		req = append(append(req, 0x01), ip.To4()...)
+	} else if ip != nil {
+		req = append(append(req, 0x04), ip.To16()...)
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("%s: host name too long", msgSocksHandshakeFailed)
+		}
+		req = append(append(req, 0x03, byte(len(host))), host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, port)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0x00 {
+		return fmt.Errorf("%s: connect failed with code %d", msgSocksHandshakeFailed, header[1])
+	}
+
+	// the bound address is not needed but has to be consumed
+	var skip int
+	switch header[3] {
+	case 0x01:
+		skip = net.IPv4len + 2
+	case 0x04:
+		skip = net.IPv6len + 2
+	case 0x03:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		skip = int(length[0]) + 2
+	default:
+		return fmt.Errorf("%s: invalid address type %d", msgSocksHandshakeFailed, header[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip))
+	return err
+}
+
+// socks4Connect sends socks4 hops the address the dialer resolved, socks4a hops resolve the host themselves
+func socks4Connect(conn net.Conn, hop *url.URL, addr string) error {
+	host, port, err := splitHostPort(addr)
+	if err != nil {
+		return err
+	}
+
+	req := binary.BigEndian.AppendUint16([]byte{0x04, 0x01}, port)
+	ip := net.ParseIP(host).To4()
+	remoteResolve := false
+	if ip == nil {
+		if hop.Scheme == "socks4a" {
+			ip = net.IPv4(0, 0, 0, 1).To4()
+			remoteResolve = true
+		} else {
+			return fmt.Errorf("%s: no ipv4 address for %s", msgSocksHandshakeFailed, host)
+		}
+	}
+	req = append(req, ip...)
+	if hop.User != nil {
+		req = append(req, hop.User.Username()...)
+	}
+	req = append(req, 0x00)
+	if remoteResolve {
+		req = append(append(req, host...), 0x00)
+	}
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 8)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != 0x5a {
+		return fmt.Errorf("%s: connect failed with code %d", msgSocksHandshakeFailed, reply[1])
+	}
+	return nil
+}
+
+func splitHostPort(addr string) (string, uint16, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return "", 0, err
+	}
+	return host, uint16(port), nil
+}
+
+// hopAddr adds the default port of the scheme when the hop url has none
+func hopAddr(hop *url.URL) string {
+	if hop.Port() != "" {
+		return hop.Host
+	}
+	port := "1080"
+	switch hop.Scheme {
+	case "http":
+		port = "80"
+	case "https":
+		port = "443"
+	}
+	return net.JoinHostPort(hop.Hostname(), port)
+}
+
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
@@ -0,0 +1,303 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newConnectProxy starts an http proxy that only answers CONNECT
+func newConnectProxy(t *testing.T, credentials string, tunnels *atomic.Int64) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if credentials != "" && r.Header.Get("Proxy-Authorization") != "Basic "+credentials {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			upstream.Close()
+			return
+		}
+		tunnels.Add(1)
+		_, _ = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		pipe(conn, upstream)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newSocksServer starts a socks4/4a/5 server, username and password are required by socks5 when set
+func newSocksServer(t *testing.T, username, password string, tunnels *atomic.Int64) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				upstream, err := socksHandshake(bufio.NewReader(conn), conn, username, password)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				tunnels.Add(1)
+				pipe(conn, upstream)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func socksHandshake(r *bufio.Reader, w io.Writer, username, password string) (net.Conn, error) {
+	version, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	if version == 0x04 {
+		head := make([]byte, 7)
+		if _, err := io.ReadFull(r, head); err != nil {
+			return nil, err
+		}
+		if _, err := r.ReadString(0); err != nil {
+			return nil, err
+		}
+		host := net.IP(head[3:7]).String()
+		if head[3] == 0 && head[4] == 0 && head[5] == 0 {
+			name, err := r.ReadString(0)
+			if err != nil {
+				return nil, err
+			}
+			host = name[:len(name)-1]
+		}
+		upstream, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(head[1:3])))))
+		if err != nil {
+			_, _ = w.Write([]byte{0, 0x5b, 0, 0, 0, 0, 0, 0})
+			return nil, err
+		}
+		_, err = w.Write([]byte{0, 0x5a, 0, 0, 0, 0, 0, 0})
+		return upstream, err
+	}
+
+	methods := make([]byte, 1)
+	if _, err := io.ReadFull(r, methods); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(r, make([]byte, methods[0])); err != nil {
+		return nil, err
+	}
+	if username == "" {
+		_, _ = w.Write([]byte{0x05, 0x00})
+	} else {
+		_, _ = w.Write([]byte{0x05, 0x02})
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, err
+		}
+		user := make([]byte, header[1])
+		if _, err := io.ReadFull(r, user); err != nil {
+			return nil, err
+		}
+		length, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		pass := make([]byte, length)
+		if _, err := io.ReadFull(r, pass); err != nil {
+			return nil, err
+		}
+		if string(user) != username || string(pass) != password {
+			_, _ = w.Write([]byte{0x01, 0x01})
+			return nil, fmt.Errorf("invalid credentials")
+		}
+		_, _ = w.Write([]byte{0x01, 0x00})
+	}
+
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(r, req); err != nil {
+		return nil, err
+	}
+	var host string
+	switch req[3] {
+	case 0x01:
+		ip := make([]byte, 4)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return nil, err
+		}
+		host = net.IP(ip).String()
+	case 0x03:
+		length, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		name := make([]byte, length)
+		if _, err := io.ReadFull(r, name); err != nil {
+			return nil, err
+		}
+		host = string(name)
+	case 0x04:
+		ip := make([]byte, net.IPv6len)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return nil, err
+		}
+		host = net.IP(ip).String()
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return nil, err
+	}
+	upstream, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+	if err != nil {
+		_, _ = w.Write([]byte{0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		return nil, err
+	}
+	// ipv6 targets are answered with an ipv6 bound address like real servers do
+	if req[3] == 0x04 {
+		_, err = w.Write(append(append([]byte{0x05, 0x00, 0x00, 0x04}, net.IPv6loopback...), 0, 0))
+		return upstream, err
+	}
+	_, err = w.Write([]byte{0x05, 0x00, 0x00, 0x01, 127, 0, 0, 1, 0, 0})
+	return upstream, err
+}
+
+func pipe(a, b net.Conn) {
+	defer a.Close()
+	defer b.Close()
+	done := make(chan struct{}, 2)
+	go func() { _, _ = io.Copy(a, b); done <- struct{}{} }()
+	go func() { _, _ = io.Copy(b, a); done <- struct{}{} }()
+	<-done
+}
+
+func TestChain(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer target.Close()
+
+	var httpTunnels, socksTunnels atomic.Int64
+	httpProxy := newConnectProxy(t, "dXNlcjpwYXNz", &httpTunnels)
+	socks := newSocksServer(t, "", "", &socksTunnels)
+	authSocks := newSocksServer(t, "user", "pass", &socksTunnels)
+	httpHop := "http://user:pass@" + httpProxy.Listener.Addr().String()
+
+	tests := []struct {
+		name string
+		hops []string
+	}{
+		{"http to socks5", []string{httpHop, "socks5://user:pass@" + authSocks}},
+		{"socks5 to http", []string{"socks5://" + socks, httpHop}},
+		{"socks4 to socks4a to socks5", []string{"socks4://" + socks, "socks4a://" + socks, "socks5://" + socks}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpTunnels.Store(0)
+			socksTunnels.Store(0)
+
+			cfg := &config.Config{ProxyFile: "proxies.txt"}
+			pl := NewProxyLoader(NewProxyServer(cfg))
+			proxy, err := pl.CreateChain(config.ChainConfig{Hops: tt.hops, Tags: []string{"Chained"}})
+			require.NoError(t, err)
+			assert.Equal(t, chainScheme, proxy.Scheme)
+			assert.Equal(t, "proxies.txt", proxy.Pool)
+			assert.Equal(t, []string{"chained"}, proxy.Tags)
+
+			resp, err := (&http.Client{Transport: proxy.Transport}).Get(target.URL)
+			require.NoError(t, err)
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			assert.NoError(t, err)
+			assert.Equal(t, "hello", string(body))
+			assert.Equal(t, int64(len(tt.hops)), httpTunnels.Load()+socksTunnels.Load())
+		})
+	}
+}
+
+func TestChainFailures(t *testing.T) {
+	var tunnels atomic.Int64
+	httpProxy := newConnectProxy(t, "dXNlcjpwYXNz", &tunnels)
+	socks := newSocksServer(t, "user", "pass", &tunnels)
+
+	cfg := &config.Config{}
+	pl := NewProxyLoader(NewProxyServer(cfg))
+
+	_, err := pl.CreateChain(config.ChainConfig{Hops: []string{"socks5://" + socks}})
+	assert.ErrorContains(t, err, msgChainTooShort)
+	_, err = pl.CreateChain(config.ChainConfig{Hops: []string{"socks5://" + socks, "ftp://127.0.0.1:21"}})
+	assert.ErrorContains(t, err, msgUnsupportedChainHop)
+
+	tests := []struct {
+		name string
+		hops []string
+		want string
+	}{
+		{"http without credentials", []string{"http://" + httpProxy.Listener.Addr().String(), "socks5://" + socks}, "hop 1"},
+		{"socks5 with wrong password", []string{"http://user:pass@" + httpProxy.Listener.Addr().String(), "socks5://user:wrong@" + socks}, "hop 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy, err := pl.CreateChain(config.ChainConfig{Hops: tt.hops})
+			require.NoError(t, err)
+			_, err = (&http.Client{Transport: proxy.Transport}).Get("http://127.0.0.1:1/")
+			assert.ErrorContains(t, err, tt.want)
+		})
+	}
+}
+
+func TestLoadChains(t *testing.T) {
+	cfg := &config.Config{
+		ProxyFile: "proxies.txt",
+		Chains: []config.ChainConfig{
+			{Hops: []string{"socks5://127.0.0.1:1080", "http://127.0.0.1:3128"}, Pool: "chains.txt"},
+			{Hops: []string{"socks5://127.0.0.1:1080"}},
+		},
+	}
+	chains := NewProxyLoader(NewProxyServer(cfg)).createChains()
+	require.Len(t, chains, 1)
+	assert.Equal(t, "socks5://127.0.0.1:1080>http://127.0.0.1:3128", chains[0].Host)
+	assert.Equal(t, "chains.txt", chains[0].Pool)
+	assert.Equal(t, "127.0.0.1:3128", chains[0].Url.Host)
+}
+
+func TestHopAddr(t *testing.T) {
+	tests := []struct {
+		hop  string
+		want string
+	}{
+		{"http://proxy.example.com", "proxy.example.com:80"},
+		{"https://proxy.example.com", "proxy.example.com:443"},
+		{"socks5://proxy.example.com", "proxy.example.com:1080"},
+		{"socks5://proxy.example.com:9050", "proxy.example.com:9050"},
+	}
+	for _, tt := range tests {
+		hop, err := url.Parse(tt.hop)
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, hopAddr(hop))
+	}
+}
@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -22,32 +23,34 @@ const (
 )
 
 type ProxyChecker struct {
-	cfg         *config.Config
 	proxyServer *ProxyServer
 }
 
-func NewProxyChecker(cfg *config.Config, proxyServer *ProxyServer) *ProxyChecker {
+func NewProxyChecker(proxyServer *ProxyServer) *ProxyChecker {
 	return &ProxyChecker{
-		cfg:         cfg,
 		proxyServer: proxyServer,
 	}
 }
 
+func (pl *ProxyChecker) config() *config.Config {
+	return pl.proxyServer.Config()
+}
+
 func (pl *ProxyChecker) Check() error {
 	var outputFile *os.File
 	var err error
 
-	if pl.cfg.Healthcheck.Output.Method == "file" {
-		outputFile, err = os.Create(pl.cfg.Healthcheck.Output.File)
+	if pl.config().Healthcheck.Output.Method == "file" {
+		outputFile, err = os.Create(pl.config().Healthcheck.Output.File)
 		if err != nil {
 			return fmt.Errorf("%s: %w", msgFailedToCreateOutputFile, err)
 		}
 		defer outputFile.Close()
 	}
 
-	wp := workerpool.New(pl.cfg.Healthcheck.Workers)
+	wp := workerpool.New(healthcheckWorkers(pl.config()))
 
-	for _, proxy := range pl.proxyServer.Proxies {
+	for _, proxy := range pl.proxyServer.GetProxies() {
 		wp.Submit(func() {
 			pl.checkProxy(proxy, outputFile)
 		})
@@ -57,39 +60,107 @@ func (pl *ProxyChecker) Check() error {
 	return nil
 }
 
-func (pl *ProxyChecker) checkProxy(proxy *Proxy, outputFile *os.File) {
+func (pl *ProxyChecker) Alive(proxies []*Proxy) []*Proxy {
+	alive := make([]bool, len(proxies))
+	wp := workerpool.New(healthcheckWorkers(pl.config()))
+	for i, proxy := range proxies {
+		wp.Submit(func() {
+			if err := pl.CheckProxy(proxy); err != nil {
+				slog.Error(msgDeadProxy, "error", err, "proxy", proxy.Host)
+				return
+			}
+			alive[i] = true
+		})
+	}
+	wp.StopWait()
+
+	result := make([]*Proxy, 0, len(proxies))
+	for i, proxy := range proxies {
+		if alive[i] {
+			result = append(result, proxy)
+		}
+	}
+	return result
+}
+
+func (pl *ProxyChecker) CheckProxy(proxy *Proxy) error {
+	defer func() {
+		proxy.lastCheck.Store(time.Now().UnixNano())
+	}()
+	check := pl.healthcheck(proxy)
 	client := &http.Client{
 		Transport: proxy.Transport,
-		Timeout:   time.Duration(pl.cfg.Healthcheck.Timeout) * time.Second,
+		Timeout:   time.Duration(check.Timeout) * time.Second,
 	}
 
-	req, err := http.NewRequest("GET", pl.cfg.Healthcheck.URL, nil)
+	// checks rotate the credential sets too without counting as their usage
+	req, err := http.NewRequestWithContext(withCredential(context.Background(), proxy.credentials.pick()), "GET", check.URL, nil)
 	if err != nil {
-		slog.Error(msgDeadProxy, "error", err, "proxy", proxy.Host)
-		return
+		return err
 	}
 
-	for _, header := range pl.cfg.Healthcheck.Headers {
+	for _, header := range pl.config().Healthcheck.Headers {
 		parts := strings.SplitN(header, ":", 2)
 		if len(parts) == 2 {
 			req.Header.Add(parts[0], parts[1])
 		}
 	}
 
+	startAt := time.Now()
 	resp, err := client.Do(req)
 	if err != nil {
-		slog.Error(msgDeadProxy, "error", err, "proxy", proxy.Host)
-		return
+		return err
 	}
+	resp.Body.Close()
+	proxy.latency.observe(time.Since(startAt))
 
-	if resp.StatusCode != pl.cfg.Healthcheck.Status {
-		slog.Error(msgDeadProxy, "error", fmt.Errorf("status code: %d", resp.StatusCode), "proxy", proxy.Host)
+	if resp.StatusCode != check.Status {
+		return fmt.Errorf("status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (p *Proxy) lastChecked() time.Time {
+	nanos := p.lastCheck.Load()
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// ProxyLastCheck returns when the last healthcheck of proxy finished, zero when it was never checked
+func (ps *ProxyServer) ProxyLastCheck(proxy *Proxy) time.Time {
+	return proxy.lastChecked()
+}
+
+// healthcheck applies the upstream's own url, status and timeout over the global settings, unset fields keep them
+func (pl *ProxyChecker) healthcheck(proxy *Proxy) config.UpstreamHealthcheckConfig {
+	check := config.UpstreamHealthcheckConfig{
+		URL:     pl.config().Healthcheck.URL,
+		Status:  pl.config().Healthcheck.Status,
+		Timeout: pl.config().Healthcheck.Timeout,
+	}
+	if proxy.healthcheck.URL != "" {
+		check.URL = proxy.healthcheck.URL
+	}
+	if proxy.healthcheck.Status != 0 {
+		check.Status = proxy.healthcheck.Status
+	}
+	if proxy.healthcheck.Timeout > 0 {
+		check.Timeout = proxy.healthcheck.Timeout
+	}
+	return check
+}
+
+func (pl *ProxyChecker) checkProxy(proxy *Proxy, outputFile *os.File) {
+	if err := pl.CheckProxy(proxy); err != nil {
+		slog.Error(msgDeadProxy, "error", err, "proxy", proxy.Host)
 		return
 	}
 
 	slog.Info(msgAliveProxy, "proxy", proxy.Host)
 	if outputFile != nil {
-		_, err = outputFile.WriteString(proxy.Host + "\n")
+		_, err := outputFile.WriteString(proxy.Host + "\n")
 		if err != nil {
 			slog.Error(msgFailedToWriteOutputFile, "error", err)
 		}
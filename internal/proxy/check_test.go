@@ -40,16 +40,15 @@ func TestProxyChecker_Check(t *testing.T) {
 		},
 	}
 
-	proxyServer := &ProxyServer{
-		Proxies: []*Proxy{
-			{
-				Host:      "http://test-proxy:8080",
-				Transport: http.DefaultTransport.(*http.Transport),
-			},
+	proxyServer := NewProxyServer(cfg)
+	proxyServer.Proxies = []*Proxy{
+		{
+			Host:      "http://test-proxy:8080",
+			Transport: http.DefaultTransport.(*http.Transport),
 		},
 	}
 
-	checker := NewProxyChecker(cfg, proxyServer)
+	checker := NewProxyChecker(proxyServer)
 	err = checker.Check()
 
 	assert.NoError(t, err)
@@ -69,9 +68,41 @@ func TestProxyChecker_InvalidOutput(t *testing.T) {
 		},
 	}
 
-	checker := NewProxyChecker(cfg, &ProxyServer{})
+	checker := NewProxyChecker(NewProxyServer(cfg))
 	err := checker.Check()
 
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), msgFailedToCreateOutputFile)
 }
+
+func TestProxyChecker_UpstreamOverride(t *testing.T) {
+	allowed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer allowed.Close()
+	global := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer global.Close()
+
+	cfg := &config.Config{
+		Healthcheck: config.HealthcheckConfig{URL: global.URL, Status: 200, Timeout: 5},
+		Upstreams: map[string]config.UpstreamConfig{
+			"http://127.0.0.1:3128": {Healthcheck: config.UpstreamHealthcheckConfig{URL: allowed.URL, Status: http.StatusNoContent}},
+		},
+	}
+	ps := NewProxyServer(cfg)
+	pl := NewProxyLoader(ps)
+	overridden, err := pl.CreateProxy("http://127.0.0.1:3128")
+	assert.NoError(t, err)
+	plain, err := pl.CreateProxy("http://127.0.0.1:3129")
+	assert.NoError(t, err)
+	// the test servers are reached without a proxy
+	overridden.Transport = http.DefaultTransport.(*http.Transport)
+	plain.Transport = http.DefaultTransport.(*http.Transport)
+
+	checker := NewProxyChecker(ps)
+	assert.NoError(t, checker.CheckProxy(overridden))
+	assert.ErrorContains(t, checker.CheckProxy(plain), "status code: 403")
+	assert.Equal(t, config.UpstreamHealthcheckConfig{URL: allowed.URL, Status: http.StatusNoContent, Timeout: 5}, checker.healthcheck(overridden))
+}
@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+const msgProxyBusy = "proxy busy, trying another one"
+
+var errProxyBusy = errors.New(msgProxyBusy)
+
+// ProxyLoad is the number of requests a proxy is serving right now, streamed responses count until their body closes
+type ProxyLoad struct {
+	Proxy         string `json:"proxy"`
+	Pool          string `json:"pool"`
+	InFlight      int64  `json:"in_flight"`
+	MaxConcurrent int    `json:"max_concurrent,omitempty"`
+}
+
+// acquire takes one of the proxy's max_concurrent slots and counts the request against its max_rpm, proxies without
+// limits always have room. Every acquired attempt counts as in flight until it is released, drains wait for that count
+func (p *Proxy) acquire() bool {
+	if p.slots != nil {
+		select {
+		case p.slots <- struct{}{}:
+		default:
+			return false
+		}
+	}
+	if !p.rpm.take(time.Now()) {
+		if p.slots != nil {
+			<-p.slots
+		}
+		return false
+	}
+	p.inFlight.Add(1)
+	return true
+}
+
+func (p *Proxy) release() {
+	p.inFlight.Add(-1)
+	if p.slots != nil {
+		<-p.slots
+	}
+}
+
+// busy reports whether every slot is taken or the minute's requests are used up, rotation passes busy proxies over
+func (p *Proxy) busy() bool {
+	return (p.slots != nil && len(p.slots) == cap(p.slots)) || p.rpm.full(time.Now())
+}
+
+// InFlight returns the load of every proxy in pool, or of all of them for an empty pool, the busiest first
+func (ps *ProxyServer) InFlight(pool string) []ProxyLoad {
+	proxies := ps.GetProxies()
+	loads := make([]ProxyLoad, 0, len(proxies))
+	for _, p := range proxies {
+		if pool != "" && p.Pool != pool {
+			continue
+		}
+		loads = append(loads, ProxyLoad{Proxy: p.Host, Pool: p.Pool, InFlight: p.inFlight.Load(), MaxConcurrent: cap(p.slots)})
+	}
+	slices.SortFunc(loads, func(a, b ProxyLoad) int {
+		return cmp.Or(cmp.Compare(b.InFlight, a.InFlight), strings.Compare(a.Proxy, b.Proxy))
+	})
+	return loads
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxConcurrent(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("slow"))
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("fast"))
+	}))
+	defer fast.Close()
+
+	cfg := &config.Config{
+		Proxy: config.ProxyConfig{
+			Rotation: config.ProxyRotationConfig{Method: "roundrobin", Retries: 1, Timeout: 5, FallbackMaxRetries: 2, RemoveUnhealthy: true},
+		},
+		Upstreams: map[string]config.UpstreamConfig{slow.URL: {MaxConcurrent: 1}},
+	}
+	ps := NewProxyServer(cfg)
+	pl := NewProxyLoader(ps)
+	for _, u := range []string{slow.URL, fast.URL} {
+		proxy, err := pl.CreateProxy(u)
+		require.NoError(t, err)
+		ps.AddProxy(proxy)
+	}
+	limited := ps.GetProxies()[0]
+
+	get := func() *http.Response {
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		response, err := ps.tryProxy(limited, requestInfo{id: "test-id", request: req})
+		if err != nil {
+			assert.ErrorIs(t, err, errProxyBusy)
+			return nil
+		}
+		return response
+	}
+
+	// the slot is held until the body is closed
+	held := get()
+	require.NotNil(t, held)
+	assert.True(t, limited.busy())
+	assert.Nil(t, get())
+
+	// rotation passes the busy proxy over without counting it as unhealthy
+	for range 2 {
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		response, err := ps.tryProxies(requestInfo{id: "test-id", request: req})
+		require.NoError(t, err)
+		body, _ := io.ReadAll(response.Body)
+		response.Body.Close()
+		assert.Equal(t, "fast", string(body))
+	}
+	assert.Equal(t, 2, ps.ProxyCount())
+
+	held.Body.Close()
+	assert.False(t, limited.busy())
+	response := get()
+	require.NotNil(t, response)
+	response.Body.Close()
+}
+
+func TestInFlight(t *testing.T) {
+	ps := NewProxyServer(&config.Config{})
+	idle := &Proxy{Host: "idle:1", Pool: "a.txt"}
+	busy := &Proxy{Host: "busy:1", Pool: "a.txt", slots: make(chan struct{}, 4)}
+	other := &Proxy{Host: "other:1", Pool: "b.txt"}
+	ps.SetProxies([]*Proxy{idle, busy, other})
+	require.True(t, busy.acquire())
+	require.True(t, busy.acquire())
+	require.True(t, other.acquire())
+
+	assert.Equal(t, []ProxyLoad{
+		{Proxy: "busy:1", Pool: "a.txt", InFlight: 2, MaxConcurrent: 4},
+		{Proxy: "other:1", Pool: "b.txt", InFlight: 1},
+		{Proxy: "idle:1", Pool: "a.txt"},
+	}, ps.InFlight(""))
+	assert.Equal(t, []ProxyLoad{{Proxy: "other:1", Pool: "b.txt", InFlight: 1}}, ps.InFlight("b.txt"))
+
+	busy.release()
+	assert.Equal(t, int64(1), ps.InFlight("a.txt")[0].InFlight)
+}
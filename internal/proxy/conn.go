@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+type clientConnKey struct{}
+
+// clientListener wraps accepted connections so CONNECT tunnels can take over their deadlines
+type clientListener struct {
+	net.Listener
+}
+
+type clientConn struct {
+	net.Conn
+	mu        sync.Mutex
+	tunneling bool
+	deadline  time.Time
+	onClose   func()
+	closeOnce sync.Once
+}
+
+type halfCloser interface {
+	CloseRead() error
+	CloseWrite() error
+}
+
+func (l *clientListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &clientConn{Conn: conn}, nil
+}
+
+func withClientConn(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, clientConnKey{}, conn)
+}
+
+func clientConnFrom(ctx context.Context) *clientConn {
+	conn, _ := ctx.Value(clientConnKey{}).(*clientConn)
+	return conn
+}
+
+// startTunnel replaces the http server deadlines with the tunnel lifetime, 0 keeps the tunnel open until either side closes it
+func (c *clientConn) startTunnel(lifetime time.Duration, onClose func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tunneling = true
+	c.onClose = onClose
+	c.deadline = time.Time{}
+	if lifetime > 0 {
+		c.deadline = time.Now().Add(lifetime)
+	}
+	c.Conn.SetDeadline(c.deadline)
+}
+
+func (c *clientConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		onClose := c.onClose
+		c.mu.Unlock()
+		if onClose != nil {
+			onClose()
+		}
+	})
+	return c.Conn.Close()
+}
+
+// CloseRead and CloseWrite let goproxy half-close direct tunnels like it does on a plain TCP connection
+func (c *clientConn) CloseRead() error {
+	if hc, ok := c.Conn.(halfCloser); ok {
+		return hc.CloseRead()
+	}
+	return errors.ErrUnsupported
+}
+
+func (c *clientConn) CloseWrite() error {
+	if hc, ok := c.Conn.(halfCloser); ok {
+		return hc.CloseWrite()
+	}
+	return errors.ErrUnsupported
+}
+
+func (c *clientConn) SetDeadline(t time.Time) error {
+	return c.Conn.SetDeadline(c.tunnelDeadline(t))
+}
+
+func (c *clientConn) SetReadDeadline(t time.Time) error {
+	return c.Conn.SetReadDeadline(c.tunnelDeadline(t))
+}
+
+func (c *clientConn) SetWriteDeadline(t time.Time) error {
+	return c.Conn.SetWriteDeadline(c.tunnelDeadline(t))
+}
+
+// past deadlines still pass, the http server uses them to interrupt a pending read when the connection is hijacked
+func (c *clientConn) tunnelDeadline(t time.Time) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.tunneling || (!t.IsZero() && t.Before(time.Now())) {
+		return t
+	}
+	return c.deadline
+}
@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientConnTunnelDeadline(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	conn := &clientConn{Conn: server}
+	soon := time.Now().Add(time.Minute)
+	assert.Equal(t, soon, conn.tunnelDeadline(soon))
+
+	conn.startTunnel(0, nil)
+	assert.True(t, conn.tunnelDeadline(soon).IsZero())
+	assert.True(t, conn.tunnelDeadline(time.Time{}).IsZero())
+
+	// past deadlines interrupt reads during the hijack and must pass through
+	past := time.Unix(1, 0)
+	assert.Equal(t, past, conn.tunnelDeadline(past))
+
+	conn.startTunnel(time.Hour, nil)
+	assert.True(t, conn.tunnelDeadline(time.Time{}).After(soon))
+}
+
+func TestTunnelTimeouts(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeouts config.ProxyTimeoutsConfig
+		wantErr  bool
+	}{
+		{"write timeout does not cut tunnels", config.ProxyTimeoutsConfig{Read: 1, Write: 1}, false},
+		{"tunnel lifetime closes tunnels", config.ProxyTimeoutsConfig{Tunnel: 1}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := NewProxyServer(&config.Config{Proxy: config.ProxyConfig{Timeouts: tt.timeouts}})
+			goProxy := newGoProxy()
+			ps.setUpListenerHandlers(goProxy, 0)
+
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			assert.NoError(t, err)
+			defer listener.Close()
+			go ps.serveListener(goProxy, listener)
+
+			conn, err := net.Dial("tcp", listener.Addr().String())
+			assert.NoError(t, err)
+			defer conn.Close()
+
+			fmt.Fprintf(conn, "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n")
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			time.Sleep(1500 * time.Millisecond)
+			tlsConn := tls.Client(conn, &tls.Config{ServerName: "example.com", InsecureSkipVerify: true})
+			tlsConn.SetDeadline(time.Now().Add(5 * time.Second))
+			err = tlsConn.Handshake()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestReadTimeoutClosesIdleClients(t *testing.T) {
+	ps := NewProxyServer(&config.Config{Proxy: config.ProxyConfig{Timeouts: config.ProxyTimeoutsConfig{Read: 1}}})
+	goProxy := newGoProxy()
+	ps.setUpListenerHandlers(goProxy, 0)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	go ps.serveListener(goProxy, listener)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	// a client that never sends its request is dropped
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	start := time.Now()
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 3*time.Second)
+}
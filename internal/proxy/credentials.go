@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync/atomic"
+)
+
+// upstreamCredentials are the username and password pairs of one upstream proxy, rotated per attempt since
+// gateway providers give every pair its own quota
+type upstreamCredentials struct {
+	sets []*credentialSet
+	next atomic.Uint64
+}
+
+type credentialSet struct {
+	user     *url.Userinfo
+	requests atomic.Uint64
+	failures atomic.Uint64
+}
+
+// CredentialUsage is what one credential set of a proxy sent since startup
+type CredentialUsage struct {
+	Username string `json:"username"`
+	Requests uint64 `json:"requests"`
+	Failures uint64 `json:"failures"`
+}
+
+type credentialKey struct{}
+
+// parseCredentials reads username:password entries, nil without any so the proxy url's own credentials apply
+func parseCredentials(entries []string) *upstreamCredentials {
+	credentials := &upstreamCredentials{}
+	for _, entry := range entries {
+		username, password, _ := strings.Cut(entry, ":")
+		if username == "" {
+			continue
+		}
+		credentials.sets = append(credentials.sets, &credentialSet{user: url.UserPassword(username, password)})
+	}
+	if len(credentials.sets) == 0 {
+		return nil
+	}
+	return credentials
+}
+
+// pick returns the next credential set in turn, nil for proxies without credential sets
+func (c *upstreamCredentials) pick() *credentialSet {
+	if c == nil {
+		return nil
+	}
+	i := c.next.Add(1) - 1
+	return c.sets[i%uint64(len(c.sets))]
+}
+
+func (s *credentialSet) observe(success bool) {
+	if s == nil {
+		return
+	}
+	s.requests.Add(1)
+	if !success {
+		s.failures.Add(1)
+	}
+}
+
+func withCredential(ctx context.Context, set *credentialSet) context.Context {
+	if set == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, credentialKey{}, set)
+}
+
+// credentialURL returns the proxy url with the credential set picked for the request, u itself when none was
+func credentialURL(ctx context.Context, u *url.URL) *url.URL {
+	set, ok := ctx.Value(credentialKey{}).(*credentialSet)
+	if !ok {
+		return u
+	}
+	withUser := *u
+	withUser.User = set.user
+	return &withUser
+}
+
+// ProxyCredentials returns the usage of each credential set of the proxy, nil when it has none
+func (ps *ProxyServer) ProxyCredentials(proxy *Proxy) []CredentialUsage {
+	if proxy.credentials == nil {
+		return nil
+	}
+	usage := make([]CredentialUsage, 0, len(proxy.credentials.sets))
+	for _, set := range proxy.credentials.sets {
+		usage = append(usage, CredentialUsage{
+			Username: set.user.Username(),
+			Requests: set.requests.Load(),
+			Failures: set.failures.Load(),
+		})
+	}
+	return usage
+}
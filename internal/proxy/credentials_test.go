@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/elazarl/goproxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCredentials(t *testing.T) {
+	assert.Nil(t, parseCredentials(nil))
+	assert.Nil(t, parseCredentials([]string{":nouser"}))
+
+	credentials := parseCredentials([]string{"a:1", "b:with:colon", ":skipped"})
+	require.Len(t, credentials.sets, 2)
+	password, _ := credentials.sets[1].user.Password()
+	assert.Equal(t, "with:colon", password)
+	assert.Equal(t, "a", credentials.pick().user.Username())
+	assert.Equal(t, "b", credentials.pick().user.Username())
+	assert.Equal(t, "a", credentials.pick().user.Username())
+}
+
+func TestUpstreamCredentials(t *testing.T) {
+	var mu sync.Mutex
+	var seen []string
+	goProxy := goproxy.NewProxyHttpServer()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Proxy-Authorization")
+		mu.Lock()
+		seen = append(seen, r.Method+" "+auth)
+		mu.Unlock()
+		if auth != "Basic "+base64.StdEncoding.EncodeToString([]byte("a:1")) {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		if r.Method == http.MethodConnect {
+			goProxy.ServeHTTP(w, r)
+		}
+	}))
+	defer upstream.Close()
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer origin.Close()
+
+	cfg := &config.Config{
+		Proxy:     config.ProxyConfig{Rotation: config.ProxyRotationConfig{Retries: 1, Timeout: 5}},
+		Upstreams: map[string]config.UpstreamConfig{upstream.URL: {Credentials: []string{"a:1", "b:2"}}},
+	}
+	ps := NewProxyServer(cfg)
+	proxy, err := NewProxyLoader(ps).CreateProxy(upstream.URL)
+	require.NoError(t, err)
+
+	for _, target := range []string{"http://example.com/", "http://example.com/", origin.URL, origin.URL} {
+		req, _ := http.NewRequest(http.MethodGet, target, nil)
+		response, err := ps.tryProxy(proxy, requestInfo{id: "test-id", request: req, attempts: &attemptLog{}})
+		if err == nil {
+			response.Body.Close()
+		}
+	}
+
+	auth := func(user string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(user))
+	}
+	assert.Equal(t, []string{
+		"GET " + auth("a:1"), "GET " + auth("b:2"), "CONNECT " + auth("a:1"), "CONNECT " + auth("b:2"),
+	}, seen, "each attempt takes the next credential set, tunnels included")
+	assert.Equal(t, []CredentialUsage{
+		{Username: "a", Requests: 2},
+		{Username: "b", Requests: 2, Failures: 2},
+	}, ps.ProxyCredentials(proxy))
+}
@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gammazero/workerpool"
+)
+
+const (
+	msgDetectingProtocols = "detecting proxy protocols"
+	msgProtocolDetected   = "proxy protocol detected"
+	msgProtocolUndetected = "no working protocol for proxy"
+)
+
+// raw ip:port lists never say which protocol they speak, so the schemes are tried in this order
+var detectSchemes = []string{"http", "socks5", "socks4"}
+
+func needsDetection(line string) bool {
+	return !strings.Contains(line, "://")
+}
+
+func (pl *ProxyLoader) detectProxies(addresses []string) []*Proxy {
+	if len(addresses) == 0 {
+		return nil
+	}
+	slog.Info(msgDetectingProtocols, "count", len(addresses))
+
+	detected := make([]*Proxy, len(addresses))
+	wp := workerpool.New(healthcheckWorkers(pl.config()))
+	for i, address := range addresses {
+		wp.Submit(func() {
+			proxy, err := pl.detectProxy(address)
+			if err != nil {
+				slog.Error(msgFailedToCreateProxy, "error", err, "proxy", address)
+				return
+			}
+			detected[i] = proxy
+		})
+	}
+	wp.StopWait()
+
+	proxies := make([]*Proxy, 0, len(detected))
+	for _, proxy := range detected {
+		if proxy != nil {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+func (pl *ProxyLoader) detectProxy(address string) (*Proxy, error) {
+	for _, scheme := range detectSchemes {
+		proxy, err := pl.CreateProxy(scheme + "://" + address)
+		if err != nil {
+			return nil, err
+		}
+
+		if pl.probe(proxy) {
+			slog.Info(msgProtocolDetected, "proxy", proxy.Host)
+			return proxy, nil
+		}
+	}
+	return nil, fmt.Errorf("%s. Address: %s", msgProtocolUndetected, address)
+}
+
+// any response means the protocol handshake worked, the status code is left to the healthcheck
+func (pl *ProxyLoader) probe(proxy *Proxy) bool {
+	client := &http.Client{
+		Transport: proxy.Transport,
+		Timeout:   time.Duration(pl.config().Healthcheck.Timeout) * time.Second,
+	}
+	defer client.CloseIdleConnections()
+
+	req, err := http.NewRequestWithContext(withCredential(context.Background(), proxy.credentials.pick()), http.MethodGet, pl.config().Healthcheck.URL, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return true
+}
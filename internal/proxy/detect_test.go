@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func serveSocks5(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go handleSocks5(conn)
+	}
+}
+
+func handleSocks5(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(br, header); err != nil || header[0] != 5 {
+		return
+	}
+	if _, err := io.ReadFull(br, make([]byte, header[1])); err != nil {
+		return
+	}
+	conn.Write([]byte{5, 0})
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(br, request); err != nil {
+		return
+	}
+	var addrLen int
+	switch request[3] {
+	case 1:
+		addrLen = 4
+	case 4:
+		addrLen = 16
+	case 3:
+		n, _ := br.ReadByte()
+		addrLen = int(n)
+	}
+	if _, err := io.ReadFull(br, make([]byte, addrLen+2)); err != nil {
+		return
+	}
+	reply := []byte{5, 0, 0, 1, 0, 0, 0, 0}
+	conn.Write(binary.BigEndian.AppendUint16(reply, 0))
+
+	if _, err := http.ReadRequest(br); err != nil {
+		return
+	}
+	fmt.Fprint(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+}
+
+func TestDetectProxies(t *testing.T) {
+	httpProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer httpProxy.Close()
+
+	socksProxy, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer socksProxy.Close()
+	go serveSocks5(socksProxy)
+
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	deadAddr := dead.Addr().String()
+	dead.Close()
+
+	cfg := &config.Config{
+		DetectProtocol: true,
+		Healthcheck: config.HealthcheckConfig{
+			URL:     "http://example.com/",
+			Timeout: 2,
+			Workers: 2,
+		},
+	}
+	pl := NewProxyLoader(NewProxyServer(cfg))
+
+	httpAddr := strings.TrimPrefix(httpProxy.URL, "http://")
+	proxies := pl.detectProxies([]string{httpAddr, deadAddr, socksProxy.Addr().String()})
+
+	assert.Len(t, proxies, 2)
+	assert.Equal(t, "http", proxies[0].Scheme)
+	assert.Equal(t, "http://"+httpAddr, proxies[0].Host)
+	assert.Equal(t, "socks5", proxies[1].Scheme)
+}
+
+func TestNeedsDetection(t *testing.T) {
+	assert.True(t, needsDetection("192.111.137.37:9911"))
+	assert.False(t, needsDetection("socks5://192.111.137.37:18762"))
+}
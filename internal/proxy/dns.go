@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+)
+
+const (
+	defaultDNSTimeout = 5
+	defaultDNSPort    = "53"
+
+	dnsResolveLocal = "local"
+
+	msgNoAddress = "no address found"
+)
+
+// newResolver queries the configured servers in turn instead of the host resolver, nil keeps the host resolver
+func newResolver(cfg config.DNSConfig) *net.Resolver {
+	servers := make([]string, 0, len(cfg.Servers))
+	for _, server := range cfg.Servers {
+		server = strings.TrimSpace(server)
+		if server == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(strings.Trim(server, "[]"), defaultDNSPort)
+		}
+		servers = append(servers, server)
+	}
+	if len(servers) == 0 {
+		return nil
+	}
+
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultDNSTimeout
+	}
+	dialer := &net.Dialer{Timeout: time.Duration(timeout) * time.Second}
+	var next atomic.Uint64
+	return &net.Resolver{
+		PreferGo: true,
+		// every query attempt goes to the next server, so a retry reaches another one when a server is down
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			server := servers[(next.Add(1)-1)%uint64(len(servers))]
+			return dialer.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// resolvesLocally is set when proxies should be sent addresses instead of the target's host name
+func resolvesLocally(cfg config.DNSConfig) bool {
+	return strings.EqualFold(cfg.Resolve, dnsResolveLocal)
+}
+
+// netDialer dials upstream proxies with the configured resolver
+func (ps *ProxyServer) netDialer(timeout time.Duration) *net.Dialer {
+	return &net.Dialer{Timeout: timeout, Resolver: ps.resolver}
+}
+
+// resolveAddr replaces the host of addr with one of its addresses, ipv4 only for hops that can not take others
+func resolveAddr(ctx context.Context, resolver *net.Resolver, addr string, ipv4 bool) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if net.ParseIP(host) != nil {
+		return addr, nil
+	}
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	network := "ip"
+	if ipv4 {
+		network = "ip4"
+	}
+	ips, err := resolver.LookupIP(ctx, network, host)
+	if err != nil {
+		return "", err
+	}
+	if len(ips) == 0 {
+		return "", fmt.Errorf("%s: %s", msgNoAddress, host)
+	}
+	return net.JoinHostPort(ips[0].String(), port), nil
+}
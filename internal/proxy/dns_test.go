@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newDNSServer answers A queries for every name with 127.0.0.1 and counts the queries
+func newDNSServer(t *testing.T, queries *atomic.Int64) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n < 12 {
+				continue
+			}
+			queries.Add(1)
+			end := 12
+			for end < n && buf[end] != 0 {
+				end += int(buf[end]) + 1
+			}
+			end += 5
+			if end > n {
+				continue
+			}
+			qtype := binary.BigEndian.Uint16(buf[end-4 : end-2])
+
+			reply := append([]byte{}, buf[:2]...)
+			reply = append(reply, 0x81, 0x80, 0, 1)
+			if qtype == 1 {
+				reply = append(reply, 0, 1)
+			} else {
+				reply = append(reply, 0, 0)
+			}
+			reply = append(reply, 0, 0, 0, 0)
+			reply = append(reply, buf[12:end]...)
+			if qtype == 1 {
+				reply = append(reply, 0xc0, 0x0c, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 127, 0, 0, 1)
+			}
+			_, _ = conn.WriteTo(reply, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestNewResolver(t *testing.T) {
+	assert.Nil(t, newResolver(config.DNSConfig{}))
+	assert.Nil(t, newResolver(config.DNSConfig{Servers: []string{" "}}))
+
+	var queries atomic.Int64
+	server := newDNSServer(t, &queries)
+	resolver := newResolver(config.DNSConfig{Servers: []string{server}})
+	require.NotNil(t, resolver)
+
+	ips, err := resolver.LookupIP(context.Background(), "ip4", "internal.example")
+	require.NoError(t, err)
+	require.Len(t, ips, 1)
+	assert.Equal(t, "127.0.0.1", ips[0].String())
+	assert.Positive(t, queries.Load())
+
+	addr, err := resolveAddr(context.Background(), resolver, "internal.example:8080", true)
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:8080", addr)
+	addr, err = resolveAddr(context.Background(), resolver, "[::1]:8080", true)
+	assert.NoError(t, err)
+	assert.Equal(t, "[::1]:8080", addr)
+}
+
+func TestDNSResolve(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer target.Close()
+	_, port, _ := net.SplitHostPort(target.Listener.Addr().String())
+	targetURL := "http://internal.example:" + port
+
+	var queries, tunnels atomic.Int64
+	server := newDNSServer(t, &queries)
+	socks := newSocksServer(t, "", "", &tunnels)
+
+	get := func(t *testing.T, cfg *config.Config) {
+		t.Helper()
+		ps := NewProxyServer(cfg)
+		if len(cfg.Routing) == 0 {
+			p, err := NewProxyLoader(ps).CreateProxy("socks5://" + socks)
+			require.NoError(t, err)
+			ps.Proxies = []*Proxy{p}
+		}
+		goProxy := newGoProxy()
+		ps.setUpListenerHandlers(goProxy, 0)
+		proxyServer := httptest.NewServer(goProxy)
+		defer proxyServer.Close()
+		proxyURL, _ := url.Parse(proxyServer.URL)
+
+		resp, err := (&http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}).Get(targetURL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "hello", strings.TrimSpace(string(body)))
+	}
+	rotation := config.ProxyRotationConfig{Method: "roundrobin", Retries: 1, Timeout: 5, FallbackMaxRetries: 1}
+
+	t.Run("direct", func(t *testing.T) {
+		queries.Store(0)
+		get(t, &config.Config{
+			Proxy:   config.ProxyConfig{Rotation: rotation},
+			Routing: []config.RoutingRuleConfig{{Hosts: []string{"internal.example"}, Direct: true}},
+			DNS:     config.DNSConfig{Servers: []string{server}},
+		})
+		assert.Positive(t, queries.Load())
+	})
+
+	t.Run("socks5 resolved locally", func(t *testing.T) {
+		queries.Store(0)
+		tunnels.Store(0)
+		// the test socks server can not resolve internal.example, so it only works when rota sends the address
+		get(t, &config.Config{
+			Proxy: config.ProxyConfig{Rotation: rotation},
+			DNS:   config.DNSConfig{Servers: []string{server}, Resolve: "local"},
+		})
+		assert.Positive(t, queries.Load())
+		assert.Equal(t, int64(1), tunnels.Load())
+	})
+}
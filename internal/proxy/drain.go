@@ -0,0 +1,198 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ProxyDraining = "draining"
+	DrainRemoved  = "removed"
+
+	defaultDrainTimeout = 30
+	drainPollInterval   = 100 * time.Millisecond
+
+	msgProxyNotFound      = "proxy not found"
+	msgProxyDraining      = "proxy is already draining"
+	msgProxyDrained       = "proxy was already drained and removed"
+	msgDrainStarted       = "draining proxy"
+	msgProxyRemoved       = "proxy drained and removed"
+	msgFailedToDrainProxy = "failed to remove proxy from its file"
+)
+
+var (
+	ErrProxyNotFound = errors.New(msgProxyNotFound)
+	ErrProxyDraining = errors.New(msgProxyDraining)
+	ErrProxyDrained  = errors.New(msgProxyDrained)
+)
+
+// DrainStatus follows one proxy from the drain request to its removal
+type DrainStatus struct {
+	Proxy      string     `json:"proxy"`
+	State      string     `json:"state"`
+	InFlight   int64      `json:"in_flight"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	TimedOut   bool       `json:"timed_out"`
+}
+
+type drainTable struct {
+	mu     sync.Mutex
+	drains map[string]*drain
+}
+
+type drain struct {
+	proxy  *Proxy
+	status DrainStatus
+}
+
+// Drain stops rotating the proxy and removes it once its in-flight requests are done, or when timeout passes.
+// A proxy read from a proxy file is taken out of the file right away so reloads do not bring it back, proxies of
+// sources and chains return with the next fetch or reload
+func (pl *ProxyLoader) Drain(host string, timeout time.Duration) (DrainStatus, error) {
+	ps := pl.proxyServer
+	host = normalizeAddress(host)
+	ps.mu.RLock()
+	i := slices.IndexFunc(ps.Proxies, func(p *Proxy) bool { return p.Host == host })
+	var proxy *Proxy
+	if i >= 0 {
+		proxy = ps.Proxies[i]
+	}
+	ps.mu.RUnlock()
+	// a repeated drain request finds the proxy gone once it is idle, that is no reason to report it unknown
+	if proxy == nil && ps.drains.removed(host) {
+		return DrainStatus{}, fmt.Errorf("%w: %s", ErrProxyDrained, host)
+	}
+	if proxy == nil {
+		return DrainStatus{}, fmt.Errorf("%w: %s", ErrProxyNotFound, host)
+	}
+	if !proxy.draining.CompareAndSwap(false, true) {
+		return DrainStatus{}, fmt.Errorf("%w: %s", ErrProxyDraining, host)
+	}
+
+	if proxy.Source == "" && proxy.Scheme != chainScheme {
+		ps.imports.Lock()
+		err := removeLines(proxy.Pool, proxy.Host)
+		ps.imports.Unlock()
+		if err != nil {
+			proxy.draining.Store(false)
+			return DrainStatus{}, fmt.Errorf("%s: %w", msgFailedToDrainProxy, err)
+		}
+	}
+
+	if timeout <= 0 {
+		timeout = defaultDrainTimeout * time.Second
+	}
+	d := &drain{proxy: proxy, status: DrainStatus{Proxy: host, State: ProxyDraining, StartedAt: time.Now()}}
+	ps.drains.add(d)
+	slog.Info(msgDrainStarted, "proxy", host, "in_flight", proxy.inFlight.Load(), "timeout", timeout.String())
+	go ps.finishDrain(d, timeout)
+	return ps.drains.status(d), nil
+}
+
+func (ps *ProxyServer) finishDrain(d *drain, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for d.proxy.inFlight.Load() > 0 && time.Now().Before(deadline) {
+		time.Sleep(drainPollInterval)
+	}
+
+	ps.mu.Lock()
+	ps.Proxies = slices.DeleteFunc(ps.Proxies, func(p *Proxy) bool { return p == d.proxy })
+	ps.poolChanged()
+	ps.mu.Unlock()
+	ps.pruneTransports()
+	ps.checkPoolThreshold()
+
+	inFlight := d.proxy.inFlight.Load()
+	ps.drains.finish(d, inFlight > 0)
+	slog.Info(msgProxyRemoved, "proxy", d.status.Proxy, "timed_out", inFlight > 0, "in_flight", inFlight)
+}
+
+// Drains lists the drains since the start, the ones still draining first
+func (ps *ProxyServer) Drains() []DrainStatus {
+	return ps.drains.all()
+}
+
+func (t *drainTable) add(d *drain) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.drains == nil {
+		t.drains = make(map[string]*drain)
+	}
+	t.drains[d.status.Proxy] = d
+}
+
+func (t *drainTable) removed(host string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d, ok := t.drains[host]
+	return ok && d.status.State == DrainRemoved
+}
+
+func (t *drainTable) finish(d *drain, timedOut bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	d.status.State = DrainRemoved
+	d.status.FinishedAt = &now
+	d.status.TimedOut = timedOut
+	d.status.InFlight = d.proxy.inFlight.Load()
+}
+
+func (t *drainTable) status(d *drain) DrainStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status := d.status
+	if status.State == ProxyDraining {
+		status.InFlight = d.proxy.inFlight.Load()
+	}
+	return status
+}
+
+func (t *drainTable) all() []DrainStatus {
+	t.mu.Lock()
+	drains := make([]*drain, 0, len(t.drains))
+	for _, d := range t.drains {
+		drains = append(drains, d)
+	}
+	t.mu.Unlock()
+
+	statuses := make([]DrainStatus, 0, len(drains))
+	for _, d := range drains {
+		statuses = append(statuses, t.status(d))
+	}
+	slices.SortFunc(statuses, func(a, b DrainStatus) int {
+		if a.State != b.State {
+			return strings.Compare(a.State, b.State)
+		}
+		return b.StartedAt.Compare(a.StartedAt)
+	})
+	return statuses
+}
+
+// removeLines drops the lines of a proxy file that list host, a line without a scheme matches any scheme since
+// detection picked it
+func removeLines(file, host string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	_, bare, _ := strings.Cut(host, "://")
+
+	lines := strings.SplitAfter(string(data), "\n")
+	kept := make([]string, 0, len(lines))
+	for _, line := range lines {
+		address, _ := parseProxyLine(line)
+		if address != "" && (address == host || (needsDetection(address) && address == bare)) {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return os.WriteFile(file, []byte(strings.Join(kept, "")), 0o644)
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDrain(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "proxies.txt")
+	require.NoError(t, os.WriteFile(file, []byte("http://10.0.0.1:8080 residential\n# spare\nhttp://10.0.0.2:8080\n10.0.0.3:8080\n"), 0o644))
+	cfg := &config.Config{ProxyFile: file, DetectProtocol: false}
+	ps := NewProxyServer(cfg)
+	pl := NewProxyLoader(ps)
+	require.NoError(t, pl.Load())
+	require.Equal(t, 2, ps.ProxyCount())
+
+	draining := ps.GetProxies()[0]
+	require.True(t, draining.acquire())
+	status, err := pl.Drain("http://10.0.0.1:8080", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, ProxyDraining, status.State)
+	assert.Equal(t, int64(1), status.InFlight)
+	state, _ := ps.ProxyState(draining)
+	assert.Equal(t, ProxyDraining, state)
+
+	// the proxy is out of the rotation and its file while its request finishes
+	filter := proxyFilter{pool: file}
+	for range 4 {
+		assert.Equal(t, "http://10.0.0.2:8080", ps.getProxy("roundrobin", filter).Host)
+	}
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "# spare\nhttp://10.0.0.2:8080\n10.0.0.3:8080\n", string(data))
+	assert.Equal(t, 2, ps.ProxyCount())
+
+	_, err = pl.Drain("http://10.0.0.1:8080", time.Minute)
+	assert.ErrorIs(t, err, ErrProxyDraining)
+	_, err = pl.Drain("http://10.0.0.9:8080", time.Minute)
+	assert.ErrorIs(t, err, ErrProxyNotFound)
+
+	draining.release()
+	assert.Eventually(t, func() bool { return ps.ProxyCount() == 1 }, time.Second, 10*time.Millisecond)
+	drains := ps.Drains()
+	require.Len(t, drains, 1)
+	assert.Equal(t, DrainRemoved, drains[0].State)
+	assert.False(t, drains[0].TimedOut)
+	assert.NotNil(t, drains[0].FinishedAt)
+	_, err = pl.Drain("http://10.0.0.1:8080", time.Minute)
+	assert.ErrorIs(t, err, ErrProxyDrained)
+}
+
+func TestDrainTimeout(t *testing.T) {
+	cfg := &config.Config{}
+	ps := NewProxyServer(cfg)
+	pl := NewProxyLoader(ps)
+	proxy, err := pl.CreateProxy("http://10.0.0.1:8080")
+	require.NoError(t, err)
+	proxy.Source = "https://example.com/proxies.txt"
+	ps.Proxies = append(ps.Proxies, proxy)
+
+	require.True(t, proxy.acquire())
+	_, err = pl.Drain(proxy.Host, 50*time.Millisecond)
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return ps.ProxyCount() == 0 }, time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool { return ps.Drains()[0].State == DrainRemoved }, time.Second, 10*time.Millisecond)
+	drains := ps.Drains()
+	assert.True(t, drains[0].TimedOut)
+	assert.Equal(t, int64(1), drains[0].InFlight)
+	proxy.release()
+}
+
+func TestRemoveLines(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "proxies.txt")
+	require.NoError(t, os.WriteFile(file, []byte("10.0.0.1:8080 fast\r\nsocks5://[2001:0db8::1]:1080\nhttp://10.0.0.2:8080"), 0o644))
+
+	require.NoError(t, removeLines(file, "http://10.0.0.1:8080"))
+	require.NoError(t, removeLines(file, "socks5://[2001:db8::1]:1080"))
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "http://10.0.0.2:8080", string(data))
+}
@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/elazarl/goproxy"
+)
+
+const (
+	// DryRunHeader set to true on a request makes rota answer with the proxy it would use instead of sending it
+	DryRunHeader = "X-Rota-Dry-Run"
+
+	msgDryRun = "dry run, request not sent"
+
+	routeDirect = "direct"
+	routeProxy  = "proxy"
+)
+
+// dryRunResult describes how a request would have been sent
+type dryRunResult struct {
+	RequestID  string       `json:"request_id"`
+	Method     string       `json:"method"`
+	URL        string       `json:"url"`
+	Credential string       `json:"credential,omitempty"`
+	Tenant     string       `json:"tenant,omitempty"`
+	Route      string       `json:"route"`
+	Rotation   string       `json:"rotation,omitempty"`
+	Session    bool         `json:"session"`
+	Proxy      *dryRunProxy `json:"proxy,omitempty"`
+	Error      string       `json:"error,omitempty"`
+}
+
+type dryRunProxy struct {
+	Scheme string   `json:"scheme"`
+	Host   string   `json:"host"`
+	Pool   string   `json:"pool"`
+	Tags   []string `json:"tags"`
+	GeoLocation
+}
+
+// isDryRun reports whether the request only asks for the proxy it would use, with proxy.dry_run every request does.
+// The header is removed either way so it never reaches the upstream
+func (ps *ProxyServer) isDryRun(r *http.Request) bool {
+	value := r.Header.Get(DryRunHeader)
+	r.Header.Del(DryRunHeader)
+	if ps.Config().Proxy.DryRun {
+		return true
+	}
+	dryRun, _ := strconv.ParseBool(value)
+	return dryRun
+}
+
+// dryRunResponse selects a proxy the way tryProxies does for the first attempt and describes it. The selection is
+// real, rotation advances and sessions are matched as if the request had been sent, so a series of dry runs shows
+// the order requests would take
+func (ps *ProxyServer) dryRunResponse(reqInfo requestInfo) *http.Response {
+	listener := ps.listenerFor(reqInfo)
+	result := dryRunResult{
+		RequestID:  reqInfo.id,
+		Method:     reqInfo.request.Method,
+		URL:        reqInfo.url,
+		Credential: reqInfo.directives.Username,
+		Tenant:     listener.tenant,
+		Route:      routeProxy,
+	}
+	statusCode := http.StatusOK
+
+	route := ps.route(reqInfo.request.URL.Host, listener)
+	if route.direct {
+		result.Route = routeDirect
+	} else {
+		result.Rotation = listener.rotation.Method
+		route.filter.country = reqInfo.directives.Country
+		proxy := ps.sessionProxy(reqInfo, route.filter)
+		result.Session = proxy != nil
+		if proxy == nil {
+			proxy = ps.selectProxy(listener.rotation, route.filter, newPickKey(reqInfo.request.URL.Hostname(), 0))
+		}
+		if proxy != nil {
+			result.Proxy = &dryRunProxy{
+				Scheme:      proxy.Scheme,
+				Host:        proxy.Host,
+				Pool:        proxy.Pool,
+				Tags:        ps.ProxyTags(proxy),
+				GeoLocation: ps.ProxyLocation(proxy),
+			}
+		} else {
+			result.Error = msgNoProxyFound
+			statusCode = http.StatusBadGateway
+		}
+	}
+
+	proxyHost := ""
+	if result.Proxy != nil {
+		proxyHost = result.Proxy.Host
+	}
+	slog.Info(msgDryRun, "request_id", reqInfo.id, "url", reqInfo.url, "route", result.Route, "proxy", proxyHost)
+
+	// the result only holds strings, numbers and slices of strings, it always encodes
+	body, _ := json.Marshal(result)
+	response := goproxy.NewResponse(reqInfo.request, "application/json", statusCode, string(body))
+	response.ProtoMajor, response.ProtoMinor = 1, 1
+	response.Header.Set(DryRunHeader, "true")
+	return response
+}
@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/elazarl/goproxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDryRun(t *testing.T) {
+	var hits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{
+		Proxy: config.ProxyConfig{
+			Rotation: config.ProxyRotationConfig{Method: "roundrobin", Retries: 1, FallbackMaxRetries: 1},
+		},
+		Routing: []config.RoutingRuleConfig{{Hosts: []string{"*.internal"}, Direct: true}},
+	}
+	ps := NewProxyServer(cfg)
+	pl := NewProxyLoader(ps)
+	for _, u := range []string{upstream.URL, "http://10.0.0.2:8080"} {
+		proxy, err := pl.CreateProxy(u)
+		require.NoError(t, err)
+		ps.AddProxy(proxy)
+	}
+
+	dryRun := func(target, header string) (*http.Response, dryRunResult) {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if header != "" {
+			req.Header.Set(DryRunHeader, header)
+		}
+		_, resp := ps.handleRequest(req, &goproxy.ProxyCtx{Req: req}, ps.resolveListener(0))
+		var result dryRunResult
+		if resp.Header.Get(DryRunHeader) != "" {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		}
+		assert.Empty(t, req.Header.Get(DryRunHeader), "the header is not forwarded")
+		return resp, result
+	}
+
+	resp, first := dryRun("http://example.com/a", "true")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, routeProxy, first.Route)
+	assert.Equal(t, "roundrobin", first.Rotation)
+	require.NotNil(t, first.Proxy)
+	_, second := dryRun("http://example.com/b", "1")
+	require.NotNil(t, second.Proxy)
+	assert.NotEqual(t, first.Proxy.Host, second.Proxy.Host, "dry runs advance the rotation")
+
+	_, direct := dryRun("http://db.internal/", "true")
+	assert.Equal(t, routeDirect, direct.Route)
+	assert.Nil(t, direct.Proxy)
+	assert.Zero(t, hits.Load())
+
+	// without the header, or with it off, requests are sent
+	dryRun("http://example.com/", "false")
+	assert.Equal(t, int32(1), hits.Load())
+	cfg.Proxy.DryRun = true
+	_, global := dryRun("http://example.com/", "")
+	assert.NotNil(t, global.Proxy)
+
+	ps.SetProxies(nil)
+	resp, none := dryRun("http://example.com/", "")
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.Equal(t, msgNoProxyFound, none.Error)
+}
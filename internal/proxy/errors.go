@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+)
+
+const (
+	ErrorClassTimeout           = "timeout"
+	ErrorClassConnectionRefused = "connection_refused"
+	ErrorClassConnectionReset   = "connection_reset"
+	ErrorClassDNS               = "dns"
+	ErrorClassTLS               = "tls"
+	ErrorClassProxy             = "proxy_error"
+	ErrorClassProxyAuth         = "proxy_auth"
+	ErrorClassForbidden         = "http_403"
+	ErrorClassRateLimited       = "http_429"
+	ErrorClassClient            = "http_4xx"
+	ErrorClassServer            = "http_5xx"
+	// the classes of 502 answers where no attempt failed: no proxy could take the request, or the response was too large
+	ErrorClassNoProxy  = "no_proxy"
+	ErrorClassTooLarge = "response_too_large"
+)
+
+// ErrorClass names why an attempt failed, transport errors by their cause and answered attempts by their status
+// code. Attempts that got a 1xx to 3xx answer have no class
+func ErrorClass(err error, statusCode int) string {
+	if err != nil {
+		if class := typedErrorClass(err); class != "" {
+			return class
+		}
+		return messageErrorClass(err.Error())
+	}
+	switch {
+	case statusCode == http.StatusProxyAuthRequired:
+		return ErrorClassProxyAuth
+	case statusCode == http.StatusForbidden:
+		return ErrorClassForbidden
+	case statusCode == http.StatusTooManyRequests:
+		return ErrorClassRateLimited
+	case statusCode >= 500:
+		return ErrorClassServer
+	case statusCode >= 400:
+		return ErrorClassClient
+	}
+	return ""
+}
+
+func typedErrorClass(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var recordErr tls.RecordHeaderError
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidCert x509.CertificateInvalidError
+	switch {
+	// a lookup that timed out is still a dns failure
+	case errors.As(err, &dnsErr):
+		return ErrorClassDNS
+	// the rotation timeout cancels the attempt's context instead of setting a deadline
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) || errors.Is(err, syscall.ETIMEDOUT):
+		return ErrorClassTimeout
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorClassTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrorClassConnectionRefused
+	case errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		return ErrorClassConnectionReset
+	case errors.As(err, &recordErr) || errors.As(err, &certErr) || errors.As(err, &unknownAuthority) ||
+		errors.As(err, &hostnameErr) || errors.As(err, &invalidCert):
+		return ErrorClassTLS
+	}
+	return ""
+}
+
+// messageErrorClass classifies errors that lost their type on the way, socks dialers and the transport's
+// CONNECT handling wrap some of them as plain strings
+func messageErrorClass(message string) string {
+	message = strings.ToLower(message)
+	switch {
+	case strings.Contains(message, "timeout") || strings.Contains(message, "deadline exceeded"):
+		return ErrorClassTimeout
+	case strings.Contains(message, "connection refused"):
+		return ErrorClassConnectionRefused
+	case strings.Contains(message, "connection reset") || strings.Contains(message, "broken pipe") || strings.HasSuffix(message, "eof"):
+		return ErrorClassConnectionReset
+	case strings.Contains(message, "no such host"):
+		return ErrorClassDNS
+	case strings.Contains(message, "proxy authentication required"):
+		return ErrorClassProxyAuth
+	case strings.Contains(message, "tls") || strings.Contains(message, "x509") || strings.Contains(message, "certificate"):
+		return ErrorClassTLS
+	}
+	return ErrorClassProxy
+}
@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		err        error
+		statusCode int
+		want       string
+	}{
+		{nil, http.StatusOK, ""},
+		{nil, http.StatusFound, ""},
+		{nil, http.StatusNotFound, ErrorClassClient},
+		{nil, http.StatusForbidden, ErrorClassForbidden},
+		{nil, http.StatusTooManyRequests, ErrorClassRateLimited},
+		{nil, http.StatusProxyAuthRequired, ErrorClassProxyAuth},
+		{nil, http.StatusBadGateway, ErrorClassServer},
+
+		{fmt.Errorf("attempt: %w", context.DeadlineExceeded), 0, ErrorClassTimeout},
+		{context.Canceled, 0, ErrorClassTimeout},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, 0, ErrorClassConnectionRefused},
+		{&net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, 0, ErrorClassConnectionReset},
+		{io.ErrUnexpectedEOF, 0, ErrorClassConnectionReset},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "proxy.invalid", IsTimeout: true}}, 0, ErrorClassDNS},
+		{x509.UnknownAuthorityError{}, 0, ErrorClassTLS},
+
+		// errors that only kept their message
+		{errors.New("context deadline exceeded (Client.Timeout exceeded while awaiting headers)"), 0, ErrorClassTimeout},
+		{errors.New("dial tcp 127.0.0.1:1: connect: connection refused"), 0, ErrorClassConnectionRefused},
+		{errors.New("read tcp 127.0.0.1:80: read: connection reset by peer"), 0, ErrorClassConnectionReset},
+		{errors.New("dial tcp: lookup proxy.invalid: no such host"), 0, ErrorClassDNS},
+		{errors.New("Proxy Authentication Required"), 0, ErrorClassProxyAuth},
+		{errors.New("tls: failed to verify certificate: x509: certificate signed by unknown authority"), 0, ErrorClassTLS},
+		{errors.New("socks connect failed"), 0, ErrorClassProxy},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, ErrorClass(tt.err, tt.statusCode), fmt.Sprint(tt.err, tt.statusCode))
+	}
+}
@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+const (
+	msgUnsupportedFingerprint = "unsupported tls fingerprint"
+)
+
+// fingerprints are the browsers whose ClientHello origin handshakes can copy
+var fingerprints = map[string]utls.ClientHelloID{
+	"chrome":  utls.HelloChrome_Auto,
+	"firefox": utls.HelloFirefox_Auto,
+	"safari":  utls.HelloSafari_Auto,
+	"edge":    utls.HelloEdge_Auto,
+	"ios":     utls.HelloIOS_Auto,
+}
+
+// tlsFingerprint returns the ClientHello of the configured browser, false when Go's own handshake is used
+func tlsFingerprint(name string) (utls.ClientHelloID, bool, error) {
+	if name == "" {
+		return utls.ClientHelloID{}, false, nil
+	}
+	id, ok := fingerprints[name]
+	if !ok {
+		return utls.ClientHelloID{}, false, fmt.Errorf("%s: %s", msgUnsupportedFingerprint, name)
+	}
+	return id, true, nil
+}
+
+// fingerprintTLS makes tr shake hands with https origins like the configured browser. Requests through http
+// proxies open their tunnel themselves, http.Transport only lets non-proxied connections use a custom handshake
+func (ps *ProxyServer) fingerprintTLS(tr *http.Transport) {
+	id, ok, err := tlsFingerprint(ps.Config().Proxy.TLSFingerprint)
+	if err != nil || !ok {
+		return
+	}
+
+	dial := tr.DialContext
+	if proxyFunc := tr.Proxy; proxyFunc != nil {
+		headers := tr.ProxyConnectHeader
+		timeout := time.Duration(ps.Config().Proxy.Rotation.Timeout) * time.Second
+		tr.Proxy = func(r *http.Request) (*url.URL, error) {
+			if r.URL.Scheme == "https" {
+				return nil, nil
+			}
+			return proxyFunc(r)
+		}
+		dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			proxyURL, err := proxyFunc((&http.Request{URL: &url.URL{Scheme: "https", Host: addr}}).WithContext(ctx))
+			if err != nil {
+				return nil, err
+			}
+			return tunnelDial(ctx, tr.DialContext, proxyURL, headers, addr, timeout)
+		}
+	}
+
+	tr.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		tlsConn := utls.UClient(conn, &utls.Config{ServerName: host, InsecureSkipVerify: true}, utls.HelloCustom)
+		if err := applyFingerprint(tlsConn, id); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+}
+
+// applyFingerprint copies the browser's ClientHello but only offers http/1.1, http.Transport can not run http/2
+// over a connection it did not set up with crypto/tls
+func applyFingerprint(conn *utls.UConn, id utls.ClientHelloID) error {
+	spec, err := utls.UTLSIdToSpec(id)
+	if err != nil {
+		return err
+	}
+	for _, extension := range spec.Extensions {
+		if alpn, ok := extension.(*utls.ALPNExtension); ok {
+			alpn.AlpnProtocols = []string{"http/1.1"}
+		}
+	}
+	return conn.ApplyPreset(&spec)
+}
+
+// tunnelDial connects to addr through an http or https proxy with CONNECT
+func tunnelDial(ctx context.Context, dial func(context.Context, string, string) (net.Conn, error), proxyURL *url.URL, headers http.Header, addr string, timeout time.Duration) (net.Conn, error) {
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	conn, err := dial(ctx, "tcp", hopAddr(proxyURL))
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL.Scheme == "https" {
+		conn = tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname(), InsecureSkipVerify: true})
+	}
+
+	deadline, ok := ctx.Deadline()
+	if timeout > 0 && (!ok || time.Until(deadline) > timeout) {
+		deadline, ok = time.Now().Add(timeout), true
+	}
+	if ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	tunnel, err := httpConnect(conn, proxyURL, headers, addr)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	_ = tunnel.SetDeadline(time.Time{})
+	return tunnel, nil
+}
@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/elazarl/goproxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFingerprintTLS(t *testing.T) {
+	var mu sync.Mutex
+	var hellos []*tls.ClientHelloInfo
+	origin := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	origin.TLS = &tls.Config{GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		hellos = append(hellos, hello)
+		return nil, nil
+	}}
+	origin.StartTLS()
+	defer origin.Close()
+
+	var connects atomic.Int32
+	goProxy := goproxy.NewProxyHttpServer()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodConnect {
+			connects.Add(1)
+		}
+		goProxy.ServeHTTP(w, r)
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{Proxy: config.ProxyConfig{TLSFingerprint: "chrome", Rotation: config.ProxyRotationConfig{Timeout: 5}}}
+	ps := NewProxyServer(cfg)
+	proxy, err := NewProxyLoader(ps).CreateProxy(upstream.URL)
+	require.NoError(t, err)
+
+	for _, tr := range []*http.Transport{ps.directProxy.Transport, proxy.Transport} {
+		response, err := (&http.Client{Transport: tr}).Get(origin.URL)
+		require.NoError(t, err)
+		response.Body.Close()
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+	}
+	assert.Equal(t, int32(1), connects.Load(), "https requests open their own tunnel through the proxy")
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, hellos, 2)
+	for _, hello := range hellos {
+		assert.Equal(t, []string{"http/1.1"}, hello.SupportedProtos)
+		// chrome sends grease cipher suites, go never does
+		assert.True(t, slices.ContainsFunc(hello.CipherSuites, func(suite uint16) bool { return suite&0x0f0f == 0x0a0a }))
+	}
+}
+
+func TestTLSFingerprintUnsupported(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "proxies.txt")
+	require.NoError(t, os.WriteFile(file, []byte("http://10.0.0.1:8080\n"), 0o644))
+	cfg := &config.Config{ProxyFile: file, Proxy: config.ProxyConfig{TLSFingerprint: "netscape"}}
+	err := NewProxyLoader(NewProxyServer(cfg)).Load()
+	assert.ErrorContains(t, err, msgUnsupportedFingerprint)
+}
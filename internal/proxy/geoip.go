@@ -0,0 +1,152 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/alpkeskin/rota/pkg/mmdb"
+)
+
+const (
+	msgFailedToOpenGeoIP   = "failed to open geoip database"
+	msgFailedToLocateProxy = "failed to locate proxy"
+	msgGeoIPLoaded         = "geoip database loaded"
+
+	geoResolveTimeout = 5 * time.Second
+)
+
+type GeoLocation struct {
+	Country      string `json:"country"`
+	City         string `json:"city"`
+	ASN          uint   `json:"asn"`
+	Organization string `json:"organization"`
+}
+
+type geoDatabases struct {
+	location *mmdb.Reader
+	asn      *mmdb.Reader
+}
+
+// LoadGeoIP opens the databases in geoip, proxies created afterwards are located
+func (ps *ProxyServer) LoadGeoIP() error {
+	cfg := ps.Config().GeoIP
+	if cfg.Database == "" && cfg.ASNDatabase == "" {
+		return nil
+	}
+
+	databases := &geoDatabases{}
+	for _, db := range []struct {
+		path   string
+		reader **mmdb.Reader
+	}{
+		{cfg.Database, &databases.location},
+		{cfg.ASNDatabase, &databases.asn},
+	} {
+		if db.path == "" {
+			continue
+		}
+		reader, err := mmdb.Open(db.path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", msgFailedToOpenGeoIP, err)
+		}
+		slog.Info(msgGeoIPLoaded, "path", db.path, "type", reader.Metadata.DatabaseType)
+		*db.reader = reader
+	}
+	ps.geoip = databases
+	return nil
+}
+
+// locate looks up the proxy's address, hostnames are resolved first
+func (ps *ProxyServer) locate(proxy *Proxy) {
+	if ps.geoip == nil || proxy.Url == nil {
+		return
+	}
+
+	ip, err := resolveProxyIP(proxy.Url.Hostname())
+	if err != nil {
+		slog.Warn(msgFailedToLocateProxy, "error", err, "proxy", proxy.Host)
+		return
+	}
+
+	location := &GeoLocation{}
+	if ps.geoip.location != nil {
+		record, err := ps.geoip.location.Lookup(ip)
+		if err != nil {
+			slog.Warn(msgFailedToLocateProxy, "error", err, "proxy", proxy.Host)
+			return
+		}
+		location.Country = strings.ToUpper(lookupString(record, "country", "iso_code"))
+		location.City = lookupString(record, "city", "names", "en")
+	}
+	if ps.geoip.asn != nil {
+		record, err := ps.geoip.asn.Lookup(ip)
+		if err != nil {
+			slog.Warn(msgFailedToLocateProxy, "error", err, "proxy", proxy.Host)
+			return
+		}
+		number, _ := lookup(record, "autonomous_system_number").(uint64)
+		location.ASN = uint(number)
+		location.Organization = lookupString(record, "autonomous_system_organization")
+	}
+	proxy.geo.Store(location)
+}
+
+// ProxyLocation returns where the proxy is, zero when no geoip database is configured or the lookup failed
+func (ps *ProxyServer) ProxyLocation(proxy *Proxy) GeoLocation {
+	if location := proxy.geo.Load(); location != nil {
+		return *location
+	}
+	return GeoLocation{}
+}
+
+// allowsCountry checks rotation.allowed_countries and the country the client asked for, both have to match
+func (f proxyFilter) allowsCountry(p *Proxy) bool {
+	if len(f.countries) == 0 && f.country == "" {
+		return true
+	}
+	location := p.geo.Load()
+	if location == nil {
+		return false
+	}
+	if f.country != "" && !strings.EqualFold(f.country, location.Country) {
+		return false
+	}
+	return len(f.countries) == 0 || slices.ContainsFunc(f.countries, func(country string) bool {
+		return strings.EqualFold(country, location.Country)
+	})
+}
+
+func resolveProxyIP(host string) (net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), geoResolveTimeout)
+	defer cancel()
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	return ips[0], nil
+}
+
+func lookup(record any, path ...string) any {
+	for _, key := range path {
+		m, ok := record.(map[string]any)
+		if !ok {
+			return nil
+		}
+		record = m[key]
+	}
+	return record
+}
+
+func lookupString(record any, path ...string) string {
+	s, _ := lookup(record, path...).(string)
+	return s
+}
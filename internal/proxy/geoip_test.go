@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllowedCountries(t *testing.T) {
+	cfg := &config.Config{
+		ProxyFile: "proxies.txt",
+		Proxy: config.ProxyConfig{
+			Port: 8000,
+			Rotation: config.ProxyRotationConfig{
+				Method:           "roundrobin",
+				AllowedCountries: []string{"de", "NL"},
+			},
+		},
+	}
+	ps := NewProxyServer(cfg)
+	us := &Proxy{Host: "us", Pool: "proxies.txt"}
+	us.geo.Store(&GeoLocation{Country: "US"})
+	de := &Proxy{Host: "de", Pool: "proxies.txt"}
+	de.geo.Store(&GeoLocation{Country: "DE", City: "Berlin"})
+	unknown := &Proxy{Host: "unknown", Pool: "proxies.txt"}
+	ps.SetProxies([]*Proxy{us, unknown, de})
+
+	filter := ps.resolveListener(8000).filter()
+	for range 3 {
+		assert.Same(t, de, ps.getProxy("roundrobin", filter))
+	}
+	assert.Equal(t, "Berlin", ps.ProxyLocation(de).City)
+	assert.Equal(t, GeoLocation{}, ps.ProxyLocation(unknown))
+
+	// without allowed countries every proxy rotates, located or not
+	assert.True(t, proxyFilter{pool: "proxies.txt"}.matches(unknown))
+}
+
+func TestLoadGeoIP(t *testing.T) {
+	ps := NewProxyServer(&config.Config{})
+	assert.NoError(t, ps.LoadGeoIP())
+	assert.Nil(t, ps.geoip)
+
+	// locating without databases is a no-op
+	p := &Proxy{Host: "http://127.0.0.1:8080"}
+	ps.locate(p)
+	assert.Nil(t, p.geo.Load())
+
+	ps = NewProxyServer(&config.Config{GeoIP: config.GeoIPConfig{Database: "does-not-exist.mmdb"}})
+	assert.Error(t, ps.LoadGeoIP())
+}
+
+func TestLookupPath(t *testing.T) {
+	record := map[string]any{
+		"country": map[string]any{"iso_code": "DE"},
+		"city":    map[string]any{"names": map[string]any{"en": "Berlin"}},
+	}
+
+	assert.Equal(t, "DE", lookupString(record, "country", "iso_code"))
+	assert.Equal(t, "Berlin", lookupString(record, "city", "names", "en"))
+	assert.Empty(t, lookupString(record, "city", "names", "de"))
+	assert.Empty(t, lookupString(record, "country", "iso_code", "deeper"))
+	assert.Nil(t, lookup(nil, "country"))
+}
+
+func TestResolveProxyIP(t *testing.T) {
+	ip, err := resolveProxyIP("192.0.2.1")
+	assert.NoError(t, err)
+	assert.Equal(t, "192.0.2.1", ip.String())
+
+	ip, err = resolveProxyIP("localhost")
+	assert.NoError(t, err)
+	assert.True(t, ip.IsLoopback())
+}
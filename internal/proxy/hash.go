@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"cmp"
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// hashVirtualNodes is the number of points each proxy gets on the ring, enough to spread hosts evenly without a large
+// ring for big pools
+const hashVirtualNodes = 50
+
+// pickKey is what the consistent_hash method picks by: the target host, and the attempt for a request whose earlier
+// attempts failed on the host's first proxies
+type pickKey struct {
+	host    string
+	attempt int
+}
+
+type ringPoint struct {
+	hash  uint64
+	proxy *Proxy
+}
+
+// hashRing maps hashes to the proxies of the pool, rebuilt after the pool changed, guarded by ps.mu
+type hashRing struct {
+	points []ringPoint
+}
+
+func newPickKey(host string, attempt int) pickKey {
+	return pickKey{host: strings.ToLower(host), attempt: attempt}
+}
+
+func newHashRing(proxies []*Proxy) *hashRing {
+	ring := &hashRing{points: make([]ringPoint, 0, len(proxies)*hashVirtualNodes)}
+	for _, p := range proxies {
+		for i := range hashVirtualNodes {
+			ring.points = append(ring.points, ringPoint{hash: hashKey(p.Host + "#" + strconv.Itoa(i)), proxy: p})
+		}
+	}
+	slices.SortFunc(ring.points, func(a, b ringPoint) int {
+		return cmp.Or(cmp.Compare(a.hash, b.hash), strings.Compare(a.proxy.Host, b.proxy.Host))
+	})
+	return ring
+}
+
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	// fnv spreads similar keys poorly in the high bits, mix them before placing them on the ring
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	return x
+}
+
+// poolChanged drops the hash ring, callers hold ps.mu
+func (ps *ProxyServer) poolChanged() {
+	ps.ring = nil
+}
+
+// pickHashed walks the ring clockwise from the host's hash and returns the first usable proxy, or for a retry the
+// proxy after the ones its earlier attempts used. A host keeps its proxy as long as that proxy stays usable
+func (ps *ProxyServer) pickHashed(key pickKey, usable func(p *Proxy) bool) *Proxy {
+	if ps.ring == nil {
+		ps.ring = newHashRing(ps.Proxies)
+	}
+	points := ps.ring.points
+	if len(points) == 0 {
+		return nil
+	}
+	hash := hashKey(key.host)
+	if ps.ringShift > 0 {
+		// every Rotate gives the hosts new, unrelated spots on the ring
+		hash = hashKey(strconv.FormatUint(ps.ringShift, 10) + "#" + key.host)
+	}
+	start, _ := slices.BinarySearchFunc(points, hash, func(p ringPoint, hash uint64) int {
+		return cmp.Compare(p.hash, hash)
+	})
+
+	var found []*Proxy
+	for i := range points {
+		p := points[(start+i)%len(points)].proxy
+		if slices.Contains(found, p) || !usable(p) {
+			continue
+		}
+		if len(found) == key.attempt {
+			return p
+		}
+		found = append(found, p)
+	}
+	// fewer usable proxies than attempts, start over with the host's first one
+	if len(found) > 0 {
+		return found[key.attempt%len(found)]
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsistentHashRotation(t *testing.T) {
+	ps := NewProxyServer(&config.Config{})
+	for _, host := range []string{"a:1", "b:1", "c:1", "d:1"} {
+		ps.AddProxy(&Proxy{Scheme: "http", Host: host})
+	}
+	rotation := config.ProxyRotationConfig{Method: "consistent_hash"}
+	pick := func(host string, attempt int) *Proxy {
+		return ps.selectProxy(rotation, proxyFilter{}, newPickKey(host, attempt))
+	}
+
+	first := pick("example.com", 0)
+	require.NotNil(t, first)
+	for range 10 {
+		assert.Same(t, first, pick("Example.com", 0))
+	}
+	assert.NotSame(t, first, pick("example.com", 1), "a retry moves on to the host's next proxy")
+
+	hosts := make(map[string]*Proxy)
+	counts := make(map[string]int)
+	for i := range 400 {
+		host := fmt.Sprintf("site%d.example", i)
+		hosts[host] = pick(host, 0)
+		counts[hosts[host].Host]++
+	}
+	for proxy, count := range counts {
+		assert.Greater(t, count, 50, proxy)
+	}
+
+	// only the hosts of a removed proxy move
+	removed := ps.Proxies[0]
+	ps.removeUnhealthyProxy(removed)
+	for host, before := range hosts {
+		if before != removed {
+			assert.Same(t, before, pick(host, 0), host)
+		}
+	}
+}
+
+func TestConsistentHashSkipsUnusable(t *testing.T) {
+	ps := NewProxyServer(&config.Config{})
+	for _, host := range []string{"a:1", "b:1", "c:1"} {
+		ps.AddProxy(&Proxy{Scheme: "http", Host: host})
+	}
+	rotation := config.ProxyRotationConfig{Method: "consistent_hash"}
+	key := newPickKey("example.com", 0)
+	first := ps.selectProxy(rotation, proxyFilter{}, key)
+	first.Scheme = "socks5"
+
+	next := ps.selectProxy(rotation, proxyFilter{scheme: "http"}, key)
+	require.NotNil(t, next)
+	assert.NotSame(t, first, next)
+	assert.Same(t, next, ps.selectProxy(rotation, proxyFilter{}, newPickKey("example.com", 1)))
+	assert.Same(t, next, ps.selectProxy(rotation, proxyFilter{scheme: "http"}, newPickKey("example.com", 2)), "attempts wrap around the usable proxies")
+	assert.Nil(t, ps.selectProxy(rotation, proxyFilter{scheme: "socks4"}, key))
+}
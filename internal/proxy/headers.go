@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/alpkeskin/rota/internal/config"
+)
+
+const (
+	// RequestIDHeader and ProxyHeader carry the request id and the proxy that answered when proxy.debug_headers is on
+	RequestIDHeader = "X-Rota-Request-Id"
+	ProxyHeader     = "X-Rota-Proxy"
+)
+
+// rewriteRequestHeaders applies the request side of every header rule matching the target host, in order
+func (ps *ProxyServer) rewriteRequestHeaders(r *http.Request) {
+	host := strings.ToLower(hostname(r.URL.Host))
+	for _, rule := range ps.Config().HeaderRules {
+		if len(rule.Hosts) == 0 || matchesHost(rule.Hosts, host) {
+			rewriteHeaders(r.Header, rule.Request)
+		}
+	}
+}
+
+// rewriteResponseHeaders applies the response side of the rules, matched against the host that was requested
+func (ps *ProxyServer) rewriteResponseHeaders(r *http.Request, response *http.Response) {
+	host := strings.ToLower(hostname(r.URL.Host))
+	for _, rule := range ps.Config().HeaderRules {
+		if len(rule.Hosts) == 0 || matchesHost(rule.Hosts, host) {
+			rewriteHeaders(response.Header, rule.Response)
+		}
+	}
+}
+
+// rewriteHeaders removes first, so a rule can strip a header and add its own value in one go
+func rewriteHeaders(header http.Header, actions config.HeaderActionsConfig) {
+	for _, name := range actions.Remove {
+		header.Del(name)
+	}
+	for name, value := range actions.Replace {
+		header.Set(name, value)
+	}
+	for name, value := range actions.Add {
+		header.Add(name, value)
+	}
+}
+
+// addDebugHeaders tells the client which request and proxy a response belongs to, so its logs can be matched with the
+// /requests records. Proxy passwords are masked
+func (ps *ProxyServer) addDebugHeaders(response *http.Response, reqInfo requestInfo, proxy *Proxy) {
+	if !ps.Config().Proxy.DebugHeaders {
+		return
+	}
+	response.Header.Set(RequestIDHeader, reqInfo.id)
+	response.Header.Set(ProxyHeader, redactedProxy(proxy.Host))
+}
+
+// redactedProxy masks the password of a proxy address shown to clients
+func redactedProxy(host string) string {
+	u, err := url.Parse(host)
+	if err != nil || u.User == nil {
+		return host
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,187 @@
+package proxy
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultHistorySize = 1000
+
+type RequestRecord struct {
+	ID            uint64    `json:"id"`
+	RequestID     string    `json:"request_id"`
+	Time          time.Time `json:"time"`
+	Proxy         string    `json:"proxy"`
+	Credential    string    `json:"credential,omitempty"`
+	Tenant        string    `json:"tenant,omitempty"`
+	Method        string    `json:"method"`
+	URL           string    `json:"url"`
+	StatusCode    int       `json:"status_code"`
+	Success       bool      `json:"success"`
+	DurationMs    int64     `json:"duration_ms"`
+	BytesSent     uint64    `json:"bytes_sent"`
+	BytesReceived uint64    `json:"bytes_received"`
+	Error         string    `json:"error,omitempty"`
+	ErrorClass    string    `json:"error_class,omitempty"`
+}
+
+// RequestFilter selects request records, zero values match everything
+type RequestFilter struct {
+	Proxy      string
+	Credential string
+	Tenant     string
+	ErrorClass string
+	Success    *bool
+	StatusMin  int
+	StatusMax  int
+	URL        string
+	Since      time.Time
+	Until      time.Time
+	Offset     int
+	Limit      int
+	// Before only matches records older than the record with this ID, pages cut this way do not shift as new records arrive
+	Before uint64
+}
+
+// requestHistory keeps the last size proxy attempts in a ring buffer
+type requestHistory struct {
+	mu      sync.RWMutex
+	records []RequestRecord
+	next    int
+	count   int
+	added   uint64
+}
+
+func newRequestHistory(size int) *requestHistory {
+	return &requestHistory{records: make([]RequestRecord, size)}
+}
+
+func (h *requestHistory) add(record RequestRecord) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.added++
+	record.ID = h.added
+	h.records[h.next] = record
+	h.next = (h.next + 1) % len(h.records)
+	h.count = min(h.count+1, len(h.records))
+}
+
+// drop removes the records that started before before, oldest first, and returns how many it removed. A zero
+// before removes every record. Ids keep counting up, so cursors handed out earlier stay valid
+func (h *requestHistory) drop(before time.Time) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	removed := 0
+	for h.count > 0 {
+		oldest := (h.next - h.count + len(h.records)) % len(h.records)
+		if !before.IsZero() && !h.records[oldest].Time.Before(before) {
+			break
+		}
+		h.records[oldest] = RequestRecord{}
+		h.count--
+		removed++
+	}
+	return removed
+}
+
+// find returns the matching records newest first and the number of matches before paging
+func (h *requestHistory) find(filter RequestFilter) ([]RequestRecord, int) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	count := h.count
+
+	// ids count up from 1 without gaps, so the records before a cursor start a known distance from the newest
+	start := 1
+	if filter.Before > 0 && filter.Before <= h.added {
+		start = int(h.added-filter.Before) + 2
+	}
+
+	matches := make([]RequestRecord, 0)
+	total := 0
+	for i := start; i <= count; i++ {
+		record := h.records[(h.next-i+len(h.records))%len(h.records)]
+		if !filter.matches(record) {
+			continue
+		}
+		total++
+		if total <= filter.Offset || (filter.Limit > 0 && len(matches) >= filter.Limit) {
+			continue
+		}
+		matches = append(matches, record)
+	}
+	return matches, total
+}
+
+func (f RequestFilter) matches(r RequestRecord) bool {
+	switch {
+	case f.Proxy != "" && r.Proxy != f.Proxy:
+		return false
+	case f.Credential != "" && r.Credential != f.Credential:
+		return false
+	case f.Tenant != "" && r.Tenant != f.Tenant:
+		return false
+	case f.ErrorClass != "" && r.ErrorClass != f.ErrorClass:
+		return false
+	case f.Success != nil && r.Success != *f.Success:
+		return false
+	case f.StatusMin > 0 && r.StatusCode < f.StatusMin:
+		return false
+	case f.StatusMax > 0 && r.StatusCode > f.StatusMax:
+		return false
+	case f.URL != "" && !strings.Contains(r.URL, f.URL):
+		return false
+	case !f.Since.IsZero() && r.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && r.Time.After(f.Until):
+		return false
+	}
+	return true
+}
+
+// Requests returns the recorded proxy attempts matching filter and the total number of matches
+func (ps *ProxyServer) Requests(filter RequestFilter) ([]RequestRecord, int) {
+	if ps.history == nil {
+		return []RequestRecord{}, 0
+	}
+	return ps.history.find(filter)
+}
+
+// DropRequests removes the recorded proxy attempts that started before before, all of them when it is zero
+func (ps *ProxyServer) DropRequests(before time.Time) int {
+	if ps.history == nil {
+		return 0
+	}
+	return ps.history.drop(before)
+}
+
+// requestRecord describes one proxy attempt, the duration is the time to the response headers
+func (ps *ProxyServer) requestRecord(proxy *Proxy, reqInfo requestInfo, startAt time.Time, statusCode int, err error) RequestRecord {
+	record := RequestRecord{
+		RequestID:  reqInfo.id,
+		Time:       startAt,
+		Proxy:      proxy.Host,
+		Credential: reqInfo.directives.Username,
+		Tenant:     ps.listenerFor(reqInfo).tenant,
+		Method:     reqInfo.request.Method,
+		URL:        reqInfo.url,
+		StatusCode: statusCode,
+		Success:    err == nil,
+		DurationMs: time.Since(startAt).Milliseconds(),
+		ErrorClass: ErrorClass(err, statusCode),
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	return record
+}
+
+func (ps *ProxyServer) addRecord(record RequestRecord) {
+	ps.tenantUsage.add(record)
+	if ps.history == nil {
+		return
+	}
+	ps.history.add(record)
+}
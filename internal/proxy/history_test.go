@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestHistoryWraps(t *testing.T) {
+	h := newRequestHistory(3)
+	for i := 1; i <= 5; i++ {
+		h.add(RequestRecord{RequestID: fmt.Sprint(i)})
+	}
+
+	records, total := h.find(RequestFilter{})
+	assert.Equal(t, 3, total)
+	assert.Equal(t, []string{"5", "4", "3"}, requestIDs(records))
+	assert.Equal(t, uint64(5), records[0].ID)
+}
+
+func TestRequestHistoryDrop(t *testing.T) {
+	h := newRequestHistory(3)
+	start := time.Now()
+	for i := 1; i <= 4; i++ {
+		h.add(RequestRecord{RequestID: fmt.Sprint(i), Time: start.Add(time.Duration(i) * time.Minute)})
+	}
+
+	assert.Equal(t, 1, h.drop(start.Add(3*time.Minute)))
+	records, total := h.find(RequestFilter{})
+	assert.Equal(t, 2, total)
+	assert.Equal(t, []string{"4", "3"}, requestIDs(records))
+
+	// the ring fills up again around the dropped slot and ids keep counting
+	h.add(RequestRecord{RequestID: "5", Time: start.Add(5 * time.Minute)})
+	h.add(RequestRecord{RequestID: "6", Time: start.Add(6 * time.Minute)})
+	records, _ = h.find(RequestFilter{})
+	assert.Equal(t, []string{"6", "5", "4"}, requestIDs(records))
+	records, _ = h.find(RequestFilter{Before: 5})
+	assert.Equal(t, []string{"4"}, requestIDs(records))
+
+	assert.Equal(t, 3, h.drop(time.Time{}))
+	records, total = h.find(RequestFilter{})
+	assert.Zero(t, total)
+	assert.Empty(t, records)
+	h.add(RequestRecord{RequestID: "7"})
+	records, _ = h.find(RequestFilter{})
+	assert.Equal(t, uint64(7), records[0].ID)
+}
+
+func TestRequestHistoryCursor(t *testing.T) {
+	h := newRequestHistory(5)
+	for i := 1; i <= 6; i++ {
+		h.add(RequestRecord{RequestID: fmt.Sprint(i), Success: i%2 == 0})
+	}
+
+	records, total := h.find(RequestFilter{Limit: 2})
+	assert.Equal(t, []string{"6", "5"}, requestIDs(records))
+	assert.Equal(t, 5, total)
+
+	// records added after the first page do not shift the next one
+	h.add(RequestRecord{RequestID: "7"})
+	records, total = h.find(RequestFilter{Limit: 2, Before: records[1].ID})
+	assert.Equal(t, []string{"4", "3"}, requestIDs(records))
+	assert.Equal(t, 2, total, "record 2 left the ring, only 4 and 3 are older than 5")
+
+	succeeded := true
+	records, total = h.find(RequestFilter{Success: &succeeded, Before: 7})
+	assert.Equal(t, []string{"6", "4"}, requestIDs(records))
+	assert.Equal(t, 2, total)
+
+	records, _ = h.find(RequestFilter{Before: 3})
+	assert.Empty(t, records)
+}
+
+func TestRequestHistoryFind(t *testing.T) {
+	now := time.Now()
+	h := newRequestHistory(10)
+	h.add(RequestRecord{RequestID: "1", Time: now.Add(-3 * time.Minute), Proxy: "a:1", URL: "http://example.com/a", StatusCode: 200, Success: true})
+	h.add(RequestRecord{RequestID: "2", Time: now.Add(-2 * time.Minute), Proxy: "b:1", Credential: "alice", URL: "http://example.com/b", StatusCode: 503, Success: true, ErrorClass: ErrorClassServer})
+	h.add(RequestRecord{RequestID: "3", Time: now.Add(-1 * time.Minute), Proxy: "a:1", URL: "http://example.org/c", Success: false, ErrorClass: ErrorClassTimeout})
+
+	failed := false
+	tests := []struct {
+		name      string
+		filter    RequestFilter
+		wantIDs   []string
+		wantTotal int
+	}{
+		{"everything newest first", RequestFilter{}, []string{"3", "2", "1"}, 3},
+		{"by proxy", RequestFilter{Proxy: "a:1"}, []string{"3", "1"}, 2},
+		{"by credential", RequestFilter{Credential: "alice"}, []string{"2"}, 1},
+		{"failed only", RequestFilter{Success: &failed}, []string{"3"}, 1},
+		{"by error class", RequestFilter{ErrorClass: ErrorClassServer}, []string{"2"}, 1},
+		{"status range", RequestFilter{StatusMin: 500, StatusMax: 599}, []string{"2"}, 1},
+		{"url substring", RequestFilter{URL: "example.com"}, []string{"2", "1"}, 2},
+		{"time range", RequestFilter{Since: now.Add(-150 * time.Second), Until: now.Add(-90 * time.Second)}, []string{"2"}, 1},
+		{"paged", RequestFilter{Offset: 1, Limit: 1}, []string{"2"}, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, total := h.find(tt.filter)
+			assert.Equal(t, tt.wantIDs, requestIDs(records))
+			assert.Equal(t, tt.wantTotal, total)
+		})
+	}
+}
+
+func TestTryProxyRecordsRequest(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{
+		Proxy:   config.ProxyConfig{Rotation: config.ProxyRotationConfig{Retries: 1}},
+		History: config.HistoryConfig{Enabled: true},
+	}
+	ps := NewProxyServer(cfg)
+	proxy, err := NewProxyLoader(ps).CreateProxy(upstream.URL)
+	assert.NoError(t, err)
+
+	reqInfo := requestInfo{
+		id:      "req-1",
+		url:     "http://example.com/",
+		request: httptest.NewRequest(http.MethodGet, "http://example.com/", nil),
+	}
+	response, err := ps.tryProxy(proxy, reqInfo)
+	assert.NoError(t, err)
+	response.Body.Close()
+
+	records, total := ps.Requests(RequestFilter{})
+	assert.Equal(t, 1, total)
+	assert.Equal(t, "req-1", records[0].RequestID)
+	assert.Equal(t, proxy.Host, records[0].Proxy)
+	assert.Equal(t, http.StatusTeapot, records[0].StatusCode)
+	assert.True(t, records[0].Success)
+}
+
+func TestRequestsDisabled(t *testing.T) {
+	ps := NewProxyServer(&config.Config{})
+	records, total := ps.Requests(RequestFilter{})
+	assert.Empty(t, records)
+	assert.Zero(t, total)
+}
+
+func requestIDs(records []RequestRecord) []string {
+	ids := make([]string, 0, len(records))
+	for _, r := range records {
+		ids = append(ids, r.RequestID)
+	}
+	return ids
+}
@@ -0,0 +1,210 @@
+package proxy
+
+import (
+	"math"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/exp/rand"
+)
+
+const (
+	// latencyWeight is the share of a new sample in the moving average, about the last ten samples carry it
+	latencyWeight            = 0.2
+	defaultLatencyPercentile = 25
+	// LatencyHours is how many hourly histograms a proxy keeps
+	LatencyHours = 24
+	// histogram buckets grow by latencyGrowth from latencyFirstBucket, the last bucket takes everything slower
+	latencyFirstBucket = time.Millisecond
+	latencyGrowth      = 1.5
+	latencyBuckets     = 28
+)
+
+// latency is a moving average of the time to response headers, in nanoseconds and zero before the first sample,
+// and a histogram of them for each of the last LatencyHours hours
+type latency struct {
+	avg atomic.Int64
+
+	mu    sync.Mutex
+	hours [LatencyHours]*latencyHour
+}
+
+type latencyHour struct {
+	start  time.Time
+	counts [latencyBuckets]uint32
+}
+
+// LatencyPercentiles are response time percentiles in milliseconds, estimated from histogram buckets
+type LatencyPercentiles struct {
+	Samples uint64 `json:"samples"`
+	P50     int64  `json:"p50"`
+	P90     int64  `json:"p90"`
+	P99     int64  `json:"p99"`
+}
+
+// LatencyHour are the response time percentiles of the hour starting at Hour
+type LatencyHour struct {
+	Hour time.Time `json:"hour"`
+	LatencyPercentiles
+}
+
+func (l *latency) observe(d time.Duration) {
+	l.record(time.Now(), d)
+	for {
+		old := l.avg.Load()
+		next := int64(d)
+		if old != 0 {
+			next = old + int64(latencyWeight*float64(int64(d)-old))
+		}
+		// zero means unmeasured
+		next = max(next, 1)
+		if l.avg.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+func (l *latency) average() time.Duration {
+	return time.Duration(l.avg.Load())
+}
+
+func (l *latency) record(now time.Time, d time.Duration) {
+	start := now.Truncate(time.Hour)
+	slot := int(start.Unix()/3600) % LatencyHours
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	hour := l.hours[slot]
+	if hour == nil || !hour.start.Equal(start) {
+		hour = &latencyHour{start: start}
+		l.hours[slot] = hour
+	}
+	hour.counts[latencyBucket(d)]++
+}
+
+// histogram returns the hours with samples that started at or after since, oldest first
+func (l *latency) histogram(since time.Time) []latencyHour {
+	since = since.Truncate(time.Hour)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	hours := make([]latencyHour, 0, LatencyHours)
+	for _, hour := range l.hours {
+		if hour != nil && !hour.start.Before(since) {
+			hours = append(hours, *hour)
+		}
+	}
+	slices.SortFunc(hours, func(a, b latencyHour) int { return a.start.Compare(b.start) })
+	return hours
+}
+
+func latencyBucket(d time.Duration) int {
+	if d <= latencyFirstBucket {
+		return 0
+	}
+	i := int(math.Ceil(math.Log(float64(d)/float64(latencyFirstBucket)) / math.Log(latencyGrowth)))
+	return min(i, latencyBuckets-1)
+}
+
+// latencyBound is the upper bound of bucket i, the last bucket has none
+func latencyBound(i int) time.Duration {
+	return time.Duration(float64(latencyFirstBucket) * math.Pow(latencyGrowth, float64(i)))
+}
+
+// percentiles estimates the percentiles of merged counts, interpolating inside the bucket holding each rank. Samples
+// in the last bucket are reported at its lower bound
+func percentiles(counts [latencyBuckets]uint32) LatencyPercentiles {
+	var total uint64
+	for _, count := range counts {
+		total += uint64(count)
+	}
+	result := LatencyPercentiles{Samples: total}
+	if total == 0 {
+		return result
+	}
+	quantile := func(q float64) int64 {
+		rank := uint64(math.Ceil(q * float64(total)))
+		var seen uint64
+		for i, count := range counts {
+			if count == 0 || seen+uint64(count) < rank {
+				seen += uint64(count)
+				continue
+			}
+			var lower time.Duration
+			if i > 0 {
+				lower = latencyBound(i - 1)
+			}
+			if i == latencyBuckets-1 {
+				return lower.Milliseconds()
+			}
+			upper := latencyBound(i)
+			return (lower + time.Duration(float64(upper-lower)*float64(rank-seen)/float64(count))).Milliseconds()
+		}
+		return 0
+	}
+	result.P50, result.P90, result.P99 = quantile(0.5), quantile(0.9), quantile(0.99)
+	return result
+}
+
+// ProxyLatency returns the moving average of the proxy's response times, zero before it answered once
+func (ps *ProxyServer) ProxyLatency(proxy *Proxy) time.Duration {
+	return proxy.latency.average()
+}
+
+// ProxyLatencyPercentiles returns the percentiles of the proxy's response times in the last hours hours, the current
+// one included. At most LatencyHours are kept
+func (ps *ProxyServer) ProxyLatencyPercentiles(proxy *Proxy, hours int) LatencyPercentiles {
+	var counts [latencyBuckets]uint32
+	for _, hour := range proxy.latency.histogram(time.Now().Add(-time.Duration(hours-1) * time.Hour)) {
+		for i, count := range hour.counts {
+			counts[i] += count
+		}
+	}
+	return percentiles(counts)
+}
+
+// ProxyLatencyHours returns the percentiles of every hour the proxy answered in, of the last LatencyHours, oldest first
+func (ps *ProxyServer) ProxyLatencyHours(proxy *Proxy) []LatencyHour {
+	hours := proxy.latency.histogram(time.Now().Add(-(LatencyHours - 1) * time.Hour))
+	result := make([]LatencyHour, 0, len(hours))
+	for _, hour := range hours {
+		result = append(result, LatencyHour{Hour: hour.start, LatencyPercentiles: percentiles(hour.counts)})
+	}
+	return result
+}
+
+// pickFastest picks at random among the usable proxies whose average response time is within the fastest percentile
+// of them. Proxies without a measurement yet are always candidates, otherwise they would never get one
+func (ps *ProxyServer) pickFastest(percentile int, usable func(p *Proxy) bool) *Proxy {
+	if percentile <= 0 || percentile > 100 {
+		percentile = defaultLatencyPercentile
+	}
+	type candidate struct {
+		proxy *Proxy
+		avg   time.Duration
+	}
+	candidates := make([]candidate, 0)
+	averages := make([]time.Duration, 0)
+	for _, p := range ps.Proxies {
+		if !usable(p) {
+			continue
+		}
+		avg := p.latency.average()
+		candidates = append(candidates, candidate{proxy: p, avg: avg})
+		if avg > 0 {
+			averages = append(averages, avg)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	if len(averages) > 0 {
+		slices.Sort(averages)
+		limit := averages[(len(averages)*percentile+99)/100-1]
+		candidates = slices.DeleteFunc(candidates, func(c candidate) bool {
+			return c.avg > limit
+		})
+	}
+	return candidates[rand.Intn(len(candidates))].proxy
+}
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatencyAverage(t *testing.T) {
+	var l latency
+	assert.Zero(t, l.average())
+	l.observe(100 * time.Millisecond)
+	assert.Equal(t, 100*time.Millisecond, l.average())
+	l.observe(200 * time.Millisecond)
+	assert.Equal(t, 120*time.Millisecond, l.average())
+}
+
+func TestLeastLatencyRotation(t *testing.T) {
+	ps := NewProxyServer(&config.Config{})
+	fast, slow, slower, unmeasured := &Proxy{Host: "fast"}, &Proxy{Host: "slow"}, &Proxy{Host: "slower"}, &Proxy{Host: "unmeasured"}
+	fast.latency.observe(50 * time.Millisecond)
+	slow.latency.observe(500 * time.Millisecond)
+	slower.latency.observe(time.Second)
+	for _, p := range []*Proxy{fast, slow, slower, unmeasured} {
+		ps.AddProxy(p)
+	}
+
+	rotation := config.ProxyRotationConfig{Method: "least_latency", LatencyPercentile: 50}
+	picked := make(map[string]int)
+	for range 200 {
+		picked[ps.selectProxy(rotation, proxyFilter{}, pickKey{}).Host]++
+	}
+	// the band holds the faster half of the measured proxies, unmeasured ones are tried too
+	assert.Equal(t, []string{"fast", "slow", "unmeasured"}, slices.Sorted(maps.Keys(picked)))
+
+	rotation.LatencyPercentile = 0
+	for range 50 {
+		assert.Contains(t, []string{"fast", "unmeasured"}, ps.selectProxy(rotation, proxyFilter{}, pickKey{}).Host)
+	}
+	assert.Nil(t, ps.selectProxy(rotation, proxyFilter{tag: "missing"}, pickKey{}))
+}
+
+func TestLatencyPercentiles(t *testing.T) {
+	ps := NewProxyServer(&config.Config{})
+	p := &Proxy{Host: "http://10.0.0.1:8080"}
+	assert.Equal(t, LatencyPercentiles{}, ps.ProxyLatencyPercentiles(p, LatencyHours))
+
+	for i := range 100 {
+		switch {
+		case i < 90:
+			p.latency.observe(10 * time.Millisecond)
+		case i < 99:
+			p.latency.observe(100 * time.Millisecond)
+		default:
+			p.latency.observe(5 * time.Second)
+		}
+	}
+	percentiles := ps.ProxyLatencyPercentiles(p, 1)
+	assert.Equal(t, uint64(100), percentiles.Samples)
+	// estimates stay inside the bucket of the samples, which spans a factor of latencyGrowth
+	assert.InDelta(t, 10, percentiles.P50, 4)
+	assert.InDelta(t, 10, percentiles.P90, 4)
+	assert.InDelta(t, 100, percentiles.P99, 40)
+	hours := ps.ProxyLatencyHours(p)
+	require.Len(t, hours, 1)
+	assert.Equal(t, time.Now().Truncate(time.Hour), hours[0].Hour)
+	assert.Equal(t, percentiles, hours[0].LatencyPercentiles)
+}
+
+func TestLatencyHistogramHours(t *testing.T) {
+	var l latency
+	now := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	l.record(now.Add(-2*time.Hour), 10*time.Millisecond)
+	l.record(now.Add(-time.Hour), time.Minute)
+	l.record(now, time.Millisecond)
+
+	hours := l.histogram(now.Add(-time.Hour))
+	require.Len(t, hours, 2)
+	assert.Equal(t, now.Add(-time.Hour).Truncate(time.Hour), hours[0].start)
+	assert.Equal(t, uint32(1), hours[0].counts[latencyBuckets-1], "slower than every bound")
+	assert.Equal(t, uint32(1), hours[1].counts[0])
+
+	// a day later the slot of an hour is reused and its old counts are gone
+	l.record(now.Add(LatencyHours*time.Hour), 10*time.Millisecond)
+	hours = l.histogram(now.Add(-2 * time.Hour))
+	require.Len(t, hours, 3)
+	assert.Equal(t, now.Add(LatencyHours*time.Hour).Truncate(time.Hour), hours[2].start)
+}
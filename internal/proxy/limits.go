@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/elazarl/goproxy"
+)
+
+const (
+	msgRequestTooLarge  = "request body too large"
+	msgResponseTooLarge = "response body too large"
+	msgBodyTooLarge     = "Rota Proxy: Request Entity Too Large. Request ID: %s"
+)
+
+var (
+	errRequestTooLarge  = errors.New(msgRequestTooLarge)
+	errResponseTooLarge = errors.New(msgResponseTooLarge)
+)
+
+// limitedBody fails a body once it grows past limit bytes, for bodies whose length is not known up front
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	err       error
+	exceeded  bool
+}
+
+func newLimitedBody(body io.ReadCloser, limit int64, err error) *limitedBody {
+	return &limitedBody{ReadCloser: body, remaining: limit, err: err}
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.exceeded {
+		return 0, b.err
+	}
+	// reading one byte past the limit tells a body of exactly limit bytes from a larger one
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.remaining {
+		b.exceeded = true
+		n = int(b.remaining)
+		b.remaining = 0
+		return n, b.err
+	}
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// limitRequestBody rejects requests over max_request_body up front when they announce their length, and wraps
+// the others so they fail while they are sent
+func limitRequestBody(r *http.Request, limit int64) (*limitedBody, bool) {
+	if limit <= 0 || r.Body == nil || r.Body == http.NoBody {
+		return nil, true
+	}
+	if r.ContentLength > limit {
+		return nil, false
+	}
+	body := newLimitedBody(r.Body, limit, errRequestTooLarge)
+	r.Body = body
+	return body, true
+}
+
+// limitResponseBody refuses responses over max_response_body that announce their length, others are cut off
+// once they pass it
+func limitResponseBody(response *http.Response, limit int64) bool {
+	if limit <= 0 {
+		return true
+	}
+	if response.ContentLength > limit {
+		response.Body.Close()
+		return false
+	}
+	response.Body = newLimitedBody(response.Body, limit, errResponseTooLarge)
+	return true
+}
+
+func (ps *ProxyServer) requestTooLarge(r *http.Request, requestID string, limit int64) *http.Response {
+	slog.Warn(msgRequestTooLarge, "request_id", requestID, "url", r.URL.String(), "ip", r.RemoteAddr, "limit", limit)
+	response := goproxy.NewResponse(r,
+		goproxy.ContentTypeText, http.StatusRequestEntityTooLarge,
+		fmt.Sprintf(msgBodyTooLarge, requestID))
+	response.ProtoMajor, response.ProtoMinor = 1, 1
+	return response
+}
@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitedBody(t *testing.T) {
+	body := newLimitedBody(io.NopCloser(strings.NewReader("12345")), 5, errResponseTooLarge)
+	data, err := io.ReadAll(body)
+	assert.NoError(t, err)
+	assert.Equal(t, "12345", string(data))
+	assert.False(t, body.exceeded)
+
+	body = newLimitedBody(io.NopCloser(strings.NewReader("123456")), 5, errResponseTooLarge)
+	data, err = io.ReadAll(body)
+	assert.ErrorIs(t, err, errResponseTooLarge)
+	assert.Equal(t, "12345", string(data))
+	assert.True(t, body.exceeded)
+}
+
+func TestBodyLimits(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		if r.URL.Path == "/stream" {
+			w.(http.Flusher).Flush()
+		}
+		_, _ = w.Write([]byte(strings.Repeat("x", 32)))
+	}))
+	defer target.Close()
+
+	cfg := &config.Config{
+		Proxy: config.ProxyConfig{
+			Rotation: config.ProxyRotationConfig{
+				Method: "roundrobin", Retries: 1, Timeout: 5, FallbackMaxRetries: 1,
+				MaxRequestBody: 16, MaxResponseBody: 16,
+			},
+		},
+		Routing: []config.RoutingRuleConfig{
+			{Hosts: []string{"127.0.0.1"}, Direct: true},
+		},
+	}
+	ps := NewProxyServer(cfg)
+	goProxy := newGoProxy()
+	ps.setUpListenerHandlers(goProxy, 0)
+	server := httptest.NewServer(goProxy)
+	defer server.Close()
+	proxyURL, _ := url.Parse(server.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	post := func(body io.Reader) int {
+		resp, err := client.Post(target.URL, "text/plain", body)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	// the response is announced with 32 bytes, over the limit
+	assert.Equal(t, http.StatusBadGateway, post(strings.NewReader("small")))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post(strings.NewReader(strings.Repeat("y", 17))))
+	// without a length the request fails while it is sent
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post(io.MultiReader(strings.NewReader(strings.Repeat("y", 17)))))
+
+	// a streamed response has no length, it is cut off at the limit
+	resp, err := client.Get(target.URL + "/stream")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	data, _ := io.ReadAll(resp.Body)
+	assert.LessOrEqual(t, len(data), 16)
+}
@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/alpkeskin/rota/internal/stats"
+	"github.com/alpkeskin/rota/pkg/unixsocket"
+	"github.com/elazarl/goproxy"
+)
+
+type listenerConfig struct {
+	// cfg is the config the request started with, a reload while it is in flight does not change it
+	cfg            *config.Config
+	rotation       config.ProxyRotationConfig
+	authentication config.ProxyAuthenticationConfig
+	pool           string
+	tag            string
+	// tenant requests stay in the tenant's pool, routing rules can not move them to another one
+	tenant string
+}
+
+func newGoProxy() *goproxy.ProxyHttpServer {
+	goProxy := goproxy.NewProxyHttpServer()
+	// keep the client's Accept-Encoding so ranged and compressed bodies pass through byte for byte
+	goProxy.KeepAcceptEncoding = true
+	return goProxy
+}
+
+func (ps *ProxyServer) newServer(goProxy *goproxy.ProxyHttpServer) *http.Server {
+	timeouts := ps.Config().Proxy.Timeouts
+	return &http.Server{
+		Handler:      goProxy,
+		ReadTimeout:  time.Duration(timeouts.Read) * time.Second,
+		WriteTimeout: time.Duration(timeouts.Write) * time.Second,
+		IdleTimeout:  time.Duration(timeouts.Idle) * time.Second,
+		ConnContext:  withClientConn,
+		ConnState:    ps.conns.connState,
+	}
+}
+
+// serveListener serves until Shutdown, which is not reported as an error
+func (ps *ProxyServer) serveListener(goProxy *goproxy.ProxyHttpServer, listener net.Listener) error {
+	srv := ps.newServer(goProxy)
+	if !ps.conns.add(srv) {
+		return listener.Close()
+	}
+	if err := srv.Serve(&clientListener{Listener: listener}); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (ps *ProxyServer) serve(goProxy *goproxy.ProxyHttpServer, port int) {
+	addr := fmt.Sprintf(":%d", port)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		slog.Error(msgFailedToListen, "error", err, "port", addr)
+		return
+	}
+
+	slog.Info(msgProxyServerStarted, "port", addr, "tls", ps.tlsConfig != nil)
+	if err := ps.serveListener(goProxy, ps.tlsListener(listener)); err != nil {
+		slog.Error(msgFailedToListen, "error", err, "port", addr)
+	}
+}
+
+func (ps *ProxyServer) serveUnix(goProxy *goproxy.ProxyHttpServer, path string) {
+	listener, err := unixsocket.Listen(path)
+	if err != nil {
+		slog.Error(msgFailedToListen, "error", err, "socket", path)
+		return
+	}
+
+	slog.Info(msgProxyServerStarted, "socket", path)
+	if err := ps.serveListener(goProxy, listener); err != nil {
+		slog.Error(msgFailedToListen, "error", err, "socket", path)
+	}
+}
+
+func (ps *ProxyServer) setUpListenerHandlers(goProxy *goproxy.ProxyHttpServer, port int) {
+	goProxy.OnRequest().HandleConnectFunc(func(host string, ctx *goproxy.ProxyCtx) (*goproxy.ConnectAction, string) {
+		listener := ps.resolveListener(port)
+		action, host := ps.authenticateHttps(host, ctx, listener)
+		// blocked targets are refused before the tunnel opens, so no tls handshake is made for them
+		if action.Action == goproxy.ConnectMitm && !ps.targetAllowed(host) {
+			ps.stats.ObserveRequest(stats.ResultForbidden)
+			ctx.Resp = ps.forbidden(ctx.Req, "")
+			ctx.Resp.Close = true
+			return goproxy.RejectConnect, host
+		}
+		if action.Action == goproxy.ConnectMitm {
+			directives := ps.requestDirectives(ctx.Req, ctx, listener)
+			ctx.UserData = directives
+			listener, ok := ps.tenantListener(listener, directives.Username)
+			if !ok {
+				ps.stats.ObserveRequest(stats.ResultForbidden)
+				ctx.Resp = ps.unknownTenant(ctx.Req, "", listener.tenant)
+				ctx.Resp.Close = true
+				return goproxy.RejectConnect, host
+			}
+			// direct hosts are tunneled as they are, there is no proxy to rotate inside the tunnel
+			if ps.route(host, listener).direct {
+				action = goproxy.OkConnect
+				// no request inside the tunnel reaches the rate limit, the tunnel counts as one
+				if !ps.middleware.AllowRequest(listener.cfg.Proxy.RateLimit, ctx.Req, directives.Username) {
+					ps.stats.ObserveRequest(stats.ResultRateLimited)
+					ctx.Resp = ps.tooManyRequests(ctx.Req, "", directives.Username)
+					ctx.Resp.Close = true
+					return goproxy.RejectConnect, host
+				}
+			}
+			ps.startTunnel(ctx.Req)
+		}
+		return action, host
+	})
+	goProxy.OnRequest().DoFunc(func(r *http.Request, ctx *goproxy.ProxyCtx) (*http.Request, *http.Response) {
+		return ps.handleRequest(r, ctx, ps.resolveListener(port))
+	})
+}
+
+// the read and write timeouts only cover the CONNECT request, the tunnel itself is bounded by timeouts.tunnel
+func (ps *ProxyServer) startTunnel(r *http.Request) {
+	if conn := clientConnFrom(r.Context()); conn != nil {
+		ps.stats.TunnelOpened()
+		ps.conns.openTunnel(conn, r.Host)
+		conn.startTunnel(time.Duration(ps.Config().Proxy.Timeouts.Tunnel)*time.Second, func() {
+			ps.conns.closeTunnel(conn)
+			ps.stats.TunnelClosed()
+		})
+	}
+}
+
+// listener settings are resolved per request so a SIGHUP reload applies to running listeners
+func (ps *ProxyServer) resolveListener(port int) *listenerConfig {
+	cfg := ps.Config()
+	listener := &listenerConfig{
+		cfg:            cfg,
+		rotation:       cfg.Proxy.Rotation,
+		authentication: cfg.Proxy.Authentication,
+		pool:           cfg.ProxyFile,
+	}
+
+	for _, lc := range cfg.Proxy.Listeners {
+		if lc.Port != port {
+			continue
+		}
+		if lc.Rotation != nil {
+			listener.rotation = *lc.Rotation
+		}
+		if lc.Authentication != nil {
+			listener.authentication = *lc.Authentication
+		}
+		if lc.ProxyFile != "" {
+			listener.pool = lc.ProxyFile
+		}
+		listener.tag = strings.ToLower(lc.Tag)
+	}
+	return listener
+}
+
+func (l *listenerConfig) filter() proxyFilter {
+	return proxyFilter{pool: l.pool, tag: l.tag, countries: l.rotation.AllowedCountries}
+}
+
+func (ps *ProxyServer) listenerFor(reqInfo requestInfo) *listenerConfig {
+	if reqInfo.listener != nil {
+		return reqInfo.listener
+	}
+	return ps.resolveListener(ps.Config().Proxy.Port)
+}
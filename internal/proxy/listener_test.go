@@ -0,0 +1,165 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPickProxyPools(t *testing.T) {
+	cfg := &config.Config{}
+	ps := NewProxyServer(cfg)
+	for _, p := range []*Proxy{
+		{Host: "a1", Pool: "a.txt"},
+		{Host: "b1", Pool: "b.txt"},
+		{Host: "a2", Pool: "a.txt"},
+		{Host: "b2", Pool: "b.txt"},
+	} {
+		ps.AddProxy(p)
+	}
+
+	var picked []string
+	for range 3 {
+		picked = append(picked, ps.getProxy("roundrobin", proxyFilter{pool: "b.txt"}).Host)
+	}
+	assert.Equal(t, []string{"b1", "b2", "b1"}, picked)
+	assert.Equal(t, "a1", ps.getProxy("roundrobin", proxyFilter{pool: "a.txt"}).Host)
+	assert.Equal(t, "a2", ps.getProxy("roundrobin", proxyFilter{pool: "a.txt"}).Host)
+
+	for range 10 {
+		assert.Equal(t, "a.txt", ps.getProxy("random", proxyFilter{pool: "a.txt"}).Pool)
+	}
+	assert.Nil(t, ps.getProxy("roundrobin", proxyFilter{pool: "missing.txt"}))
+	assert.Nil(t, ps.getProxy("random", proxyFilter{pool: "missing.txt"}))
+	assert.Len(t, ps.GetProxies(), 4)
+}
+
+func TestListenerAuthentication(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	rotation := config.ProxyRotationConfig{Method: "roundrobin", Retries: 1, Timeout: 5, FallbackMaxRetries: 1}
+	cfg := &config.Config{
+		ProxyFile: "default.txt",
+		Proxy: config.ProxyConfig{
+			Port:     9000,
+			Rotation: rotation,
+			Listeners: []config.ProxyListenerConfig{
+				{
+					Port:      9001,
+					ProxyFile: "residential.txt",
+					Authentication: &config.ProxyAuthenticationConfig{
+						Enabled:  true,
+						Username: "team",
+						Password: "secret",
+					},
+				},
+			},
+		},
+	}
+	ps := NewProxyServer(cfg)
+	proxy, err := NewProxyLoader(ps).CreateProxy(upstream.URL)
+	assert.NoError(t, err)
+	proxy.Pool = "residential.txt"
+	ps.AddProxy(proxy)
+
+	tests := []struct {
+		name       string
+		port       int
+		user       *url.Userinfo
+		wantStatus int
+	}{
+		{"default listener has an empty pool", 9000, nil, http.StatusBadGateway},
+		{"listener requires its own credentials", 9001, nil, http.StatusProxyAuthRequired},
+		{"listener rejects wrong credentials", 9001, url.UserPassword("team", "wrong"), http.StatusProxyAuthRequired},
+		{"listener routes to its pool", 9001, url.UserPassword("team", "secret"), http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			goProxy := newGoProxy()
+			ps.setUpListenerHandlers(goProxy, tt.port)
+			server := httptest.NewServer(goProxy)
+			defer server.Close()
+
+			proxyURL, _ := url.Parse(server.URL)
+			proxyURL.User = tt.user
+			client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+			resp, err := client.Get("http://example.com/")
+			assert.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+		})
+	}
+}
+
+func TestListenerRotation(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{
+		Proxy: config.ProxyConfig{
+			Rotation: config.ProxyRotationConfig{Method: "roundrobin"},
+			Listeners: []config.ProxyListenerConfig{
+				{
+					Port: 9001,
+					Rotation: &config.ProxyRotationConfig{
+						Method:             "random",
+						Retries:            1,
+						Timeout:            5,
+						FallbackMaxRetries: 1,
+					},
+				},
+				{Port: 9002},
+			},
+		},
+	}
+	ps := NewProxyServer(cfg)
+	proxy, err := NewProxyLoader(ps).CreateProxy(upstream.URL)
+	assert.NoError(t, err)
+	ps.AddProxy(proxy)
+
+	assert.Equal(t, "random", ps.resolveListener(9001).rotation.Method)
+	assert.Equal(t, "roundrobin", ps.resolveListener(9002).rotation.Method)
+	assert.Equal(t, "roundrobin", ps.listenerFor(requestInfo{}).rotation.Method)
+
+	tests := []struct {
+		name       string
+		port       int
+		wantStatus int
+	}{
+		// the default rotation has no retries configured, only the listener override can succeed
+		{"default listener", 0, http.StatusBadGateway},
+		{"listener with own rotation", 9001, http.StatusOK},
+		{"listener inheriting rotation", 9002, http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			goProxy := newGoProxy()
+			if tt.port == 0 {
+				ps.goProxy = goProxy
+				ps.setUpHandlers()
+			} else {
+				ps.setUpListenerHandlers(goProxy, tt.port)
+			}
+			server := httptest.NewServer(goProxy)
+			defer server.Close()
+
+			proxyURL, _ := url.Parse(server.URL)
+			client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+			resp, err := client.Get("http://example.com/")
+			assert.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+		})
+	}
+}
@@ -1,16 +1,17 @@
 package proxy
 
 import (
-	"crypto/tls"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/alpkeskin/rota/internal/config"
-	"h12.io/socks"
 )
 
 const (
@@ -19,82 +20,260 @@ const (
 	msgLoadingProxies            = "loading proxies"
 	msgFailedToLoadProxies       = "failed to load proxies"
 	msgUnsupportedProxyScheme    = "unsupported proxy scheme"
+	msgNoProxiesInFile           = "no proxies in file"
+	msgKeepingLastSnapshot       = "reload failed, keeping last proxy snapshot"
+	msgRetryingProxyLoad         = "failed to load proxies, retrying"
+	msgProxiesValidated          = "proxies validated"
 )
 
 type ProxyLoader struct {
-	cfg         *config.Config
 	proxyServer *ProxyServer
 }
 
-func NewProxyLoader(cfg *config.Config, proxyServer *ProxyServer) *ProxyLoader {
+func NewProxyLoader(proxyServer *ProxyServer) *ProxyLoader {
 	return &ProxyLoader{
-		cfg:         cfg,
 		proxyServer: proxyServer,
 	}
 }
 
+// config is the config of the proxy server, loading again after a reload uses the reloaded one
+func (pl *ProxyLoader) config() *config.Config {
+	return pl.proxyServer.Config()
+}
+
 func (pl *ProxyLoader) Load() error {
-	slog.Info(msgLoadingProxies)
-	data, err := os.ReadFile(pl.cfg.ProxyFile)
+	proxies, err := pl.readProxies()
 	if err != nil {
-		return fmt.Errorf("%s: %w", msgFailedToLoadProxies, err)
+		return err
+	}
+
+	for _, proxy := range proxies {
+		pl.proxyServer.AddProxy(proxy)
+	}
+	pl.proxyServer.pruneTransports()
+
+	slog.Info(msgProxiesLoadedSuccessfully)
+	return nil
+}
+
+func (pl *ProxyLoader) LoadWithRetry() error {
+	backoff := time.Duration(pl.config().Startup.Backoff) * time.Second
+	maxBackoff := time.Duration(pl.config().Startup.MaxBackoff) * time.Second
+
+	var err error
+	for attempt := 0; attempt <= pl.config().Startup.Retries; attempt++ {
+		if attempt > 0 {
+			slog.Warn(msgRetryingProxyLoad, "error", err, "attempt", attempt, "backoff", backoff.String())
+			time.Sleep(backoff)
+			backoff *= 2
+			if maxBackoff > 0 && backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		}
+
+		if err = pl.Load(); err == nil {
+			return nil
+		}
 	}
+	return err
+}
 
-	content := strings.TrimSpace(string(data))
+func (pl *ProxyLoader) Reload() error {
+	proxies, err := pl.readProxies()
+	if err == nil && len(proxies) == 0 {
+		err = errors.New(msgNoProxiesInFile)
+	}
+
+	if err != nil {
+		pl.proxyServer.SetDegraded(true)
+		slog.Warn(msgKeepingLastSnapshot, "error", err, "proxies", pl.proxyServer.ProxyCount())
+		return err
+	}
+
+	pl.proxyServer.setFileProxies(proxies)
+	pl.proxyServer.pruneTransports()
+	pl.proxyServer.SetDegraded(false)
+	slog.Info(msgProxiesLoadedSuccessfully)
+	return nil
+}
+
+func (pl *ProxyLoader) ProxyFiles() []string {
+	files := []string{pl.config().ProxyFile}
+	for _, listener := range pl.config().Proxy.Listeners {
+		if listener.ProxyFile != "" && !slices.Contains(files, listener.ProxyFile) {
+			files = append(files, listener.ProxyFile)
+		}
+	}
+	for _, tenant := range pl.config().Tenants {
+		if tenant.ProxyFile != "" && !slices.Contains(files, tenant.ProxyFile) {
+			files = append(files, tenant.ProxyFile)
+		}
+	}
+	// the mirror pool is loaded and checked like the others, no listener rotates through it
+	if mirror := pl.config().Proxy.Mirror; mirror.Enabled && mirror.ProxyFile != "" && !slices.Contains(files, mirror.ProxyFile) {
+		files = append(files, mirror.ProxyFile)
+	}
+	return files
+}
+
+func (pl *ProxyLoader) readProxies() ([]*Proxy, error) {
+	if _, _, err := tlsFingerprint(pl.config().Proxy.TLSFingerprint); err != nil {
+		return nil, err
+	}
+	proxies := make([]*Proxy, 0)
+	for _, file := range pl.ProxyFiles() {
+		pool, err := pl.readProxyFile(file)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, pool...)
+	}
+	proxies = append(proxies, pl.createChains()...)
+
+	if pl.config().Healthcheck.ValidateOnLoad {
+		proxies = NewProxyChecker(pl.proxyServer).Alive(proxies)
+		slog.Info(msgProxiesValidated, "alive", len(proxies))
+	}
+	return proxies, nil
+}
+
+func (pl *ProxyLoader) readProxyFile(file string) ([]*Proxy, error) {
+	slog.Info(msgLoadingProxies, "file", file)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", msgFailedToLoadProxies, err)
+	}
+
+	proxies := pl.parseProxies(string(data))
+	for _, proxy := range proxies {
+		proxy.Pool = file
+	}
+	return proxies, nil
+}
+
+// parseProxies reads a proxy list, one proxy per line, lines that can not be used are logged and skipped
+func (pl *ProxyLoader) parseProxies(content string) []*Proxy {
+	proxies := make([]*Proxy, 0)
+	content = strings.TrimSpace(content)
 	content = strings.ReplaceAll(content, "\r\n", "\n")
 	lines := strings.Split(content, "\n")
+	undetected := make([]string, 0)
+	undetectedTags := make(map[string][]string)
 	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
+		address, tags := parseProxyLine(line)
+		if address == "" {
 			continue
 		}
-		proxy, err := pl.CreateProxy(line)
+		if pl.config().DetectProtocol && needsDetection(address) {
+			undetected = append(undetected, address)
+			undetectedTags[address] = tags
+			continue
+		}
+		proxy, err := pl.CreateProxy(address)
 		if err != nil {
-			slog.Error(msgFailedToCreateProxy, "error", err, "proxy", line)
+			slog.Error(msgFailedToCreateProxy, "error", err, "proxy", address)
 			continue
 		}
 
-		pl.proxyServer.AddProxy(proxy)
+		proxy.Tags = tags
+		proxies = append(proxies, proxy)
 	}
 
-	slog.Info(msgProxiesLoadedSuccessfully)
-	return nil
+	for _, proxy := range pl.detectProxies(undetected) {
+		proxy.Tags = undetectedTags[proxy.Url.Host]
+		proxies = append(proxies, proxy)
+	}
+	return proxies
 }
 
-func (pl *ProxyLoader) Reload() error {
-	pl.proxyServer.Proxies = make([]*Proxy, 0)
-	return pl.Load()
+func (pl *ProxyLoader) createChains() []*Proxy {
+	chains := make([]*Proxy, 0, len(pl.config().Chains))
+	for _, chain := range pl.config().Chains {
+		proxy, err := pl.CreateChain(chain)
+		if err != nil {
+			slog.Error(msgFailedToCreateProxy, "error", err, "chain", strings.Join(chain.Hops, chainSeparator))
+			continue
+		}
+		chains = append(chains, proxy)
+	}
+	return chains
 }
 
 func (pl *ProxyLoader) CreateProxy(proxyURL string) (*Proxy, error) {
+	proxyURL = normalizeAddress(proxyURL)
 	parsedUrl, err := url.Parse(proxyURL)
 	if err != nil {
 		return nil, err
 	}
 
+	upstream := pl.upstream(proxyURL)
 	p := Proxy{
-		Scheme: parsedUrl.Scheme,
-		Host:   proxyURL,
-		Url:    parsedUrl,
+		Scheme:      parsedUrl.Scheme,
+		Host:        proxyURL,
+		Url:         parsedUrl,
+		Headers:     parseHeaders(upstream.Headers),
+		healthcheck: upstream.Healthcheck,
 	}
+	if upstream.MaxConcurrent > 0 {
+		p.slots = make(chan struct{}, upstream.MaxConcurrent)
+	}
+	p.rpm = newRPMWindow(upstream.MaxRPM)
+	p.credentials = parseCredentials(upstream.Credentials)
 
-	tr := &http.Transport{}
+	var build func() *http.Transport
 	switch p.Scheme {
 	case "socks4", "socks4a", "socks5":
-		tr = &http.Transport{
-			Dial: socks.Dial(p.Host),
+		// the handshake chains use, it reads IPv6 bound addresses and sends IPv6 targets as addresses
+		dialer := &chainDialer{
+			hops:           []*url.URL{p.Url},
+			headers:        []http.Header{nil},
+			dialer:         pl.proxyServer.netDialer(time.Duration(pl.config().Proxy.Rotation.Timeout) * time.Second),
+			resolveTargets: pl.proxyServer.resolveTargets,
+			auth:           []string{""},
+		}
+		build = func() *http.Transport {
+			return &http.Transport{DialContext: dialer.DialContext}
 		}
 	case "http", "https":
-		tr = &http.Transport{
-			Proxy: http.ProxyURL(p.Url),
+		if scheme, ok := connectionAuthScheme(upstream.Auth); ok {
+			timeout := time.Duration(pl.config().Proxy.Rotation.Timeout) * time.Second
+			build = func() *http.Transport {
+				return &http.Transport{DialContext: newNtlmDialer(p.Url, p.Headers, scheme, pl.proxyServer.netDialer(timeout)).DialContext}
+			}
+			break
+		}
+		build = func() *http.Transport {
+			return &http.Transport{
+				Proxy: func(r *http.Request) (*url.URL, error) {
+					return credentialURL(r.Context(), p.Url), nil
+				},
+				ProxyConnectHeader: p.Headers,
+				DialContext:        pl.proxyServer.netDialer(time.Duration(pl.config().Proxy.Rotation.Timeout) * time.Second).DialContext,
+			}
 		}
 	default:
 		return nil, fmt.Errorf("%s. URL: %s", msgUnsupportedProxyScheme, proxyURL)
 	}
 
-	tr.DisableKeepAlives = true
-	tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-
-	p.Transport = tr
+	key := pl.transportKey(proxyURL, upstream.Auth, upstream.Headers, upstream.Credentials)
+	p.Transport = pl.proxyServer.transports.get(key, func() *http.Transport {
+		return pl.configureTransport(build(), p.Host)
+	})
+	pl.proxyServer.locate(&p)
 	return &p, nil
 }
+
+func parseHeaders(headers []string) http.Header {
+	if len(headers) == 0 {
+		return nil
+	}
+
+	parsed := make(http.Header)
+	for _, header := range headers {
+		parts := strings.SplitN(header, ":", 2)
+		if len(parts) == 2 {
+			parsed.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+		}
+	}
+	return parsed
+}
@@ -0,0 +1,51 @@
+package proxy
+
+import "github.com/alpkeskin/rota/internal/config"
+
+const (
+	// ceilings of the low memory mode, configured values above them are lowered
+	lowMemoryMaxCerts   = 256
+	lowMemoryMaxWorkers = 4
+	lowMemoryBufferSize = 1 << 10
+	lowMemoryMaxHistory = 100
+	// a pooled connection holds its buffers while idle
+	lowMemoryMaxIdlePerHost = 1
+)
+
+func certLimit(cfg *config.Config) int {
+	if !cfg.LowMemory.Enabled {
+		return 0
+	}
+	if cfg.LowMemory.MaxCerts <= 0 {
+		return lowMemoryMaxCerts
+	}
+	return min(cfg.LowMemory.MaxCerts, lowMemoryMaxCerts)
+}
+
+func healthcheckWorkers(cfg *config.Config) int {
+	workers := cfg.Healthcheck.Workers
+	if cfg.LowMemory.Enabled && (workers <= 0 || workers > lowMemoryMaxWorkers) {
+		return lowMemoryMaxWorkers
+	}
+	return workers
+}
+
+func historySize(cfg *config.Config) int {
+	size := cfg.History.Size
+	if size <= 0 {
+		size = defaultHistorySize
+	}
+	if cfg.LowMemory.Enabled {
+		return min(size, lowMemoryMaxHistory)
+	}
+	return size
+}
+
+// logSampled reports whether a successful request is logged, low memory mode logs one of every log_sample_rate requests
+func (ps *ProxyServer) logSampled() bool {
+	rate := ps.Config().LowMemory.LogSampleRate
+	if !ps.Config().LowMemory.Enabled || rate <= 1 {
+		return true
+	}
+	return ps.requests.Add(1)%uint64(rate) == 1
+}
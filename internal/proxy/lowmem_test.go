@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLowMemoryCeilings(t *testing.T) {
+	testCases := []struct {
+		name      string
+		cfg       config.Config
+		certLimit int
+		workers   int
+	}{
+		{
+			name:      "Disabled",
+			cfg:       config.Config{Healthcheck: config.HealthcheckConfig{Workers: 20}},
+			certLimit: 0,
+			workers:   20,
+		},
+		{
+			name: "Defaults",
+			cfg: config.Config{
+				LowMemory:   config.LowMemoryConfig{Enabled: true},
+				Healthcheck: config.HealthcheckConfig{Workers: 20},
+			},
+			certLimit: lowMemoryMaxCerts,
+			workers:   lowMemoryMaxWorkers,
+		},
+		{
+			name: "Below ceilings",
+			cfg: config.Config{
+				LowMemory:   config.LowMemoryConfig{Enabled: true, MaxCerts: 10},
+				Healthcheck: config.HealthcheckConfig{Workers: 2},
+			},
+			certLimit: 10,
+			workers:   2,
+		},
+		{
+			name: "Above ceilings",
+			cfg: config.Config{
+				LowMemory: config.LowMemoryConfig{Enabled: true, MaxCerts: 10000},
+			},
+			certLimit: lowMemoryMaxCerts,
+			workers:   lowMemoryMaxWorkers,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.certLimit, certLimit(&tc.cfg))
+			assert.Equal(t, tc.workers, healthcheckWorkers(&tc.cfg))
+		})
+	}
+}
+
+func TestLogSampled(t *testing.T) {
+	ps := NewProxyServer(&config.Config{LowMemory: config.LowMemoryConfig{Enabled: true, LogSampleRate: 3}})
+	var logged []bool
+	for range 6 {
+		logged = append(logged, ps.logSampled())
+	}
+	assert.Equal(t, []bool{true, false, false, true, false, false}, logged)
+
+	ps = NewProxyServer(&config.Config{LowMemory: config.LowMemoryConfig{Enabled: false, LogSampleRate: 3}})
+	for range 3 {
+		assert.True(t, ps.logSampled())
+	}
+}
@@ -0,0 +1,243 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/rand"
+)
+
+const (
+	msgMirrorFailed = "mirrored request failed"
+
+	// mirrors past this many in flight are dropped, shadow traffic must not pile up behind a slow candidate
+	maxMirrorsInFlight = 64
+)
+
+// mirrorTable holds the comparison of sampled requests with their mirrors since startup
+type mirrorTable struct {
+	mu         sync.Mutex
+	inFlight   chan struct{}
+	primary    mirrorSide
+	mirror     mirrorSide
+	proxies    map[string]*mirrorSide
+	mismatches uint64
+	dropped    uint64
+}
+
+type mirrorSide struct {
+	requests uint64
+	errors   uint64
+	duration time.Duration
+}
+
+// MirrorSide is how one side of the mirrored requests did, errors include failed requests and 5xx answers
+type MirrorSide struct {
+	Requests    uint64 `json:"requests"`
+	Errors      uint64 `json:"errors"`
+	AvgResponse int64  `json:"avg_response_time"`
+}
+
+type MirrorProxy struct {
+	Proxy string `json:"proxy"`
+	MirrorSide
+}
+
+// MirrorStats compares the main pool with the mirror pool on the same requests
+type MirrorStats struct {
+	Enabled          bool          `json:"enabled"`
+	Percent          float64       `json:"percent"`
+	Pool             string        `json:"pool"`
+	Primary          MirrorSide    `json:"primary"`
+	Mirror           MirrorSide    `json:"mirror"`
+	StatusMismatches uint64        `json:"status_mismatches"`
+	Dropped          uint64        `json:"dropped"`
+	Proxies          []MirrorProxy `json:"proxies"`
+}
+
+// mirrorOutcome is the status code and time to the response headers of one side, 0 when it failed
+type mirrorOutcome struct {
+	statusCode int
+	duration   time.Duration
+}
+
+func (o mirrorOutcome) failed() bool {
+	return o.statusCode == 0 || o.statusCode >= http.StatusInternalServerError
+}
+
+func (s *mirrorSide) add(outcome mirrorOutcome) {
+	s.requests++
+	s.duration += outcome.duration
+	if outcome.failed() {
+		s.errors++
+	}
+}
+
+func (s *mirrorSide) export() MirrorSide {
+	side := MirrorSide{Requests: s.requests, Errors: s.errors}
+	if s.requests > 0 {
+		side.AvgResponse = (s.duration / time.Duration(s.requests)).Milliseconds()
+	}
+	return side
+}
+
+// shouldMirror samples proxy.mirror.percent of the requests that are safe to send twice while the mirror feature flag
+// is on. Tenant requests stay in the tenant's pool and direct routes have no proxy to compare with
+func (ps *ProxyServer) shouldMirror(reqInfo requestInfo) bool {
+	listener := ps.listenerFor(reqInfo)
+	mirror := listener.cfg.Proxy.Mirror
+	r := reqInfo.request
+	if !mirror.Enabled || mirror.ProxyFile == "" || mirror.Percent <= 0 || !ps.features.Enabled(FeatureMirror) {
+		return false
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if r.Body != nil && r.Body != http.NoBody {
+		return false
+	}
+	if listener.tenant != "" || ps.route(r.URL.Host, listener).direct {
+		return false
+	}
+	return rand.Float64()*100 < mirror.Percent
+}
+
+// startMirror sends a copy of the request through the mirror pool and returns the channel the primary outcome is
+// reported on, nil when the request is not mirrored
+func (ps *ProxyServer) startMirror(reqInfo requestInfo) chan<- mirrorOutcome {
+	if !ps.shouldMirror(reqInfo) {
+		return nil
+	}
+	select {
+	case ps.mirrors.slots() <- struct{}{}:
+	default:
+		ps.mirrors.drop()
+		return nil
+	}
+
+	listener := ps.listenerFor(reqInfo)
+	r := reqInfo.request.Clone(context.Background())
+	r.RequestURI = ""
+	ps.removeHopHeaders(r)
+	primary := make(chan mirrorOutcome, 1)
+	go func() {
+		defer func() { <-ps.mirrors.slots() }()
+		proxy, outcome := ps.sendMirror(r, listener)
+		if proxy == nil {
+			return
+		}
+		ps.mirrors.record(<-primary, outcome, proxy.Host)
+	}()
+	return primary
+}
+
+// sendMirror sends r through the next proxy of the mirror pool and discards the response, nil when none is free
+func (ps *ProxyServer) sendMirror(r *http.Request, listener *listenerConfig) (*Proxy, mirrorOutcome) {
+	rotation := listener.rotation
+	filter := proxyFilter{pool: listener.cfg.Proxy.Mirror.ProxyFile}
+	proxy := ps.selectProxy(rotation, filter, newPickKey(r.URL.Hostname(), 0))
+	if proxy == nil || !proxy.acquire() {
+		ps.mirrors.drop()
+		return nil, mirrorOutcome{}
+	}
+	defer proxy.release()
+
+	ctx := context.Background()
+	if rotation.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(rotation.Timeout)*time.Second)
+		defer cancel()
+	}
+	r = r.WithContext(withCredential(ctx, proxy.credentials.pick()))
+	ps.addUpstreamHeaders(proxy, r)
+
+	sentAt := time.Now()
+	// the transport is used as it is, a redirect would be a request the primary never sent
+	response, err := proxy.Transport.RoundTrip(r)
+	outcome := mirrorOutcome{duration: time.Since(sentAt)}
+	if err != nil {
+		slog.Debug(msgMirrorFailed, "error", err, "proxy", proxy.Host, "url", r.URL.String())
+	} else {
+		outcome.statusCode = response.StatusCode
+		_, _ = io.Copy(io.Discard, response.Body)
+		response.Body.Close()
+		proxy.latency.observe(outcome.duration)
+	}
+	ps.stats.ObserveProxy(proxy.Host, err == nil)
+	ps.recordResult(proxy, err == nil)
+	return proxy, outcome
+}
+
+// finishMirror reports the primary outcome of a mirrored request
+func finishMirror(primary chan<- mirrorOutcome, startAt time.Time, response *http.Response, err error) {
+	if primary == nil {
+		return
+	}
+	outcome := mirrorOutcome{duration: time.Since(startAt)}
+	if err == nil && response != nil {
+		outcome.statusCode = response.StatusCode
+	}
+	primary <- outcome
+}
+
+func (t *mirrorTable) slots() chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.inFlight == nil {
+		t.inFlight = make(chan struct{}, maxMirrorsInFlight)
+	}
+	return t.inFlight
+}
+
+func (t *mirrorTable) drop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.dropped++
+}
+
+func (t *mirrorTable) record(primary, mirror mirrorOutcome, proxy string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.primary.add(primary)
+	t.mirror.add(mirror)
+	if t.proxies == nil {
+		t.proxies = make(map[string]*mirrorSide)
+	}
+	side, ok := t.proxies[proxy]
+	if !ok {
+		side = &mirrorSide{}
+		t.proxies[proxy] = side
+	}
+	side.add(mirror)
+	if primary.statusCode != mirror.statusCode {
+		t.mismatches++
+	}
+}
+
+// MirrorStats returns the comparison of the mirrored requests since startup
+func (ps *ProxyServer) MirrorStats() MirrorStats {
+	mirror := ps.Config().Proxy.Mirror
+	ps.mirrors.mu.Lock()
+	defer ps.mirrors.mu.Unlock()
+	stats := MirrorStats{
+		Enabled:          mirror.Enabled,
+		Percent:          mirror.Percent,
+		Pool:             mirror.ProxyFile,
+		Primary:          ps.mirrors.primary.export(),
+		Mirror:           ps.mirrors.mirror.export(),
+		StatusMismatches: ps.mirrors.mismatches,
+		Dropped:          ps.mirrors.dropped,
+		Proxies:          make([]MirrorProxy, 0, len(ps.mirrors.proxies)),
+	}
+	for host, side := range ps.mirrors.proxies {
+		stats.Proxies = append(stats.Proxies, MirrorProxy{Proxy: host, MirrorSide: side.export()})
+	}
+	slices.SortFunc(stats.Proxies, func(a, b MirrorProxy) int { return strings.Compare(a.Proxy, b.Proxy) })
+	return stats
+}
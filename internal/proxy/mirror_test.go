@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMirror(t *testing.T) {
+	main := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("main"))
+	}))
+	defer main.Close()
+	var mirrored atomic.Int32
+	candidate := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mirrored.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer candidate.Close()
+
+	cfg := &config.Config{
+		ProxyFile: "proxies.txt",
+		Proxy: config.ProxyConfig{
+			Rotation: config.ProxyRotationConfig{Method: "roundrobin", Retries: 1, Timeout: 5, FallbackMaxRetries: 1},
+			Mirror:   config.ProxyMirrorConfig{Enabled: true, Percent: 100, ProxyFile: "candidates.txt"},
+		},
+	}
+	ps := NewProxyServer(cfg)
+	pl := NewProxyLoader(ps)
+	assert.Equal(t, []string{"proxies.txt", "candidates.txt"}, pl.ProxyFiles())
+	for pool, u := range map[string]string{"proxies.txt": main.URL, "candidates.txt": candidate.URL} {
+		proxy, err := pl.CreateProxy(u)
+		require.NoError(t, err)
+		proxy.Pool = pool
+		ps.AddProxy(proxy)
+	}
+	ps.setUpHandlers()
+	server := httptest.NewServer(ps.goProxy)
+	defer server.Close()
+	proxyURL, _ := url.Parse(server.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	for range 2 {
+		response, err := client.Get("http://example.com/")
+		require.NoError(t, err)
+		body, _ := io.ReadAll(response.Body)
+		response.Body.Close()
+		assert.Equal(t, "main", string(body), "the client gets the main pool's answer")
+	}
+	response, err := client.Post("http://example.com/", "text/plain", strings.NewReader("once"))
+	require.NoError(t, err)
+	response.Body.Close()
+
+	require.Eventually(t, func() bool { return ps.MirrorStats().Mirror.Requests == 2 }, time.Second, time.Millisecond)
+	stats := ps.MirrorStats()
+	assert.Equal(t, int32(2), mirrored.Load(), "requests with a body are not sent twice")
+	assert.Equal(t, uint64(2), stats.Primary.Requests)
+	assert.Zero(t, stats.Primary.Errors)
+	assert.Equal(t, uint64(2), stats.Mirror.Errors)
+	assert.Equal(t, uint64(2), stats.StatusMismatches)
+	assert.Equal(t, "candidates.txt", stats.Pool)
+	require.Len(t, stats.Proxies, 1)
+	assert.Equal(t, candidate.URL, stats.Proxies[0].Proxy)
+	assert.Equal(t, uint64(2), stats.Proxies[0].Requests)
+}
+
+func TestShouldMirror(t *testing.T) {
+	cfg := &config.Config{
+		ProxyFile: "proxies.txt",
+		Proxy:     config.ProxyConfig{Mirror: config.ProxyMirrorConfig{Enabled: true, Percent: 100, ProxyFile: "candidates.txt"}},
+		Routing:   []config.RoutingRuleConfig{{Hosts: []string{"direct.example.com"}, Direct: true}},
+	}
+	ps := NewProxyServer(cfg)
+	mirrors := func(method, target string, listener *listenerConfig) bool {
+		r := httptest.NewRequest(method, target, nil)
+		return ps.shouldMirror(requestInfo{request: r, listener: listener})
+	}
+
+	assert.True(t, mirrors(http.MethodGet, "http://example.com/", nil))
+	assert.True(t, mirrors(http.MethodHead, "http://example.com/", nil))
+	assert.False(t, mirrors(http.MethodDelete, "http://example.com/", nil))
+	assert.False(t, mirrors(http.MethodGet, "http://direct.example.com/", nil))
+	assert.False(t, mirrors(http.MethodGet, "http://example.com/", &listenerConfig{cfg: cfg, pool: "tenant.txt", tenant: "acme"}))
+
+	ps.Features().Set(FeatureMirror, false)
+	assert.False(t, mirrors(http.MethodGet, "http://example.com/", nil))
+	ps.Features().Set(FeatureMirror, true)
+
+	cfg.Proxy.Mirror.Percent = 0
+	assert.False(t, mirrors(http.MethodGet, "http://example.com/", nil))
+}
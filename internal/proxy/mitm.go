@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+
+	"github.com/elazarl/goproxy"
+)
+
+const (
+	msgFailedToLoadMitmCA   = "failed to load mitm ca"
+	msgMissingMitmCAKeyPair = "mitm needs both ca_cert_file and ca_key_file"
+	msgMitmCertNotCA        = "mitm ca_cert_file is not a ca certificate"
+)
+
+// LoadMitmCA reads the CA that signs the certificates of intercepted HTTPS tunnels. Without files goproxy's
+// built-in CA is used, its key is public so clients trusting it trust anyone holding it
+func (ps *ProxyServer) LoadMitmCA() error {
+	cfg := ps.Config().Proxy.Mitm
+	switch {
+	case cfg.CaCertFile == "" && cfg.CaKeyFile == "":
+		return nil
+	case cfg.CaCertFile == "" || cfg.CaKeyFile == "":
+		return errors.New(msgMissingMitmCAKeyPair)
+	}
+
+	ca, err := tls.LoadX509KeyPair(cfg.CaCertFile, cfg.CaKeyFile)
+	if err != nil {
+		return fmt.Errorf("%s: %w", msgFailedToLoadMitmCA, err)
+	}
+	// clients reject leaf certificates signed by a certificate that may not sign others
+	if !ca.Leaf.IsCA {
+		return errors.New(msgMitmCertNotCA)
+	}
+	ps.mitm = &goproxy.ConnectAction{Action: goproxy.ConnectMitm, TLSConfig: goproxy.TLSConfigFromCA(&ca)}
+	return nil
+}
+
+// mitmConnect returns the action intercepting a tunnel, signed by the configured CA when there is one
+func (ps *ProxyServer) mitmConnect() *goproxy.ConnectAction {
+	if ps.mitm != nil {
+		return ps.mitm
+	}
+	return goproxy.MitmConnect
+}
@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/elazarl/goproxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeKeyPair(t *testing.T, dir, name string, isCA bool) (string, string, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, name+".pem")
+	keyFile := filepath.Join(dir, name+"-key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0o600))
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return certFile, keyFile, leaf
+}
+
+func TestLoadMitmCA(t *testing.T) {
+	dir := t.TempDir()
+	caCert, caKey, ca := writeKeyPair(t, dir, "ca", true)
+	leafCert, leafKey, _ := writeKeyPair(t, dir, "leaf", false)
+
+	tests := []struct {
+		name    string
+		mitm    config.ProxyMitmConfig
+		wantErr string
+		wantCA  bool
+	}{
+		{"built-in", config.ProxyMitmConfig{}, "", false},
+		{"ca", config.ProxyMitmConfig{CaCertFile: caCert, CaKeyFile: caKey}, "", true},
+		{"cert without key", config.ProxyMitmConfig{CaCertFile: caCert}, msgMissingMitmCAKeyPair, false},
+		{"not a ca", config.ProxyMitmConfig{CaCertFile: leafCert, CaKeyFile: leafKey}, msgMitmCertNotCA, false},
+		{"missing files", config.ProxyMitmConfig{CaCertFile: "missing.pem", CaKeyFile: "missing.pem"}, msgFailedToLoadMitmCA, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := NewProxyServer(&config.Config{Proxy: config.ProxyConfig{Mitm: tt.mitm}})
+			err := ps.LoadMitmCA()
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantCA, ps.mitmConnect() != goproxy.MitmConnect)
+			assert.True(t, ps.mitmConnect().Action == goproxy.ConnectMitm)
+		})
+	}
+
+	// intercepted hosts get certificates signed by the configured ca
+	ps := NewProxyServer(&config.Config{Proxy: config.ProxyConfig{Mitm: config.ProxyMitmConfig{CaCertFile: caCert, CaKeyFile: caKey}}})
+	require.NoError(t, ps.LoadMitmCA())
+	tlsConfig, err := ps.mitmConnect().TLSConfig("example.com:443", &goproxy.ProxyCtx{Proxy: newGoProxy()})
+	require.NoError(t, err)
+	require.Len(t, tlsConfig.Certificates, 1)
+	leaf, err := x509.ParseCertificate(tlsConfig.Certificates[0].Certificate[0])
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	_, err = leaf.Verify(x509.VerifyOptions{DNSName: "example.com", Roots: roots})
+	assert.NoError(t, err)
+}
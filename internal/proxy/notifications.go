@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"time"
+
+	"github.com/alpkeskin/rota/internal/notify"
+)
+
+func (ps *ProxyServer) notifyProxy(eventType string, proxy *Proxy) {
+	if ps.notifier == nil {
+		return
+	}
+
+	healthy, total := ps.healthyCount()
+	ps.notifier.Notify(notify.Event{
+		Type:    eventType,
+		Proxy:   proxy.Host,
+		Pool:    proxy.Pool,
+		Healthy: healthy,
+		Total:   total,
+	})
+	ps.checkPoolThreshold()
+}
+
+// checkPoolThreshold sends pool_below_threshold once when the healthy proxies drop below notifications.pool_threshold, it is sent again only after the pool recovered
+func (ps *ProxyServer) checkPoolThreshold() {
+	threshold := ps.Config().Notifications.PoolThreshold
+	if ps.notifier == nil || threshold <= 0 {
+		return
+	}
+
+	healthy, total := ps.healthyCount()
+	if healthy >= threshold {
+		ps.poolLow.Store(false)
+		return
+	}
+	if ps.poolLow.Swap(true) {
+		return
+	}
+	ps.notifier.Notify(notify.Event{
+		Type:      notify.EventPoolBelowThreshold,
+		Healthy:   healthy,
+		Total:     total,
+		Threshold: threshold,
+	})
+}
+
+// healthyCount returns the number of proxies whose circuit is not open and the pool size
+func (ps *ProxyServer) healthyCount() (int, int) {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	now := time.Now()
+	cooldown := ps.breakerCooldown()
+	healthy := 0
+	for _, p := range ps.Proxies {
+		if p.breaker.allows(now, cooldown) {
+			healthy++
+		}
+	}
+	return healthy, len(ps.Proxies)
+}
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/alpkeskin/rota/internal/notify"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyNotifications(t *testing.T) {
+	var mu sync.Mutex
+	var events []notify.Event
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event notify.Event
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}))
+	defer webhook.Close()
+
+	cfg := &config.Config{
+		Proxy: config.ProxyConfig{
+			CircuitBreaker: config.CircuitBreakerConfig{Failures: 1, Cooldown: 60},
+		},
+		Notifications: config.NotificationsConfig{
+			PoolThreshold: 2,
+			Webhooks:      []config.WebhookConfig{{URL: webhook.URL}},
+		},
+	}
+	ps := NewProxyServer(cfg)
+	bad := &Proxy{Host: "bad:1", Pool: "proxies.txt"}
+	ps.SetProxies([]*Proxy{bad, {Host: "good:1"}})
+
+	ps.recordResult(bad, false)
+	ps.recordResult(bad, false)
+	ps.recordResult(bad, true)
+	ps.recordResult(bad, false)
+	ps.notifier.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	types := make([]string, 0, len(events))
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	// the pool alert is sent again because the pool recovered in between
+	assert.Equal(t, []string{
+		notify.EventProxyFailed,
+		notify.EventPoolBelowThreshold,
+		notify.EventProxyRecovered,
+		notify.EventProxyFailed,
+		notify.EventPoolBelowThreshold,
+	}, types)
+	assert.Equal(t, "bad:1", events[0].Proxy)
+	assert.Equal(t, "proxies.txt", events[0].Pool)
+	assert.Equal(t, 1, events[0].Healthy)
+	assert.Equal(t, 2, events[0].Total)
+	assert.Equal(t, 2, events[1].Threshold)
+}
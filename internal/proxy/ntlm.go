@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-ntlmssp"
+)
+
+const (
+	ntlmAuthScheme      = "NTLM"
+	negotiateAuthScheme = "Negotiate"
+
+	msgNtlmChallengeMissing  = "ntlm challenge missing"
+	msgUpstreamConnectFailed = "upstream proxy connect failed"
+)
+
+// NTLM authenticates the connection, not the request, so the handshake runs on the tunnel itself
+type ntlmDialer struct {
+	proxyURL *url.URL
+	headers  http.Header
+	scheme   string
+	dialer   *net.Dialer
+}
+
+func newNtlmDialer(proxyURL *url.URL, headers http.Header, scheme string, dialer *net.Dialer) *ntlmDialer {
+	return &ntlmDialer{
+		proxyURL: proxyURL,
+		headers:  headers,
+		scheme:   scheme,
+		dialer:   dialer,
+	}
+}
+
+// connectionAuthScheme returns the scheme of an upstreams auth setting that authenticates the connection.
+// Negotiate proxies get NTLM tokens, which SPNEGO accepts when Kerberos is not available
+func connectionAuthScheme(auth string) (string, bool) {
+	switch {
+	case strings.EqualFold(auth, ntlmAuthScheme):
+		return ntlmAuthScheme, true
+	case strings.EqualFold(auth, negotiateAuthScheme):
+		return negotiateAuthScheme, true
+	}
+	return "", false
+}
+
+func (d *ntlmDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := d.dialer.DialContext(ctx, network, hopAddr(d.proxyURL))
+	if err != nil {
+		return nil, err
+	}
+
+	if d.proxyURL.Scheme == "https" {
+		conn = tls.Client(conn, &tls.Config{ServerName: d.proxyURL.Hostname(), InsecureSkipVerify: true})
+	}
+
+	if d.dialer.Timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(d.dialer.Timeout))
+	}
+
+	tunnel, err := ntlmConnect(conn, credentialURL(ctx, d.proxyURL), d.headers, d.scheme, addr)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	_ = tunnel.SetDeadline(time.Time{})
+	return tunnel, nil
+}
+
+// ntlmConnect opens a CONNECT tunnel to addr on conn with the NTLM handshake, in the header of the given scheme
+func ntlmConnect(conn net.Conn, proxyURL *url.URL, headers http.Header, scheme, addr string) (net.Conn, error) {
+	br := bufio.NewReader(conn)
+	var username, password string
+	if proxyURL.User != nil {
+		username = proxyURL.User.Username()
+		password, _ = proxyURL.User.Password()
+	}
+	user, domain, domainNeeded := ntlmssp.GetDomain(username)
+
+	negotiate, err := ntlmssp.NewNegotiateMessage(domain, "")
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := ntlmRequest(conn, br, headers, scheme, addr, negotiate)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusProxyAuthRequired {
+		challenge, err := ntlmChallenge(resp.Header.Values("Proxy-Authenticate"), scheme)
+		if err != nil {
+			return nil, err
+		}
+
+		authenticate, err := ntlmssp.ProcessChallenge(challenge, user, password, domainNeeded)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err = ntlmRequest(conn, br, headers, scheme, addr, authenticate)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", msgUpstreamConnectFailed, resp.Status)
+	}
+	// bytes the proxy sent right after its response belong to the tunnel
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+func ntlmRequest(conn net.Conn, br *bufio.Reader, headers http.Header, scheme, addr string, token []byte) (*http.Response, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	for name, values := range headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("Proxy-Authorization", scheme+" "+base64.StdEncoding.EncodeToString(token))
+	req.Header.Set("Proxy-Connection", "Keep-Alive")
+
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// the challenge body has to be consumed so the next leg can reuse the connection
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	return resp, nil
+}
+
+func ntlmChallenge(headers []string, scheme string) ([]byte, error) {
+	for _, header := range headers {
+		name, data, _ := strings.Cut(header, " ")
+		if !strings.EqualFold(name, scheme) || data == "" {
+			continue
+		}
+		return base64.StdEncoding.DecodeString(strings.TrimSpace(data))
+	}
+	return nil, errors.New(msgNtlmChallengeMissing)
+}
@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ntlmTestChallenge() []byte {
+	var b bytes.Buffer
+	b.WriteString("NTLMSSP\x00")
+	_ = binary.Write(&b, binary.LittleEndian, uint32(2))
+	b.Write(make([]byte, 8))
+	_ = binary.Write(&b, binary.LittleEndian, uint32(0x00000201))
+	b.WriteString("01234567")
+	b.Write(make([]byte, 16))
+	return b.Bytes()
+}
+
+func ntlmMessageType(header, scheme string) uint32 {
+	token, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(header, scheme+" "))
+	if err != nil || len(token) < 12 || !bytes.HasPrefix(token, []byte("NTLMSSP\x00")) {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(token[8:12])
+}
+
+// serveNtlmProxy runs one NTLM handshake in the given scheme and then answers the request sent through the tunnel
+func serveNtlmProxy(listener net.Listener, scheme string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+
+	req, err := http.ReadRequest(br)
+	if err != nil || ntlmMessageType(req.Header.Get("Proxy-Authorization"), scheme) != 1 {
+		fmt.Fprint(conn, "HTTP/1.1 400 Bad Request\r\nContent-Length: 0\r\n\r\n")
+		return
+	}
+	challenge := base64.StdEncoding.EncodeToString(ntlmTestChallenge())
+	fmt.Fprintf(conn, "HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: Basic realm=\"proxy\"\r\nProxy-Authenticate: %s %s\r\nContent-Length: 4\r\n\r\ndeny", scheme, challenge)
+
+	req, err = http.ReadRequest(br)
+	if err != nil || ntlmMessageType(req.Header.Get("Proxy-Authorization"), scheme) != 3 {
+		fmt.Fprint(conn, "HTTP/1.1 407 Proxy Authentication Required\r\nContent-Length: 0\r\n\r\n")
+		return
+	}
+	fmt.Fprint(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+
+	if _, err := http.ReadRequest(br); err != nil {
+		return
+	}
+	fmt.Fprint(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+}
+
+func TestNtlmDialer(t *testing.T) {
+	for _, scheme := range []string{ntlmAuthScheme, negotiateAuthScheme} {
+		t.Run(scheme, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			assert.NoError(t, err)
+			defer listener.Close()
+			go serveNtlmProxy(listener, scheme)
+
+			proxyURL, _ := url.Parse(fmt.Sprintf("http://%s:secret@%s", url.PathEscape(`CORP\user`), listener.Addr().String()))
+			client := &http.Client{
+				Transport: &http.Transport{
+					DialContext: newNtlmDialer(proxyURL, nil, scheme, &net.Dialer{Timeout: 5 * time.Second}).DialContext,
+				},
+			}
+
+			resp, err := client.Get("http://example.com/")
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			body, _ := io.ReadAll(resp.Body)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, "ok", string(body))
+		})
+	}
+}
+
+func TestNtlmChainHop(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go serveNtlmProxy(listener, negotiateAuthScheme)
+	var tunnels atomic.Int64
+	entry := newConnectProxy(t, "", &tunnels)
+
+	exit := fmt.Sprintf("http://%s:secret@%s", url.PathEscape(`CORP\user`), listener.Addr().String())
+	cfg := &config.Config{Upstreams: map[string]config.UpstreamConfig{exit: {Auth: "negotiate"}}}
+	proxy, err := NewProxyLoader(NewProxyServer(cfg)).CreateChain(config.ChainConfig{Hops: []string{entry.URL, exit}})
+	require.NoError(t, err)
+
+	resp, err := (&http.Client{Transport: proxy.Transport}).Get("http://example.com/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "ok", string(body))
+	assert.Equal(t, int64(1), tunnels.Load())
+}
+
+func TestConnectionAuthScheme(t *testing.T) {
+	for auth, want := range map[string]string{"ntlm": ntlmAuthScheme, "NTLM": ntlmAuthScheme, "negotiate": negotiateAuthScheme, "": "", "basic": ""} {
+		scheme, ok := connectionAuthScheme(auth)
+		assert.Equal(t, want, scheme, auth)
+		assert.Equal(t, want != "", ok, auth)
+	}
+}
+
+func TestNtlmChallenge(t *testing.T) {
+	challenge, err := ntlmChallenge([]string{"Basic realm=\"proxy\"", "NTLM " + base64.StdEncoding.EncodeToString([]byte("data"))}, ntlmAuthScheme)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data"), challenge)
+
+	_, err = ntlmChallenge([]string{"NTLM"}, ntlmAuthScheme)
+	assert.Error(t, err)
+	_, err = ntlmChallenge([]string{"NTLM " + base64.StdEncoding.EncodeToString([]byte("data"))}, negotiateAuthScheme)
+	assert.Error(t, err, "a negotiate handshake only reads negotiate challenges")
+}
@@ -0,0 +1,213 @@
+package proxy
+
+import (
+	"cmp"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	msgPeriodicCheckStarted     = "periodic healthcheck started"
+	msgPeriodicCheckFinished    = "periodic healthcheck finished"
+	msgPeriodicCheckSkipped     = "periodic healthcheck paused, skipping run"
+	msgIncrementalCheckFinished = "incremental healthcheck batch finished"
+
+	defaultIncrementalRate = 10
+)
+
+type HealthcheckSummary struct {
+	StartedAt time.Time `json:"started_at"`
+	Duration  float64   `json:"duration_seconds"`
+	Checked   int       `json:"checked"`
+	Alive     int       `json:"alive"`
+	Dead      int       `json:"dead"`
+	Removed   int       `json:"removed"`
+}
+
+type HealthcheckStatus struct {
+	Interval    int                 `json:"interval"`
+	Incremental bool                `json:"incremental"`
+	Paused      bool                `json:"paused"`
+	NextRun     *time.Time          `json:"next_run"`
+	LastRun     *HealthcheckSummary `json:"last_run"`
+}
+
+type periodicState struct {
+	mu          sync.RWMutex
+	interval    int
+	incremental bool
+	paused      bool
+	nextRun     time.Time
+	lastRun     *HealthcheckSummary
+}
+
+// RunPeriodic checks the whole pool every healthcheck.interval seconds, it returns right away when no interval is set.
+// With healthcheck.incremental the pool is checked in small batches instead
+func (pl *ProxyChecker) RunPeriodic() {
+	interval := pl.config().Healthcheck.Interval
+	if interval <= 0 {
+		return
+	}
+	if pl.config().Healthcheck.Incremental.Enabled {
+		pl.runIncremental(interval)
+		return
+	}
+
+	state := &pl.proxyServer.healthchecks
+	every := time.Duration(interval) * time.Second
+	state.start(interval, false, time.Now().Add(every))
+
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for range ticker.C {
+		state.scheduled(time.Now().Add(every))
+		if state.isPaused() {
+			slog.Info(msgPeriodicCheckSkipped)
+			continue
+		}
+		state.finished(pl.CheckPool())
+	}
+}
+
+// runIncremental checks up to healthcheck.incremental.rate proxies a second, those checked longest ago first. Every
+// proxy is due again interval seconds after its last check, so the pool is covered about once per interval without the
+// burst of a full sweep
+func (pl *ProxyChecker) runIncremental(interval int) {
+	rate := pl.config().Healthcheck.Incremental.Rate
+	if rate <= 0 {
+		rate = defaultIncrementalRate
+	}
+	state := &pl.proxyServer.healthchecks
+	every := time.Duration(interval) * time.Second
+	state.start(interval, true, time.Now().Add(time.Second))
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		state.scheduled(now.Add(time.Second))
+		if state.isPaused() {
+			continue
+		}
+		batch := pl.proxyServer.dueProxies(now.Add(-every), rate)
+		if len(batch) == 0 {
+			continue
+		}
+		summary := pl.checkProxies(batch)
+		state.finished(summary)
+		slog.Debug(msgIncrementalCheckFinished,
+			"checked", summary.Checked,
+			"alive", summary.Alive,
+			"dead", summary.Dead,
+			"removed", summary.Removed,
+		)
+	}
+}
+
+// dueProxies returns at most limit proxies last checked before due, the oldest check first and never checked proxies
+// before all others
+func (ps *ProxyServer) dueProxies(due time.Time, limit int) []*Proxy {
+	proxies := make([]*Proxy, 0)
+	for _, proxy := range ps.GetProxies() {
+		if proxy.lastChecked().Before(due) {
+			proxies = append(proxies, proxy)
+		}
+	}
+	slices.SortStableFunc(proxies, func(a, b *Proxy) int {
+		return cmp.Compare(a.lastCheck.Load(), b.lastCheck.Load())
+	})
+	return proxies[:min(len(proxies), limit)]
+}
+
+// CheckPool checks every proxy in the pool once. Results feed the circuit breaker and dead proxies are removed with rotation.remove_unhealthy
+func (pl *ProxyChecker) CheckPool() HealthcheckSummary {
+	slog.Info(msgPeriodicCheckStarted)
+	summary := pl.checkProxies(pl.proxyServer.GetProxies())
+	slog.Info(msgPeriodicCheckFinished,
+		"checked", summary.Checked,
+		"alive", summary.Alive,
+		"dead", summary.Dead,
+		"removed", summary.Removed,
+	)
+	return summary
+}
+
+func (pl *ProxyChecker) checkProxies(proxies []*Proxy) HealthcheckSummary {
+	summary := HealthcheckSummary{StartedAt: time.Now()}
+	alive := make(map[*Proxy]bool, len(proxies))
+	for _, proxy := range pl.Alive(proxies) {
+		alive[proxy] = true
+	}
+
+	for _, proxy := range proxies {
+		// hostnames may point somewhere else by now
+		pl.proxyServer.locate(proxy)
+		pl.proxyServer.recordResult(proxy, alive[proxy])
+		if alive[proxy] {
+			continue
+		}
+		if pl.config().Proxy.Rotation.RemoveUnhealthy {
+			pl.proxyServer.removeUnhealthyProxy(proxy)
+			summary.Removed++
+		}
+	}
+
+	summary.Checked = len(proxies)
+	summary.Alive = len(alive)
+	summary.Dead = summary.Checked - summary.Alive
+	summary.Duration = time.Since(summary.StartedAt).Seconds()
+	pl.proxyServer.checkPoolThreshold()
+	return summary
+}
+
+func (ps *ProxyServer) HealthcheckStatus() HealthcheckStatus {
+	return ps.healthchecks.status()
+}
+
+func (ps *ProxyServer) PauseHealthchecks(paused bool) {
+	ps.healthchecks.setPaused(paused)
+}
+
+func (s *periodicState) start(interval int, incremental bool, nextRun time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.interval = interval
+	s.incremental = incremental
+	s.nextRun = nextRun
+}
+
+func (s *periodicState) scheduled(nextRun time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextRun = nextRun
+}
+
+func (s *periodicState) finished(summary HealthcheckSummary) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastRun = &summary
+}
+
+func (s *periodicState) setPaused(paused bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = paused
+}
+
+func (s *periodicState) isPaused() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.paused
+}
+
+func (s *periodicState) status() HealthcheckStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	status := HealthcheckStatus{Interval: s.interval, Incremental: s.incremental, Paused: s.paused, LastRun: s.lastRun}
+	if s.interval > 0 {
+		nextRun := s.nextRun
+		status.NextRun = &nextRun
+	}
+	return status
+}
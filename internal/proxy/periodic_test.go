@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckPool(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name            string
+		removeUnhealthy bool
+		wantRemoved     int
+		wantPool        int
+	}{
+		{"dead proxies stay in the pool", false, 0, 2},
+		{"dead proxies are removed", true, 1, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				Proxy: config.ProxyConfig{
+					Rotation:       config.ProxyRotationConfig{RemoveUnhealthy: tt.removeUnhealthy},
+					CircuitBreaker: config.CircuitBreakerConfig{Failures: 1, Cooldown: 60},
+				},
+				Healthcheck: config.HealthcheckConfig{
+					URL:     "http://example.com/",
+					Status:  http.StatusOK,
+					Timeout: 2,
+					Workers: 2,
+				},
+			}
+			ps := NewProxyServer(cfg)
+			pl := NewProxyLoader(ps)
+			alive, err := pl.CreateProxy(upstream.URL)
+			assert.NoError(t, err)
+			dead, err := pl.CreateProxy("http://127.0.0.1:1")
+			assert.NoError(t, err)
+			ps.SetProxies([]*Proxy{alive, dead})
+
+			summary := NewProxyChecker(ps).CheckPool()
+			assert.Equal(t, 2, summary.Checked)
+			assert.Equal(t, 1, summary.Alive)
+			assert.Equal(t, 1, summary.Dead)
+			assert.Equal(t, tt.wantRemoved, summary.Removed)
+			assert.Equal(t, tt.wantPool, ps.ProxyCount())
+			assert.Equal(t, CircuitClosed, ps.CircuitState(alive))
+			assert.Equal(t, CircuitOpen, ps.CircuitState(dead))
+		})
+	}
+}
+
+func TestRunPeriodicDisabled(t *testing.T) {
+	ps := NewProxyServer(&config.Config{})
+	// returns right away without an interval
+	NewProxyChecker(ps).RunPeriodic()
+	assert.Nil(t, ps.HealthcheckStatus().NextRun)
+}
+
+func TestDueProxies(t *testing.T) {
+	ps := NewProxyServer(&config.Config{})
+	now := time.Now()
+	never, old, older, recent := &Proxy{Host: "never:1"}, &Proxy{Host: "old:1"}, &Proxy{Host: "older:1"}, &Proxy{Host: "recent:1"}
+	old.lastCheck.Store(now.Add(-2 * time.Minute).UnixNano())
+	older.lastCheck.Store(now.Add(-3 * time.Minute).UnixNano())
+	recent.lastCheck.Store(now.Add(-10 * time.Second).UnixNano())
+	ps.SetProxies([]*Proxy{recent, old, never, older})
+
+	due := now.Add(-time.Minute)
+	assert.Equal(t, []*Proxy{never, older, old}, ps.dueProxies(due, 10))
+	assert.Equal(t, []*Proxy{never, older}, ps.dueProxies(due, 2))
+	assert.Equal(t, []*Proxy{never}, ps.dueProxies(now.Add(-time.Hour), 10), "only never checked proxies are due")
+}
+
+func TestCheckProxySetsLastCheck(t *testing.T) {
+	cfg := &config.Config{Healthcheck: config.HealthcheckConfig{URL: "http://example.com/", Status: http.StatusOK, Timeout: 1}}
+	ps := NewProxyServer(cfg)
+	dead, err := NewProxyLoader(ps).CreateProxy("http://127.0.0.1:1")
+	assert.NoError(t, err)
+	assert.True(t, ps.ProxyLastCheck(dead).IsZero())
+
+	before := time.Now()
+	assert.Error(t, NewProxyChecker(ps).CheckProxy(dead))
+	assert.False(t, ps.ProxyLastCheck(dead).Before(before), "failed checks count as checks")
+}
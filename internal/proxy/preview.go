@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/alpkeskin/rota/internal/middleware"
+)
+
+const (
+	msgUnknownListener  = "unknown listener"
+	msgPreviewNeedsHost = "consistent_hash picks by target host, the preview needs a host"
+)
+
+var (
+	ErrUnknownListener  = errors.New(msgUnknownListener)
+	ErrUnknownTenant    = errors.New(msgUnknownTenant)
+	ErrPreviewNeedsHost = errors.New(msgPreviewNeedsHost)
+)
+
+// Preview is the request a rotation preview is made for. Username is the proxy username as a client sends it,
+// directives included, Session replaces its session directive and Listener is the port, 0 for proxy.port
+type Preview struct {
+	Host     string
+	Username string
+	Session  string
+	Listener int
+}
+
+// PeekProxy returns the proxy a request like preview would be sent through first, without advancing the rotation.
+// The listener, tenant, routing rule, session pin and hash key are resolved like tryProxies does, a direct route
+// returns the direct proxy. The rotation is the one the request would use, with consistent_hash the pick depends on
+// the host so a preview without one is refused
+func (ps *ProxyServer) PeekProxy(preview Preview) (*Proxy, config.ProxyRotationConfig, error) {
+	cfg := ps.Config()
+	port := cfg.Proxy.Port
+	if preview.Listener != 0 && preview.Listener != port {
+		if !slices.ContainsFunc(cfg.Proxy.Listeners, func(lc config.ProxyListenerConfig) bool { return lc.Port == preview.Listener }) {
+			return nil, config.ProxyRotationConfig{}, ErrUnknownListener
+		}
+		port = preview.Listener
+	}
+
+	listener := ps.resolveListener(port)
+	directives := middleware.Directives{Username: preview.Username}
+	if listener.authentication.Directives {
+		directives = middleware.ParseUsername(preview.Username)
+	}
+	if preview.Session != "" {
+		directives.Session = preview.Session
+	}
+	listener, ok := ps.tenantListener(listener, directives.Username)
+	if !ok {
+		return nil, listener.rotation, ErrUnknownTenant
+	}
+
+	if listener.rotation.Method == "consistent_hash" && preview.Host == "" {
+		return nil, listener.rotation, ErrPreviewNeedsHost
+	}
+
+	route := ps.route(preview.Host, listener)
+	if route.direct {
+		return ps.directProxy, listener.rotation, nil
+	}
+	route.filter.country = directives.Country
+	if proxy := ps.sessionProxy(requestInfo{directives: directives}, route.filter); proxy != nil {
+		return proxy, listener.rotation, nil
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.pickProxy(listener.rotation, route.filter, newPickKey(hostname(preview.Host), 0), false), listener.rotation, nil
+}
@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/alpkeskin/rota/internal/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeekProxy(t *testing.T) {
+	cfg := &config.Config{
+		ProxyFile: "proxies.txt",
+		Proxy: config.ProxyConfig{
+			Port:     8080,
+			Rotation: config.ProxyRotationConfig{Method: "roundrobin"},
+			Listeners: []config.ProxyListenerConfig{
+				{Port: 8081, ProxyFile: "residential.txt", Rotation: &config.ProxyRotationConfig{Method: "consistent_hash"}},
+			},
+		},
+		Routing: []config.RoutingRuleConfig{
+			{Hosts: []string{"direct.example.com"}, Direct: true},
+			{Hosts: []string{"*.de"}, Pool: "residential.txt"},
+		},
+	}
+	ps := NewProxyServer(cfg)
+	for _, p := range []*Proxy{
+		{Scheme: "http", Host: "a:1", Pool: "proxies.txt"},
+		{Scheme: "http", Host: "b:1", Pool: "proxies.txt"},
+		{Scheme: "http", Host: "c:1", Pool: "residential.txt"},
+		{Scheme: "http", Host: "d:1", Pool: "residential.txt"},
+	} {
+		ps.AddProxy(p)
+	}
+	peek := func(preview Preview) *Proxy {
+		proxy, _, err := ps.PeekProxy(preview)
+		require.NoError(t, err)
+		require.NotNil(t, proxy)
+		return proxy
+	}
+
+	assert.Equal(t, "a:1", peek(Preview{}).Host)
+	assert.Equal(t, "a:1", peek(Preview{}).Host, "previewing does not advance the rotation")
+	assert.Equal(t, "c:1", peek(Preview{Host: "shop.de"}).Host)
+	assert.Same(t, ps.directProxy, peek(Preview{Host: "direct.example.com"}))
+
+	// the listener's consistent_hash picks by host within its pool, like a request to that host would
+	proxy, rotation, err := ps.PeekProxy(Preview{Host: "example.com", Listener: 8081})
+	require.NoError(t, err)
+	assert.Equal(t, "consistent_hash", rotation.Method)
+	assert.Same(t, ps.selectProxy(rotation, proxyFilter{pool: "residential.txt"}, newPickKey("example.com", 0)), proxy)
+
+	// a session pin wins over the rotation
+	pinned := ps.Proxies[1]
+	ps.pinSession(requestInfo{directives: middleware.Directives{Username: "alice", Session: "s1"}}, proxyFilter{pool: "proxies.txt"}, pinned)
+	assert.Same(t, pinned, peek(Preview{Username: "alice", Session: "s1"}))
+	assert.NotSame(t, pinned, peek(Preview{Username: "alice", Session: "s2"}))
+
+	_, _, err = ps.PeekProxy(Preview{Listener: 8081})
+	assert.ErrorIs(t, err, ErrPreviewNeedsHost)
+	_, _, err = ps.PeekProxy(Preview{Listener: 9999})
+	assert.ErrorIs(t, err, ErrUnknownListener)
+}
@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/alpkeskin/rota/internal/config"
+)
+
+const (
+	ProviderWebshare = "webshare"
+
+	webshareURL      = "https://proxy.webshare.io/api/v2/proxy/list/?mode=direct&page=1&page_size=100"
+	maxProviderPages = 100
+
+	msgUnknownProvider = "unknown proxy provider"
+	msgMissingAPIKey   = "provider api_key is required"
+)
+
+// providerFetcher returns the proxy lines of an account, in the proxy file format
+type providerFetcher func(client *http.Client, source config.SourceConfig) ([]string, error)
+
+// providers pull proxy lists from provider APIs, a new provider only needs an entry here
+var providers = map[string]providerFetcher{
+	ProviderWebshare: fetchWebshare,
+}
+
+// sourceID names a source in statuses and on its proxies, provider accounts are told apart by a hash of their key
+func sourceID(source config.SourceConfig) string {
+	if source.Provider == "" || source.URL != "" {
+		return source.URL
+	}
+	sum := sha256.Sum256([]byte(source.APIKey))
+	return source.Provider + ":" + hex.EncodeToString(sum[:4])
+}
+
+func (pl *ProxyLoader) fetchProvider(source config.SourceConfig) ([]*Proxy, error) {
+	fetch, ok := providers[strings.ToLower(source.Provider)]
+	if !ok {
+		return nil, fmt.Errorf("%s: %s", msgUnknownProvider, source.Provider)
+	}
+	if source.APIKey == "" {
+		return nil, fmt.Errorf("%s: %s", msgMissingAPIKey, source.Provider)
+	}
+	lines, err := fetch(&http.Client{Timeout: sourceFetchTimeout}, source)
+	if err != nil {
+		return nil, err
+	}
+	return pl.parseProxies(strings.Join(lines, "\n")), nil
+}
+
+type webshareProxy struct {
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	ProxyAddress string `json:"proxy_address"`
+	Port         int    `json:"port"`
+	Valid        bool   `json:"valid"`
+}
+
+// fetchWebshare follows the pages of the proxy list, proxies webshare marks invalid are left out. The endpoints
+// accept http and socks5 alike, scheme picks one (default http)
+func fetchWebshare(client *http.Client, source config.SourceConfig) ([]string, error) {
+	scheme := source.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	next := source.URL
+	if next == "" {
+		next = webshareURL
+	}
+
+	lines := make([]string, 0)
+	for page := 0; next != "" && page < maxProviderPages; page++ {
+		var list struct {
+			Next    *string         `json:"next"`
+			Results []webshareProxy `json:"results"`
+		}
+		if err := getProviderJSON(client, next, "Token "+source.APIKey, &list); err != nil {
+			return nil, err
+		}
+		for _, p := range list.Results {
+			if !p.Valid {
+				continue
+			}
+			u := url.URL{
+				Scheme: scheme,
+				User:   url.UserPassword(p.Username, p.Password),
+				Host:   net.JoinHostPort(p.ProxyAddress, strconv.Itoa(p.Port)),
+			}
+			lines = append(lines, u.String()+" "+ProviderWebshare)
+		}
+		next = ""
+		if list.Next != nil {
+			next = *list.Next
+		}
+	}
+	return lines, nil
+}
+
+func getProviderJSON(client *http.Client, endpoint, authorization string, v any) error {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", authorization)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", msgUnexpectedStatus, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxSourceSize)).Decode(v)
+}
@@ -1,16 +1,27 @@
 package proxy
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
+	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"errors"
 
 	"github.com/alpkeskin/rota/internal/config"
+	"github.com/alpkeskin/rota/internal/features"
 	"github.com/alpkeskin/rota/internal/middleware"
+	"github.com/alpkeskin/rota/internal/notify"
+	"github.com/alpkeskin/rota/internal/stats"
+	"github.com/alpkeskin/rota/pkg/systemd"
 	"github.com/elazarl/goproxy"
 	"github.com/google/uuid"
 	"golang.org/x/exp/rand"
@@ -20,6 +31,15 @@ const (
 	// HTTP Status Codes
 	StatusProxyAuthRequired = 407
 	StatusBadGateway        = 502
+	StatusTooManyRequests   = 429
+	StatusForbidden         = 403
+
+	defaultRotateAfter = 10
+
+	// feature flags that switch off a request path at runtime, they are on until features or /features turn them off
+	FeatureCache     = "cache"
+	FeatureMirror    = "mirror"
+	FeatureUserAgent = "user_agent"
 
 	msgFailedToListen         = "failed to listen"
 	msgProxyServerStarted     = "rota proxy server started"
@@ -32,7 +52,9 @@ const (
 	msgProxyAttemptsExhausted = "proxy attempts exhausted"
 	msgAllProxyAttemptsFailed = "all proxy attempts failed"
 	msgUnauthorized           = "Rota Proxy: Unauthorized. Request ID: %s"
-	msgBadGateway             = "Rota Proxy: Bad Gateway. Request ID: %s"
+	msgTooManyRequests        = "Rota Proxy: Too Many Requests. Request ID: %s"
+	msgForbidden              = "Rota Proxy: Forbidden. Request ID: %s"
+	msgRateLimited            = "request rate limited"
 )
 
 var hopHeaders = []string{
@@ -48,133 +70,499 @@ var hopHeaders = []string{
 }
 
 type requestInfo struct {
-	id      string
-	url     string
-	request *http.Request
-	startAt time.Time
+	id         string
+	url        string
+	request    *http.Request
+	startAt    time.Time
+	listener   *listenerConfig
+	directives middleware.Directives
+	attempts   *attemptLog
 }
 
 type Proxy struct {
-	Scheme    string
-	Host      string
-	Url       *url.URL
-	Transport *http.Transport
+	Scheme     string
+	Host       string
+	Url        *url.URL
+	Transport  *http.Transport
+	Headers    http.Header
+	Pool       string
+	Tags       []string
+	Source     string
+	breaker    circuitBreaker
+	quarantine quarantine
+	geo        atomic.Pointer[GeoLocation]
+	slots      chan struct{}
+	rpm        *rpmWindow
+	// credentials rotate per attempt in place of the url's own, nil when the upstream has no credential sets
+	credentials *upstreamCredentials
+	inFlight    atomic.Int64
+	draining    atomic.Bool
+	latency     latency
+	// lastCheck is the unix nano time the last healthcheck of the proxy finished, zero before the first one
+	lastCheck atomic.Int64
+	// served counts the picks of the sequential method since the proxy last moved to the back, guarded by ps.mu
+	served int
+	// healthcheck overrides the global check for upstreams that only allow specific targets
+	healthcheck config.UpstreamHealthcheckConfig
+}
+
+type proxyFilter struct {
+	pool      string
+	scheme    string
+	tag       string
+	countries []string
+	country   string
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
 }
 
 type ProxyServer struct {
-	goProxy *goproxy.ProxyHttpServer
-	Proxies []*Proxy
-	cfg     *config.Config
+	goProxy        *goproxy.ProxyHttpServer
+	Proxies        []*Proxy
+	current        atomic.Pointer[config.Config]
+	features       *features.Flags
+	middleware     *middleware.Middleware
+	mu             sync.RWMutex
+	degraded       atomic.Bool
+	requests       atomic.Uint64
+	directProxy    *Proxy
+	stats          *stats.Stats
+	sources        sourceStatuses
+	healthchecks   periodicState
+	history        *requestHistory
+	ring           *hashRing
+	ringShift      uint64
+	geoip          *geoDatabases
+	tlsConfig      *tls.Config
+	mitm           *goproxy.ConnectAction
+	transports     transportCache
+	sessions       sessionTable
+	notifier       *notify.Notifier
+	poolLow        atomic.Bool
+	imports        sync.Mutex
+	shared         *sharedState
+	cache          *responseCache
+	drains         drainTable
+	tests          testTable
+	resolver       *net.Resolver
+	resolveTargets bool
+	tenantUsage    tenantUsage
+	conns          connTable
+	mirrors        mirrorTable
 }
 
 func NewProxyServer(cfg *config.Config) *ProxyServer {
-	return &ProxyServer{
-		Proxies: make([]*Proxy, 0),
-		cfg:     cfg,
-		goProxy: goproxy.NewProxyHttpServer(),
+	ps := &ProxyServer{
+		Proxies:     make([]*Proxy, 0),
+		goProxy:     newGoProxy(),
+		features:    features.NewFlags(cfg.Features),
+		middleware:  middleware.NewMiddleware(),
+		directProxy: newDirectProxy(),
+		stats:       stats.New(),
+		notifier:    notify.New(cfg.Notifications),
+		shared:      newSharedState(cfg.Redis),
+	}
+	ps.current.Store(cfg)
+	for _, name := range []string{FeatureCache, FeatureMirror, FeatureUserAgent} {
+		ps.features.Define(name, true)
+	}
+	ps.middleware.Credentials().Reset(cfg.Proxy.Credentials)
+	ps.directProxy.Transport.ForceAttemptHTTP2 = cfg.Proxy.HTTP2
+	ps.resolver = newResolver(cfg.DNS)
+	ps.resolveTargets = resolvesLocally(cfg.DNS)
+	ps.directProxy.Transport.DialContext = ps.netDialer(0).DialContext
+	ps.fingerprintTLS(ps.directProxy.Transport)
+	if ps.shared != nil {
+		ps.middleware.RateLimiter().Share(ps.shared.client, ps.shared.prefix)
 	}
+	if cfg.History.Enabled {
+		ps.history = newRequestHistory(historySize(cfg))
+	}
+	if cfg.Cache.Enabled {
+		var shared *sharedState
+		if cfg.Cache.Redis {
+			shared = ps.shared
+		}
+		ps.cache = newResponseCache(cfg.Cache.MaxEntries, shared)
+	}
+	return ps
+}
+
+// Config returns the config in effect. A reload swaps in another one instead of changing it, so a request loads it
+// once and finishes with the settings it started with
+func (ps *ProxyServer) Config() *config.Config {
+	return ps.current.Load()
+}
+
+// SetConfig swaps in a reloaded config for the requests that start from now on
+func (ps *ProxyServer) SetConfig(cfg *config.Config) {
+	ps.current.Store(cfg)
+	ps.features.Reset(cfg.Features)
+	ps.middleware.Credentials().Reset(cfg.Proxy.Credentials)
+}
+
+// UpdateConfig swaps in a copy of the config with change applied, a reload at the same time is not lost
+func (ps *ProxyServer) UpdateConfig(change func(cfg *config.Config)) {
+	for {
+		current := ps.current.Load()
+		next := *current
+		change(&next)
+		if ps.current.CompareAndSwap(current, &next) {
+			return
+		}
+	}
+}
+
+func (ps *ProxyServer) Features() *features.Flags {
+	return ps.features
+}
+
+func (ps *ProxyServer) Credentials() *middleware.Credentials {
+	return ps.middleware.Credentials()
+}
+
+func (ps *ProxyServer) Stats() *stats.Stats {
+	return ps.stats
 }
 
 func (ps *ProxyServer) AddProxy(proxy *Proxy) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
 	ps.Proxies = append(ps.Proxies, proxy)
+	ps.poolChanged()
+}
+
+func (ps *ProxyServer) SetProxies(proxies []*Proxy) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.Proxies = proxies
+	ps.poolChanged()
+}
+
+func (ps *ProxyServer) GetProxies() []*Proxy {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	proxies := make([]*Proxy, len(ps.Proxies))
+	copy(proxies, ps.Proxies)
+	return proxies
+}
+
+func (ps *ProxyServer) ProxyCount() int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return len(ps.Proxies)
+}
+
+func (ps *ProxyServer) SetDegraded(degraded bool) {
+	ps.degraded.Store(degraded)
+}
+
+func (ps *ProxyServer) IsDegraded() bool {
+	return ps.degraded.Load()
 }
 
-func (ps *ProxyServer) getProxy() *Proxy {
-	method := ps.cfg.Proxy.Rotation.Method
+func (ps *ProxyServer) getProxy(method string, filter proxyFilter) *Proxy {
+	return ps.selectProxy(config.ProxyRotationConfig{Method: method}, filter, pickKey{})
+}
 
-	switch method {
+// selectProxy picks the next proxy for a request with the rotation's method, key only matters to consistent_hash
+func (ps *ProxyServer) selectProxy(rotation config.ProxyRotationConfig, filter proxyFilter, key pickKey) *Proxy {
+	if rotation.Method == "roundrobin" {
+		if turn, ok := ps.shared.turn(filter); ok {
+			ps.mu.RLock()
+			defer ps.mu.RUnlock()
+			return ps.pickTurn(filter, turn)
+		}
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.pickProxy(rotation, filter, key, true)
+}
+
+// proxies of all pools share one slice, rotation skips the ones the filter does not match and open circuits
+func (ps *ProxyServer) pickProxy(rotation config.ProxyRotationConfig, filter proxyFilter, key pickKey, advance bool) *Proxy {
+	now := time.Now()
+	cooldown := ps.breakerCooldown()
+	if picked := ps.pickUsable(rotation, key, advance, now, cooldown, func(p *Proxy) bool {
+		return filter.matches(p) && !p.busy() && p.breaker.allows(now, cooldown) && ps.rotatable(p, now, false)
+	}); picked != nil || !ps.Config().Proxy.Quarantine.Enabled {
+		return picked
+	}
+	// degraded proxies are only used when every active one is skipped
+	return ps.pickUsable(rotation, key, advance, now, cooldown, func(p *Proxy) bool {
+		return filter.matches(p) && !p.busy() && p.breaker.allows(now, cooldown) && ps.rotatable(p, now, true)
+	})
+}
+
+func (ps *ProxyServer) pickUsable(rotation config.ProxyRotationConfig, key pickKey, advance bool, now time.Time, cooldown time.Duration, usable func(p *Proxy) bool) *Proxy {
+	var picked *Proxy
+	switch rotation.Method {
 	case "random":
-		return ps.Proxies[rand.Intn(len(ps.Proxies))]
+		matches := 0
+		for _, p := range ps.Proxies {
+			if usable(p) {
+				matches++
+			}
+		}
+		if matches == 0 {
+			return nil
+		}
+
+		n := rand.Intn(matches)
+		for _, p := range ps.Proxies {
+			if !usable(p) {
+				continue
+			}
+			if n == 0 {
+				picked = p
+				break
+			}
+			n--
+		}
 	case "roundrobin":
-		proxy := ps.Proxies[0]
-		ps.Proxies = append(ps.Proxies[1:], proxy)
-		return proxy
+		for i, p := range ps.Proxies {
+			if !usable(p) {
+				continue
+			}
+			if advance && i == 0 {
+				ps.Proxies = append(ps.Proxies[1:], p)
+			} else if advance {
+				copy(ps.Proxies[i:], ps.Proxies[i+1:])
+				ps.Proxies[len(ps.Proxies)-1] = p
+			}
+			picked = p
+			break
+		}
+	case "sequential":
+		rotateAfter := rotation.RotateAfter
+		if rotateAfter <= 0 {
+			rotateAfter = defaultRotateAfter
+		}
+		// like roundrobin, but the proxy only moves to the back once it served rotateAfter picks
+		for i, p := range ps.Proxies {
+			if !usable(p) {
+				continue
+			}
+			if advance {
+				p.served++
+				if p.served >= rotateAfter {
+					p.served = 0
+					copy(ps.Proxies[i:], ps.Proxies[i+1:])
+					ps.Proxies[len(ps.Proxies)-1] = p
+				}
+			}
+			picked = p
+			break
+		}
+	case "least_latency":
+		picked = ps.pickFastest(rotation.LatencyPercentile, usable)
+	case "consistent_hash":
+		picked = ps.pickHashed(key, usable)
 	}
 
-	return nil
+	if picked != nil && advance {
+		picked.breaker.claimProbe(now, cooldown)
+	}
+	return picked
 }
 
 func (ps *ProxyServer) Listen() {
-	ps.goProxy.CertStore = NewCertStorage()
+	certStore := NewCertStorage(certLimit(ps.Config()))
+	ps.goProxy.CertStore = certStore
 	ps.setUpHandlers()
-	time.Sleep(500 * time.Millisecond)
 
-	port := fmt.Sprintf(":%d", ps.cfg.Proxy.Port)
-	slog.Info(msgProxyServerStarted, "port", port)
-	err := http.ListenAndServe(port, ps.goProxy)
+	for _, listener := range ps.Config().Proxy.Listeners {
+		goProxy := newGoProxy()
+		goProxy.CertStore = certStore
+		ps.setUpListenerHandlers(goProxy, listener.Port)
+		go ps.serve(goProxy, listener.Port)
+	}
+
+	if ps.Config().Proxy.Socket != "" {
+		go ps.serveUnix(ps.goProxy, ps.Config().Proxy.Socket)
+	}
+
+	// a socket passed by systemd replaces the configured port
+	activated, err := systemd.Listener(systemd.ProxySocket)
 	if err != nil {
 		slog.Error(msgFailedToListen, "error", err)
+	}
+	if activated != nil {
+		slog.Info(msgProxyServerStarted, "systemd", activated.Addr().String(), "tls", ps.tlsConfig != nil)
+		if err := ps.serveListener(ps.goProxy, ps.tlsListener(activated)); err != nil {
+			slog.Error(msgFailedToListen, "error", err)
+		}
 		return
 	}
+
+	time.Sleep(500 * time.Millisecond)
+	// with a socket and no port the proxy never touches a network port
+	if ps.Config().Proxy.Port > 0 || ps.Config().Proxy.Socket == "" {
+		ps.serve(ps.goProxy, ps.Config().Proxy.Port)
+	}
 }
 
 func (ps *ProxyServer) setUpHandlers() {
-	ps.goProxy.OnRequest().HandleConnectFunc(ps.authenticateHttps)
-	ps.goProxy.OnRequest().DoFunc(ps.handleRequest)
+	ps.setUpListenerHandlers(ps.goProxy, ps.Config().Proxy.Port)
 }
 
-func (ps *ProxyServer) handleRequest(r *http.Request, ctx *goproxy.ProxyCtx) (*http.Request, *http.Response) {
+func (ps *ProxyServer) handleRequest(r *http.Request, ctx *goproxy.ProxyCtx, listener *listenerConfig) (*http.Request, *http.Response) {
 	reqInfo := requestInfo{
-		id:      uuid.New().String(),
-		url:     r.URL.String(),
-		request: r,
-		startAt: time.Now(),
+		id:       uuid.New().String(),
+		url:      r.URL.String(),
+		request:  r,
+		startAt:  time.Now(),
+		listener: listener,
+		attempts: &attemptLog{},
 	}
+	reqInfo.directives = ps.requestDirectives(r, ctx, listener)
 
-	if r.URL.Scheme == "http" && ps.cfg.Proxy.Authentication.Enabled {
+	if r.URL.Scheme == "http" && ps.listenerFor(reqInfo).authentication.Enabled {
 		if err := ps.authenticateHttp(ctx, reqInfo); err != nil {
+			ps.stats.ObserveRequest(stats.ResultUnauthorized)
 			return ps.unauthorizedResponse(reqInfo)
 		}
 	}
 
+	tenantListener, ok := ps.tenantListener(ps.listenerFor(reqInfo), reqInfo.directives.Username)
+	if !ok {
+		ps.stats.ObserveRequest(stats.ResultForbidden)
+		return nil, ps.unknownTenant(r, reqInfo.id, tenantListener.tenant)
+	}
+	reqInfo.listener = tenantListener
+
+	if !ps.targetAllowed(r.URL.Host) {
+		ps.stats.ObserveRequest(stats.ResultForbidden)
+		return nil, ps.forbidden(r, reqInfo.id)
+	}
+
+	if !ps.middleware.AllowRequest(listener.cfg.Proxy.RateLimit, r, reqInfo.directives.Username) {
+		ps.stats.ObserveRequest(stats.ResultRateLimited)
+		return nil, ps.tooManyRequests(r, reqInfo.id, reqInfo.directives.Username)
+	}
+
+	ps.rotateUserAgent(r, reqInfo.directives)
+	ps.rewriteRequestHeaders(r)
+	if ps.isDryRun(r) {
+		ps.stats.ObserveRequest(stats.ResultDryRun)
+		return r, ps.dryRunResponse(reqInfo)
+	}
+	if response := ps.cachedResponse(r, reqInfo.listener); response != nil {
+		ps.rewriteResponseHeaders(r, response)
+		ps.stats.ObserveRequest(stats.ResultSuccess)
+		return r, response
+	}
+	rotation := ps.listenerFor(reqInfo).rotation
+	body, ok := limitRequestBody(r, rotation.MaxRequestBody)
+	if !ok {
+		ps.stats.ObserveRequest(stats.ResultTooLarge)
+		return nil, ps.requestTooLarge(r, reqInfo.id, rotation.MaxRequestBody)
+	}
+	mirror := ps.startMirror(reqInfo)
+	sentAt := time.Now()
 	response, err := ps.tryProxies(reqInfo)
+	finishMirror(mirror, sentAt, response, err)
+	if err != nil && body != nil && body.exceeded {
+		ps.stats.ObserveRequest(stats.ResultTooLarge)
+		return nil, ps.requestTooLarge(r, reqInfo.id, rotation.MaxRequestBody)
+	}
 	if err != nil {
+		ps.stats.ObserveRequest(stats.ResultBadGateway)
 		return ps.badGatewayResponse(reqInfo, err)
 	}
+	if !limitResponseBody(response, rotation.MaxResponseBody) {
+		ps.stats.ObserveRequest(stats.ResultBadGateway)
+		return ps.badGatewayResponse(reqInfo, fmt.Errorf("%w: %d bytes", errResponseTooLarge, response.ContentLength))
+	}
 
+	ps.cacheResponse(r, reqInfo.listener, response)
+	ps.rewriteResponseHeaders(r, response)
+	streamResponse(r, response)
+	ps.stats.ObserveRequest(stats.ResultSuccess)
 	return r, response
 }
 
 func (ps *ProxyServer) authenticateHttp(ctx *goproxy.ProxyCtx, reqInfo requestInfo) error {
-	mid := middleware.NewMiddleware(ps.cfg)
-	if err := mid.ProxyAuth(ctx); err != nil {
+	if err := ps.middleware.ProxyAuth(ctx, ps.listenerFor(reqInfo).authentication); err != nil {
 		slog.Error(msgAuthError, "error", err, "request_id", reqInfo.id, "url", reqInfo.url)
 		return err
 	}
 	return nil
 }
 
-func (ps *ProxyServer) authenticateHttps(host string, ctx *goproxy.ProxyCtx) (*goproxy.ConnectAction, string) {
-	if !ps.cfg.Proxy.Authentication.Enabled {
-		return goproxy.MitmConnect, host
+func (ps *ProxyServer) authenticateHttps(host string, ctx *goproxy.ProxyCtx, listener *listenerConfig) (*goproxy.ConnectAction, string) {
+	if !listener.authentication.Enabled {
+		return ps.mitmConnect(), host
 	}
 
-	mid := middleware.NewMiddleware(ps.cfg)
-	if err := mid.ProxyAuth(ctx); err != nil {
+	if err := ps.middleware.ProxyAuth(ctx, listener.authentication); err != nil {
 		slog.Error(msgAuthError, "error", err, "url", host)
+		ctx.Resp = ps.proxyAuthRequired(ctx.Req, "", listener.authentication)
+		ctx.Resp.Close = true
 		return goproxy.RejectConnect, host
 	}
-	return goproxy.MitmConnect, host
+	return ps.mitmConnect(), host
 }
 
 func (ps *ProxyServer) tryProxies(reqInfo requestInfo) (*http.Response, error) {
-	for attempt := 0; attempt < ps.cfg.Proxy.Rotation.FallbackMaxRetries; attempt++ {
-		proxy := ps.getProxy()
+	listener := ps.listenerFor(reqInfo)
+	rotation := listener.rotation
+	route := ps.route(reqInfo.request.URL.Host, listener)
+	if route.direct {
+		slog.Debug(msgRoutedDirect, "request_id", reqInfo.id, "url", reqInfo.url)
+		response, err := ps.tryProxy(ps.directProxy, reqInfo)
+		if err == nil {
+			ps.addDebugHeaders(response, reqInfo, ps.directProxy)
+		}
+		return response, err
+	}
+
+	route.filter.country = reqInfo.directives.Country
+	failures := 0
+	for attempt := 0; attempt < rotation.FallbackMaxRetries; attempt++ {
+		// only failed proxies count, rotating past a busy one needs no wait
+		if err := waitBackoff(reqInfo.request.Context(), rotation.Backoff, failures, reqInfo.id); err != nil {
+			return nil, err
+		}
+		var proxy *Proxy
+		if attempt == 0 {
+			proxy = ps.sessionProxy(reqInfo, route.filter)
+		}
+		if proxy == nil {
+			selectedAt := time.Now()
+			proxy = ps.selectProxy(rotation, route.filter, newPickKey(reqInfo.request.URL.Hostname(), attempt))
+			ps.stats.ObserveSelection(time.Since(selectedAt))
+		}
 		if proxy == nil {
 			slog.Error(msgNoProxyFound, "request_id", reqInfo.id, "url", reqInfo.url)
 			return nil, errors.New(msgNoProxyFound)
 		}
 
-		if response, err := ps.tryProxy(proxy, reqInfo); err == nil {
+		response, err := ps.tryProxy(proxy, reqInfo)
+		if err == nil {
+			// a session sticks to the proxy that last served it
+			ps.pinSession(reqInfo, route.filter, proxy)
+			ps.addDebugHeaders(response, reqInfo, proxy)
 			return response, nil
 		}
+		// a busy proxy is healthy, the next attempt rotates to one with room
+		if errors.Is(err, errProxyBusy) {
+			slog.Debug(msgProxyBusy, "request_id", reqInfo.id, "proxy", proxy.Host, "url", reqInfo.url)
+			continue
+		}
+		failures++
 
-		if ps.cfg.Proxy.Rotation.RemoveUnhealthy {
+		if rotation.RemoveUnhealthy {
 			slog.Warn(msgRemovingUnhealthyProxy, "request_id", reqInfo.id, "proxy", proxy.Host, "url", reqInfo.url)
 			ps.removeUnhealthyProxy(proxy)
 		}
 
-		if !ps.cfg.Proxy.Rotation.Fallback {
+		// a ranged request is part of a larger download, switching proxies would change the client IP mid-download
+		if !rotation.Fallback || isRangeRequest(reqInfo.request) {
 			break
 		}
 	}
@@ -182,24 +570,73 @@ func (ps *ProxyServer) tryProxies(reqInfo requestInfo) (*http.Response, error) {
 }
 
 func (ps *ProxyServer) tryProxy(proxy *Proxy, reqInfo requestInfo) (*http.Response, error) {
-	for i := 0; i < ps.cfg.Proxy.Rotation.Retries; i++ {
+	rotation := ps.listenerFor(reqInfo).rotation
+	for i := 0; i < rotation.Retries; i++ {
+		if err := waitBackoff(reqInfo.request.Context(), rotation.Backoff, i, reqInfo.id); err != nil {
+			return nil, err
+		}
+		if !proxy.acquire() {
+			return nil, errProxyBusy
+		}
 		client := &http.Client{
 			Transport: proxy.Transport,
-			Timeout:   time.Duration(ps.cfg.Proxy.Rotation.Timeout) * time.Second,
 		}
-		defer client.CloseIdleConnections()
 
 		ps.removeHopHeaders(reqInfo.request)
+		ps.addUpstreamHeaders(proxy, reqInfo.request)
 		reqInfo.request.RequestURI = ""
-		response, err := client.Do(reqInfo.request)
+
+		// the timeout only covers the response headers, long downloads must not be cut off while streaming
+		ctx, cancel := context.WithCancel(reqInfo.request.Context())
+		credential := proxy.credentials.pick()
+		ctx = withCredential(ctx, credential)
+		timer := startTimeout(rotation.Timeout, cancel)
+		attemptAt := time.Now()
+		attempt := reqInfo.request.WithContext(ctx)
+		sent := &countingBody{}
+		if attempt.Body != nil && attempt.Body != http.NoBody {
+			sent.ReadCloser = attempt.Body
+			attempt.Body = sent
+		}
+		response, err := client.Do(attempt)
+		if timer != nil {
+			timer.Stop()
+		}
+		statusCode := 0
+		if response != nil {
+			statusCode = response.StatusCode
+		}
+		record := ps.requestRecord(proxy, reqInfo, attemptAt, statusCode, err)
+		if err == nil && response != nil {
+			proxy.latency.observe(time.Since(attemptAt))
+		}
+		ps.stats.ObserveProxy(proxy.Host, err == nil && response != nil)
+		if record.ErrorClass != "" {
+			ps.stats.ObserveError(proxy.Host, record.ErrorClass)
+		}
+		ps.recordResult(proxy, err == nil && response != nil)
+		// a 407 is passed on to the client, for the credential set it means its quota or password is gone
+		credential.observe(err == nil && response != nil && response.StatusCode != http.StatusProxyAuthRequired)
 		if err == nil && response != nil {
-			duration := time.Since(reqInfo.startAt)
-			slog.Info(msgReqRotationSuccess,
-				"request_id", reqInfo.id,
-				"proxy", proxy.Host,
-				"url", reqInfo.url,
-				"duration", fmt.Sprintf("%.2fs", duration.Seconds()),
-			)
+			// the record waits for the body so it carries the bytes of the whole exchange
+			response.Body = &countingBody{
+				ReadCloser: &cancelOnClose{ReadCloser: response.Body, cancel: cancel},
+				onClose: func(received uint64) {
+					proxy.release()
+					record.BytesSent = sent.n.Load()
+					record.BytesReceived = received
+					ps.addRecord(record)
+				},
+			}
+			if ps.logSampled() {
+				duration := time.Since(reqInfo.startAt)
+				slog.Info(msgReqRotationSuccess,
+					"request_id", reqInfo.id,
+					"proxy", proxy.Host,
+					"url", reqInfo.url,
+					"duration", fmt.Sprintf("%.2fs", duration.Seconds()),
+				)
+			}
 			return response, nil
 		}
 		slog.Error(msgReqRotationError,
@@ -208,17 +645,33 @@ func (ps *ProxyServer) tryProxy(proxy *Proxy, reqInfo requestInfo) (*http.Respon
 			"proxy", proxy.Host,
 			"url", reqInfo.url,
 		)
+		record.BytesSent = sent.n.Load()
+		ps.addRecord(record)
+		reqInfo.attempts.add(record)
+		cancel()
+		proxy.release()
 	}
 	return nil, errors.New(msgProxyAttemptsExhausted)
 }
 
+func startTimeout(timeout int, cancel context.CancelFunc) *time.Timer {
+	if timeout <= 0 {
+		return nil
+	}
+	return time.AfterFunc(time.Duration(timeout)*time.Second, cancel)
+}
+
 func (ps *ProxyServer) removeUnhealthyProxy(proxy *Proxy) {
+	ps.mu.Lock()
 	for i, p := range ps.Proxies {
 		if p == proxy {
 			ps.Proxies = append(ps.Proxies[:i], ps.Proxies[i+1:]...)
-			return
+			ps.poolChanged()
+			break
 		}
 	}
+	ps.mu.Unlock()
+	ps.checkPoolThreshold()
 }
 
 func (ps *ProxyServer) removeHopHeaders(r *http.Request) {
@@ -227,15 +680,60 @@ func (ps *ProxyServer) removeHopHeaders(r *http.Request) {
 	}
 }
 
+func (ps *ProxyServer) addUpstreamHeaders(proxy *Proxy, r *http.Request) {
+	// CONNECT tunnels get the headers from the transport, plain http requests carry them to the proxy
+	if len(proxy.Headers) == 0 || r.URL.Scheme != "http" || proxy.Transport == nil || proxy.Transport.Proxy == nil {
+		return
+	}
+
+	for name, values := range proxy.Headers {
+		r.Header[name] = values
+	}
+}
+
+func (f proxyFilter) matches(p *Proxy) bool {
+	return p.Pool == f.pool &&
+		(f.scheme == "" || p.Scheme == f.scheme) &&
+		(f.tag == "" || slices.Contains(p.Tags, f.tag)) &&
+		f.allowsCountry(p)
+}
+
+func (c *cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+func isRangeRequest(r *http.Request) bool {
+	return r.Header.Get("Range") != ""
+}
+
 func (ps *ProxyServer) unauthorizedResponse(reqInfo requestInfo) (*http.Request, *http.Response) {
-	return nil, goproxy.NewResponse(reqInfo.request,
+	return nil, ps.proxyAuthRequired(reqInfo.request, reqInfo.id, ps.listenerFor(reqInfo).authentication)
+}
+
+func (ps *ProxyServer) proxyAuthRequired(r *http.Request, requestID string, auth config.ProxyAuthenticationConfig) *http.Response {
+	response := goproxy.NewResponse(r,
 		goproxy.ContentTypeText, StatusProxyAuthRequired,
-		fmt.Sprintf(msgUnauthorized, reqInfo.id))
+		fmt.Sprintf(msgUnauthorized, requestID))
+	response.ProtoMajor, response.ProtoMinor = 1, 1
+	response.Header.Set(middleware.ProxyAuthenticateHeader, ps.middleware.Challenge(auth))
+	return response
+}
+
+func (ps *ProxyServer) tooManyRequests(r *http.Request, requestID, username string) *http.Response {
+	slog.Warn(msgRateLimited, "request_id", requestID, "url", r.URL.String(), "ip", r.RemoteAddr, "username", username)
+	response := goproxy.NewResponse(r,
+		goproxy.ContentTypeText, StatusTooManyRequests,
+		fmt.Sprintf(msgTooManyRequests, requestID))
+	response.ProtoMajor, response.ProtoMinor = 1, 1
+	return response
 }
 
-func (ps *ProxyServer) badGatewayResponse(reqInfo requestInfo, err error) (*http.Request, *http.Response) {
-	slog.Error(msgReqRotationError, "error", err, "request_id", reqInfo.id, "url", reqInfo.url)
-	return nil, goproxy.NewResponse(reqInfo.request,
-		goproxy.ContentTypeText, StatusBadGateway,
-		fmt.Sprintf(msgBadGateway, reqInfo.id))
+func (ps *ProxyServer) forbidden(r *http.Request, requestID string) *http.Response {
+	slog.Warn(msgTargetBlocked, "request_id", requestID, "url", r.URL.String(), "ip", r.RemoteAddr)
+	response := goproxy.NewResponse(r,
+		goproxy.ContentTypeText, StatusForbidden,
+		fmt.Sprintf(msgForbidden, requestID))
+	response.ProtoMajor, response.ProtoMinor = 1, 1
+	return response
 }
@@ -0,0 +1,192 @@
+package proxy
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+)
+
+const (
+	ProxyActive      = "active"
+	ProxyDegraded    = "degraded"
+	ProxyQuarantined = "quarantined"
+
+	defaultQuarantineWindow    = 20
+	defaultQuarantineMinimum   = 10
+	defaultDegradedErrorRate   = 0.25
+	defaultQuarantineErrorRate = 0.5
+	defaultQuarantineCooldown  = 300
+
+	msgProxyDegraded    = "proxy degraded, preferring other proxies"
+	msgProxyQuarantined = "proxy quarantined, skipping proxy"
+	msgProxyActive      = "proxy active again"
+)
+
+// quarantine moves a proxy between active, degraded and quarantined by the error rate of its last attempts.
+// Degraded proxies are rotated only when no active proxy matches, quarantined ones are skipped until the cooldown
+// is over and come back degraded with a fresh window
+type quarantine struct {
+	mu       sync.Mutex
+	results  []bool
+	next     int
+	failures int
+	state    string
+	until    time.Time
+	changed  time.Time
+}
+
+type quarantineSettings struct {
+	window       int
+	minimum      int
+	degradedRate float64
+	quarantineAt float64
+	cooldown     time.Duration
+}
+
+func newQuarantineSettings(cfg config.QuarantineConfig) quarantineSettings {
+	s := quarantineSettings{
+		window:       cfg.Window,
+		minimum:      cfg.MinRequests,
+		degradedRate: cfg.DegradedErrorRate,
+		quarantineAt: cfg.QuarantineErrorRate,
+		cooldown:     time.Duration(cfg.Cooldown) * time.Second,
+	}
+	if s.window <= 0 {
+		s.window = defaultQuarantineWindow
+	}
+	if s.minimum <= 0 {
+		s.minimum = defaultQuarantineMinimum
+	}
+	s.minimum = min(s.minimum, s.window)
+	if s.degradedRate <= 0 {
+		s.degradedRate = defaultDegradedErrorRate
+	}
+	if s.quarantineAt <= 0 {
+		s.quarantineAt = defaultQuarantineErrorRate
+	}
+	if s.cooldown <= 0 {
+		s.cooldown = defaultQuarantineCooldown * time.Second
+	}
+	return s
+}
+
+// record adds an attempt and returns the state it left and the state it is in, they are equal when nothing changed
+func (q *quarantine) record(ok bool, s quarantineSettings, now time.Time) (string, string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	from := q.current(now)
+	if from == ProxyQuarantined {
+		return from, from
+	}
+	if q.state == ProxyQuarantined {
+		// the cooldown is over, the proxy is on probation with a window of its own
+		q.reset(ProxyDegraded, now)
+	}
+
+	if cap(q.results) != s.window {
+		q.results = make([]bool, 0, s.window)
+		q.next = 0
+		q.failures = 0
+	}
+	if len(q.results) < s.window {
+		q.results = append(q.results, ok)
+	} else {
+		if !q.results[q.next] {
+			q.failures--
+		}
+		q.results[q.next] = ok
+		q.next = (q.next + 1) % s.window
+	}
+	if !ok {
+		q.failures++
+	}
+
+	if len(q.results) >= s.minimum {
+		rate := float64(q.failures) / float64(len(q.results))
+		switch {
+		case rate >= s.quarantineAt:
+			q.reset(ProxyQuarantined, now)
+			q.until = now.Add(s.cooldown)
+		case rate >= s.degradedRate && q.state != ProxyDegraded:
+			q.state = ProxyDegraded
+			q.changed = now
+		case rate < s.degradedRate && q.state == ProxyDegraded:
+			q.state = ProxyActive
+			q.changed = now
+		}
+	}
+	return from, q.current(now)
+}
+
+func (q *quarantine) reset(state string, now time.Time) {
+	q.results = q.results[:0]
+	q.next = 0
+	q.failures = 0
+	q.state = state
+	q.until = time.Time{}
+	q.changed = now
+}
+
+// current reports the state, a quarantine whose cooldown is over reads as degraded until the next attempt
+func (q *quarantine) current(now time.Time) string {
+	switch {
+	case q.state == "":
+		return ProxyActive
+	case q.state == ProxyQuarantined && !now.Before(q.until):
+		return ProxyDegraded
+	}
+	return q.state
+}
+
+func (q *quarantine) status(now time.Time) (string, time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.current(now), q.changed
+}
+
+func (ps *ProxyServer) recordQuarantine(proxy *Proxy, ok bool) {
+	cfg := ps.Config().Proxy.Quarantine
+	if !cfg.Enabled {
+		return
+	}
+	s := newQuarantineSettings(cfg)
+	from, to := proxy.quarantine.record(ok, s, time.Now())
+	if from == to {
+		return
+	}
+	switch to {
+	case ProxyDegraded:
+		slog.Warn(msgProxyDegraded, "proxy", proxy.Host, "from", from)
+	case ProxyQuarantined:
+		slog.Warn(msgProxyQuarantined, "proxy", proxy.Host, "from", from, "cooldown", s.cooldown.String())
+	case ProxyActive:
+		slog.Info(msgProxyActive, "proxy", proxy.Host, "from", from)
+	}
+}
+
+// ProxyState returns the quarantine state of the proxy and when it last changed, proxies are always active
+// while the quarantine is disabled. Draining proxies read as draining
+func (ps *ProxyServer) ProxyState(proxy *Proxy) (string, time.Time) {
+	if proxy.draining.Load() {
+		return ProxyDraining, time.Time{}
+	}
+	if !ps.Config().Proxy.Quarantine.Enabled {
+		return ProxyActive, time.Time{}
+	}
+	return proxy.quarantine.status(time.Now())
+}
+
+// rotatable skips draining and quarantined proxies, and degraded ones unless degraded is set
+func (ps *ProxyServer) rotatable(proxy *Proxy, now time.Time, degraded bool) bool {
+	if proxy.draining.Load() {
+		return false
+	}
+	if !ps.Config().Proxy.Quarantine.Enabled {
+		return true
+	}
+	state, _ := proxy.quarantine.status(now)
+	return state == ProxyActive || (degraded && state == ProxyDegraded)
+}
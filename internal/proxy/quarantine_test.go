@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuarantine(t *testing.T) {
+	s := newQuarantineSettings(config.QuarantineConfig{Window: 10, MinRequests: 4, DegradedErrorRate: 0.25, QuarantineErrorRate: 0.5, Cooldown: 60})
+	q := &quarantine{}
+	now := time.Now()
+
+	record := func(results ...bool) (from, to string) {
+		for _, ok := range results {
+			from, to = q.record(ok, s, now)
+		}
+		return from, to
+	}
+
+	// rates are only judged once min_requests attempts are in the window
+	from, to := record(false, true, true)
+	assert.Equal(t, ProxyActive, from)
+	assert.Equal(t, ProxyActive, to)
+
+	from, to = record(true)
+	assert.Equal(t, ProxyDegraded, to, "1 of 4 failed")
+	assert.Equal(t, ProxyActive, from)
+
+	_, to = record(true, true, true)
+	assert.Equal(t, ProxyActive, to, "1 of 7 failed")
+
+	_, to = record(false, false, false)
+	assert.Equal(t, ProxyDegraded, to, "4 of 10 failed")
+	_, to = record(false)
+	assert.Equal(t, ProxyDegraded, to, "the oldest failure dropped out of the window")
+	_, to = record(false)
+	assert.Equal(t, ProxyQuarantined, to, "5 of 10 failed")
+	from, to = q.record(true, s, now)
+	assert.Equal(t, ProxyQuarantined, from)
+	assert.Equal(t, ProxyQuarantined, to, "attempts are ignored while quarantined")
+
+	// after the cooldown the proxy is on probation with an empty window
+	now = now.Add(time.Minute)
+	state, changed := q.status(now)
+	assert.Equal(t, ProxyDegraded, state)
+	assert.False(t, changed.IsZero())
+	_, to = record(true, true, true, true)
+	assert.Equal(t, ProxyActive, to)
+}
+
+func TestPickProxyPrefersActiveProxies(t *testing.T) {
+	cfg := &config.Config{Proxy: config.ProxyConfig{
+		Quarantine: config.QuarantineConfig{Enabled: true, Window: 4, MinRequests: 4, Cooldown: 60},
+	}}
+	ps := NewProxyServer(cfg)
+	flaky := &Proxy{Host: "flaky"}
+	good := &Proxy{Host: "good"}
+	ps.SetProxies([]*Proxy{flaky, good})
+
+	for _, ok := range []bool{false, true, true, true} {
+		ps.recordResult(flaky, ok)
+	}
+	state, _ := ps.ProxyState(flaky)
+	assert.Equal(t, ProxyDegraded, state)
+	for range 4 {
+		assert.Same(t, good, ps.getProxy("roundrobin", proxyFilter{}))
+		assert.Same(t, good, ps.getProxy("random", proxyFilter{}))
+	}
+
+	// with every active proxy skipped degraded ones are still used, quarantined ones never
+	for range 4 {
+		ps.recordResult(good, false)
+	}
+	state, _ = ps.ProxyState(good)
+	assert.Equal(t, ProxyQuarantined, state)
+	assert.Same(t, flaky, ps.getProxy("roundrobin", proxyFilter{}))
+
+	for range 4 {
+		ps.recordResult(flaky, false)
+	}
+	assert.Nil(t, ps.getProxy("random", proxyFilter{}))
+}
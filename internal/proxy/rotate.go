@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const msgAssignmentsRotated = "sticky assignments rotated"
+
+// RotateScope picks the session pins Rotate drops, an empty field matches every value
+type RotateScope struct {
+	Username string
+	Session  string
+}
+
+// Rotation counts what Rotate reassigned
+type Rotation struct {
+	Sessions       int  `json:"sessions"`
+	SharedSessions int  `json:"shared_sessions"`
+	HashRing       bool `json:"hash_ring"`
+	Sequential     int  `json:"sequential"`
+}
+
+func (s RotateScope) all() bool {
+	return s.Username == "" && s.Session == ""
+}
+
+// matches reads the username and session back from a key made by sessionKey
+func (s RotateScope) matches(key string) bool {
+	parts := strings.Split(key, "\x00")
+	if len(parts) != 5 {
+		return false
+	}
+	return (s.Username == "" || parts[2] == s.Username) && (s.Session == "" || parts[3] == s.Session)
+}
+
+// Rotate drops the session pins in scope, here and in redis, so their next request picks a new proxy. An unscoped
+// rotation also moves every host to a new spot on the consistent_hash ring of this instance and sends the proxies
+// the sequential method is using to the back of the line
+func (ps *ProxyServer) Rotate(scope RotateScope) Rotation {
+	rotation := Rotation{
+		Sessions:       ps.sessions.drop(scope.matches, time.Now()),
+		SharedSessions: ps.shared.unpin(scope.matches),
+	}
+	if scope.all() {
+		ps.mu.Lock()
+		ps.ringShift++
+		rotation.Sequential = ps.rotateSequential()
+		ps.mu.Unlock()
+		rotation.HashRing = true
+	}
+	slog.Info(msgAssignmentsRotated,
+		"username", scope.Username,
+		"session", scope.Session,
+		"sessions", rotation.Sessions,
+		"shared_sessions", rotation.SharedSessions,
+		"hash_ring", rotation.HashRing,
+		"sequential", rotation.Sequential,
+	)
+	return rotation
+}
+
+// rotateSequential moves the proxies that served sequential picks since they last moved to the back, as if they
+// had reached rotate_after, and returns how many it moved. Callers hold ps.mu
+func (ps *ProxyServer) rotateSequential() int {
+	kept := make([]*Proxy, 0, len(ps.Proxies))
+	var heads []*Proxy
+	for _, p := range ps.Proxies {
+		if p.served == 0 {
+			kept = append(kept, p)
+			continue
+		}
+		p.served = 0
+		heads = append(heads, p)
+	}
+	ps.Proxies = append(kept, heads...)
+	return len(heads)
+}
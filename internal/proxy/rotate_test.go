@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/alpkeskin/rota/internal/middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRotate(t *testing.T) {
+	ps := NewProxyServer(&config.Config{})
+	for _, host := range []string{"a:1", "b:1", "c:1", "d:1"} {
+		ps.AddProxy(&Proxy{Scheme: "http", Host: host})
+	}
+	sessions := map[string]requestInfo{
+		"alice/s1": {directives: middleware.Directives{Username: "alice", Session: "s1"}},
+		"alice/s2": {directives: middleware.Directives{Username: "alice", Session: "s2"}},
+		"bob/s1":   {directives: middleware.Directives{Username: "bob", Session: "s1"}},
+	}
+	pinned := func() []string {
+		var names []string
+		for name, reqInfo := range sessions {
+			if ps.sessionProxy(reqInfo, proxyFilter{}) != nil {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+	for _, reqInfo := range sessions {
+		ps.pinSession(reqInfo, proxyFilter{}, ps.Proxies[0])
+	}
+
+	rotation := ps.Rotate(RotateScope{Username: "alice", Session: "s1"})
+	assert.Equal(t, Rotation{Sessions: 1}, rotation, "a scoped rotation leaves the hash ring alone")
+	assert.ElementsMatch(t, []string{"alice/s2", "bob/s1"}, pinned())
+
+	for _, reqInfo := range sessions {
+		ps.pinSession(reqInfo, proxyFilter{}, ps.Proxies[0])
+	}
+	assert.Equal(t, 2, ps.Rotate(RotateScope{Session: "s1"}).Sessions)
+	assert.ElementsMatch(t, []string{"alice/s2"}, pinned())
+
+	hashed := config.ProxyRotationConfig{Method: "consistent_hash"}
+	before := make(map[string]*Proxy)
+	for i := range 100 {
+		host := fmt.Sprintf("site%d.example", i)
+		before[host] = ps.selectProxy(hashed, proxyFilter{}, newPickKey(host, 0))
+	}
+	assert.Equal(t, Rotation{Sessions: 1, HashRing: true}, ps.Rotate(RotateScope{}))
+	assert.Empty(t, pinned())
+
+	moved := 0
+	for host, proxy := range before {
+		if ps.selectProxy(hashed, proxyFilter{}, newPickKey(host, 0)) != proxy {
+			moved++
+		}
+	}
+	// a quarter of the hosts land on their old proxy again by chance
+	assert.Greater(t, moved, 50)
+}
+
+func TestRotateSequential(t *testing.T) {
+	ps := NewProxyServer(&config.Config{})
+	for _, host := range []string{"a:1", "b:1", "c:1"} {
+		ps.AddProxy(&Proxy{Scheme: "http", Host: host})
+	}
+	sequential := config.ProxyRotationConfig{Method: "sequential", RotateAfter: 10}
+	pick := func() string {
+		return ps.selectProxy(sequential, proxyFilter{}, newPickKey("example.com", 0)).Host
+	}
+	for range 3 {
+		assert.Equal(t, "a:1", pick())
+	}
+
+	assert.Zero(t, ps.Rotate(RotateScope{Username: "alice"}).Sequential, "a scoped rotation keeps the head proxy")
+	assert.Equal(t, "a:1", pick())
+
+	assert.Equal(t, 1, ps.Rotate(RotateScope{}).Sequential)
+	for range 10 {
+		assert.Equal(t, "b:1", pick())
+	}
+	assert.Equal(t, "c:1", pick())
+	assert.Equal(t, []string{"c:1", "a:1", "b:1"}, []string{ps.Proxies[0].Host, ps.Proxies[1].Host, ps.Proxies[2].Host})
+	assert.Zero(t, ps.Proxies[1].served, "the rotated proxy starts over at the back")
+}
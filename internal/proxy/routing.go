@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+)
+
+const (
+	directProxyHost = "direct"
+
+	msgRoutedDirect = "request routed direct"
+)
+
+type route struct {
+	direct bool
+	filter proxyFilter
+}
+
+func newDirectProxy() *Proxy {
+	return &Proxy{
+		Host: directProxyHost,
+		Transport: &http.Transport{
+			DisableKeepAlives:  true,
+			DisableCompression: true,
+			TLSClientConfig:    &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+}
+
+// route returns the first routing rule matching host, hosts without a rule rotate within the listener's pool
+func (ps *ProxyServer) route(host string, listener *listenerConfig) route {
+	host = strings.ToLower(hostname(host))
+	for _, rule := range ps.Config().Routing {
+		if !matchesHost(rule.Hosts, host) {
+			continue
+		}
+		// a tenant's traffic leaves through the tenant's pool, never from this host
+		if rule.Direct && listener.tenant == "" {
+			return route{direct: true}
+		}
+
+		filter := listener.filter()
+		filter.scheme = rule.Scheme
+		if rule.Pool != "" && listener.tenant == "" {
+			filter.pool = rule.Pool
+		}
+		if rule.Tag != "" {
+			filter.tag = strings.ToLower(rule.Tag)
+		}
+		return route{filter: filter}
+	}
+	return route{filter: listener.filter()}
+}
+
+// patterns are shell globs, "*.example.com" matches every subdomain but not example.com itself
+func matchesHost(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(strings.ToLower(pattern), host); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func hostname(hostport string) string {
+	if host, _, err := net.SplitHostPort(hostport); err == nil {
+		return host
+	}
+	return strings.Trim(hostport, "[]")
+}
@@ -0,0 +1,200 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/elazarl/goproxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoute(t *testing.T) {
+	cfg := &config.Config{
+		Routing: []config.RoutingRuleConfig{
+			{Hosts: []string{"localhost", "*.internal.example.com"}, Direct: true},
+			{Hosts: []string{"*.google.com"}, Pool: "residential.txt", Scheme: "socks5"},
+			{Hosts: []string{"api.example.com"}, Scheme: "http"},
+		},
+	}
+	ps := NewProxyServer(cfg)
+	listener := &listenerConfig{pool: "proxies.txt"}
+
+	tests := []struct {
+		name string
+		host string
+		want route
+	}{
+		{"exact host direct", "localhost:8080", route{direct: true}},
+		{"wildcard direct", "git.internal.example.com", route{direct: true}},
+		{"wildcard needs a subdomain", "internal.example.com", route{filter: proxyFilter{pool: "proxies.txt"}}},
+		{"case insensitive", "WWW.Google.com:443", route{filter: proxyFilter{pool: "residential.txt", scheme: "socks5"}}},
+		{"scheme keeps the listener pool", "api.example.com", route{filter: proxyFilter{pool: "proxies.txt", scheme: "http"}}},
+		{"no rule", "example.org", route{filter: proxyFilter{pool: "proxies.txt"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, ps.route(tt.host, listener))
+		})
+	}
+}
+
+func TestPickProxyScheme(t *testing.T) {
+	ps := NewProxyServer(&config.Config{})
+	ps.SetProxies([]*Proxy{
+		{Host: "http://a", Scheme: "http"},
+		{Host: "socks5://b", Scheme: "socks5"},
+		{Host: "http://c", Scheme: "http"},
+	})
+
+	for range 3 {
+		assert.Equal(t, "socks5", ps.getProxy("roundrobin", proxyFilter{scheme: "socks5"}).Scheme)
+		assert.Equal(t, "http", ps.getProxy("random", proxyFilter{scheme: "http"}).Scheme)
+	}
+	assert.Nil(t, ps.getProxy("roundrobin", proxyFilter{scheme: "socks4"}))
+}
+
+func TestDirectRoute(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer target.Close()
+
+	cfg := &config.Config{
+		Proxy: config.ProxyConfig{
+			Rotation: config.ProxyRotationConfig{Method: "roundrobin", Retries: 1, Timeout: 5, FallbackMaxRetries: 1},
+		},
+		Routing: []config.RoutingRuleConfig{
+			{Hosts: []string{"127.0.0.1"}, Direct: true},
+		},
+	}
+	// the pool is empty, only a direct route can reach the target
+	ps := NewProxyServer(cfg)
+	goProxy := newGoProxy()
+	ps.setUpListenerHandlers(goProxy, 0)
+	server := httptest.NewServer(goProxy)
+	defer server.Close()
+
+	proxyURL, _ := url.Parse(server.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	resp, err := client.Get(target.URL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = client.Get("http://example.invalid/")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+}
+
+func TestDirectRouteConnect(t *testing.T) {
+	target := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer target.Close()
+
+	cfg := &config.Config{
+		Routing: []config.RoutingRuleConfig{
+			{Hosts: []string{"127.0.0.1"}, Direct: true},
+		},
+	}
+	ps := NewProxyServer(cfg)
+	goProxy := newGoProxy()
+	ps.setUpListenerHandlers(goProxy, 0)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	go ps.serveListener(goProxy, listener)
+
+	proxyURL := &url.URL{Scheme: "http", Host: listener.Addr().String()}
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyURL(proxyURL),
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	resp, err := client.Get(target.URL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	// the tunnel is not intercepted, the client sees the target's own certificate
+	assert.Equal(t, target.Certificate().Raw, resp.TLS.PeerCertificates[0].Raw)
+
+	assert.Equal(t, int64(1), ps.Stats().Tunnels())
+	client.CloseIdleConnections()
+	assert.Eventually(t, func() bool { return ps.Stats().Tunnels() == 0 }, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestDirectRouteTenant(t *testing.T) {
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer plain.Close()
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer secure.Close()
+	var mu sync.Mutex
+	var relayed []string
+	relay := goproxy.NewProxyHttpServer()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		relayed = append(relayed, r.Method)
+		mu.Unlock()
+		relay.ServeHTTP(w, r)
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{
+		Proxy: config.ProxyConfig{
+			Rotation:       config.ProxyRotationConfig{Method: "roundrobin", Retries: 1, Timeout: 5, FallbackMaxRetries: 1},
+			Authentication: config.ProxyAuthenticationConfig{Enabled: true},
+			Credentials: []config.CredentialConfig{
+				{Username: "alice", Password: "a", Tenant: "team-a"},
+				{Username: "eve", Password: "e", Tenant: "gone"},
+			},
+		},
+		Routing: []config.RoutingRuleConfig{{Hosts: []string{"127.0.0.1"}, Direct: true}},
+		Tenants: []config.TenantConfig{{Name: "team-a", ProxyFile: "team-a.txt"}},
+	}
+	ps := NewProxyServer(cfg)
+	proxy, err := NewProxyLoader(ps).CreateProxy(upstream.URL)
+	require.NoError(t, err)
+	proxy.Pool = "team-a.txt"
+	ps.SetProxies([]*Proxy{proxy})
+	goProxy := newGoProxy()
+	ps.setUpListenerHandlers(goProxy, 0)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go ps.serveListener(goProxy, listener)
+
+	client := func(user string) *http.Client {
+		proxyURL := &url.URL{Scheme: "http", User: url.UserPassword(user, user[:1]), Host: listener.Addr().String()}
+		return &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyURL(proxyURL),
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}}
+	}
+
+	// the direct rule does not apply to a tenant, plain requests and tunnels go through its pool
+	resp, err := client("alice").Get(plain.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, err = client("alice").Get(secure.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotEqual(t, secure.Certificate().Raw, resp.TLS.PeerCertificates[0].Raw, "the tunnel must not be opened directly")
+	mu.Lock()
+	assert.Equal(t, []string{http.MethodGet, http.MethodConnect}, relayed)
+	mu.Unlock()
+
+	// an account of a removed tenant gets no tunnel either
+	_, err = client("eve").Get(secure.URL)
+	assert.ErrorContains(t, err, "Forbidden")
+}
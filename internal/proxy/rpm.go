@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+)
+
+// rpmWindow remembers the start of the last max_rpm requests through a proxy. A request is allowed once the oldest of
+// them is a minute old, so no 60 second window ever holds more than max_rpm requests, bursts included
+type rpmWindow struct {
+	mu    sync.Mutex
+	times []time.Time
+	next  int
+}
+
+func newRPMWindow(maxRPM int) *rpmWindow {
+	if maxRPM <= 0 {
+		return nil
+	}
+	return &rpmWindow{times: make([]time.Time, maxRPM)}
+}
+
+// limit returns max_rpm, 0 for proxies without a cap
+func (w *rpmWindow) limit() int {
+	if w == nil {
+		return 0
+	}
+	return len(w.times)
+}
+
+// full reports whether the proxy served max_rpm requests in the last minute, proxies without a cap never are
+func (w *rpmWindow) full(now time.Time) bool {
+	if w == nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.fullLocked(now)
+}
+
+func (w *rpmWindow) fullLocked(now time.Time) bool {
+	oldest := w.times[w.next]
+	return !oldest.IsZero() && now.Sub(oldest) < time.Minute
+}
+
+// take counts a request when the cap allows it
+func (w *rpmWindow) take(now time.Time) bool {
+	if w == nil {
+		return true
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.fullLocked(now) {
+		return false
+	}
+	w.times[w.next] = now
+	w.next = (w.next + 1) % len(w.times)
+	return true
+}
@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRPMWindow(t *testing.T) {
+	assert.Nil(t, newRPMWindow(0))
+	var unlimited *rpmWindow
+	assert.True(t, unlimited.take(time.Now()))
+	assert.False(t, unlimited.full(time.Now()))
+
+	start := time.Now()
+	w := newRPMWindow(2)
+	assert.True(t, w.take(start))
+	assert.True(t, w.take(start.Add(30*time.Second)))
+	assert.True(t, w.full(start.Add(59*time.Second)))
+	assert.False(t, w.take(start.Add(59*time.Second)))
+	// the first request leaves the window, the second still counts
+	assert.True(t, w.take(start.Add(time.Minute)))
+	assert.False(t, w.take(start.Add(80*time.Second)))
+	assert.True(t, w.take(start.Add(90*time.Second)))
+}
+
+func TestMaxRPM(t *testing.T) {
+	capped := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("capped"))
+	}))
+	defer capped.Close()
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("other"))
+	}))
+	defer other.Close()
+
+	cfg := &config.Config{
+		Proxy: config.ProxyConfig{
+			Rotation: config.ProxyRotationConfig{Method: "roundrobin", Retries: 1, Timeout: 5, FallbackMaxRetries: 2, RemoveUnhealthy: true},
+		},
+		Upstreams: map[string]config.UpstreamConfig{capped.URL: {MaxRPM: 1}},
+	}
+	ps := NewProxyServer(cfg)
+	pl := NewProxyLoader(ps)
+	for _, u := range []string{capped.URL, other.URL} {
+		proxy, err := pl.CreateProxy(u)
+		require.NoError(t, err)
+		ps.AddProxy(proxy)
+	}
+	limited := ps.GetProxies()[0]
+
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	response, err := ps.tryProxy(limited, requestInfo{id: "test-id", request: req})
+	require.NoError(t, err)
+	response.Body.Close()
+	assert.True(t, limited.busy())
+	req, _ = http.NewRequest("GET", "http://example.com/", nil)
+	_, err = ps.tryProxy(limited, requestInfo{id: "test-id", request: req})
+	assert.ErrorIs(t, err, errProxyBusy)
+
+	// rotation skips the capped proxy without removing it
+	for range 2 {
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		response, err := ps.tryProxies(requestInfo{id: "test-id", request: req})
+		require.NoError(t, err)
+		body, _ := io.ReadAll(response.Body)
+		response.Body.Close()
+		assert.Equal(t, "other", string(body))
+	}
+	assert.Equal(t, 2, ps.ProxyCount())
+}
@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/middleware"
+	"github.com/elazarl/goproxy"
+)
+
+const defaultSessionTTL = 600
+
+type session struct {
+	proxy   *Proxy
+	expires time.Time
+}
+
+// sessionTable pins the sessions clients name in their username to a proxy, a session expires after session_ttl seconds without requests
+type sessionTable struct {
+	mu        sync.Mutex
+	sessions  map[string]*session
+	nextSweep time.Time
+}
+
+func (t *sessionTable) get(key string, now time.Time) *Proxy {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.sessions[key]
+	if !ok || now.After(s.expires) {
+		return nil
+	}
+	return s.proxy
+}
+
+func (t *sessionTable) pin(key string, proxy *Proxy, now time.Time, ttl time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.sessions == nil {
+		t.sessions = make(map[string]*session)
+	}
+	t.sessions[key] = &session{proxy: proxy, expires: now.Add(ttl)}
+
+	// abandoned sessions are dropped once per ttl
+	if now.Before(t.nextSweep) {
+		return
+	}
+	t.nextSweep = now.Add(ttl)
+	for k, s := range t.sessions {
+		if now.After(s.expires) {
+			delete(t.sessions, k)
+		}
+	}
+}
+
+func (t *sessionTable) count(now time.Time) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for _, s := range t.sessions {
+		if !now.After(s.expires) {
+			n++
+		}
+	}
+	return n
+}
+
+// drop removes the sessions whose key matches and returns how many of them had not expired
+func (t *sessionTable) drop(match func(key string) bool, now time.Time) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for k, s := range t.sessions {
+		if !match(k) {
+			continue
+		}
+		if !now.After(s.expires) {
+			n++
+		}
+		delete(t.sessions, k)
+	}
+	return n
+}
+
+func (ps *ProxyServer) sessionTTL() time.Duration {
+	ttl := ps.Config().Proxy.SessionTTL
+	if ttl <= 0 {
+		ttl = defaultSessionTTL
+	}
+	return time.Duration(ttl) * time.Second
+}
+
+// SessionCount returns the number of sessions that have not expired
+func (ps *ProxyServer) SessionCount() int {
+	return ps.sessions.count(time.Now())
+}
+
+// sessions of different pools never share a proxy
+func sessionKey(directives middleware.Directives, filter proxyFilter) string {
+	return strings.Join([]string{filter.pool, filter.tag, directives.Username, directives.Session, directives.Country}, "\x00")
+}
+
+// sessionProxy returns the proxy pinned to the request's session while it is still in the pool and healthy
+func (ps *ProxyServer) sessionProxy(reqInfo requestInfo, filter proxyFilter) *Proxy {
+	if reqInfo.directives.Session == "" {
+		return nil
+	}
+
+	now := time.Now()
+	key := sessionKey(reqInfo.directives, filter)
+	if host, ok := ps.shared.session(key); ok {
+		return ps.sharedSessionProxy(host, filter, now)
+	}
+	proxy := ps.sessions.get(key, now)
+	if proxy == nil || !filter.matches(proxy) || !proxy.breaker.allows(now, ps.breakerCooldown()) || !ps.rotatable(proxy, now, true) {
+		return nil
+	}
+
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	if !slices.Contains(ps.Proxies, proxy) {
+		return nil
+	}
+	return proxy
+}
+
+func (ps *ProxyServer) pinSession(reqInfo requestInfo, filter proxyFilter, proxy *Proxy) {
+	if reqInfo.directives.Session == "" {
+		return
+	}
+	key := sessionKey(reqInfo.directives, filter)
+	ps.sessions.pin(key, proxy, time.Now(), ps.sessionTTL())
+	ps.shared.pin(key, proxy.Host, ps.sessionTTL())
+}
+
+// sharedSessionProxy finds the proxy another instance pinned, proxies are matched by their address
+func (ps *ProxyServer) sharedSessionProxy(host string, filter proxyFilter, now time.Time) *Proxy {
+	if host == "" {
+		return nil
+	}
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	for _, p := range ps.Proxies {
+		if p.Host == host && filter.matches(p) && p.breaker.allows(now, ps.breakerCooldown()) && ps.rotatable(p, now, true) {
+			return p
+		}
+	}
+	return nil
+}
+
+// requestDirectives returns the client's username, and its directives when the listener reads them.
+// Requests inside a MITM tunnel carry no credentials, they inherit the directives of the CONNECT request
+func (ps *ProxyServer) requestDirectives(r *http.Request, ctx *goproxy.ProxyCtx, listener *listenerConfig) middleware.Directives {
+	if !listener.authentication.Enabled {
+		return middleware.Directives{}
+	}
+	if directives, ok := ctx.UserData.(middleware.Directives); ok {
+		return directives
+	}
+	if !listener.authentication.Directives {
+		return middleware.Directives{Username: middleware.RequestUsername(r)}
+	}
+	return middleware.RequestDirectives(r)
+}
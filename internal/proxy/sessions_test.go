@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/alpkeskin/rota/internal/middleware"
+	"github.com/elazarl/goproxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionTable(t *testing.T) {
+	table := &sessionTable{}
+	now := time.Now()
+	a := &Proxy{Host: "a"}
+
+	assert.Nil(t, table.get("s1", now))
+	table.pin("s1", a, now, time.Minute)
+	assert.Same(t, a, table.get("s1", now.Add(30*time.Second)))
+	assert.Nil(t, table.get("s1", now.Add(2*time.Minute)))
+	assert.Equal(t, 1, table.count(now))
+
+	// the next pin after a ttl drops expired sessions
+	table.pin("s2", a, now.Add(2*time.Minute), time.Minute)
+	assert.Len(t, table.sessions, 1)
+}
+
+func TestSessionDirectives(t *testing.T) {
+	var upstreams []*httptest.Server
+	for i := range 3 {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Upstream", strconv.Itoa(i))
+		}))
+		defer upstream.Close()
+		upstreams = append(upstreams, upstream)
+	}
+
+	cfg := &config.Config{
+		Proxy: config.ProxyConfig{
+			Rotation: config.ProxyRotationConfig{
+				Method:             "roundrobin",
+				Retries:            1,
+				FallbackMaxRetries: 1,
+			},
+		},
+	}
+	ps := NewProxyServer(cfg)
+	pl := NewProxyLoader(ps)
+	var proxies []*Proxy
+	for i, country := range []string{"DE", "US", "DE"} {
+		proxy, err := pl.CreateProxy(upstreams[i].URL)
+		require.NoError(t, err)
+		proxy.geo.Store(&GeoLocation{Country: country})
+		proxies = append(proxies, proxy)
+	}
+	ps.SetProxies(proxies)
+
+	served := func(directives middleware.Directives) string {
+		req, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+		response, err := ps.tryProxies(requestInfo{id: "test-id", request: req, directives: directives})
+		require.NoError(t, err)
+		response.Body.Close()
+		return response.Header.Get("X-Upstream")
+	}
+
+	session := middleware.Directives{Username: "user", Session: "abc"}
+	first := served(session)
+	for range 4 {
+		assert.Equal(t, first, served(session))
+	}
+	assert.NotEqual(t, first, served(middleware.Directives{Username: "user", Session: "other"}))
+	assert.Equal(t, 2, ps.SessionCount())
+
+	// a pinned proxy whose circuit opened is replaced
+	cfg.Proxy.CircuitBreaker = config.CircuitBreakerConfig{Failures: 1, Cooldown: 60}
+	pinned, err := strconv.Atoi(first)
+	require.NoError(t, err)
+	ps.recordResult(proxies[pinned], false)
+	replaced := served(session)
+	assert.NotEqual(t, first, replaced)
+	assert.Equal(t, replaced, served(session))
+
+	for range 4 {
+		host := served(middleware.Directives{Username: "user", Country: "DE"})
+		assert.NotEqual(t, "1", host)
+	}
+	for range 2 {
+		assert.Equal(t, "1", served(middleware.Directives{Username: "user", Country: "US"}))
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	_, err = ps.tryProxies(requestInfo{id: "test-id", request: req, directives: middleware.Directives{Username: "user", Country: "FR"}})
+	assert.Error(t, err)
+}
+
+func TestRequestDirectivesDisabled(t *testing.T) {
+	ps := NewProxyServer(&config.Config{})
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	req.SetBasicAuth("user-session-abc", "pass")
+	req.Header.Set(middleware.ProxyAuthHeader, req.Header.Get("Authorization"))
+
+	listener := &listenerConfig{authentication: config.ProxyAuthenticationConfig{Enabled: true}}
+	// without directives the username is kept as sent, it names the credential in the request history
+	assert.Equal(t, middleware.Directives{Username: "user-session-abc"}, ps.requestDirectives(req, &goproxy.ProxyCtx{Req: req}, listener))
+
+	listener.authentication.Directives = true
+	assert.Equal(t, middleware.Directives{Username: "user", Session: "abc"}, ps.requestDirectives(req, &goproxy.ProxyCtx{Req: req}, listener))
+
+	// requests inside a tunnel inherit the directives of the CONNECT request
+	inherited := middleware.Directives{Username: "user", Country: "DE"}
+	assert.Equal(t, inherited, ps.requestDirectives(req, &goproxy.ProxyCtx{Req: req, UserData: inherited}, listener))
+}
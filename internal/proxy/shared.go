@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/alpkeskin/rota/pkg/redis"
+)
+
+const (
+	defaultRedisPrefix  = "rota"
+	defaultRedisTimeout = 1
+
+	msgSharedStateFailed = "shared state failed, using local state"
+)
+
+// sharedState keeps the round robin turns and sessions in redis so instances behind one load balancer rotate
+// as one, every call falls back to the local state when redis can not be reached
+type sharedState struct {
+	client *redis.Client
+	prefix string
+}
+
+func newSharedState(cfg config.RedisConfig) *sharedState {
+	if !cfg.Enabled || cfg.Address == "" {
+		return nil
+	}
+	prefix := cfg.Prefix
+	if prefix == "" {
+		prefix = defaultRedisPrefix
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultRedisTimeout
+	}
+	return &sharedState{
+		client: redis.NewWithPool(cfg.Address, cfg.Password, cfg.DB, time.Duration(timeout)*time.Second, redis.Pool{
+			MaxIdle:     cfg.MaxIdleConns,
+			RetryAfter:  time.Duration(cfg.RetryAfter) * time.Second,
+			DialTimeout: time.Duration(cfg.DialTimeout) * time.Second,
+		}),
+		prefix: prefix,
+	}
+}
+
+// turn counts the picks of one filter across instances
+func (s *sharedState) turn(filter proxyFilter) (int64, bool) {
+	if s == nil {
+		return 0, false
+	}
+	key := strings.Join([]string{filter.pool, filter.scheme, filter.tag, filter.country, strings.Join(filter.countries, ",")}, "\x00")
+	n, err := s.client.Int("INCR", s.prefix+":roundrobin:"+key)
+	if err != nil {
+		s.failed(err)
+		return 0, false
+	}
+	return n - 1, true
+}
+
+// session returns the host of the proxy pinned to a session by any instance
+func (s *sharedState) session(key string) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	host, err := s.client.String("GET", s.prefix+":session:"+key)
+	if errors.Is(err, redis.ErrNil) {
+		return "", true
+	}
+	if err != nil {
+		s.failed(err)
+		return "", false
+	}
+	return host, true
+}
+
+func (s *sharedState) pin(key, host string, ttl time.Duration) {
+	if s == nil {
+		return
+	}
+	if _, err := s.client.Do("SET", s.prefix+":session:"+key, host, "PX", strconv.FormatInt(ttl.Milliseconds(), 10)); err != nil {
+		s.failed(err)
+	}
+}
+
+// unpin deletes the shared session pins whose key matches and returns how many it deleted
+func (s *sharedState) unpin(match func(key string) bool) int {
+	if s == nil {
+		return 0
+	}
+	prefix := s.prefix + ":session:"
+	n := 0
+	cursor := "0"
+	for {
+		reply, err := s.client.Do("SCAN", cursor, "MATCH", prefix+"*", "COUNT", "100")
+		if err != nil {
+			s.failed(err)
+			return n
+		}
+		items, ok := reply.([]any)
+		if !ok || len(items) != 2 {
+			return n
+		}
+		cursor, _ = items[0].(string)
+		keys, _ := items[1].([]any)
+		for _, item := range keys {
+			key, _ := item.(string)
+			if !strings.HasPrefix(key, prefix) || !match(strings.TrimPrefix(key, prefix)) {
+				continue
+			}
+			if _, err := s.client.Do("DEL", key); err != nil {
+				s.failed(err)
+				return n
+			}
+			n++
+		}
+		if cursor == "0" || cursor == "" {
+			return n
+		}
+	}
+}
+
+func (s *sharedState) failed(err error) {
+	if !errors.Is(err, redis.ErrUnavailable) {
+		slog.Warn(msgSharedStateFailed, "error", err)
+	}
+}
+
+// pickTurn picks the usable proxy whose turn it is, the shared slice is not reordered so every instance
+// with the same proxy list agrees on the order
+func (ps *ProxyServer) pickTurn(filter proxyFilter, turn int64) *Proxy {
+	now := time.Now()
+	cooldown := ps.breakerCooldown()
+	usable := make([]*Proxy, 0, len(ps.Proxies))
+	for _, degraded := range []bool{false, true} {
+		for _, p := range ps.Proxies {
+			if filter.matches(p) && !p.busy() && p.breaker.allows(now, cooldown) && ps.rotatable(p, now, degraded) {
+				usable = append(usable, p)
+			}
+		}
+		if len(usable) > 0 || !ps.Config().Proxy.Quarantine.Enabled {
+			break
+		}
+	}
+	if len(usable) == 0 {
+		return nil
+	}
+	picked := usable[turn%int64(len(usable))]
+	picked.breaker.claimProbe(now, cooldown)
+	return picked
+}
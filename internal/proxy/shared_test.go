@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/alpkeskin/rota/internal/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newFakeRedis starts a server that keeps keys in memory and answers INCR, GET, SET, PEXPIRE, DEL and SCAN,
+// expiry is ignored and SCAN returns every key with the MATCH prefix in one go
+func newFakeRedis(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	var mu sync.Mutex
+	keys := make(map[string]string)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					args, err := readCommand(r)
+					if err != nil {
+						return
+					}
+					mu.Lock()
+					var reply string
+					switch strings.ToUpper(args[0]) {
+					case "INCR":
+						n, _ := strconv.Atoi(keys[args[1]])
+						keys[args[1]] = strconv.Itoa(n + 1)
+						reply = fmt.Sprintf(":%d\r\n", n+1)
+					case "GET":
+						value, ok := keys[args[1]]
+						reply = "$-1\r\n"
+						if ok {
+							reply = fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+						}
+					case "SET":
+						keys[args[1]] = args[2]
+						reply = "+OK\r\n"
+					case "PEXPIRE":
+						reply = ":1\r\n"
+					case "DEL":
+						_, ok := keys[args[1]]
+						delete(keys, args[1])
+						reply = ":0\r\n"
+						if ok {
+							reply = ":1\r\n"
+						}
+					case "SCAN":
+						var b strings.Builder
+						n := 0
+						for key := range keys {
+							if strings.HasPrefix(key, strings.TrimSuffix(args[3], "*")) {
+								fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(key), key)
+								n++
+							}
+						}
+						reply = fmt.Sprintf("*2\r\n$1\r\n0\r\n*%d\r\n%s", n, b.String())
+					default:
+						reply = "-ERR unknown command\r\n"
+					}
+					mu.Unlock()
+					if _, err := conn.Write([]byte(reply)); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	var n int
+	if _, err := fmt.Fscanf(r, "*%d\r\n", &n); err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		var size int
+		if _, err := fmt.Fscanf(r, "$%d\r\n", &size); err != nil {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
+
+func TestSharedState(t *testing.T) {
+	cfg := &config.Config{
+		Redis: config.RedisConfig{Enabled: true, Address: newFakeRedis(t)},
+		Proxy: config.ProxyConfig{RateLimit: config.ProxyRateLimitConfig{Enabled: true, By: "credential", RequestsPerSecond: 0.01, Burst: 2}},
+	}
+	instances := []*ProxyServer{NewProxyServer(cfg), NewProxyServer(cfg)}
+	for _, ps := range instances {
+		for _, host := range []string{"a", "b", "c"} {
+			ps.AddProxy(&Proxy{Host: host})
+		}
+	}
+
+	t.Run("round robin", func(t *testing.T) {
+		picked := make([]string, 0)
+		for i := 0; i < 6; i++ {
+			picked = append(picked, instances[i%2].getProxy("roundrobin", proxyFilter{}).Host)
+		}
+		assert.Equal(t, []string{"a", "b", "c", "a", "b", "c"}, picked)
+	})
+
+	t.Run("sessions", func(t *testing.T) {
+		reqInfo := requestInfo{directives: middleware.Directives{Username: "user", Session: "s1"}}
+		assert.Nil(t, instances[1].sessionProxy(reqInfo, proxyFilter{}))
+		instances[0].pinSession(reqInfo, proxyFilter{}, instances[0].Proxies[1])
+		assert.Equal(t, "b", instances[1].sessionProxy(reqInfo, proxyFilter{}).Host)
+
+		rotation := instances[1].Rotate(RotateScope{Session: "s1"})
+		assert.Equal(t, 1, rotation.SharedSessions)
+		assert.Nil(t, instances[0].sessionProxy(reqInfo, proxyFilter{}))
+	})
+
+	t.Run("rate limits", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "http://example.com", nil)
+		allowed := 0
+		for i := 0; i < 4; i++ {
+			if instances[i%2].middleware.AllowRequest(cfg.Proxy.RateLimit, req, "user") {
+				allowed++
+			}
+		}
+		assert.Equal(t, 2, allowed)
+	})
+}
+
+func TestSharedStateUnavailable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	ps := NewProxyServer(&config.Config{Redis: config.RedisConfig{Enabled: true, Address: addr}})
+	ps.AddProxy(&Proxy{Host: "a"})
+	ps.AddProxy(&Proxy{Host: "b"})
+	assert.Equal(t, "a", ps.getProxy("roundrobin", proxyFilter{}).Host)
+	assert.Equal(t, "b", ps.getProxy("roundrobin", proxyFilter{}).Host)
+}
@@ -0,0 +1,179 @@
+package proxy
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	msgShuttingDown     = "shutting down proxy servers"
+	msgRequestCutOff    = "request cut off by shutdown"
+	msgTunnelCutOff     = "tunnel cut off by shutdown"
+	msgShutdownComplete = "proxy servers shut down"
+
+	defaultShutdownTimeout = 30 // seconds
+	shutdownPollInterval   = 50 * time.Millisecond
+)
+
+// ShutdownReport counts what was in flight when the shutdown started and what was still open at its deadline
+type ShutdownReport struct {
+	Requests    int
+	Tunnels     int
+	CutRequests int
+	CutTunnels  int
+}
+
+// connTable follows the proxy servers and their client connections, http.Server forgets a connection once
+// goproxy hijacks it for a tunnel
+type connTable struct {
+	mu       sync.Mutex
+	closing  bool
+	servers  []*http.Server
+	requests map[net.Conn]struct{}
+	tunnels  map[*clientConn]openTunnel
+}
+
+type openTunnel struct {
+	target   string
+	openedAt time.Time
+}
+
+// add registers a server, false once the shutdown started so a late listener does not serve at all
+func (t *connTable) add(srv *http.Server) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closing {
+		return false
+	}
+	t.servers = append(t.servers, srv)
+	return true
+}
+
+// connState counts connections serving a request, hijacked ones are counted as tunnels instead
+func (t *connTable) connState(conn net.Conn, state http.ConnState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if state == http.StateActive {
+		if t.requests == nil {
+			t.requests = make(map[net.Conn]struct{})
+		}
+		t.requests[conn] = struct{}{}
+		return
+	}
+	delete(t.requests, conn)
+}
+
+func (t *connTable) openTunnel(conn *clientConn, target string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tunnels == nil {
+		t.tunnels = make(map[*clientConn]openTunnel)
+	}
+	t.tunnels[conn] = openTunnel{target: target, openedAt: time.Now()}
+}
+
+func (t *connTable) closeTunnel(conn *clientConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.tunnels, conn)
+}
+
+func (t *connTable) close() []*http.Server {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closing = true
+	return t.servers
+}
+
+func (t *connTable) inFlight() (requests, tunnels int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.requests), len(t.tunnels)
+}
+
+// ShuttingDown tells whether Shutdown was called, the proxy accepts no new connections from then on
+func (ps *ProxyServer) ShuttingDown() bool {
+	ps.conns.mu.Lock()
+	defer ps.conns.mu.Unlock()
+	return ps.conns.closing
+}
+
+// Shutdown stops accepting proxy connections and waits up to proxy.timeouts.shutdown for the requests and tunnels
+// in flight, whatever is still open then is closed and logged
+func (ps *ProxyServer) Shutdown() ShutdownReport {
+	timeout := ps.Config().Proxy.Timeouts.Shutdown
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+	return ps.shutdown(ctx)
+}
+
+func (ps *ProxyServer) shutdown(ctx context.Context) ShutdownReport {
+	servers := ps.conns.close()
+	// Shutdown closes the listener and idle connections and closes the others once their request is answered
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = srv.Shutdown(ctx)
+		}()
+	}
+
+	var report ShutdownReport
+	report.Requests, report.Tunnels = ps.conns.inFlight()
+	slog.Info(msgShuttingDown, "requests", report.Requests, "tunnels", report.Tunnels)
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for requests, tunnels := report.Requests, report.Tunnels; requests > 0 || tunnels > 0; requests, tunnels = ps.conns.inFlight() {
+		select {
+		case <-ctx.Done():
+			report.CutRequests, report.CutTunnels = ps.cutOff(servers)
+			wg.Wait()
+			slog.Warn(msgShutdownComplete, "cut_requests", report.CutRequests, "cut_tunnels", report.CutTunnels)
+			return report
+		case <-ticker.C:
+		}
+	}
+	wg.Wait()
+	slog.Info(msgShutdownComplete)
+	return report
+}
+
+// cutOff closes the connections still open at the shutdown deadline
+func (ps *ProxyServer) cutOff(servers []*http.Server) (requests, tunnels int) {
+	ps.conns.mu.Lock()
+	cutRequests := make([]net.Conn, 0, len(ps.conns.requests))
+	for conn := range ps.conns.requests {
+		cutRequests = append(cutRequests, conn)
+	}
+	cutTunnels := make(map[*clientConn]openTunnel, len(ps.conns.tunnels))
+	for conn, tunnel := range ps.conns.tunnels {
+		cutTunnels[conn] = tunnel
+	}
+	ps.conns.mu.Unlock()
+
+	for _, conn := range cutRequests {
+		slog.Warn(msgRequestCutOff, "client", conn.RemoteAddr().String())
+	}
+	for _, srv := range servers {
+		_ = srv.Close()
+	}
+	// closing a tunnel runs its onClose, which takes the table lock
+	for conn, tunnel := range cutTunnels {
+		slog.Warn(msgTunnelCutOff,
+			"target", tunnel.target,
+			"client", conn.RemoteAddr().String(),
+			"open_for", time.Since(tunnel.openedAt).Round(time.Millisecond).String(),
+		)
+		conn.Close()
+	}
+	return len(cutRequests), len(cutTunnels)
+}
@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShutdown(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{Proxy: config.ProxyConfig{
+		Rotation: config.ProxyRotationConfig{Method: "roundrobin", Retries: 1, Timeout: 5, FallbackMaxRetries: 1},
+	}}
+	ps := NewProxyServer(cfg)
+	proxy, err := NewProxyLoader(ps).CreateProxy(upstream.URL)
+	require.NoError(t, err)
+	ps.AddProxy(proxy)
+	ps.setUpHandlers()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	served := make(chan error, 1)
+	go func() { served <- ps.serveListener(ps.goProxy, listener) }()
+	proxyURL := &url.URL{Scheme: "http", Host: listener.Addr().String()}
+
+	// a request waiting on the upstream and a tunnel nobody speaks in
+	responses := make(chan *http.Response, 1)
+	go func() {
+		client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+		response, err := client.Get("http://example.com/")
+		if err == nil {
+			response.Body.Close()
+		}
+		responses <- response
+	}()
+	tunnel, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer tunnel.Close()
+	fmt.Fprintf(tunnel, "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n")
+	response, err := http.ReadResponse(bufio.NewReader(tunnel), nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Eventually(t, func() bool {
+		requests, tunnels := ps.conns.inFlight()
+		return requests == 1 && tunnels == 1
+	}, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	time.AfterFunc(50*time.Millisecond, func() { close(release) })
+	report := ps.shutdown(ctx)
+
+	assert.Equal(t, ShutdownReport{Requests: 1, Tunnels: 1, CutTunnels: 1}, report)
+	assert.True(t, ps.ShuttingDown())
+	assert.NoError(t, <-served, "a shutdown is not a serve error")
+	if response := <-responses; assert.NotNil(t, response, "the request in flight was answered") {
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+	}
+	_, err = tunnel.Read(make([]byte, 1))
+	assert.Error(t, err, "the tunnel was closed")
+	_, err = net.Dial("tcp", listener.Addr().String())
+	assert.Error(t, err, "no new connections are accepted")
+}
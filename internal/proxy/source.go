@@ -0,0 +1,246 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+)
+
+const (
+	defaultSourceInterval = 3600
+	sourceFetchTimeout    = 30 * time.Second
+	maxSourceSize         = 10 << 20
+
+	msgFetchingSource      = "fetching proxy source"
+	msgFailedToFetchSource = "failed to fetch proxy source"
+	msgSourceUpdated       = "proxy source updated"
+	msgUnexpectedStatus    = "unexpected status"
+)
+
+type SourceStatus struct {
+	URL       string    `json:"url"`
+	Provider  string    `json:"provider,omitempty"`
+	Pool      string    `json:"pool"`
+	Proxies   int       `json:"proxies"`
+	LastFetch time.Time `json:"last_fetch"`
+	Error     string    `json:"error,omitempty"`
+}
+
+type sourceStatuses struct {
+	mu       sync.RWMutex
+	statuses map[string]SourceStatus
+}
+
+// RunSources fetches every configured source right away and then on its interval, it blocks forever
+func (pl *ProxyLoader) RunSources() {
+	var wg sync.WaitGroup
+	for _, source := range pl.config().Sources {
+		interval := source.Interval
+		if interval <= 0 {
+			interval = defaultSourceInterval
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(time.Duration(interval) * time.Second)
+			defer ticker.Stop()
+			for {
+				pl.refreshSource(source)
+				<-ticker.C
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// RefreshSource replaces the proxies of a source with its current list, the previous list is kept when the fetch fails
+func (pl *ProxyLoader) RefreshSource(sourceURL, pool string) {
+	pl.refreshSource(config.SourceConfig{URL: sourceURL, Pool: pool})
+}
+
+func (pl *ProxyLoader) refreshSource(source config.SourceConfig) {
+	pool := source.Pool
+	if pool == "" {
+		pool = pl.config().ProxyFile
+	}
+	sourceURL := sourceID(source)
+	status := SourceStatus{URL: sourceURL, Provider: source.Provider, Pool: pool, LastFetch: time.Now()}
+	defer func() { pl.proxyServer.sources.set(status) }()
+
+	slog.Info(msgFetchingSource, "url", sourceURL, "provider", source.Provider)
+	var proxies []*Proxy
+	var err error
+	if source.Provider != "" {
+		proxies, err = pl.fetchProvider(source)
+	} else {
+		proxies, err = pl.fetchSource(sourceURL)
+	}
+	if err != nil {
+		slog.Error(msgFailedToFetchSource, "error", err, "url", sourceURL)
+		status.Error = err.Error()
+		status.Proxies = pl.proxyServer.sourceProxyCount(sourceURL)
+		return
+	}
+
+	for _, proxy := range proxies {
+		proxy.Pool = pool
+		proxy.Source = sourceURL
+	}
+	if pl.config().Healthcheck.ValidateOnLoad {
+		proxies = NewProxyChecker(pl.proxyServer).Alive(proxies)
+	}
+
+	added, removed := pl.proxyServer.setSourceProxies(sourceURL, proxies)
+	pl.proxyServer.pruneTransports()
+	status.Proxies = len(proxies)
+	slog.Info(msgSourceUpdated, "url", sourceURL, "proxies", len(proxies), "added", added, "removed", removed)
+}
+
+func (pl *ProxyLoader) fetchSource(sourceURL string) ([]*Proxy, error) {
+	client := &http.Client{Timeout: sourceFetchTimeout}
+	resp, err := client.Get(sourceURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", msgUnexpectedStatus, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSourceSize))
+	if err != nil {
+		return nil, err
+	}
+	return pl.parseProxies(string(data)), nil
+}
+
+func (ps *ProxyServer) Sources() []SourceStatus {
+	return ps.sources.all()
+}
+
+// setSourceProxies swaps the proxies of one source, proxies that are still listed keep their place and tags
+func (ps *ProxyServer) setSourceProxies(source string, proxies []*Proxy) (int, int) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	listed := make(map[string]*Proxy, len(proxies))
+	for _, p := range proxies {
+		listed[p.Host] = p
+	}
+
+	removed := 0
+	kept := make([]*Proxy, 0, len(ps.Proxies)+len(proxies))
+	for _, p := range ps.Proxies {
+		if p.Source != source {
+			kept = append(kept, p)
+			continue
+		}
+		if _, ok := listed[p.Host]; ok {
+			delete(listed, p.Host)
+			kept = append(kept, p)
+			continue
+		}
+		removed++
+	}
+
+	added := 0
+	for _, p := range proxies {
+		if _, ok := listed[p.Host]; ok {
+			delete(listed, p.Host)
+			kept = append(kept, p)
+			added++
+		}
+	}
+
+	ps.Proxies = kept
+	ps.poolChanged()
+	return added, removed
+}
+
+// setFileProxies replaces the proxies read from proxy files, proxies fetched from sources are kept. Proxies that are
+// still listed with the same settings keep their place in the rotation and their breaker, latency and check state,
+// so a reload does not send the next burst of requests to the first proxies of the file
+func (ps *ProxyServer) setFileProxies(proxies []*Proxy) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	listed := make(map[string]*Proxy, len(proxies))
+	for _, p := range proxies {
+		listed[p.Pool+"\x00"+p.Host] = p
+	}
+
+	kept := make([]*Proxy, 0, len(ps.Proxies)+len(proxies))
+	for _, p := range ps.Proxies {
+		if p.Source != "" {
+			kept = append(kept, p)
+			continue
+		}
+		key := p.Pool + "\x00" + p.Host
+		if updated, ok := listed[key]; ok && sameUpstream(p, updated) {
+			// tags are replaced instead of modified in place, readers may still hold the old slice
+			p.Tags = updated.Tags
+			listed[key] = p
+			kept = append(kept, p)
+		}
+	}
+	for _, p := range proxies {
+		if listed[p.Pool+"\x00"+p.Host] == p {
+			kept = append(kept, p)
+		}
+	}
+
+	ps.Proxies = kept
+	ps.poolChanged()
+}
+
+// sameUpstream reports whether a reloaded proxy is built like the running one. The transport cache hands out the
+// same transport for the same url, credentials and upstream settings
+func sameUpstream(running, reloaded *Proxy) bool {
+	return running.Transport == reloaded.Transport &&
+		running.healthcheck == reloaded.healthcheck &&
+		cap(running.slots) == cap(reloaded.slots) &&
+		running.rpm.limit() == reloaded.rpm.limit()
+}
+
+func (ps *ProxyServer) sourceProxyCount(source string) int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	count := 0
+	for _, p := range ps.Proxies {
+		if p.Source == source {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *sourceStatuses) set(status SourceStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.statuses == nil {
+		s.statuses = make(map[string]SourceStatus)
+	}
+	s.statuses[status.URL] = status
+}
+
+func (s *sourceStatuses) all() []SourceStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	statuses := make([]SourceStatus, 0, len(s.statuses))
+	for _, status := range s.statuses {
+		statuses = append(statuses, status)
+	}
+	slices.SortFunc(statuses, func(a, b SourceStatus) int {
+		return strings.Compare(a.URL, b.URL)
+	})
+	return statuses
+}
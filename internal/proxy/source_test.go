@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefreshSource(t *testing.T) {
+	var list atomic.Value
+	list.Store("http://127.0.0.1:8080 residential\nhttp://127.0.0.1:8081")
+	var failing atomic.Bool
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(list.Load().(string)))
+	}))
+	defer source.Close()
+
+	file := filepath.Join(t.TempDir(), "proxies.txt")
+	if err := os.WriteFile(file, []byte("socks5://127.0.0.1:1080"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{ProxyFile: file}
+	ps := NewProxyServer(cfg)
+	pl := NewProxyLoader(ps)
+	assert.NoError(t, pl.Load())
+
+	hosts := func() []string {
+		var hosts []string
+		for _, p := range ps.GetProxies() {
+			hosts = append(hosts, p.Host)
+		}
+		return hosts
+	}
+
+	pl.RefreshSource(source.URL, "")
+	assert.Equal(t, []string{"socks5://127.0.0.1:1080", "http://127.0.0.1:8080", "http://127.0.0.1:8081"}, hosts())
+	kept := ps.GetProxies()[1]
+	assert.Equal(t, file, kept.Pool)
+	assert.Equal(t, source.URL, kept.Source)
+	assert.Equal(t, []string{"residential"}, kept.Tags)
+
+	// proxies that disappeared are dropped, the ones still listed are the same proxies
+	list.Store("http://127.0.0.1:8080\nhttp://127.0.0.1:8082")
+	pl.RefreshSource(source.URL, "")
+	assert.Equal(t, []string{"socks5://127.0.0.1:1080", "http://127.0.0.1:8080", "http://127.0.0.1:8082"}, hosts())
+	assert.Same(t, kept, ps.GetProxies()[1])
+
+	// reloading the proxy file keeps the source proxies
+	assert.NoError(t, pl.Reload())
+	assert.Len(t, ps.GetProxies(), 3)
+
+	failing.Store(true)
+	pl.RefreshSource(source.URL, "")
+	assert.Len(t, ps.GetProxies(), 3)
+
+	statuses := ps.Sources()
+	assert.Len(t, statuses, 1)
+	assert.Equal(t, source.URL, statuses[0].URL)
+	assert.Equal(t, 2, statuses[0].Proxies)
+	assert.Contains(t, statuses[0].Error, "500")
+}
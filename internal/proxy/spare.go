@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// a spare connection is checked for a close by the proxy without waiting for data
+	spareProbeTimeout = time.Millisecond
+)
+
+// spareDialer keeps connections to an upstream proxy dialed ahead, so a new tunnel starts with the CONNECT instead
+// of the tcp handshake. Spares are dialed once the proxy is first used and closed when they outlive maxAge
+type spareDialer struct {
+	dial    func(ctx context.Context, network, addr string) (net.Conn, error)
+	size    int
+	maxAge  time.Duration
+	timeout time.Duration
+
+	mu      sync.Mutex
+	spares  map[string][]*spareConn
+	dialing map[string]int
+}
+
+type spareConn struct {
+	net.Conn
+	expire *time.Timer
+}
+
+// spareConnections dials spare connections for tr when it dials http or https proxies, other schemes dial the target
+// through the proxy and have nothing to dial ahead
+func (pl *ProxyLoader) spareConnections(tr *http.Transport) {
+	pool := pl.config().Proxy.ConnectionPool
+	size := pool.Spare
+	if pl.config().LowMemory.Enabled {
+		size = min(size, lowMemoryMaxIdlePerHost)
+	}
+	if size <= 0 || tr.Proxy == nil {
+		return
+	}
+	dial := tr.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	idleTimeout := pool.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = defaultIdleTimeout
+	}
+	d := &spareDialer{
+		dial:    dial,
+		size:    size,
+		maxAge:  time.Duration(idleTimeout) * time.Second,
+		timeout: time.Duration(pl.config().Proxy.Rotation.Timeout) * time.Second,
+	}
+	tr.DialContext = d.DialContext
+}
+
+func (d *spareDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if conn := d.take(network, addr); conn != nil {
+		d.fill(network, addr)
+		return conn, nil
+	}
+	conn, err := d.dial(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	d.fill(network, addr)
+	return conn, nil
+}
+
+// take returns a spare connection the proxy has not closed yet, or nil
+func (d *spareDialer) take(network, addr string) net.Conn {
+	key := network + "\x00" + addr
+	for {
+		d.mu.Lock()
+		spares := d.spares[key]
+		if len(spares) == 0 {
+			d.mu.Unlock()
+			return nil
+		}
+		conn := spares[0]
+		d.spares[key] = spares[1:]
+		d.mu.Unlock()
+
+		if !conn.expire.Stop() {
+			// expired while it was taken, the timer closes it
+			continue
+		}
+		if spareAlive(conn.Conn) {
+			return conn.Conn
+		}
+		conn.Conn.Close()
+	}
+}
+
+// fill dials spare connections in the background until addr has size of them
+func (d *spareDialer) fill(network, addr string) {
+	key := network + "\x00" + addr
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.dialing == nil {
+		d.spares = make(map[string][]*spareConn)
+		d.dialing = make(map[string]int)
+	}
+	for len(d.spares[key])+d.dialing[key] < d.size {
+		d.dialing[key]++
+		go d.dialSpare(network, addr, key)
+	}
+}
+
+func (d *spareDialer) dialSpare(network, addr, key string) {
+	ctx := context.Background()
+	if d.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.timeout)
+		defer cancel()
+	}
+	conn, err := d.dial(ctx, network, addr)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dialing[key]--
+	// a failed dial is retried by the next tunnel, not in a loop against a proxy that may be down
+	if err != nil {
+		return
+	}
+	spare := &spareConn{Conn: conn}
+	spare.expire = time.AfterFunc(d.maxAge, func() {
+		d.mu.Lock()
+		d.spares[key] = slices.DeleteFunc(d.spares[key], func(c *spareConn) bool { return c == spare })
+		d.mu.Unlock()
+		conn.Close()
+	})
+	d.spares[key] = append(d.spares[key], spare)
+}
+
+// spareAlive tells whether the proxy still holds the connection open, it sends nothing before the client speaks
+func spareAlive(conn net.Conn) bool {
+	if err := conn.SetReadDeadline(time.Now().Add(spareProbeTimeout)); err != nil {
+		return false
+	}
+	_, err := conn.Read(make([]byte, 1))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		return false
+	}
+	return conn.SetReadDeadline(time.Time{}) == nil
+}
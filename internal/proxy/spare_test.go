@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/elazarl/goproxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpareDialer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	var mu sync.Mutex
+	var accepted []net.Conn
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			accepted = append(accepted, conn)
+			mu.Unlock()
+		}
+	}()
+	acceptedConns := func() []net.Conn {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(accepted)
+	}
+	isSpare := func(conn net.Conn, spares []net.Conn) bool {
+		for _, c := range spares {
+			if c.RemoteAddr().String() == conn.LocalAddr().String() {
+				return true
+			}
+		}
+		return false
+	}
+
+	d := &spareDialer{dial: (&net.Dialer{}).DialContext, size: 2, maxAge: time.Minute}
+	addr := listener.Addr().String()
+	first, err := d.DialContext(context.Background(), "tcp", addr)
+	require.NoError(t, err)
+	defer first.Close()
+	require.Eventually(t, func() bool { return len(acceptedConns()) == 3 }, time.Second, time.Millisecond)
+
+	// the next dial takes a spare and a new one replaces it
+	spares := acceptedConns()
+	second, err := d.DialContext(context.Background(), "tcp", addr)
+	require.NoError(t, err)
+	defer second.Close()
+	assert.True(t, isSpare(second, spares))
+	require.Eventually(t, func() bool { return len(acceptedConns()) == 4 }, time.Second, time.Millisecond)
+
+	// spares the proxy closed are skipped
+	spares = acceptedConns()
+	for _, conn := range spares {
+		conn.Close()
+	}
+	time.Sleep(10 * time.Millisecond)
+	third, err := d.DialContext(context.Background(), "tcp", addr)
+	require.NoError(t, err)
+	defer third.Close()
+	assert.False(t, isSpare(third, spares), "both spares were dead, the dial is a new connection")
+}
+
+func TestSpareDialerExpires(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	d := &spareDialer{dial: (&net.Dialer{}).DialContext, size: 1, maxAge: 20 * time.Millisecond}
+	conn, err := d.DialContext(context.Background(), "tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	spares := func() int {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return len(d.spares["tcp\x00"+listener.Addr().String()])
+	}
+	require.Eventually(t, func() bool { return spares() == 1 }, time.Second, time.Millisecond)
+	require.Eventually(t, func() bool { return spares() == 0 }, time.Second, time.Millisecond)
+}
+
+func TestSpareConnections(t *testing.T) {
+	var connections atomic.Int32
+	upstream := httptest.NewUnstartedServer(goproxy.NewProxyHttpServer())
+	upstream.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections.Add(1)
+		}
+	}
+	upstream.Start()
+	defer upstream.Close()
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer origin.Close()
+
+	cfg := &config.Config{Proxy: config.ProxyConfig{
+		Rotation:       config.ProxyRotationConfig{Timeout: 5},
+		ConnectionPool: config.ConnectionPoolConfig{Spare: 1},
+	}}
+	proxy, err := NewProxyLoader(NewProxyServer(cfg)).CreateProxy(upstream.URL)
+	require.NoError(t, err)
+
+	for range 2 {
+		response, err := (&http.Client{Transport: proxy.Transport}).Get(origin.URL)
+		require.NoError(t, err)
+		response.Body.Close()
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+	}
+	// every tunnel left a spare behind, the second one used the first's
+	assert.Eventually(t, func() bool { return connections.Load() == 3 }, time.Second, time.Millisecond)
+}
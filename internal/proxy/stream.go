@@ -0,0 +1,20 @@
+package proxy
+
+import (
+	"net/http"
+)
+
+// streamResponse makes goproxy flush every read of a body without a length to the client. Bodies are always copied
+// as they arrive, but plain http responses sit in the server's write buffer until it fills, which holds back event
+// streams and slow downloads. Tunneled responses are written straight to the connection and need no hint
+func streamResponse(r *http.Request, response *http.Response) {
+	if response.ContentLength >= 0 || r.Method == http.MethodHead {
+		return
+	}
+	switch {
+	case response.StatusCode < http.StatusOK, response.StatusCode == http.StatusNoContent, response.StatusCode == http.StatusNotModified:
+		return
+	}
+	// the server chunks the body itself and writes this header only once
+	response.Header.Set("Transfer-Encoding", "chunked")
+}
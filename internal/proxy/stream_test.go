@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamResponse(t *testing.T) {
+	release := make(chan struct{})
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("first\n"))
+		w.(http.Flusher).Flush()
+		<-release
+		_, _ = w.Write([]byte("second\n"))
+	}))
+	defer target.Close()
+
+	cfg := &config.Config{
+		Proxy: config.ProxyConfig{
+			Rotation: config.ProxyRotationConfig{Method: "roundrobin", Retries: 1, Timeout: 5, FallbackMaxRetries: 1},
+		},
+		Routing: []config.RoutingRuleConfig{{Hosts: []string{"127.0.0.1"}, Direct: true}},
+	}
+	ps := NewProxyServer(cfg)
+	goProxy := newGoProxy()
+	ps.setUpListenerHandlers(goProxy, 0)
+	server := httptest.NewServer(goProxy)
+	defer server.Close()
+	// the origin is released before the servers close, they wait for the request in flight
+	var once sync.Once
+	releaseOrigin := func() { once.Do(func() { close(release) }) }
+	defer releaseOrigin()
+	proxyURL, _ := url.Parse(server.URL)
+
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}, Timeout: 5 * time.Second}
+	resp, err := client.Get(target.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	// the first line arrives while the origin still holds back the rest
+	line := make(chan string, 1)
+	reader := bufio.NewReader(resp.Body)
+	go func() {
+		first, _ := reader.ReadString('\n')
+		line <- first
+	}()
+	select {
+	case first := <-line:
+		assert.Equal(t, "first\n", first)
+	case <-time.After(2 * time.Second):
+		t.Fatal("first chunk was not streamed")
+	}
+
+	releaseOrigin()
+	rest, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "second\n", string(rest))
+}
+
+func TestStreamResponseSkipsKnownLengths(t *testing.T) {
+	get, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	head, _ := http.NewRequest(http.MethodHead, "http://example.com/", nil)
+	tests := []struct {
+		name     string
+		request  *http.Request
+		response *http.Response
+		chunked  bool
+	}{
+		{"unknown length", get, &http.Response{StatusCode: http.StatusOK, ContentLength: -1, Header: http.Header{}}, true},
+		{"known length", get, &http.Response{StatusCode: http.StatusOK, ContentLength: 10, Header: http.Header{}}, false},
+		{"head", head, &http.Response{StatusCode: http.StatusOK, ContentLength: -1, Header: http.Header{}}, false},
+		{"not modified", get, &http.Response{StatusCode: http.StatusNotModified, ContentLength: -1, Header: http.Header{}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			streamResponse(tt.request, tt.response)
+			assert.Equal(t, tt.chunked, tt.response.Header.Get("Transfer-Encoding") == "chunked")
+		})
+	}
+}
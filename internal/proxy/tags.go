@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"slices"
+	"strings"
+)
+
+// a proxy file line is the proxy url optionally followed by tags, e.g. "socks5://1.2.3.4:1080 residential us"
+func parseProxyLine(line string) (string, []string) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", nil
+	}
+	return normalizeAddress(fields[0]), normalizeTags(fields[1:])
+}
+
+func normalizeTags(tags []string) []string {
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	if len(normalized) == 0 {
+		return nil
+	}
+	return normalized
+}
+
+// ProxyTags returns the tags of a proxy, tags can change at runtime so they are read under the pool lock
+func (ps *ProxyServer) ProxyTags(proxy *Proxy) []string {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return proxy.Tags
+}
+
+// TagProxies adds tags to, or with remove removes them from, the proxies listed in hosts and every proxy of pool.
+// It returns the number of proxies that matched, changes last until the proxy file is reloaded
+func (ps *ProxyServer) TagProxies(hosts []string, pool string, tags []string, remove bool) int {
+	tags = normalizeTags(tags)
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	matched := 0
+	for _, p := range ps.Proxies {
+		if !slices.Contains(hosts, p.Host) && (pool == "" || p.Pool != pool) {
+			continue
+		}
+		matched++
+
+		// tags are replaced instead of modified in place, readers may still hold the old slice
+		updated := slices.Clone(p.Tags)
+		for _, tag := range tags {
+			if remove {
+				updated = slices.DeleteFunc(updated, func(t string) bool { return t == tag })
+			} else if !slices.Contains(updated, tag) {
+				updated = append(updated, tag)
+			}
+		}
+		p.Tags = normalizeTags(updated)
+	}
+	return matched
+}
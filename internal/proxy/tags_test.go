@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTagProxies(t *testing.T) {
+	ps := NewProxyServer(&config.Config{})
+	a := &Proxy{Host: "a", Pool: "one.txt", Tags: []string{"residential"}}
+	b := &Proxy{Host: "b", Pool: "one.txt"}
+	c := &Proxy{Host: "c", Pool: "two.txt"}
+	ps.SetProxies([]*Proxy{a, b, c})
+
+	assert.Equal(t, 2, ps.TagProxies(nil, "one.txt", []string{"EU", "residential"}, false))
+	assert.Equal(t, []string{"residential", "eu"}, ps.ProxyTags(a))
+	assert.Equal(t, []string{"eu", "residential"}, ps.ProxyTags(b))
+	assert.Nil(t, ps.ProxyTags(c))
+
+	assert.Equal(t, 2, ps.TagProxies([]string{"a", "c"}, "", []string{"residential"}, true))
+	assert.Equal(t, []string{"eu"}, ps.ProxyTags(a))
+	assert.Nil(t, ps.ProxyTags(c))
+
+	assert.Equal(t, 0, ps.TagProxies(nil, "", []string{"eu"}, false))
+}
+
+func TestPickProxyTag(t *testing.T) {
+	ps := NewProxyServer(&config.Config{})
+	ps.SetProxies([]*Proxy{
+		{Host: "a", Tags: []string{"residential"}},
+		{Host: "b", Tags: []string{"hosting"}},
+		{Host: "c", Tags: []string{"residential", "us"}},
+	})
+
+	var picked []string
+	for range 3 {
+		picked = append(picked, ps.getProxy("roundrobin", proxyFilter{tag: "residential"}).Host)
+	}
+	assert.Equal(t, []string{"a", "c", "a"}, picked)
+	assert.Equal(t, "b", ps.getProxy("random", proxyFilter{tag: "hosting"}).Host)
+	assert.Nil(t, ps.getProxy("roundrobin", proxyFilter{tag: "mobile"}))
+}
@@ -0,0 +1,36 @@
+package proxy
+
+import (
+	"net/netip"
+	"strings"
+)
+
+const msgTargetBlocked = "target blocked"
+
+// targetAllowed reports whether clients may reach host, deny patterns win and a non-empty allow list admits only its hosts
+func (ps *ProxyServer) targetAllowed(host string) bool {
+	targets := ps.Config().Targets
+	host = strings.ToLower(hostname(host))
+	if matchesTarget(targets.Deny, host) {
+		return false
+	}
+	return len(targets.Allow) == 0 || matchesTarget(targets.Allow, host)
+}
+
+// matchesTarget matches host against globs like routing rules do, and IP addresses against CIDRs such as 10.0.0.0/8
+func matchesTarget(patterns []string, host string) bool {
+	if matchesHost(patterns, host) {
+		return true
+	}
+	addr, err := netip.ParseAddr(strings.Trim(host, "[]"))
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, pattern := range patterns {
+		if prefix, err := netip.ParsePrefix(pattern); err == nil && prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/elazarl/goproxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTargetAllowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		targets config.TargetsConfig
+		host    string
+		want    bool
+	}{
+		{"no lists", config.TargetsConfig{}, "example.com:443", true},
+		{"denied glob", config.TargetsConfig{Deny: []string{"*.internal"}}, "db.internal:5432", false},
+		{"denied cidr", config.TargetsConfig{Deny: []string{"10.0.0.0/8"}}, "10.1.2.3:80", false},
+		{"denied ipv6 cidr", config.TargetsConfig{Deny: []string{"fc00::/7"}}, "[fd00::1]:443", false},
+		{"outside the deny list", config.TargetsConfig{Deny: []string{"10.0.0.0/8", "*.internal"}}, "example.com", true},
+		{"allowed", config.TargetsConfig{Allow: []string{"*.example.com"}}, "WWW.Example.com:443", true},
+		{"not allowed", config.TargetsConfig{Allow: []string{"*.example.com"}}, "example.org", false},
+		{"deny wins", config.TargetsConfig{Allow: []string{"*.example.com"}, Deny: []string{"admin.example.com"}}, "admin.example.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := NewProxyServer(&config.Config{Targets: tt.targets})
+			assert.Equal(t, tt.want, ps.targetAllowed(tt.host))
+		})
+	}
+}
+
+func TestBlockedTarget(t *testing.T) {
+	ps := NewProxyServer(&config.Config{Targets: config.TargetsConfig{Deny: []string{"127.0.0.1"}}})
+	goProxy := newGoProxy()
+	ps.setUpListenerHandlers(goProxy, 0)
+
+	req, _ := http.NewRequest("GET", "http://127.0.0.1/admin", nil)
+	_, resp := ps.handleRequest(req, &goproxy.ProxyCtx{Req: req}, ps.resolveListener(0))
+	assert.Equal(t, StatusForbidden, resp.StatusCode)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go ps.serveListener(goProxy, listener)
+
+	client := &http.Client{Transport: &http.Transport{
+		Proxy: http.ProxyURL(&url.URL{Scheme: "http", Host: listener.Addr().String()}),
+	}}
+	_, err = client.Get("https://127.0.0.1:8443/")
+	assert.ErrorContains(t, err, "Forbidden")
+}
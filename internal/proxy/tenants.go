@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/elazarl/goproxy"
+)
+
+const (
+	msgUnknownTenant = "credential belongs to an unknown tenant"
+)
+
+// TenantUsage counts the proxy attempts of a tenant's credentials since startup, the way the request history does
+type TenantUsage struct {
+	Requests      uint64 `json:"requests"`
+	Failed        uint64 `json:"failed"`
+	BytesSent     uint64 `json:"bytes_sent"`
+	BytesReceived uint64 `json:"bytes_received"`
+}
+
+type tenantUsage struct {
+	mu    sync.Mutex
+	usage map[string]TenantUsage
+}
+
+func (u *tenantUsage) add(record RequestRecord) {
+	if record.Tenant == "" {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.usage == nil {
+		u.usage = make(map[string]TenantUsage)
+	}
+	usage := u.usage[record.Tenant]
+	usage.Requests++
+	if !record.Success {
+		usage.Failed++
+	}
+	usage.BytesSent += record.BytesSent
+	usage.BytesReceived += record.BytesReceived
+	u.usage[record.Tenant] = usage
+}
+
+func (u *tenantUsage) get(tenant string) TenantUsage {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.usage[tenant]
+}
+
+// Tenant returns a configured tenant, tenants without a name or a proxy file are ignored
+func (ps *ProxyServer) Tenant(name string) (config.TenantConfig, bool) {
+	if name == "" {
+		return config.TenantConfig{}, false
+	}
+	for _, tenant := range ps.Config().Tenants {
+		if tenant.Name == name && tenant.ProxyFile != "" {
+			return tenant, true
+		}
+	}
+	return config.TenantConfig{}, false
+}
+
+func (ps *ProxyServer) TenantUsage(name string) TenantUsage {
+	return ps.tenantUsage.get(name)
+}
+
+// tenantListener replaces the pool, tag and rotation of the listener with the tenant's when username belongs to one.
+// It fails for accounts of a tenant that is not configured, they must not fall back to the shared pool
+func (ps *ProxyServer) tenantListener(listener *listenerConfig, username string) (*listenerConfig, bool) {
+	name, ok := ps.middleware.Credentials().Tenant(username)
+	if !ok || name == "" {
+		return listener, true
+	}
+	scoped := *listener
+	scoped.tenant = name
+	tenant, ok := ps.Tenant(name)
+	if !ok {
+		return &scoped, false
+	}
+
+	scoped.pool = tenant.ProxyFile
+	scoped.tag = strings.ToLower(tenant.Tag)
+	if tenant.Rotation != nil {
+		scoped.rotation = *tenant.Rotation
+	}
+	return &scoped, true
+}
+
+func (ps *ProxyServer) unknownTenant(r *http.Request, requestID, tenant string) *http.Response {
+	slog.Error(msgUnknownTenant, "request_id", requestID, "tenant", tenant, "url", r.URL.String(), "ip", r.RemoteAddr)
+	response := goproxy.NewResponse(r,
+		goproxy.ContentTypeText, StatusForbidden,
+		fmt.Sprintf(msgForbidden, requestID))
+	response.ProtoMajor, response.ProtoMinor = 1, 1
+	return response
+}
@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/alpkeskin/rota/internal/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenantListener(t *testing.T) {
+	var upstreams []*httptest.Server
+	for i := range 2 {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Upstream", strconv.Itoa(i))
+		}))
+		defer upstream.Close()
+		upstreams = append(upstreams, upstream)
+	}
+
+	cfg := &config.Config{
+		ProxyFile: "proxies.txt",
+		Proxy: config.ProxyConfig{
+			Rotation: config.ProxyRotationConfig{Method: "roundrobin", Retries: 1, FallbackMaxRetries: 1},
+			Credentials: []config.CredentialConfig{
+				{Username: "alice", Password: "a", Tenant: "team-a"},
+				{Username: "bob", Password: "b"},
+				{Username: "eve", Password: "e", Tenant: "gone"},
+			},
+		},
+		Routing: []config.RoutingRuleConfig{{Hosts: []string{"pinned.example"}, Pool: "proxies.txt"}},
+		Tenants: []config.TenantConfig{
+			{Name: "team-a", ProxyFile: "team-a.txt", Rotation: &config.ProxyRotationConfig{Method: "random", Retries: 1, FallbackMaxRetries: 1}},
+			{Name: "no-pool"},
+		},
+	}
+	ps := NewProxyServer(cfg)
+	pl := NewProxyLoader(ps)
+	var proxies []*Proxy
+	for i, pool := range []string{"proxies.txt", "team-a.txt"} {
+		proxy, err := pl.CreateProxy(upstreams[i].URL)
+		require.NoError(t, err)
+		proxy.Pool = pool
+		proxies = append(proxies, proxy)
+	}
+	ps.SetProxies(proxies)
+	assert.Contains(t, pl.ProxyFiles(), "team-a.txt")
+
+	_, ok := ps.Tenant("no-pool")
+	assert.False(t, ok)
+
+	served := func(username, target string) string {
+		listener, ok := ps.tenantListener(ps.resolveListener(0), username)
+		require.True(t, ok)
+		req, _ := http.NewRequest(http.MethodGet, target, nil)
+		response, err := ps.tryProxies(requestInfo{id: "test-id", request: req, listener: listener, directives: middleware.Directives{Username: username}})
+		require.NoError(t, err)
+		response.Body.Close()
+		return response.Header.Get("X-Upstream")
+	}
+	for range 3 {
+		assert.Equal(t, "1", served("alice", "http://example.com/"))
+		assert.Equal(t, "0", served("bob", "http://example.com/"))
+		// routing rules can not move a tenant out of its pool
+		assert.Equal(t, "1", served("alice", "http://pinned.example/"))
+	}
+
+	listener, ok := ps.tenantListener(ps.resolveListener(0), "alice")
+	assert.True(t, ok)
+	assert.Equal(t, "team-a", listener.tenant)
+	assert.Equal(t, "random", listener.rotation.Method)
+
+	// an account of a tenant that is not configured must not use the shared pool
+	_, ok = ps.tenantListener(ps.resolveListener(0), "eve")
+	assert.False(t, ok)
+
+	usage := ps.TenantUsage("team-a")
+	assert.Equal(t, uint64(6), usage.Requests)
+	assert.Zero(t, usage.Failed)
+	assert.Zero(t, ps.TenantUsage("gone").Requests)
+}
+
+func TestTenantUsage(t *testing.T) {
+	var usage tenantUsage
+	usage.add(RequestRecord{Success: true, BytesSent: 10, BytesReceived: 100})
+	usage.add(RequestRecord{Tenant: "a", Success: true, BytesSent: 10, BytesReceived: 100})
+	usage.add(RequestRecord{Tenant: "a", BytesSent: 5})
+	assert.Equal(t, TenantUsage{Requests: 2, Failed: 1, BytesSent: 15, BytesReceived: 100}, usage.get("a"))
+	assert.Equal(t, TenantUsage{}, usage.get(""))
+}
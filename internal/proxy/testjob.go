@@ -0,0 +1,162 @@
+package proxy
+
+import (
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gammazero/workerpool"
+	"github.com/google/uuid"
+)
+
+const (
+	msgTestStarted     = "proxy test started"
+	msgTestFinished    = "proxy test finished"
+	msgTestNotFound    = "proxy test not found"
+	msgNoProxiesToTest = "no proxies to test"
+
+	// finished tests are kept so their results can still be read, the oldest is dropped first
+	maxTestJobs = 16
+)
+
+var (
+	ErrTestNotFound    = errors.New(msgTestNotFound)
+	ErrNoProxiesToTest = errors.New(msgNoProxiesToTest)
+)
+
+// TestResult is the healthcheck outcome of one proxy of a test
+type TestResult struct {
+	Proxy     string `json:"proxy"`
+	Alive     bool   `json:"alive"`
+	Error     string `json:"error,omitempty"`
+	LatencyMs int64  `json:"latency_ms"`
+}
+
+// TestJob follows a healthcheck of chosen proxies, results are added in the order the checks finish
+type TestJob struct {
+	ID         string       `json:"id"`
+	StartedAt  time.Time    `json:"started_at"`
+	FinishedAt *time.Time   `json:"finished_at,omitempty"`
+	Total      int          `json:"total"`
+	Checked    int          `json:"checked"`
+	Alive      int          `json:"alive"`
+	Dead       int          `json:"dead"`
+	Results    []TestResult `json:"results"`
+}
+
+type testTable struct {
+	mu   sync.Mutex
+	jobs []*TestJob
+}
+
+// StartTest checks the listed proxies and the proxies of pool in the background, the whole pool when both are empty.
+// Results feed the circuit breaker like periodic checks, dead proxies are never removed by a test
+func (pl *ProxyChecker) StartTest(hosts []string, pool string) (TestJob, error) {
+	ps := pl.proxyServer
+	hosts = slices.Clone(hosts)
+	for i, host := range hosts {
+		hosts[i] = normalizeAddress(host)
+	}
+	proxies := slices.DeleteFunc(ps.GetProxies(), func(p *Proxy) bool {
+		if len(hosts) == 0 && pool == "" {
+			return false
+		}
+		return !slices.Contains(hosts, p.Host) && (pool == "" || p.Pool != pool)
+	})
+	if len(proxies) == 0 {
+		return TestJob{}, ErrNoProxiesToTest
+	}
+
+	job := &TestJob{ID: uuid.New().String(), StartedAt: time.Now(), Total: len(proxies), Results: make([]TestResult, 0, len(proxies))}
+	ps.tests.add(job)
+	slog.Info(msgTestStarted, "id", job.ID, "proxies", job.Total)
+	go pl.runTest(job, proxies)
+	return ps.tests.snapshot(job, 0), nil
+}
+
+func (pl *ProxyChecker) runTest(job *TestJob, proxies []*Proxy) {
+	ps := pl.proxyServer
+	wp := workerpool.New(healthcheckWorkers(pl.config()))
+	for _, proxy := range proxies {
+		wp.Submit(func() {
+			startAt := time.Now()
+			err := pl.CheckProxy(proxy)
+			ps.recordResult(proxy, err == nil)
+			result := TestResult{Proxy: proxy.Host, Alive: err == nil, LatencyMs: time.Since(startAt).Milliseconds()}
+			if err != nil {
+				result.Error = err.Error()
+			}
+			ps.tests.record(job, result)
+		})
+	}
+	wp.StopWait()
+
+	ps.tests.finish(job)
+	summary := ps.tests.snapshot(job, job.Total)
+	slog.Info(msgTestFinished, "id", job.ID, "alive", summary.Alive, "dead", summary.Dead)
+}
+
+// TestJob returns the test with this id, with the results from the offset-th on
+func (ps *ProxyServer) TestJob(id string, offset int) (TestJob, error) {
+	job := ps.tests.find(id)
+	if job == nil {
+		return TestJob{}, ErrTestNotFound
+	}
+	return ps.tests.snapshot(job, offset), nil
+}
+
+func (t *testTable) add(job *TestJob) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.jobs = append(t.jobs, job)
+	// running tests are never dropped, a table full of them grows until they finish
+	for i := 0; len(t.jobs) > maxTestJobs && i < len(t.jobs); {
+		if t.jobs[i].FinishedAt == nil {
+			i++
+			continue
+		}
+		t.jobs = slices.Delete(t.jobs, i, i+1)
+	}
+}
+
+func (t *testTable) find(id string) *TestJob {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	i := slices.IndexFunc(t.jobs, func(job *TestJob) bool { return job.ID == id })
+	if i < 0 {
+		return nil
+	}
+	return t.jobs[i]
+}
+
+func (t *testTable) record(job *TestJob, result TestResult) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	job.Results = append(job.Results, result)
+	job.Checked++
+	if result.Alive {
+		job.Alive++
+	} else {
+		job.Dead++
+	}
+}
+
+func (t *testTable) finish(job *TestJob) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	job.FinishedAt = &now
+}
+
+func (t *testTable) snapshot(job *TestJob, offset int) TestJob {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	snapshot := *job
+	snapshot.Results = slices.Clone(job.Results[min(max(offset, 0), len(job.Results)):])
+	if snapshot.Results == nil {
+		snapshot.Results = []TestResult{}
+	}
+	return snapshot
+}
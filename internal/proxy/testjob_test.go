@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartTest(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+
+	cfg := &config.Config{Healthcheck: config.HealthcheckConfig{URL: target.URL, Status: http.StatusOK, Timeout: 5, Workers: 2}}
+	ps := NewProxyServer(cfg)
+	refused, _ := url.Parse("http://127.0.0.1:1")
+	ps.AddProxy(&Proxy{Host: "http://10.0.0.1:8080", Pool: "a", Transport: &http.Transport{}})
+	ps.AddProxy(&Proxy{Host: "http://10.0.0.2:8080", Pool: "a", Transport: &http.Transport{Proxy: http.ProxyURL(refused)}})
+	ps.AddProxy(&Proxy{Host: "http://10.0.0.3:8080", Pool: "b", Transport: &http.Transport{}})
+	checker := NewProxyChecker(ps)
+
+	_, err := checker.StartTest([]string{"10.0.0.9:8080"}, "")
+	assert.ErrorIs(t, err, ErrNoProxiesToTest)
+	_, err = ps.TestJob("missing", 0)
+	assert.ErrorIs(t, err, ErrTestNotFound)
+
+	job, err := checker.StartTest(nil, "a")
+	require.NoError(t, err)
+	assert.Equal(t, 2, job.Total)
+	require.Eventually(t, func() bool {
+		job, err = ps.TestJob(job.ID, 0)
+		return err == nil && job.FinishedAt != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 2, job.Checked)
+	assert.Equal(t, 1, job.Alive)
+	assert.Equal(t, 1, job.Dead)
+	require.Len(t, job.Results, 2)
+
+	later, err := ps.TestJob(job.ID, 1)
+	require.NoError(t, err)
+	assert.Equal(t, job.Results[1:], later.Results)
+
+	// listed proxies are tested on top of the pool
+	job, err = checker.StartTest([]string{"http://10.0.0.3:8080"}, "a")
+	require.NoError(t, err)
+	assert.Equal(t, 3, job.Total)
+}
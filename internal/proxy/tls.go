@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"time"
+)
+
+const (
+	msgFailedToLoadTLSCert     = "failed to load tls certificate"
+	msgFailedToGenerateTLSCert = "failed to generate tls certificate"
+	msgMissingTLSKeyPair       = "tls needs both cert_file and key_file"
+	msgSelfSignedTLSCert       = "serving a self-signed tls certificate, clients have to trust it or skip verification"
+
+	selfSignedValidity = 365 * 24 * time.Hour
+)
+
+// LoadTLS reads the certificate proxy ports serve, a self-signed one is generated when no files are set
+func (ps *ProxyServer) LoadTLS() error {
+	cfg := ps.Config().Proxy.TLS
+	if !cfg.Enabled {
+		return nil
+	}
+
+	var cert tls.Certificate
+	var err error
+	switch {
+	case cfg.CertFile != "" && cfg.KeyFile != "":
+		cert, err = tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return fmt.Errorf("%s: %w", msgFailedToLoadTLSCert, err)
+		}
+	case cfg.CertFile != "" || cfg.KeyFile != "":
+		return errors.New(msgMissingTLSKeyPair)
+	default:
+		cert, err = selfSignedCert(tlsHosts(cfg.Hosts), time.Now())
+		if err != nil {
+			return fmt.Errorf("%s: %w", msgFailedToGenerateTLSCert, err)
+		}
+		slog.Warn(msgSelfSignedTLSCert)
+	}
+
+	ps.tlsConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		// goproxy hijacks connections for CONNECT, which http/2 does not allow
+		NextProtos: []string{"http/1.1"},
+	}
+	return nil
+}
+
+// tlsListener wraps listener in tls when it is enabled, unix sockets are never wrapped
+func (ps *ProxyServer) tlsListener(listener net.Listener) net.Listener {
+	if ps.tlsConfig == nil {
+		return listener
+	}
+	return tls.NewListener(listener, ps.tlsConfig)
+}
+
+func tlsHosts(hosts []string) []string {
+	if len(hosts) > 0 {
+		return hosts
+	}
+	hosts = []string{"localhost", "127.0.0.1", "::1"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		hosts = append(hosts, hostname)
+	}
+	return hosts
+}
+
+func selfSignedCert(hosts []string, now time.Time) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"rota"}, CommonName: hosts[0]},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(selfSignedValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
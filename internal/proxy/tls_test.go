@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadTLS(t *testing.T) {
+	cert, err := selfSignedCert([]string{"proxy.example.com", "10.0.0.1"}, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"proxy.example.com"}, cert.Leaf.DNSNames)
+	assert.Len(t, cert.Leaf.IPAddresses, 1)
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0o600))
+
+	tests := []struct {
+		name    string
+		tls     config.ProxyTLSConfig
+		wantErr string
+		wantTLS bool
+	}{
+		{"disabled", config.ProxyTLSConfig{CertFile: certFile, KeyFile: keyFile}, "", false},
+		{"key pair", config.ProxyTLSConfig{Enabled: true, CertFile: certFile, KeyFile: keyFile}, "", true},
+		{"self-signed", config.ProxyTLSConfig{Enabled: true}, "", true},
+		{"cert without key", config.ProxyTLSConfig{Enabled: true, CertFile: certFile}, msgMissingTLSKeyPair, false},
+		{"missing files", config.ProxyTLSConfig{Enabled: true, CertFile: "missing.pem", KeyFile: "missing.pem"}, msgFailedToLoadTLSCert, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := NewProxyServer(&config.Config{Proxy: config.ProxyConfig{TLS: tt.tls}})
+			err := ps.LoadTLS()
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantTLS, ps.tlsConfig != nil)
+		})
+	}
+}
+
+func TestTLSListener(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer target.Close()
+
+	cfg := &config.Config{
+		Proxy: config.ProxyConfig{
+			Rotation: config.ProxyRotationConfig{Method: "roundrobin", Retries: 1, Timeout: 5, FallbackMaxRetries: 1},
+			TLS:      config.ProxyTLSConfig{Enabled: true, Hosts: []string{"127.0.0.1"}},
+		},
+		Routing: []config.RoutingRuleConfig{{Hosts: []string{"127.0.0.1"}, Direct: true}},
+	}
+	ps := NewProxyServer(cfg)
+	require.NoError(t, ps.LoadTLS())
+	goProxy := newGoProxy()
+	ps.setUpListenerHandlers(goProxy, 0)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go ps.serveListener(goProxy, ps.tlsListener(listener))
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ps.tlsConfig.Certificates[0].Leaf)
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyURL(&url.URL{Scheme: "https", Host: listener.Addr().String()}),
+		TLSClientConfig: &tls.Config{RootCAs: roots},
+	}}
+	resp, err := client.Get(target.URL)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+
+	// plain http is refused on a tls port
+	plain := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(&url.URL{Scheme: "http", Host: listener.Addr().String()})}}
+	resp, err = plain.Get(target.URL)
+	if err == nil {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+}
@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxIdlePerHost = 4
+	defaultIdleTimeout    = 90
+)
+
+// transportCache hands out one transport per upstream so repeated requests reuse its pooled connections.
+// The key holds everything a transport is built from, so a changed url, credential, scheme or upstream setting gets a new one
+type transportCache struct {
+	mu         sync.Mutex
+	transports map[string]*http.Transport
+}
+
+func (c *transportCache) get(key string, build func() *http.Transport) *http.Transport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if tr, ok := c.transports[key]; ok {
+		return tr
+	}
+	if c.transports == nil {
+		c.transports = make(map[string]*http.Transport)
+	}
+	tr := build()
+	c.transports[key] = tr
+	return tr
+}
+
+// prune forgets the transports no proxy uses anymore and closes their idle connections
+func (c *transportCache) prune(proxies []*Proxy) {
+	inUse := make(map[*http.Transport]bool, len(proxies))
+	for _, p := range proxies {
+		inUse[p.Transport] = true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, tr := range c.transports {
+		if !inUse[tr] {
+			tr.CloseIdleConnections()
+			delete(c.transports, key)
+		}
+	}
+}
+
+func (c *transportCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.transports)
+}
+
+func (ps *ProxyServer) pruneTransports() {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	ps.transports.prune(ps.Proxies)
+}
+
+// transportKey joins the settings a transport is built from, settings changed by a reload are part of it
+func (pl *ProxyLoader) transportKey(parts ...any) string {
+	key := make([]string, 0, len(parts)+4)
+	for _, part := range parts {
+		key = append(key, fmt.Sprint(part))
+	}
+	key = append(key, fmt.Sprint(pl.config().Proxy.Rotation.Timeout), fmt.Sprint(pl.config().Proxy.ConnectionPool), fmt.Sprint(pl.config().LowMemory.Enabled), fmt.Sprint(pl.config().Proxy.HTTP2), pl.config().Proxy.TLSFingerprint)
+	return strings.Join(key, "\x00")
+}
+
+// configureTransport applies the settings every upstream transport shares
+func (pl *ProxyLoader) configureTransport(tr *http.Transport, proxy string) *http.Transport {
+	pl.spareConnections(tr)
+	pl.proxyServer.countBandwidth(tr, proxy)
+	pool := pl.config().Proxy.ConnectionPool
+	tr.DisableKeepAlives = !pool.Enabled
+	tr.DisableCompression = true
+	tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	// custom dialers and tls configs turn http/2 off unless it is forced, origins are offered h2 over tls
+	tr.ForceAttemptHTTP2 = pl.config().Proxy.HTTP2
+	if pool.Enabled {
+		tr.MaxIdleConnsPerHost = pool.MaxIdlePerHost
+		if tr.MaxIdleConnsPerHost <= 0 {
+			tr.MaxIdleConnsPerHost = defaultMaxIdlePerHost
+		}
+		idleTimeout := pool.IdleTimeout
+		if idleTimeout <= 0 {
+			idleTimeout = defaultIdleTimeout
+		}
+		tr.IdleConnTimeout = time.Duration(idleTimeout) * time.Second
+	}
+	if pl.config().LowMemory.Enabled {
+		tr.ReadBufferSize = lowMemoryBufferSize
+		tr.WriteBufferSize = lowMemoryBufferSize
+		tr.MaxIdleConnsPerHost = min(tr.MaxIdleConnsPerHost, lowMemoryMaxIdlePerHost)
+	}
+	pl.proxyServer.fingerprintTLS(tr)
+	return tr
+}
@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"hash/fnv"
+	"net/http"
+	"strings"
+
+	"github.com/alpkeskin/rota/internal/middleware"
+	"golang.org/x/exp/rand"
+)
+
+const (
+	UserAgentPerRequest = "request"
+	UserAgentPerSession = "session"
+)
+
+// builtinUserAgents are current desktop and mobile browsers, used when user_agent.list is empty
+var builtinUserAgents = []string{
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36 Edg/129.0.0.0",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:131.0) Gecko/20100101 Firefox/131.0",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/18.0 Safari/605.1.15",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 14.7; rv:131.0) Gecko/20100101 Firefox/131.0",
+	"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36",
+	"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:131.0) Gecko/20100101 Firefox/131.0",
+	"Mozilla/5.0 (iPhone; CPU iPhone OS 18_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/18.0 Mobile/15E148 Safari/604.1",
+	"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Mobile Safari/537.36",
+}
+
+// rotateUserAgent replaces the client's User-Agent. Per session, every request of a session directive sends the same
+// one so the target sees one browser, requests without a session pick one at random like per request. The
+// user_agent feature flag switches it off without a reload
+func (ps *ProxyServer) rotateUserAgent(r *http.Request, directives middleware.Directives) {
+	cfg := ps.Config().Proxy.UserAgent
+	if !cfg.Enabled || !ps.features.Enabled(FeatureUserAgent) {
+		return
+	}
+	agents := cfg.List
+	if len(agents) == 0 {
+		agents = builtinUserAgents
+	}
+
+	i := rand.Intn(len(agents))
+	if strings.EqualFold(cfg.Per, UserAgentPerSession) && directives.Session != "" {
+		h := fnv.New32a()
+		h.Write([]byte(directives.Username + "\x00" + directives.Session))
+		i = int(h.Sum32() % uint32(len(agents)))
+	}
+	r.Header.Set("User-Agent", agents[i])
+}
@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/alpkeskin/rota/internal/config"
+	"github.com/alpkeskin/rota/internal/middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRotateUserAgent(t *testing.T) {
+	agents := []string{"agent-a", "agent-b", "agent-c", "agent-d"}
+	userAgent := func(cfg config.UserAgentConfig, directives middleware.Directives) string {
+		ps := NewProxyServer(&config.Config{Proxy: config.ProxyConfig{UserAgent: cfg}})
+		r, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+		r.Header.Set("User-Agent", "curl/8.0")
+		ps.rotateUserAgent(r, directives)
+		return r.Header.Get("User-Agent")
+	}
+
+	assert.Equal(t, "curl/8.0", userAgent(config.UserAgentConfig{List: agents}, middleware.Directives{}))
+	assert.Contains(t, builtinUserAgents, userAgent(config.UserAgentConfig{Enabled: true}, middleware.Directives{}))
+
+	perRequest := make(map[string]bool)
+	for range 100 {
+		agent := userAgent(config.UserAgentConfig{Enabled: true, List: agents}, middleware.Directives{Session: "abc"})
+		assert.Contains(t, agents, agent)
+		perRequest[agent] = true
+	}
+	assert.Greater(t, len(perRequest), 1)
+
+	perSession := config.UserAgentConfig{Enabled: true, Per: UserAgentPerSession, List: agents}
+	sessions := make(map[string]bool)
+	for _, session := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		agent := userAgent(perSession, middleware.Directives{Username: "user", Session: session})
+		for range 10 {
+			assert.Equal(t, agent, userAgent(perSession, middleware.Directives{Username: "user", Session: session}))
+		}
+		sessions[agent] = true
+	}
+	assert.Greater(t, len(sessions), 1)
+}
+
+func TestRotateUserAgentFeatureFlag(t *testing.T) {
+	ps := NewProxyServer(&config.Config{
+		Proxy:    config.ProxyConfig{UserAgent: config.UserAgentConfig{Enabled: true, List: []string{"agent-a"}}},
+		Features: map[string]bool{FeatureUserAgent: false},
+	})
+	r, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	r.Header.Set("User-Agent", "curl/8.0")
+	ps.rotateUserAgent(r, middleware.Directives{})
+	assert.Equal(t, "curl/8.0", r.Header.Get("User-Agent"))
+
+	ps.Features().Set(FeatureUserAgent, true)
+	ps.rotateUserAgent(r, middleware.Directives{})
+	assert.Equal(t, "agent-a", r.Header.Get("User-Agent"))
+}
@@ -0,0 +1,224 @@
+package stats
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	ResultSuccess      = "success"
+	ResultUnauthorized = "unauthorized"
+	ResultBadGateway   = "bad_gateway"
+	ResultRateLimited  = "rate_limited"
+	ResultForbidden    = "forbidden"
+	ResultTooLarge     = "too_large"
+	ResultDryRun       = "dry_run"
+)
+
+// selection buckets in seconds, picking a proxy is a lock and a slice scan
+var selectionBuckets = []float64{0.000001, 0.000005, 0.00001, 0.00005, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05}
+
+type proxyCounters struct {
+	success uint64
+	failure uint64
+}
+
+// Bandwidth counts the bytes written to and read from connections to one upstream proxy
+type Bandwidth struct {
+	sent     atomic.Uint64
+	received atomic.Uint64
+}
+
+type Stats struct {
+	mu            sync.Mutex
+	requests      map[string]uint64
+	proxies       map[string]*proxyCounters
+	errors        map[string]map[string]uint64
+	bandwidth     map[string]*Bandwidth
+	selections    []uint64
+	selectionSum  float64
+	selectionsAll uint64
+	tunnels       atomic.Int64
+}
+
+func New() *Stats {
+	return &Stats{
+		requests:   make(map[string]uint64),
+		proxies:    make(map[string]*proxyCounters),
+		errors:     make(map[string]map[string]uint64),
+		bandwidth:  make(map[string]*Bandwidth),
+		selections: make([]uint64, len(selectionBuckets)),
+	}
+}
+
+func (s *Stats) ObserveRequest(result string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[result]++
+}
+
+func (s *Stats) ObserveProxy(proxy string, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counters, found := s.proxies[proxy]
+	if !found {
+		counters = &proxyCounters{}
+		s.proxies[proxy] = counters
+	}
+	if ok {
+		counters.success++
+	} else {
+		counters.failure++
+	}
+}
+
+// ObserveError counts a failed or error status attempt of proxy by its error class
+func (s *Stats) ObserveError(proxy, class string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	classes, ok := s.errors[proxy]
+	if !ok {
+		classes = make(map[string]uint64)
+		s.errors[proxy] = classes
+	}
+	classes[class]++
+}
+
+// Bandwidth returns the byte counters of proxy, connections add to them without taking the stats lock
+func (s *Stats) Bandwidth(proxy string) *Bandwidth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.bandwidth[proxy]
+	if !ok {
+		b = &Bandwidth{}
+		s.bandwidth[proxy] = b
+	}
+	return b
+}
+
+// ProxyBandwidth returns the bytes sent to and received from proxy
+func (s *Stats) ProxyBandwidth(proxy string) (uint64, uint64) {
+	s.mu.Lock()
+	b, ok := s.bandwidth[proxy]
+	s.mu.Unlock()
+	if !ok {
+		return 0, 0
+	}
+	return b.Sent(), b.Received()
+}
+
+func (b *Bandwidth) AddSent(n int) {
+	b.sent.Add(uint64(n))
+}
+
+func (b *Bandwidth) AddReceived(n int) {
+	b.received.Add(uint64(n))
+}
+
+func (b *Bandwidth) Sent() uint64 {
+	return b.sent.Load()
+}
+
+func (b *Bandwidth) Received() uint64 {
+	return b.received.Load()
+}
+
+func (s *Stats) ObserveSelection(d time.Duration) {
+	seconds := d.Seconds()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, bound := range selectionBuckets {
+		if seconds <= bound {
+			s.selections[i]++
+			break
+		}
+	}
+	s.selectionSum += seconds
+	s.selectionsAll++
+}
+
+func (s *Stats) TunnelOpened() {
+	s.tunnels.Add(1)
+}
+
+func (s *Stats) TunnelClosed() {
+	s.tunnels.Add(-1)
+}
+
+func (s *Stats) Tunnels() int64 {
+	return s.tunnels.Load()
+}
+
+// WritePrometheus writes all counters in the Prometheus text exposition format
+func (s *Stats) WritePrometheus(w io.Writer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var b strings.Builder
+	writeHeader(&b, "rota_requests_total", "Proxied requests by result.", "counter")
+	for _, result := range slices.Sorted(maps.Keys(s.requests)) {
+		fmt.Fprintf(&b, "rota_requests_total{result=\"%s\"} %d\n", escapeLabel(result), s.requests[result])
+	}
+
+	proxies := slices.Sorted(maps.Keys(s.proxies))
+	writeHeader(&b, "rota_proxy_requests_total", "Upstream proxy attempts by proxy and result.", "counter")
+	for _, proxy := range proxies {
+		counters := s.proxies[proxy]
+		fmt.Fprintf(&b, "rota_proxy_requests_total{proxy=\"%s\",result=\"success\"} %d\n", escapeLabel(proxy), counters.success)
+		fmt.Fprintf(&b, "rota_proxy_requests_total{proxy=\"%s\",result=\"failure\"} %d\n", escapeLabel(proxy), counters.failure)
+	}
+
+	writeHeader(&b, "rota_proxy_errors_total", "Failed upstream proxy attempts by proxy and error class.", "counter")
+	for _, proxy := range slices.Sorted(maps.Keys(s.errors)) {
+		classes := s.errors[proxy]
+		for _, class := range slices.Sorted(maps.Keys(classes)) {
+			fmt.Fprintf(&b, "rota_proxy_errors_total{proxy=\"%s\",class=\"%s\"} %d\n", escapeLabel(proxy), escapeLabel(class), classes[class])
+		}
+	}
+
+	writeHeader(&b, "rota_proxy_bytes_total", "Bytes exchanged with upstream proxies by proxy and direction.", "counter")
+	for _, proxy := range slices.Sorted(maps.Keys(s.bandwidth)) {
+		bandwidth := s.bandwidth[proxy]
+		fmt.Fprintf(&b, "rota_proxy_bytes_total{proxy=\"%s\",direction=\"sent\"} %d\n", escapeLabel(proxy), bandwidth.Sent())
+		fmt.Fprintf(&b, "rota_proxy_bytes_total{proxy=\"%s\",direction=\"received\"} %d\n", escapeLabel(proxy), bandwidth.Received())
+	}
+
+	writeHeader(&b, "rota_selection_duration_seconds", "Time spent picking a proxy from the pool.", "histogram")
+	var cumulative uint64
+	for i, bound := range selectionBuckets {
+		cumulative += s.selections[i]
+		fmt.Fprintf(&b, "rota_selection_duration_seconds_bucket{le=\"%g\"} %d\n", bound, cumulative)
+	}
+	fmt.Fprintf(&b, "rota_selection_duration_seconds_bucket{le=\"+Inf\"} %d\n", s.selectionsAll)
+	fmt.Fprintf(&b, "rota_selection_duration_seconds_sum %g\n", s.selectionSum)
+	fmt.Fprintf(&b, "rota_selection_duration_seconds_count %d\n", s.selectionsAll)
+
+	writeHeader(&b, "rota_tunnels_in_flight", "Open CONNECT tunnels.", "gauge")
+	fmt.Fprintf(&b, "rota_tunnels_in_flight %d\n", s.tunnels.Load())
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteGauge writes a single gauge in the Prometheus text exposition format
+func WriteGauge(w io.Writer, name, help string, value float64) error {
+	var b strings.Builder
+	writeHeader(&b, name, help, "gauge")
+	fmt.Fprintf(&b, "%s %g\n", name, value)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeHeader(b *strings.Builder, name, help, kind string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,72 @@
+package stats
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	s := New()
+	s.ObserveRequest(ResultSuccess)
+	s.ObserveRequest(ResultSuccess)
+	s.ObserveRequest(ResultBadGateway)
+	s.ObserveProxy("http://1.1.1.1:80", true)
+	s.ObserveProxy("http://1.1.1.1:80", false)
+	s.ObserveProxy(`socks5://"quoted"`, false)
+	s.ObserveError("http://1.1.1.1:80", "timeout")
+	s.ObserveError("http://1.1.1.1:80", "timeout")
+	s.Bandwidth("http://1.1.1.1:80").AddSent(100)
+	s.Bandwidth("http://1.1.1.1:80").AddReceived(2048)
+	s.ObserveSelection(2 * time.Microsecond)
+	s.ObserveSelection(time.Second)
+	s.TunnelOpened()
+	s.TunnelOpened()
+	s.TunnelClosed()
+
+	var buf bytes.Buffer
+	assert.NoError(t, s.WritePrometheus(&buf))
+	out := buf.String()
+
+	for _, line := range []string{
+		"# TYPE rota_requests_total counter",
+		`rota_requests_total{result="success"} 2`,
+		`rota_requests_total{result="bad_gateway"} 1`,
+		`rota_proxy_requests_total{proxy="http://1.1.1.1:80",result="success"} 1`,
+		`rota_proxy_requests_total{proxy="http://1.1.1.1:80",result="failure"} 1`,
+		`rota_proxy_requests_total{proxy="socks5://\"quoted\"",result="failure"} 1`,
+		`rota_proxy_errors_total{proxy="http://1.1.1.1:80",class="timeout"} 2`,
+		`rota_proxy_bytes_total{proxy="http://1.1.1.1:80",direction="sent"} 100`,
+		`rota_proxy_bytes_total{proxy="http://1.1.1.1:80",direction="received"} 2048`,
+		`rota_selection_duration_seconds_bucket{le="1e-06"} 0`,
+		`rota_selection_duration_seconds_bucket{le="5e-06"} 1`,
+		`rota_selection_duration_seconds_bucket{le="0.05"} 1`,
+		`rota_selection_duration_seconds_bucket{le="+Inf"} 2`,
+		"rota_selection_duration_seconds_count 2",
+		"rota_tunnels_in_flight 1",
+	} {
+		assert.Contains(t, out, line+"\n")
+	}
+}
+
+func TestProxyBandwidth(t *testing.T) {
+	s := New()
+	sent, received := s.ProxyBandwidth("http://1.1.1.1:80")
+	assert.Zero(t, sent+received)
+
+	b := s.Bandwidth("http://1.1.1.1:80")
+	b.AddSent(10)
+	b.AddReceived(20)
+	assert.Same(t, b, s.Bandwidth("http://1.1.1.1:80"))
+	sent, received = s.ProxyBandwidth("http://1.1.1.1:80")
+	assert.Equal(t, uint64(10), sent)
+	assert.Equal(t, uint64(20), received)
+}
+
+func TestWriteGauge(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, WriteGauge(&buf, "rota_proxies", "Proxies in the pool.", 3))
+	assert.Equal(t, "# HELP rota_proxies Proxies in the pool.\n# TYPE rota_proxies gauge\nrota_proxies 3\n", buf.String())
+}
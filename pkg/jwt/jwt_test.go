@@ -0,0 +1,52 @@
+package jwt
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignVerify(t *testing.T) {
+	secret := []byte("secret")
+	claims := Claims{
+		Subject:   "admin",
+		Type:      "access",
+		ID:        "1",
+		IssuedAt:  time.Now().Unix(),
+		ExpiresAt: time.Now().Add(time.Minute).Unix(),
+	}
+	token, err := Sign(claims, secret)
+	assert.NoError(t, err)
+
+	verified, err := Verify(token, secret)
+	assert.NoError(t, err)
+	assert.Equal(t, claims, *verified)
+
+	expired := claims
+	expired.ExpiresAt = time.Now().Add(-time.Second).Unix()
+	expiredToken, err := Sign(expired, secret)
+	assert.NoError(t, err)
+
+	parts := strings.Split(token, ".")
+	tests := []struct {
+		name    string
+		token   string
+		secret  []byte
+		wantErr error
+	}{
+		{"wrong secret", token, []byte("other"), ErrInvalidToken},
+		{"expired", expiredToken, secret, ErrTokenExpired},
+		{"tampered payload", parts[0] + "." + strings.Split(expiredToken, ".")[1] + "." + parts[2], secret, ErrInvalidToken},
+		{"alg none", "eyJhbGciOiJub25lIn0." + parts[1] + ".", secret, ErrInvalidToken},
+		{"malformed", "not-a-token", secret, ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Verify(tt.token, tt.secret)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
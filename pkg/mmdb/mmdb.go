@@ -0,0 +1,343 @@
+// Package mmdb reads MaxMind DB files such as the GeoLite2 country, city and ASN databases.
+package mmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"os"
+)
+
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+
+	dataSectionSeparator = 16
+	maxDecodeDepth       = 64
+)
+
+var (
+	ErrInvalidDatabase = errors.New("invalid mmdb database")
+
+	metadataStart = []byte("\xab\xcd\xefMaxMind.com")
+)
+
+type Metadata struct {
+	NodeCount    uint
+	RecordSize   uint
+	IPVersion    uint
+	DatabaseType string
+}
+
+type Reader struct {
+	Metadata  Metadata
+	tree      []byte
+	data      []byte
+	ipv4Start uint
+}
+
+func Open(path string) (*Reader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return FromBytes(buf)
+}
+
+func FromBytes(buf []byte) (*Reader, error) {
+	start := bytes.LastIndex(buf, metadataStart)
+	if start < 0 {
+		return nil, fmt.Errorf("%w: metadata not found", ErrInvalidDatabase)
+	}
+	metaBuf := buf[start+len(metadataStart):]
+	value, _, err := (&decoder{buf: metaBuf}).decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: metadata: %v", ErrInvalidDatabase, err)
+	}
+	meta, ok := value.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: metadata is not a map", ErrInvalidDatabase)
+	}
+
+	r := &Reader{}
+	r.Metadata.NodeCount = uintValue(meta["node_count"])
+	r.Metadata.RecordSize = uintValue(meta["record_size"])
+	r.Metadata.IPVersion = uintValue(meta["ip_version"])
+	r.Metadata.DatabaseType, _ = meta["database_type"].(string)
+
+	switch r.Metadata.RecordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("%w: unsupported record size %d", ErrInvalidDatabase, r.Metadata.RecordSize)
+	}
+
+	treeSize := r.Metadata.NodeCount * r.Metadata.RecordSize / 4
+	if treeSize+dataSectionSeparator > uint(start) {
+		return nil, fmt.Errorf("%w: search tree exceeds the file", ErrInvalidDatabase)
+	}
+	r.tree = buf[:treeSize]
+	r.data = buf[treeSize+dataSectionSeparator : start]
+
+	// ipv4 addresses live under the ::/96 subtree of ipv6 databases
+	if r.Metadata.IPVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < r.Metadata.NodeCount; i++ {
+			node = r.record(node, 0)
+		}
+		r.ipv4Start = node
+	}
+	return r, nil
+}
+
+// Lookup returns the record of the network containing ip, nil when the database has none
+func (r *Reader) Lookup(ip net.IP) (any, error) {
+	node := uint(0)
+	bits := 128
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		bits = 32
+		node = r.ipv4Start
+	} else if r.Metadata.IPVersion == 4 {
+		return nil, nil
+	}
+	if ip.To16() == nil {
+		return nil, fmt.Errorf("invalid ip address: %v", ip)
+	}
+
+	for i := 0; i < bits && node < r.Metadata.NodeCount; i++ {
+		bit := uint(ip[i/8]>>(7-i%8)) & 1
+		node = r.record(node, bit)
+	}
+
+	switch {
+	case node == r.Metadata.NodeCount:
+		return nil, nil
+	case node < r.Metadata.NodeCount:
+		return nil, fmt.Errorf("%w: search tree is deeper than the address", ErrInvalidDatabase)
+	}
+
+	offset := node - r.Metadata.NodeCount - dataSectionSeparator
+	if offset >= uint(len(r.data)) {
+		return nil, fmt.Errorf("%w: record points outside the data section", ErrInvalidDatabase)
+	}
+	value, _, err := (&decoder{buf: r.data}).decode(offset, 0)
+	return value, err
+}
+
+func (r *Reader) record(node, bit uint) uint {
+	size := r.Metadata.RecordSize
+	b := r.tree[node*size/4:]
+	switch size {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+type decoder struct {
+	buf []byte
+}
+
+// decode returns the value at offset and the offset right after it
+func (d *decoder) decode(offset uint, depth int) (any, uint, error) {
+	if depth > maxDecodeDepth {
+		return nil, 0, errors.New("data nested too deeply")
+	}
+
+	kind, size, offset, err := d.control(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if kind == typePointer {
+		pointer, next, err := d.pointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decode(pointer, depth+1)
+		return value, next, err
+	}
+
+	switch kind {
+	case typeMap:
+		m := make(map[string]any, size)
+		for range size {
+			var key, value any
+			key, offset, err = d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is not a string")
+			}
+			value, offset, err = d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[k] = value
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]any, 0, size)
+		for range size {
+			var value any
+			value, offset, err = d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	}
+
+	end := offset + size
+	if end > uint(len(d.buf)) {
+		return nil, 0, errors.New("value exceeds the data section")
+	}
+	b := d.buf[offset:end]
+
+	switch kind {
+	case typeString:
+		return string(b), end, nil
+	case typeBytes:
+		return bytes.Clone(b), end, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errors.New("invalid double size")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), end, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errors.New("invalid float size")
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), end, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			return nil, 0, errors.New("invalid unsigned integer size")
+		}
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n, end, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, errors.New("invalid int32 size")
+		}
+		var n uint32
+		for _, c := range b {
+			n = n<<8 | uint32(c)
+		}
+		return int64(int32(n)), end, nil
+	case typeUint128:
+		if size > 16 {
+			return nil, 0, errors.New("invalid uint128 size")
+		}
+		return new(big.Int).SetBytes(b), end, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported data type %d", kind)
+}
+
+// control reads the type and size of the value at offset and returns the offset of its payload
+func (d *decoder) control(offset uint) (uint, uint, uint, error) {
+	next := func() (uint, error) {
+		if offset >= uint(len(d.buf)) {
+			return 0, errors.New("unexpected end of data")
+		}
+		b := d.buf[offset]
+		offset++
+		return uint(b), nil
+	}
+
+	ctrl, err := next()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	kind := ctrl >> 5
+	if kind == typeExtended {
+		ext, err := next()
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		kind = ext + 7
+	}
+	size := ctrl & 0x1f
+	if kind == typePointer || size < 29 {
+		return kind, size, offset, nil
+	}
+
+	extra := size - 28
+	if offset+extra > uint(len(d.buf)) {
+		return 0, 0, 0, errors.New("unexpected end of data")
+	}
+	n := uint(0)
+	for _, c := range d.buf[offset : offset+extra] {
+		n = n<<8 | uint(c)
+	}
+	offset += extra
+	switch size {
+	case 29:
+		size = 29 + n
+	case 30:
+		size = 285 + n
+	default:
+		size = 65821 + n
+	}
+	return kind, size, offset, nil
+}
+
+// pointer resolves a pointer whose control byte carried size, see the "Pointer - 1" section of the spec
+func (d *decoder) pointer(size, offset uint) (uint, uint, error) {
+	length := (size>>3)&0x3 + 1
+	if offset+length > uint(len(d.buf)) {
+		return 0, 0, errors.New("unexpected end of data")
+	}
+	n := uint(0)
+	for _, c := range d.buf[offset : offset+length] {
+		n = n<<8 | uint(c)
+	}
+
+	vvv := size & 0x7
+	switch length {
+	case 1:
+		n = vvv<<8 | n
+	case 2:
+		n = (vvv<<16 | n) + 2048
+	case 3:
+		n = (vvv<<24 | n) + 526336
+	}
+	return n, offset + length, nil
+}
+
+func uintValue(v any) uint {
+	n, _ := v.(uint64)
+	return uint(n)
+}
@@ -0,0 +1,283 @@
+package mmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"maps"
+	"math"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type pointer uint
+
+// encode writes v in the mmdb data format
+func encode(buf *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case pointer:
+		switch {
+		case v < 2048:
+			buf.WriteByte(1<<5 | byte(v>>8))
+			buf.WriteByte(byte(v))
+		default:
+			v -= 2048
+			buf.WriteByte(1<<5 | 1<<3 | byte(v>>16))
+			buf.WriteByte(byte(v >> 8))
+			buf.WriteByte(byte(v))
+		}
+	case string:
+		writeControl(buf, typeString, len(v))
+		buf.WriteString(v)
+	case uint32:
+		b := binary.BigEndian.AppendUint32(nil, v)
+		b = bytes.TrimLeft(b, "\x00")
+		writeControl(buf, typeUint32, len(b))
+		buf.Write(b)
+	case uint64:
+		b := binary.BigEndian.AppendUint64(nil, v)
+		writeControl(buf, typeUint64, 8)
+		buf.Write(b)
+	case int32:
+		writeControl(buf, typeInt32, 4)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(v)))
+	case float64:
+		writeControl(buf, typeDouble, 8)
+		buf.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(v)))
+	case bool:
+		n := 0
+		if v {
+			n = 1
+		}
+		writeControl(buf, typeBool, n)
+	case []any:
+		writeControl(buf, typeArray, len(v))
+		for _, item := range v {
+			encode(buf, item)
+		}
+	case map[string]any:
+		writeControl(buf, typeMap, len(v))
+		for _, k := range slices.Sorted(maps.Keys(v)) {
+			encode(buf, k)
+			encode(buf, v[k])
+		}
+	default:
+		panic("unsupported test value")
+	}
+}
+
+func writeControl(buf *bytes.Buffer, kind, size int) {
+	var extra []byte
+	switch {
+	case size < 29:
+	case size < 285:
+		extra = []byte{byte(size - 29)}
+		size = 29
+	default:
+		extra = []byte{byte((size - 285) >> 8), byte(size - 285)}
+		size = 30
+	}
+	if kind > 7 {
+		buf.WriteByte(byte(size))
+		buf.WriteByte(byte(kind - 7))
+	} else {
+		buf.WriteByte(byte(kind<<5 | size))
+	}
+	buf.Write(extra)
+}
+
+type trieNode struct {
+	children [2]*trieNode
+	data     [2]int
+}
+
+type network struct {
+	cidr   string
+	record any
+}
+
+// build writes an ipv4 or ipv6 database holding networks
+func build(t *testing.T, ipVersion, recordSize int, networks []network) []byte {
+	var data bytes.Buffer
+	root := &trieNode{data: [2]int{-1, -1}}
+	for _, n := range networks {
+		_, ipNet, err := net.ParseCIDR(n.cidr)
+		require.NoError(t, err)
+		ip := ipNet.IP
+		ones, _ := ipNet.Mask.Size()
+		if ipVersion == 6 && len(ip) == net.IPv4len {
+			ip = append(make(net.IP, 12), ip...)
+			ones += 96
+		}
+
+		offset := data.Len()
+		encode(&data, n.record)
+
+		node := root
+		for i := 0; i < ones-1; i++ {
+			bit := ip[i/8] >> (7 - i%8) & 1
+			if node.children[bit] == nil {
+				node.children[bit] = &trieNode{data: [2]int{-1, -1}}
+			}
+			node = node.children[bit]
+		}
+		last := ones - 1
+		node.data[ip[last/8]>>(7-last%8)&1] = offset
+	}
+
+	var nodes []*trieNode
+	var walk func(*trieNode)
+	walk = func(n *trieNode) {
+		nodes = append(nodes, n)
+		for _, c := range n.children {
+			if c != nil {
+				walk(c)
+			}
+		}
+	}
+	walk(root)
+	index := make(map[*trieNode]int, len(nodes))
+	for i, n := range nodes {
+		index[n] = i
+	}
+
+	var tree bytes.Buffer
+	for _, n := range nodes {
+		var records [2]uint32
+		for bit := range 2 {
+			switch {
+			case n.children[bit] != nil:
+				records[bit] = uint32(index[n.children[bit]])
+			case n.data[bit] >= 0:
+				records[bit] = uint32(len(nodes) + dataSectionSeparator + n.data[bit])
+			default:
+				records[bit] = uint32(len(nodes))
+			}
+		}
+		switch recordSize {
+		case 24:
+			for _, r := range records {
+				tree.Write([]byte{byte(r >> 16), byte(r >> 8), byte(r)})
+			}
+		case 28:
+			l, r := records[0], records[1]
+			tree.Write([]byte{byte(l >> 16), byte(l >> 8), byte(l), byte(l>>20)&0xf0 | byte(r>>24)&0x0f, byte(r >> 16), byte(r >> 8), byte(r)})
+		case 32:
+			tree.Write(binary.BigEndian.AppendUint32(nil, records[0]))
+			tree.Write(binary.BigEndian.AppendUint32(nil, records[1]))
+		}
+	}
+
+	var file bytes.Buffer
+	file.Write(tree.Bytes())
+	file.Write(make([]byte, dataSectionSeparator))
+	file.Write(data.Bytes())
+	file.Write(metadataStart)
+	encode(&file, map[string]any{
+		"node_count":    uint32(len(nodes)),
+		"record_size":   uint32(recordSize),
+		"ip_version":    uint32(ipVersion),
+		"database_type": "Test-City",
+	})
+	return file.Bytes()
+}
+
+func TestLookup(t *testing.T) {
+	germany := map[string]any{
+		"country": map[string]any{"iso_code": "DE"},
+		"city":    map[string]any{"names": map[string]any{"en": "Berlin"}},
+	}
+	long := strings.Repeat("x", 300)
+
+	for _, ipVersion := range []int{4, 6} {
+		for _, recordSize := range []int{24, 28, 32} {
+			networks := []network{
+				{"10.0.0.0/8", germany},
+				{"192.168.1.0/24", map[string]any{
+					"autonomous_system_number":       uint32(64512),
+					"autonomous_system_organization": long,
+					"location":                       map[string]any{"latitude": 52.5, "longitude": 13.4},
+					"flags":                          []any{true, false, int32(-7), uint64(1 << 40)},
+				}},
+			}
+			if ipVersion == 6 {
+				networks = append(networks, network{"2001:db8::/32", map[string]any{"country": map[string]any{"iso_code": "NL"}}})
+			}
+			r, err := FromBytes(build(t, ipVersion, recordSize, networks))
+			require.NoError(t, err)
+			assert.Equal(t, "Test-City", r.Metadata.DatabaseType)
+
+			record, err := r.Lookup(net.ParseIP("10.1.2.3"))
+			assert.NoError(t, err)
+			assert.Equal(t, germany, record)
+
+			record, err = r.Lookup(net.ParseIP("192.168.1.200"))
+			assert.NoError(t, err)
+			assert.Equal(t, map[string]any{
+				"autonomous_system_number":       uint64(64512),
+				"autonomous_system_organization": long,
+				"location":                       map[string]any{"latitude": 52.5, "longitude": 13.4},
+				"flags":                          []any{true, false, int64(-7), uint64(1 << 40)},
+			}, record)
+
+			record, err = r.Lookup(net.ParseIP("172.16.0.1"))
+			assert.NoError(t, err)
+			assert.Nil(t, record)
+
+			record, err = r.Lookup(net.ParseIP("2001:db8::1"))
+			assert.NoError(t, err)
+			if ipVersion == 6 {
+				assert.Equal(t, map[string]any{"country": map[string]any{"iso_code": "NL"}}, record)
+			} else {
+				assert.Nil(t, record)
+			}
+		}
+	}
+}
+
+func TestPointers(t *testing.T) {
+	// a record whose key and value point at strings written before it
+	var data bytes.Buffer
+	encode(&data, "country")
+	valueAt := data.Len()
+	encode(&data, "DE")
+	for data.Len() < 3000 {
+		encode(&data, "padding")
+	}
+	farAt := data.Len()
+	encode(&data, "far")
+
+	d := &decoder{}
+	var record bytes.Buffer
+	writeControl(&record, typeMap, 2)
+	encode(&record, pointer(0))
+	encode(&record, pointer(valueAt))
+	encode(&record, "other")
+	encode(&record, pointer(farAt))
+	recordAt := data.Len()
+	data.Write(record.Bytes())
+	d.buf = data.Bytes()
+
+	value, next, err := d.decode(uint(recordAt), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]any{"country": "DE", "other": "far"}, value)
+	assert.Equal(t, uint(data.Len()), next)
+}
+
+func TestInvalidDatabase(t *testing.T) {
+	_, err := FromBytes([]byte("not a database"))
+	assert.ErrorIs(t, err, ErrInvalidDatabase)
+
+	var file bytes.Buffer
+	file.Write(metadataStart)
+	encode(&file, map[string]any{"node_count": uint32(1000), "record_size": uint32(24), "ip_version": uint32(4)})
+	_, err = FromBytes(file.Bytes())
+	assert.ErrorIs(t, err, ErrInvalidDatabase)
+
+	_, err = Open("does-not-exist.mmdb")
+	assert.Error(t, err)
+}
@@ -0,0 +1,255 @@
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultMaxIdle    = 8
+	defaultRetryAfter = 5 * time.Second
+
+	msgUnexpectedReply = "unexpected reply"
+	msgUnavailable     = "redis: unavailable"
+)
+
+var (
+	// ErrNil is returned for missing keys
+	ErrNil = errors.New("redis: nil")
+	// ErrUnavailable is returned without trying the server for a few seconds after a connection failed, callers
+	// falling back to local state log the first error only
+	ErrUnavailable = errors.New(msgUnavailable)
+
+	errUnexpectedReply = errors.New(msgUnexpectedReply)
+)
+
+// Error is an error reply sent by the server
+type Error string
+
+func (e Error) Error() string {
+	return "redis: " + string(e)
+}
+
+// Client speaks RESP2 to one server, the connections it opens are reused
+type Client struct {
+	addr        string
+	password    string
+	db          int
+	timeout     time.Duration
+	dialTimeout time.Duration
+	retryAfter  time.Duration
+	idle        chan *conn
+	down        atomic.Int64
+}
+
+// Pool tunes how a client keeps and opens connections, zero values keep the defaults
+type Pool struct {
+	// MaxIdle is the number of idle connections kept for reuse (default 8), busier callers open and close extra ones
+	MaxIdle int
+	// RetryAfter is how long the server is not tried after a connection failed (default 5s)
+	RetryAfter time.Duration
+	// DialTimeout bounds opening a connection (default the command timeout)
+	DialTimeout time.Duration
+}
+
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func New(addr, password string, db int, timeout time.Duration) *Client {
+	return NewWithPool(addr, password, db, timeout, Pool{})
+}
+
+func NewWithPool(addr, password string, db int, timeout time.Duration, pool Pool) *Client {
+	maxIdle := pool.MaxIdle
+	if maxIdle <= 0 {
+		maxIdle = defaultMaxIdle
+	}
+	retryAfter := pool.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = defaultRetryAfter
+	}
+	dialTimeout := pool.DialTimeout
+	if dialTimeout <= 0 {
+		dialTimeout = timeout
+	}
+	return &Client{
+		addr:        addr,
+		password:    password,
+		db:          db,
+		timeout:     timeout,
+		dialTimeout: dialTimeout,
+		retryAfter:  retryAfter,
+		idle:        make(chan *conn, maxIdle),
+	}
+}
+
+// Do sends a command and returns its reply, an int64, a string, a []any or nil for a nil array
+func (c *Client) Do(args ...string) (any, error) {
+	if time.Now().UnixNano() < c.down.Load() {
+		return nil, ErrUnavailable
+	}
+
+	cn, err := c.get()
+	if err != nil {
+		c.down.Store(time.Now().Add(c.retryAfter).UnixNano())
+		return nil, err
+	}
+	reply, err := cn.do(c.timeout, args...)
+	var replyErr Error
+	if err != nil && !errors.As(err, &replyErr) && !errors.Is(err, ErrNil) {
+		cn.Close()
+		c.down.Store(time.Now().Add(c.retryAfter).UnixNano())
+		return nil, err
+	}
+	c.put(cn)
+	return reply, err
+}
+
+func (c *Client) Int(args ...string) (int64, error) {
+	reply, err := c.Do(args...)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("%w: %v", errUnexpectedReply, reply)
+	}
+	return n, nil
+}
+
+func (c *Client) String(args ...string) (string, error) {
+	reply, err := c.Do(args...)
+	if err != nil {
+		return "", err
+	}
+	s, ok := reply.(string)
+	if !ok {
+		return "", fmt.Errorf("%w: %v", errUnexpectedReply, reply)
+	}
+	return s, nil
+}
+
+// Close closes the idle connections, connections in use are closed when they are returned
+func (c *Client) Close() error {
+	for {
+		select {
+		case cn := <-c.idle:
+			cn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (c *Client) get() (*conn, error) {
+	select {
+	case cn := <-c.idle:
+		return cn, nil
+	default:
+	}
+
+	nc, err := net.DialTimeout("tcp", c.addr, c.dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{Conn: nc, r: bufio.NewReader(nc)}
+	if c.password != "" {
+		if _, err := cn.do(c.timeout, "AUTH", c.password); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+	if c.db != 0 {
+		if _, err := cn.do(c.timeout, "SELECT", strconv.Itoa(c.db)); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+func (c *Client) put(cn *conn) {
+	select {
+	case c.idle <- cn:
+	default:
+		cn.Close()
+	}
+}
+
+func (cn *conn) do(timeout time.Duration, args ...string) (any, error) {
+	if timeout > 0 {
+		if err := cn.SetDeadline(time.Now().Add(timeout)); err != nil {
+			return nil, err
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := cn.Write([]byte(b.String())); err != nil {
+		return nil, err
+	}
+	return readReply(cn.r)
+}
+
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("%w: %q", errUnexpectedReply, line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, Error(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, ErrNil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]any, n)
+		for i := range items {
+			item, err := readReply(r)
+			if err != nil && !errors.Is(err, ErrNil) {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("%w: %q", errUnexpectedReply, line)
+}
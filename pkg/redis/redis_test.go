@@ -0,0 +1,108 @@
+package redis
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serve answers the commands of every connection with the replies in order, and records the commands
+func serve(t *testing.T, replies ...string) (string, chan []string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	commands := make(chan []string, len(replies))
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for _, reply := range replies {
+			command, err := readReply(r)
+			if err != nil {
+				return
+			}
+			args := make([]string, 0)
+			for _, arg := range command.([]any) {
+				args = append(args, arg.(string))
+			}
+			commands <- args
+			if _, err := conn.Write([]byte(reply)); err != nil {
+				return
+			}
+		}
+	}()
+	return listener.Addr().String(), commands
+}
+
+func TestClient(t *testing.T) {
+	addr, commands := serve(t, "+OK\r\n", "+OK\r\n", ":3\r\n", "$5\r\nhello\r\n", "$-1\r\n", "*2\r\n$1\r\na\r\n:1\r\n", "-ERR wrong type\r\n")
+	client := New(addr, "secret", 2, time.Second)
+	defer client.Close()
+
+	n, err := client.Int("INCR", "counter")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+	assert.Equal(t, []string{"AUTH", "secret"}, <-commands)
+	assert.Equal(t, []string{"SELECT", "2"}, <-commands)
+	assert.Equal(t, []string{"INCR", "counter"}, <-commands)
+
+	s, err := client.String("GET", "greeting")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", s)
+
+	_, err = client.String("GET", "missing")
+	assert.ErrorIs(t, err, ErrNil)
+
+	reply, err := client.Do("LRANGE", "list", "0", "-1")
+	require.NoError(t, err)
+	assert.Equal(t, []any{"a", int64(1)}, reply)
+
+	_, err = client.Do("INCR", "greeting")
+	assert.Equal(t, Error("ERR wrong type"), err)
+}
+
+func TestClientUnavailable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	client := New(addr, "", 0, time.Second)
+	_, err = client.Do("PING")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrUnavailable)
+	_, err = client.Do("PING")
+	assert.ErrorIs(t, err, ErrUnavailable)
+}
+
+func TestClientPool(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	client := NewWithPool(addr, "", 0, time.Second, Pool{MaxIdle: 2, RetryAfter: 50 * time.Millisecond})
+	assert.Equal(t, 2, cap(client.idle))
+	assert.Equal(t, time.Second, client.dialTimeout)
+
+	_, err = client.Do("PING")
+	assert.NotErrorIs(t, err, ErrUnavailable)
+	_, err = client.Do("PING")
+	assert.ErrorIs(t, err, ErrUnavailable)
+	// the server is tried again once retry_after passed
+	time.Sleep(60 * time.Millisecond)
+	_, err = client.Do("PING")
+	assert.NotErrorIs(t, err, ErrUnavailable)
+
+	defaults := New(addr, "", 0, time.Second)
+	assert.Equal(t, defaultMaxIdle, cap(defaults.idle))
+	assert.Equal(t, defaultRetryAfter, defaults.retryAfter)
+}
@@ -0,0 +1,108 @@
+package systemd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	Ready     = "READY=1"
+	Reloading = "RELOADING=1"
+	Stopping  = "STOPPING=1"
+
+	ProxySocket = "proxy"
+	ApiSocket   = "api"
+
+	listenFdsStart = 3
+
+	msgInvalidListenFds        = "invalid LISTEN_FDS"
+	msgFailedToUseListenSocket = "failed to use socket passed by systemd"
+)
+
+var (
+	once      sync.Once
+	mu        sync.Mutex
+	listeners map[string]net.Listener
+	parseErr  error
+)
+
+// Notify sends a state change to the service manager, it is a no-op when not started by systemd
+func Notify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// Listener returns the socket passed with socket activation under the given FileDescriptorName.
+// Unnamed sockets are taken in order, the first one is the proxy and the second one the API.
+func Listener(name string) (net.Listener, error) {
+	once.Do(func() {
+		listeners, parseErr = activatedListeners(listenFdsStart)
+	})
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	listener := listeners[name]
+	delete(listeners, name)
+	return listener, nil
+}
+
+func activatedListeners(start int) (map[string]net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 0 {
+		return nil, errors.New(msgInvalidListenFds)
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	defaults := []string{ProxySocket, ApiSocket}
+
+	result := make(map[string]net.Listener, count)
+	for i := 0; i < count; i++ {
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		if (name == "" || name == "unknown") && i < len(defaults) {
+			name = defaults[i]
+		}
+
+		file := os.NewFile(uintptr(start+i), name)
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", msgFailedToUseListenSocket, err)
+		}
+		result[name] = listener
+	}
+	return result, nil
+}
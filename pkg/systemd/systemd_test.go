@@ -0,0 +1,63 @@
+//go:build !windows
+
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	assert.NoError(t, Notify(Ready))
+
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", socket)
+	assert.NoError(t, Notify(Ready))
+
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, Ready, string(buf[:n]))
+}
+
+func TestActivatedListeners(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer tcp.Close()
+
+	file, err := tcp.(*net.TCPListener).File()
+	assert.NoError(t, err)
+	defer file.Close()
+
+	// the listeners take ownership of the descriptors they are handed
+	fd, err := syscall.Dup(int(file.Fd()))
+	assert.NoError(t, err)
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_FDNAMES", "")
+
+	listeners, err := activatedListeners(fd)
+	assert.NoError(t, err)
+	assert.Len(t, listeners, 1)
+	assert.Equal(t, tcp.Addr().String(), listeners[ProxySocket].Addr().String())
+	listeners[ProxySocket].Close()
+	assert.Empty(t, os.Getenv("LISTEN_FDS"))
+
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	listeners, err = activatedListeners(fd)
+	assert.NoError(t, err)
+	assert.Nil(t, listeners)
+}
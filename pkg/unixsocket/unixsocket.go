@@ -0,0 +1,39 @@
+package unixsocket
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+)
+
+const (
+	msgSocketInUse          = "unix socket is in use"
+	msgNotASocket           = "path exists and is not a unix socket"
+	msgFailedToRemoveSocket = "failed to remove stale unix socket"
+	msgFailedToListenSocket = "failed to listen on unix socket"
+)
+
+func Listen(path string) (net.Listener, error) {
+	// a socket left behind by an unclean shutdown would otherwise fail with "address already in use"
+	if info, err := os.Stat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s: %s", msgNotASocket, path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s: %s", msgSocketInUse, path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("%s: %w", msgFailedToRemoveSocket, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", msgFailedToListenSocket, err)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", msgFailedToListenSocket, err)
+	}
+	return listener, nil
+}
@@ -0,0 +1,44 @@
+package unixsocket
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rota.sock")
+
+	listener, err := Listen(path)
+	assert.NoError(t, err)
+
+	t.Run("socket in use", func(t *testing.T) {
+		_, err := Listen(path)
+		assert.ErrorContains(t, err, msgSocketInUse)
+	})
+
+	t.Run("stale socket is replaced", func(t *testing.T) {
+		// keep the file around after close, like a crashed process would
+		listener.(*net.UnixListener).SetUnlinkOnClose(false)
+		listener.Close()
+		_, err := os.Stat(path)
+		assert.NoError(t, err)
+
+		listener, err = Listen(path)
+		assert.NoError(t, err)
+		listener.Close()
+	})
+
+	t.Run("regular file is not removed", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "config.yml")
+		assert.NoError(t, os.WriteFile(file, []byte("proxy_file: proxies.txt"), 0644))
+
+		_, err := Listen(file)
+		assert.ErrorContains(t, err, msgNotASocket)
+		_, err = os.Stat(file)
+		assert.NoError(t, err)
+	})
+}
@@ -39,9 +39,11 @@ func NewFileWatcher(proxyLoader ProxyLoader) (*FileWatcher, error) {
 	}, nil
 }
 
-func (fw *FileWatcher) Watch(filePath string) error {
-	if err := fw.watcher.Add(filePath); err != nil {
-		return fmt.Errorf("%s: %w", msgFailedToAddFileToWatcher, err)
+func (fw *FileWatcher) Watch(filePaths ...string) error {
+	for _, filePath := range filePaths {
+		if err := fw.watcher.Add(filePath); err != nil {
+			return fmt.Errorf("%s: %w", msgFailedToAddFileToWatcher, err)
+		}
 	}
 
 	slog.Info(msgWatchingProxyFile)
@@ -58,6 +60,7 @@ func (fw *FileWatcher) Watch(filePath string) error {
 					slog.Info(msgProxyFileChanged, "file", event.Name)
 					if err := fw.proxyLoader.Reload(); err != nil {
 						slog.Error(msgFailedToReloadProxies, "error", err)
+						continue
 					}
 
 					slog.Info(msgProxyReloadedSuccessfully)